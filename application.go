@@ -1,37 +1,356 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/handlers/admin"
+	"github.com/kylegrantlucas/platform-exercise/handlers/jwks"
+	"github.com/kylegrantlucas/platform-exercise/handlers/oauth"
+	"github.com/kylegrantlucas/platform-exercise/handlers/openid"
+	"github.com/kylegrantlucas/platform-exercise/handlers/passwordreset"
 	"github.com/kylegrantlucas/platform-exercise/handlers/session"
 	"github.com/kylegrantlucas/platform-exercise/handlers/user"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/federation"
+	"github.com/kylegrantlucas/platform-exercise/pkg/jobs"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mailer"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mfa"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oidc"
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
 	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
-	"github.com/pascaldekloe/jwt"
+	"github.com/kylegrantlucas/platform-exercise/pkg/ratelimit"
+	"github.com/kylegrantlucas/platform-exercise/pkg/retention"
+	"github.com/kylegrantlucas/platform-exercise/pkg/secrets"
+	"github.com/kylegrantlucas/platform-exercise/pkg/sessioncache"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tenant"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 )
 
+const (
+	// How many validated sessions are kept in memory
+	sessionCacheSize = 10000
+	// How long a cached session is trusted for, this bounds staleness if a revocation notification is ever lost
+	sessionCacheTTL = time.Minute
+)
+
+// How often JWT_KEY_DIR is checked for added, retired or newly designated signing keys
+const keyDirSyncInterval = time.Minute
+
+// How often users who were deleted longer than USER_RETENTION ago, sessions that ended longer than
+// SESSION_RETENTION ago, and login rate limit buckets that have refilled are looked for and purged
+const (
+	userPurgeInterval      = time.Hour
+	sessionPurgeInterval   = 10 * time.Minute
+	rateLimitPurgeInterval = 10 * time.Minute
+)
+
+// How long requests in flight are given to finish once we're told to shut down
+const shutdownTimeout = 30 * time.Second
+
 func attachHandlers(router *mux.Router) {
-	keys := &jwt.KeyRegister{Secrets: [][]byte{[]byte(os.Getenv("JWT_KEY"))}}
+	keys := signing.Keys
 	headers := map[string]string{
 		"sub": "X-Verified-User-Uuid",
 		"sid": "X-Verified-Session-Uuid",
 	}
 
+	// Protected handlers need a valid token for a live session, which is put on the request context. Sessions OAuth
+	// clients started are kept out unless the handler is scoped, and then only with that scope.
+	scoped := func(scope string, handler http.HandlerFunc) http.Handler {
+		return &signing.Handler{Target: auth.RequireSession(auth.RequireScope(scope, handler)), HeaderBinding: headers, Keys: keys}
+	}
+	protected := func(handler http.HandlerFunc) http.Handler {
+		return scoped("", handler)
+	}
+	// Admin handlers are protected, and the user's roles have to grant them the permission too
+	permitted := func(permission string, handler http.HandlerFunc) http.Handler {
+		return protected(auth.RequirePermission(permission, handler).ServeHTTP)
+	}
+
 	router.Use(jsonMiddleware)
 
 	// User Handlers
 	router.HandleFunc("/users", user.Create).Methods("POST")
-	router.Handle("/users", &jwt.Handler{Target: http.HandlerFunc(user.Delete), HeaderBinding: headers, Keys: keys}).Methods("DELETE")
-	router.Handle("/users", &jwt.Handler{Target: http.HandlerFunc(user.Update), HeaderBinding: headers, Keys: keys}).Methods("PUT")
+	router.HandleFunc("/users/verify", user.Verify).Methods("POST")
+	router.Handle("/users/me", scoped("profile", user.Get)).Methods("GET")
+	router.Handle("/users", protected(user.Delete)).Methods("DELETE")
+	router.Handle("/users", protected(user.Update)).Methods("PUT")
+	router.Handle("/users/mfa/totp", protected(user.EnrollTOTP)).Methods("POST")
+	router.Handle("/users/mfa/totp/confirm", protected(user.ConfirmTOTP)).Methods("POST")
+	router.Handle("/users/webauthn/registrations", protected(user.BeginWebAuthnRegistration)).Methods("POST")
+	router.Handle("/users/webauthn/credentials", protected(user.FinishWebAuthnRegistration)).Methods("POST")
 
 	// Session Handlers
 	router.HandleFunc("/sessions", session.Create).Methods("POST")
-	router.Handle("/sessions", &jwt.Handler{Target: http.HandlerFunc(session.Delete), HeaderBinding: headers, Keys: keys}).Methods("DELETE")
+	router.HandleFunc("/sessions/refresh", session.Refresh).Methods("POST")
+	router.HandleFunc("/sessions/mfa", session.MFA).Methods("POST")
+	router.HandleFunc("/sessions/webauthn", session.BeginWebAuthnLogin).Methods("POST")
+	router.HandleFunc("/sessions/providers/{provider}", session.BeginFederatedLogin).Methods("POST")
+	router.Handle("/sessions", protected(session.List)).Methods("GET")
+	router.Handle("/sessions", protected(session.Delete)).Methods("DELETE")
+	router.Handle("/sessions/{uuid:[0-9a-fA-F-]{36}}", protected(session.DeleteByUUID)).Methods("DELETE")
+	router.Handle("/sessions/switch-org", protected(session.SwitchOrganization)).Methods("POST")
+
+	// Password Reset Handlers
+	router.HandleFunc("/password-resets", passwordreset.Create).Methods("POST")
+	router.HandleFunc("/password-resets/{token}", passwordreset.Reset).Methods("POST")
+
+	// Key Handlers
+	router.HandleFunc("/.well-known/jwks.json", jwks.Get).Methods("GET")
+
+	// OAuth Handlers
+	router.Handle("/oauth/authorize", protected(oauth.Authorize)).Methods("GET")
+	router.Handle("/oauth/authorize", protected(oauth.Approve)).Methods("POST")
+	router.HandleFunc("/oauth/token", oauth.Token).Methods("POST")
+	router.HandleFunc("/oauth/introspect", oauth.Introspect).Methods("POST")
+	router.HandleFunc("/oauth/revoke", oauth.Revoke).Methods("POST")
+
+	// Admin Handlers
+	router.Handle("/admin/users", permitted(auth.PermissionReadUsers, admin.List)).Methods("GET")
+	router.Handle("/admin/users/{uuid:[0-9a-fA-F-]{36}}", permitted(auth.PermissionReadUsers, admin.Get)).Methods("GET")
+	router.Handle("/admin/users/{uuid:[0-9a-fA-F-]{36}}", permitted(auth.PermissionWriteUsers, admin.Update)).Methods("PUT")
+	router.Handle("/admin/users/{uuid:[0-9a-fA-F-]{36}}", permitted(auth.PermissionWriteUsers, admin.Delete)).Methods("DELETE")
+	router.Handle("/admin/users/{uuid:[0-9a-fA-F-]{36}}/sessions:revoke", permitted(auth.PermissionRevokeSessions, admin.RevokeSessions)).Methods("POST")
+	router.Handle("/admin/users/{uuid:[0-9a-fA-F-]{36}}/restore", permitted(auth.PermissionWriteUsers, admin.Restore)).Methods("POST")
+	router.Handle("/admin/jobs", permitted(auth.PermissionReadJobs, admin.Jobs)).Methods("GET")
+
+	// OpenID Connect Handlers
+	router.HandleFunc("/.well-known/openid-configuration", openid.Discovery).Methods("GET")
+	router.Handle("/userinfo", scoped(oidc.ScopeOpenID, openid.UserInfo)).Methods("GET", "POST")
+}
+
+// Loads the keys we sign and verify tokens with, JWT_SIGNING_KEY is a PEM encoded RSA, ECDSA or Ed25519 private key
+// and JWT_VERIFICATION_KEYS is a comma separated list of PEM files with extra public keys to accept signatures from.
+// JWT_KEY is the legacy HS512 secret, it's used to sign only when there's no JWT_SIGNING_KEY so existing tokens keep
+// working while we migrate. JWT_KEY_DIR is a directory of keys that's watched for rotations, see signing.KeyDir,
+// it picks the signer itself so it can't be set along with JWT_SIGNING_KEY.
+func loadSigningKeys() (*signing.Register, error) {
+	keys := signing.NewRegister()
+
+	if os.Getenv("JWT_KEY_DIR") != "" && os.Getenv("JWT_SIGNING_KEY") != "" {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY and JWT_KEY_DIR can't both be set, put the signing key in the directory")
+	}
+
+	if os.Getenv("JWT_KEY_DIR") != "" {
+		keyDir := signing.NewKeyDir(os.Getenv("JWT_KEY_DIR"))
+		err := keyDir.Sync(keys)
+		if err != nil {
+			return nil, err
+		}
+
+		go keyDir.Watch(keys, keyDirSyncInterval, nil)
+	}
+
+	if os.Getenv("JWT_SIGNING_KEY") != "" {
+		signers, err := signing.LoadPEMFile(os.Getenv("JWT_SIGNING_KEY"))
+		if err != nil {
+			return nil, err
+		}
+
+		err = keys.SetSigner(signers[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if os.Getenv("JWT_VERIFICATION_KEYS") != "" {
+		for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEYS"), ",") {
+			verifiers, err := signing.LoadPEMFile(strings.TrimSpace(path))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, key := range verifiers {
+				keys.Add(key)
+			}
+		}
+	}
+
+	if os.Getenv("JWT_KEY") != "" {
+		keys.AddSecret([]byte(os.Getenv("JWT_KEY")))
+	}
+
+	return keys, nil
+}
+
+// loadPasswordHasher builds the hasher new password hashes are made with, existing hashes verify whatever it is
+func loadPasswordHasher() (password.Hasher, error) {
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "", "bcrypt":
+		hasher := &password.Bcrypt{Cost: password.DefaultBcryptCost}
+		if os.Getenv("BCRYPT_COST") != "" {
+			cost, err := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid BCRYPT_COST: %v", err)
+			}
+			hasher.Cost = cost
+		}
+
+		return hasher, nil
+	case "argon2id":
+		hasher := password.NewArgon2id()
+		params := []struct {
+			env   string
+			value *uint32
+		}{
+			{"ARGON2_TIME", &hasher.Time},
+			{"ARGON2_MEMORY", &hasher.Memory},
+		}
+		for _, param := range params {
+			if os.Getenv(param.env) == "" {
+				continue
+			}
+
+			value, err := strconv.ParseUint(os.Getenv(param.env), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v: %v", param.env, err)
+			}
+			*param.value = uint32(value)
+		}
+
+		if os.Getenv("ARGON2_THREADS") != "" {
+			threads, err := strconv.ParseUint(os.Getenv("ARGON2_THREADS"), 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ARGON2_THREADS: %v", err)
+			}
+			hasher.Threads = uint8(threads)
+		}
+
+		return hasher, nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", os.Getenv("PASSWORD_HASHER"))
+	}
+}
+
+// loadBreachChecker builds the checker new passwords are checked against for breaches
+func loadBreachChecker() (password.BreachChecker, error) {
+	switch os.Getenv("BREACH_CHECKER") {
+	case "", "hibp":
+		url := password.DefaultHIBPURL
+		if os.Getenv("HIBP_URL") != "" {
+			url = os.Getenv("HIBP_URL")
+		}
+
+		return password.NewBreachCache(password.NewHIBP(url), password.DefaultBreachCacheSize, password.DefaultBreachCacheTTL), nil
+	case "local":
+		return password.OpenRangeFile(os.Getenv("BREACH_DUMP"), os.Getenv("BREACH_INDEX"))
+	case "none":
+		return password.NoopChecker{}, nil
+	default:
+		return nil, fmt.Errorf("unknown BREACH_CHECKER %q", os.Getenv("BREACH_CHECKER"))
+	}
+}
+
+// loadIdentityProviders configures the providers in IDENTITY_PROVIDERS, a comma separated list of names. Each is
+// configured with IDP_<NAME>_ variables, ex: IDP_GOOGLE_ISSUER, see the README for the full list.
+func loadIdentityProviders() error {
+	federation.Providers = map[string]*federation.Provider{}
+	if os.Getenv("IDENTITY_PROVIDERS") == "" {
+		return nil
+	}
+
+	for _, name := range strings.Split(os.Getenv("IDENTITY_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		prefix := "IDP_" + strings.ToUpper(name) + "_"
+
+		provider := federation.NewProvider(name, os.Getenv(prefix+"ISSUER"), os.Getenv(prefix+"CLIENT_ID"), os.Getenv(prefix+"CLIENT_SECRET"), os.Getenv(prefix+"REDIRECT_URL"))
+		provider.LinkByEmail = os.Getenv(prefix+"LINK_BY_EMAIL") == "true"
+		provider.AuthURL = os.Getenv(prefix + "AUTH_URL")
+		provider.TokenURL = os.Getenv(prefix + "TOKEN_URL")
+		provider.UserInfoURL = os.Getenv(prefix + "USERINFO_URL")
+		if os.Getenv(prefix+"SCOPES") != "" {
+			provider.Scopes = strings.Split(os.Getenv(prefix+"SCOPES"), ",")
+		}
+
+		if provider.ClientID == "" || provider.RedirectURL == "" {
+			return fmt.Errorf("%vCLIENT_ID and %vREDIRECT_URL are required", prefix, prefix)
+		}
+		if provider.Issuer == "" && (provider.AuthURL == "" || provider.TokenURL == "" || provider.UserInfoURL == "") {
+			return fmt.Errorf("%vISSUER is required unless all of its endpoints are set", prefix)
+		}
+
+		federation.Providers[name] = provider
+	}
+
+	return nil
+}
+
+// loadLoginThrottling configures the login and password reset rate limiters and lockout, RATE_LIMIT_STORE picks
+// where buckets are kept, memory is per replica so anything running more than one should use postgres
+func loadLoginThrottling() error {
+	ipRate, emailRate, lockout := session.DefaultIPRate, session.DefaultEmailRate, session.DefaultLockout
+	resetRate := passwordreset.DefaultEmailRate
+	ints := []struct {
+		env   string
+		value *int
+	}{
+		{"LOGIN_IP_BURST", &ipRate.Burst},
+		{"LOGIN_EMAIL_BURST", &emailRate.Burst},
+		{"LOCKOUT_THRESHOLD", &lockout.Threshold},
+		{"RESET_EMAIL_BURST", &resetRate.Burst},
+	}
+	for _, param := range ints {
+		if os.Getenv(param.env) == "" {
+			continue
+		}
+
+		value, err := strconv.Atoi(os.Getenv(param.env))
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", param.env, err)
+		}
+		*param.value = value
+	}
+
+	durations := []struct {
+		env   string
+		value *time.Duration
+	}{
+		{"LOGIN_IP_EVERY", &ipRate.Every},
+		{"LOGIN_EMAIL_EVERY", &emailRate.Every},
+		{"LOCKOUT_BASE", &lockout.Base},
+		{"LOCKOUT_MAX", &lockout.Max},
+		{"RESET_EMAIL_EVERY", &resetRate.Every},
+	}
+	for _, param := range durations {
+		if os.Getenv(param.env) == "" {
+			continue
+		}
+
+		value, err := time.ParseDuration(os.Getenv(param.env))
+		if err != nil || value <= 0 {
+			return fmt.Errorf("invalid %v: %q", param.env, os.Getenv(param.env))
+		}
+		*param.value = value
+	}
+
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+		session.IPLimiter, session.EmailLimiter = ratelimit.NewMemory(ipRate), ratelimit.NewMemory(emailRate)
+		passwordreset.EmailLimiter = ratelimit.NewMemory(resetRate)
+	case "postgres":
+		session.IPLimiter = ratelimit.NewPostgres(postgres.DB, "login:ip:", ipRate)
+		session.EmailLimiter = ratelimit.NewPostgres(postgres.DB, "login:email:", emailRate)
+		passwordreset.EmailLimiter = ratelimit.NewPostgres(postgres.DB, "reset:email:", resetRate)
+	default:
+		return fmt.Errorf("unknown RATE_LIMIT_STORE %q", os.Getenv("RATE_LIMIT_STORE"))
+	}
+	session.Lockout = lockout
+
+	return nil
 }
 
 func main() {
@@ -42,6 +361,163 @@ func main() {
 		log.Fatalf("couldn't connect to postgres: %v", err)
 	}
 
+	// Cache validated sessions and their users in memory, dropping them as soon as any replica revokes the session or
+	// changes the user
+	notifier, err := sessioncache.NewPQNotifier(postgres.ConnectionString(os.Getenv("PG_HOST"), os.Getenv("PG_PORT"), os.Getenv("PG_USER"), os.Getenv("PG_PASS"), os.Getenv("PG_DB_NAME")))
+	if err != nil {
+		log.Fatalf("couldn't listen for session revocations: %v", err)
+	}
+	sessions := sessioncache.New(postgres.DB, sessionCacheSize, sessionCacheTTL)
+	go sessions.Listen(notifier)
+	auth.Sessions = sessions
+
+	// Only trust X-Forwarded-For when running behind a proxy that sets it, and then only the TRUSTED_PROXY_HOPS
+	// addresses our proxies appended
+	auth.TrustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+	if os.Getenv("TRUSTED_PROXY_HOPS") != "" {
+		auth.TrustedProxyHops, err = strconv.Atoi(os.Getenv("TRUSTED_PROXY_HOPS"))
+		if err != nil || auth.TrustedProxyHops < 1 {
+			log.Fatalf("invalid TRUSTED_PROXY_HOPS: %q", os.Getenv("TRUSTED_PROXY_HOPS"))
+		}
+	}
+
+	// Verification emails go out over SMTP, without a relay they're logged and dropped. Outside of production the log
+	// has the whole email, so tokens can be copied out of it while developing.
+	if os.Getenv("SMTP_HOST") != "" {
+		mailer.Default = mailer.NewSMTP(os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASS"), os.Getenv("SMTP_FROM"))
+	} else {
+		mailer.Default = mailer.Log{ShowBody: os.Getenv("GO_ENV") != "production"}
+		log.Printf("SMTP_HOST isn't set, emails won't be delivered")
+	}
+	user.VerificationURL = os.Getenv("VERIFICATION_URL")
+	passwordreset.ResetURL = os.Getenv("PASSWORD_RESET_URL")
+	session.RequireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	// Requests that don't name an organization are for DEFAULT_ORGANIZATION, the one the migrations seed otherwise
+	if os.Getenv("DEFAULT_ORGANIZATION") != "" {
+		tenant.DefaultSlug = os.Getenv("DEFAULT_ORGANIZATION")
+	}
+
+	err = loadLoginThrottling()
+	if err != nil {
+		log.Fatalf("couldn't configure login throttling: %v", err)
+	}
+
+	// TOTP secrets are sealed with MFA_ENCRYPTION_KEY, a base64 encoded 32 byte key, without it MFA can't be turned on
+	if os.Getenv("MFA_ENCRYPTION_KEY") != "" {
+		mfa.Secrets, err = secrets.NewBoxFromBase64(os.Getenv("MFA_ENCRYPTION_KEY"))
+		if err != nil {
+			log.Fatalf("couldn't load MFA_ENCRYPTION_KEY: %v", err)
+		}
+	} else {
+		log.Printf("MFA_ENCRYPTION_KEY isn't set, users won't be able to turn on MFA")
+	}
+	if os.Getenv("MFA_ISSUER") != "" {
+		mfa.Issuer = os.Getenv("MFA_ISSUER")
+	}
+
+	// Passkeys are scoped to WEBAUTHN_RP_ID, the domain the frontend is served from, and only accepted from WEBAUTHN_ORIGINS
+	if os.Getenv("WEBAUTHN_RP_ID") != "" {
+		webauthn.Default = &webauthn.RelyingParty{
+			ID:                      os.Getenv("WEBAUTHN_RP_ID"),
+			Name:                    os.Getenv("WEBAUTHN_RP_NAME"),
+			Origins:                 []string{"https://" + os.Getenv("WEBAUTHN_RP_ID")},
+			RequireUserVerification: os.Getenv("WEBAUTHN_REQUIRE_USER_VERIFICATION") == "true",
+		}
+		if webauthn.Default.Name == "" {
+			webauthn.Default.Name = webauthn.Default.ID
+		}
+		if os.Getenv("WEBAUTHN_ORIGINS") != "" {
+			webauthn.Default.Origins = strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",")
+		}
+	} else {
+		log.Printf("WEBAUTHN_RP_ID isn't set, users won't be able to use passkeys")
+	}
+
+	err = loadIdentityProviders()
+	if err != nil {
+		log.Fatalf("couldn't configure identity providers: %v", err)
+	}
+
+	// OpenID Connect clients find us at ISSUER_URL and check it's the iss of our tokens, it's also where the token and
+	// userinfo endpoints are. Users log in and consent at OIDC_AUTHORIZATION_URL, a page on the frontend.
+	if os.Getenv("ISSUER_URL") != "" {
+		tokens.Issuer = strings.TrimSuffix(os.Getenv("ISSUER_URL"), "/")
+		oidc.AuthorizationURL = os.Getenv("OIDC_AUTHORIZATION_URL")
+	} else {
+		log.Printf("ISSUER_URL isn't set, OpenID Connect discovery is disabled")
+	}
+
+	password.Default, err = loadPasswordHasher()
+	if err != nil {
+		log.Fatalf("couldn't configure password hashing: %v", err)
+	}
+
+	// Make the dummy hash unknown logins are compared against now, so the first one isn't slower than the rest
+	_, err = password.DummyHash()
+	if err != nil {
+		log.Fatalf("couldn't configure password hashing: %v", err)
+	}
+
+	password.Breaches, err = loadBreachChecker()
+	if err != nil {
+		log.Fatalf("couldn't configure breached password checks: %v", err)
+	}
+	password.FailOpen = os.Getenv("BREACH_FAIL_OPEN") == "true"
+
+	if os.Getenv("PASSWORD_POLICY_FILE") != "" {
+		password.DefaultPolicy, err = password.LoadPolicy(os.Getenv("PASSWORD_POLICY_FILE"))
+		if err != nil {
+			log.Fatalf("couldn't load password policy: %v", err)
+		}
+	}
+
+	signing.Keys, err = loadSigningKeys()
+	if err != nil {
+		log.Fatalf("couldn't load signing keys: %v", err)
+	}
+
+	// Deleted users can be restored for USER_RETENTION, then they're purged for good. Sessions are kept for
+	// SESSION_RETENTION after they expire or are logged out.
+	retentionPeriods := []struct {
+		env   string
+		value *time.Duration
+	}{
+		{"USER_RETENTION", &retention.UserPeriod},
+		{"SESSION_RETENTION", &retention.SessionPeriod},
+	}
+	for _, param := range retentionPeriods {
+		if os.Getenv(param.env) == "" {
+			continue
+		}
+
+		value, err := time.ParseDuration(os.Getenv(param.env))
+		if err != nil || value <= 0 {
+			log.Fatalf("invalid %v: %q", param.env, os.Getenv(param.env))
+		}
+		*param.value = value
+	}
+
+	// Every replica schedules the purges, whichever one takes the job's lock first runs it and the rest skip that run
+	stop := make(chan struct{})
+	runner := &jobs.Runner{Store: postgres.DB}
+	runner.Host, _ = os.Hostname()
+	runner.Add(jobs.Job{Name: "purge-users", Every: userPurgeInterval, Run: retention.PurgeUsers, Exclusive: true})
+	runner.Add(jobs.Job{Name: "purge-sessions", Every: sessionPurgeInterval, Run: retention.PurgeSessions, Exclusive: true})
+
+	// Rate limit buckets kept in Postgres are keyed by whatever email or address a request came from, so they're
+	// purged once they've refilled, which leaves the limits as they were
+	var limiters []retention.Purger
+	for _, limiter := range []ratelimit.Limiter{session.IPLimiter, session.EmailLimiter, passwordreset.EmailLimiter} {
+		if purger, ok := limiter.(retention.Purger); ok {
+			limiters = append(limiters, purger)
+		}
+	}
+	if len(limiters) > 0 {
+		runner.Add(jobs.Job{Name: "purge-rate-limits", Every: rateLimitPurgeInterval, Run: retention.PurgeRateLimits(limiters...), Exclusive: true})
+	}
+	runner.Start(stop)
+
 	// Setup our mux router, handlers, negroni middleware and logger
 	router, n, recovery := mux.NewRouter().StrictSlash(true), negroni.New(), negroni.NewRecovery()
 	setupLogger(recovery)
@@ -55,11 +531,31 @@ func main() {
 	if os.Getenv("PORT") != "" {
 		port = os.Getenv("PORT")
 	}
-	log.Printf("now serving traffic on port :%v", port)
-	err = http.ListenAndServe(fmt.Sprintf(":%v", port), n)
+	server := &http.Server{Addr: fmt.Sprintf(":%v", port), Handler: n}
+	go func() {
+		log.Printf("now serving traffic on port :%v", port)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGINT or SIGTERM stop taking requests and let the ones in flight finish, then stop the jobs, a job that's
+	// running finishes the batch it's on and lets go of its lock
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	log.Printf("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(ctx)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("couldn't finish serving requests: %v", err)
 	}
+
+	close(stop)
+	runner.Wait()
 }
 
 // Ensures all requests are Content-Type application/json
@@ -0,0 +1,100 @@
+// Command oauthclient registers an OAuth client, printing its client_id and, for confidential clients, its
+// client_secret. The secret is only stored hashed, so it's only ever shown here. It connects to the database
+// with the same PG_* environment variables as the server.
+//
+// Usage:
+//
+//	oauthclient -name "Fender Tune" -redirect-uris com.fender.tune:/callback -public
+//	oauthclient -name "Partner" -grant-types client_credentials -scopes profile
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/oauth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+func main() {
+	name := flag.String("name", "", "the name users are shown when asked to consent")
+	redirectURIs := flag.String("redirect-uris", "", "comma separated redirect URIs, required for authorization_code")
+	grantTypes := flag.String("grant-types", "authorization_code,refresh_token", "comma separated grant types")
+	scopes := flag.String("scopes", "profile", "comma separated scopes the client can be granted")
+	public := flag.Bool("public", false, "the client can't keep a secret, ex: a mobile or single page app")
+	flag.Parse()
+
+	if *name == "" {
+		log.Fatalf("-name is required")
+	}
+
+	for _, grantType := range split(*grantTypes) {
+		if !contains(oauth.GrantTypes, grantType) {
+			log.Fatalf("unknown grant type %q", grantType)
+		}
+		if grantType == oauth.GrantClientCredentials && *public {
+			log.Fatalf("public clients can't use client_credentials")
+		}
+		if grantType == oauth.GrantAuthorizationCode && *redirectURIs == "" {
+			log.Fatalf("-redirect-uris is required for authorization_code")
+		}
+	}
+
+	for _, scope := range split(*scopes) {
+		if _, ok := oauth.Scopes[scope]; !ok {
+			log.Fatalf("unknown scope %q", scope)
+		}
+	}
+
+	clientID, err := randtoken.Generate()
+	if err != nil {
+		log.Fatalf("couldn't generate client_id: %v", err)
+	}
+
+	secret, secretHash := "", ""
+	if !*public {
+		secret, err = randtoken.Generate()
+		if err != nil {
+			log.Fatalf("couldn't generate client_secret: %v", err)
+		}
+		secretHash = randtoken.Hash(secret)
+	}
+
+	db, err := postgres.CreateDatabase(os.Getenv("PG_HOST"), os.Getenv("PG_PORT"), os.Getenv("PG_USER"), os.Getenv("PG_PASS"), os.Getenv("PG_DB_NAME"))
+	if err != nil {
+		log.Fatalf("couldn't connect to the database: %v", err)
+	}
+
+	client, err := db.CreateOAuthClient(clientID, secretHash, *name, split(*redirectURIs), split(*grantTypes), split(*scopes))
+	if err != nil {
+		log.Fatalf("couldn't register client: %v", err)
+	}
+
+	fmt.Printf("client_id: %v\n", client.ClientID)
+	if secret != "" {
+		fmt.Printf("client_secret: %v\n", secret)
+	}
+}
+
+func split(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if strings.TrimSpace(value) != "" {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Command organization creates an organization, or makes an existing user a member of one so they can switch to it
+// with POST /sessions/switch-org. Creating an organization that already exists leaves it as it is. It connects to
+// the database with the same PG_* environment variables as the server.
+//
+// Usage:
+//
+//	organization -slug gibson -name "Gibson"
+//	organization -slug gibson -member ops@fender.com -home default
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+func main() {
+	slug := flag.String("slug", "", "the slug the organization is named by when logging in")
+	name := flag.String("name", "", "the organization's display name, creates the organization")
+	member := flag.String("member", "", "the email address of a user to make a member of the organization")
+	home := flag.String("home", "default", "the slug of the organization the member signed up in")
+	flag.Parse()
+
+	if *slug == "" {
+		log.Fatalf("-slug is required")
+	}
+	if *name == "" && *member == "" {
+		log.Fatalf("one of -name or -member is required")
+	}
+
+	db, err := postgres.CreateDatabase(os.Getenv("PG_HOST"), os.Getenv("PG_PORT"), os.Getenv("PG_USER"), os.Getenv("PG_PASS"), os.Getenv("PG_DB_NAME"))
+	if err != nil {
+		log.Fatalf("couldn't connect to the database: %v", err)
+	}
+
+	if *name != "" {
+		_, err = db.CreateOrganization(*slug, *name)
+		if err != nil {
+			log.Fatalf("couldn't create the organization: %v", err)
+		}
+	}
+
+	organization, err := db.GetOrganizationBySlug(*slug)
+	if err != nil {
+		log.Fatalf("couldn't look up the organization: %v", err)
+	}
+	if organization.UUID == "" {
+		log.Fatalf("no organization has the slug %q", *slug)
+	}
+
+	if *member != "" {
+		signedUpIn, err := db.GetOrganizationBySlug(*home)
+		if err != nil {
+			log.Fatalf("couldn't look up the organization: %v", err)
+		}
+		if signedUpIn.UUID == "" {
+			log.Fatalf("no organization has the slug %q", *home)
+		}
+
+		user, err := db.GetUserByEmail(signedUpIn.UUID, *member)
+		if err != nil {
+			log.Fatalf("couldn't look up the user: %v", err)
+		}
+		if user.UUID == "" {
+			log.Fatalf("no user in %v has the email address %q", *home, *member)
+		}
+
+		_, err = db.CreateMembership(organization.UUID, user.UUID)
+		if err != nil {
+			log.Fatalf("couldn't add the member: %v", err)
+		}
+
+		fmt.Printf("%v is a member of %v\n", user.UUID, organization.Slug)
+	}
+
+	fmt.Printf("%v: %v %v\n", organization.UUID, organization.Slug, organization.Name)
+}
@@ -0,0 +1,40 @@
+// Command pwnedindex builds the index BREACH_CHECKER=local needs to look up ranges in a Pwned Passwords dump.
+//
+// Usage:
+//
+//	pwnedindex pwned-passwords-sha1-ordered-by-hash-v4.txt pwned-passwords.idx
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatalf("usage: %v <dump> <index>", os.Args[0])
+	}
+
+	dump, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatalf("couldn't open dump: %v", err)
+	}
+	defer dump.Close()
+
+	index, err := os.Create(os.Args[2])
+	if err != nil {
+		log.Fatalf("couldn't create index: %v", err)
+	}
+
+	err = password.BuildRangeIndex(dump, index)
+	if err != nil {
+		log.Fatalf("couldn't build index: %v", err)
+	}
+
+	err = index.Close()
+	if err != nil {
+		log.Fatalf("couldn't write index: %v", err)
+	}
+}
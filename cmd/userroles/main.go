@@ -0,0 +1,100 @@
+// Command userroles sets a user's roles in an organization, replacing whatever they had there. It's how the first
+// admin of an organization is made, after that admins can manage roles with PUT /admin/users/{uuid}. The user is
+// looked up by email address in the organization they signed up in, which is the one given unless -home says
+// otherwise. It connects to the database with the same PG_* environment variables as the server.
+//
+// Usage:
+//
+//	userroles -email ops@fender.com -roles admin
+//	userroles -organization gibson -email ops@fender.com -home default -roles support
+//	userroles -email ops@fender.com -roles ""
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+func main() {
+	organization := flag.String("organization", "default", "the slug of the organization the roles are in")
+	home := flag.String("home", "", "the slug of the organization the user signed up in, if it isn't -organization")
+	email := flag.String("email", "", "the email address of the user")
+	roles := flag.String("roles", "", "comma separated roles the user should have, empty takes them all away")
+	flag.Parse()
+
+	if *email == "" {
+		log.Fatalf("-email is required")
+	}
+
+	db, err := postgres.CreateDatabase(os.Getenv("PG_HOST"), os.Getenv("PG_PORT"), os.Getenv("PG_USER"), os.Getenv("PG_PASS"), os.Getenv("PG_DB_NAME"))
+	if err != nil {
+		log.Fatalf("couldn't connect to the database: %v", err)
+	}
+
+	if *home == "" {
+		*home = *organization
+	}
+
+	found, err := db.GetOrganizationBySlug(*organization)
+	if err != nil {
+		log.Fatalf("couldn't look up the organization: %v", err)
+	}
+	if found.UUID == "" {
+		log.Fatalf("no organization has the slug %q", *organization)
+	}
+
+	signedUpIn, err := db.GetOrganizationBySlug(*home)
+	if err != nil {
+		log.Fatalf("couldn't look up the organization: %v", err)
+	}
+	if signedUpIn.UUID == "" {
+		log.Fatalf("no organization has the slug %q", *home)
+	}
+
+	user, err := db.GetUserByEmail(signedUpIn.UUID, *email)
+	if err != nil {
+		log.Fatalf("couldn't look up the user: %v", err)
+	}
+	if user.UUID == "" {
+		log.Fatalf("no user in %v has the email address %q", *home, *email)
+	}
+
+	// Roles belong to a membership, so the user has to be a member first, see the organization command
+	membership, err := db.GetMembership(found.UUID, user.UUID)
+	if err != nil {
+		log.Fatalf("couldn't look up the membership: %v", err)
+	}
+	if membership.UserUUID == "" {
+		log.Fatalf("%v isn't a member of %v", *email, *organization)
+	}
+
+	names := split(*roles)
+	set, err := db.SetMembershipRoles(found.UUID, user.UUID, names)
+	if err != nil {
+		log.Fatalf("couldn't set roles: %v", err)
+	}
+	if set != len(names) {
+		log.Fatalf("unknown role in %q", *roles)
+	}
+
+	fmt.Printf("%v in %v: %v\n", user.UUID, found.Slug, strings.Join(names, ", "))
+}
+
+// split returns the distinct values in a comma separated list
+func split(list string) []string {
+	values := []string{}
+	seen := map[string]bool{}
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
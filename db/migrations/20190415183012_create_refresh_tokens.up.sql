@@ -0,0 +1,11 @@
+create table refresh_tokens (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  session_uuid uuid NOT NULL REFERENCES sessions (uuid),
+  token_hash text UNIQUE NOT NULL,
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz,
+  revoked_at timestamptz
+);
+
+create index refresh_tokens_session_uuid_idx on refresh_tokens (session_uuid);
@@ -0,0 +1,2 @@
+drop trigger sessions_notify_revoked on sessions;
+drop function notify_session_revoked();
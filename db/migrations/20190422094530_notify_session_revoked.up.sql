@@ -0,0 +1,12 @@
+create function notify_session_revoked() returns trigger as $$
+begin
+  perform pg_notify('session_revoked', NEW.uuid::text);
+  return NEW;
+end;
+$$ language plpgsql;
+
+create trigger sessions_notify_revoked
+  after update of deleted_at on sessions
+  for each row
+  when (OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL)
+  execute procedure notify_session_revoked();
@@ -0,0 +1,5 @@
+drop index sessions_user_uuid_idx;
+
+alter table sessions drop column last_seen_at;
+alter table sessions drop column ip_address;
+alter table sessions drop column user_agent;
@@ -0,0 +1,8 @@
+alter table sessions add column user_agent text NOT NULL DEFAULT '';
+alter table sessions add column ip_address text NOT NULL DEFAULT '';
+alter table sessions add column last_seen_at timestamptz;
+
+update sessions set last_seen_at = created_at;
+alter table sessions alter column last_seen_at set NOT NULL;
+
+create index sessions_user_uuid_idx on sessions (user_uuid);
@@ -0,0 +1,2 @@
+drop table verification_tokens cascade;
+alter table users drop column verified_at;
@@ -0,0 +1,13 @@
+alter table users add column verified_at timestamptz;
+
+create table verification_tokens (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  email text NOT NULL,
+  token_hash text UNIQUE NOT NULL,
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz
+);
+
+create index verification_tokens_user_uuid_idx on verification_tokens (user_uuid);
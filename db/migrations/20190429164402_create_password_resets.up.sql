@@ -0,0 +1,10 @@
+create table password_resets (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  token_hash text UNIQUE NOT NULL,
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz
+);
+
+create index password_resets_user_uuid_idx on password_resets (user_uuid);
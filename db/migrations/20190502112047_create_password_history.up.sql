@@ -0,0 +1,11 @@
+create table password_history (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  password text NOT NULL,
+  created_at timestamptz NOT NULL
+);
+
+create index password_history_user_uuid_created_at_idx on password_history (user_uuid, created_at DESC);
+
+-- Everyone's current password is the start of their history
+insert into password_history (user_uuid, password, created_at) select uuid, password, updated_at from users;
@@ -0,0 +1,3 @@
+drop table rate_limits cascade;
+alter table users drop column locked_until;
+alter table users drop column failed_login_count;
@@ -0,0 +1,8 @@
+alter table users add column failed_login_count integer NOT NULL DEFAULT 0;
+alter table users add column locked_until timestamptz;
+
+create table rate_limits (
+  key text PRIMARY KEY,
+  tokens double precision NOT NULL,
+  updated_at timestamptz NOT NULL
+);
@@ -0,0 +1,3 @@
+drop table mfa_challenges cascade;
+drop table recovery_codes cascade;
+drop table totp_credentials cascade;
@@ -0,0 +1,28 @@
+create table totp_credentials (
+  user_uuid uuid PRIMARY KEY REFERENCES users (uuid),
+  secret text NOT NULL,
+  created_at timestamptz NOT NULL,
+  confirmed_at timestamptz,
+  last_used_step bigint NOT NULL DEFAULT 0
+);
+
+create table recovery_codes (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  code_hash text NOT NULL,
+  created_at timestamptz NOT NULL,
+  used_at timestamptz,
+  UNIQUE (user_uuid, code_hash)
+);
+
+create table mfa_challenges (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  token_hash text UNIQUE NOT NULL,
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz,
+  attempts integer NOT NULL DEFAULT 0
+);
+
+create index mfa_challenges_user_uuid_idx on mfa_challenges (user_uuid);
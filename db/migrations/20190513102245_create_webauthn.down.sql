@@ -0,0 +1,2 @@
+drop table webauthn_challenges cascade;
+drop table webauthn_credentials cascade;
@@ -0,0 +1,22 @@
+create table webauthn_credentials (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  credential_id text UNIQUE NOT NULL,
+  public_key bytea NOT NULL,
+  sign_count bigint NOT NULL DEFAULT 0,
+  name text NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL,
+  last_used_at timestamptz
+);
+
+create index webauthn_credentials_user_uuid_idx on webauthn_credentials (user_uuid);
+
+create table webauthn_challenges (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid REFERENCES users (uuid),
+  ceremony text NOT NULL,
+  challenge_hash text UNIQUE NOT NULL,
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz
+);
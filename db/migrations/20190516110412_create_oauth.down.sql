@@ -0,0 +1,4 @@
+alter table sessions drop column scope;
+alter table sessions drop column client_id;
+drop table oauth_authorization_codes cascade;
+drop table oauth_clients cascade;
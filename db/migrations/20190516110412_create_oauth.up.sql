@@ -0,0 +1,30 @@
+create table oauth_clients (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  client_id text UNIQUE NOT NULL,
+  secret_hash text NOT NULL DEFAULT '',
+  name text NOT NULL,
+  redirect_uris text[] NOT NULL DEFAULT '{}',
+  grant_types text[] NOT NULL DEFAULT '{}',
+  scopes text[] NOT NULL DEFAULT '{}',
+  created_at timestamptz NOT NULL,
+  deleted_at timestamptz
+);
+
+create table oauth_authorization_codes (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  code_hash text UNIQUE NOT NULL,
+  client_id text NOT NULL REFERENCES oauth_clients (client_id),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  redirect_uri text NOT NULL,
+  scope text NOT NULL DEFAULT '',
+  code_challenge text NOT NULL DEFAULT '',
+  code_challenge_method text NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz
+);
+
+create index oauth_authorization_codes_user_uuid_idx on oauth_authorization_codes (user_uuid);
+
+alter table sessions add column client_id text NOT NULL DEFAULT '';
+alter table sessions add column scope text NOT NULL DEFAULT '';
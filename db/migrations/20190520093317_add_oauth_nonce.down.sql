@@ -0,0 +1 @@
+alter table oauth_authorization_codes drop column nonce;
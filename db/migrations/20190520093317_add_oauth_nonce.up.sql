@@ -0,0 +1 @@
+alter table oauth_authorization_codes add column nonce text NOT NULL DEFAULT '';
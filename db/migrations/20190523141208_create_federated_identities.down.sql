@@ -0,0 +1,2 @@
+drop table federated_login_states cascade;
+drop table federated_identities cascade;
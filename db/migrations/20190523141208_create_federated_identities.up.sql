@@ -0,0 +1,21 @@
+create table federated_identities (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  provider text NOT NULL,
+  subject text NOT NULL,
+  email text NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL,
+  UNIQUE (provider, subject)
+);
+
+create index federated_identities_user_uuid_idx on federated_identities (user_uuid);
+
+create table federated_login_states (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  provider text NOT NULL,
+  state_hash text UNIQUE NOT NULL,
+  code_verifier text NOT NULL,
+  created_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz
+);
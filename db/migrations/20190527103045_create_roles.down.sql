@@ -0,0 +1,4 @@
+drop table user_roles cascade;
+drop table role_permissions cascade;
+drop table permissions cascade;
+drop table roles cascade;
@@ -0,0 +1,38 @@
+create table roles (
+  name text PRIMARY KEY,
+  description text NOT NULL DEFAULT ''
+);
+
+create table permissions (
+  name text PRIMARY KEY,
+  description text NOT NULL DEFAULT ''
+);
+
+create table role_permissions (
+  role_name text NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+  permission_name text NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+  PRIMARY KEY (role_name, permission_name)
+);
+
+create table user_roles (
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  role_name text NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+  created_at timestamptz NOT NULL,
+  PRIMARY KEY (user_uuid, role_name)
+);
+
+insert into permissions (name, description) values
+  ('users:read', 'Look up and search users'),
+  ('users:write', 'Update and delete users'),
+  ('sessions:revoke', 'Log users out of every session');
+
+insert into roles (name, description) values
+  ('admin', 'Manages users'),
+  ('support', 'Helps users with their accounts');
+
+insert into role_permissions (role_name, permission_name) values
+  ('admin', 'users:read'),
+  ('admin', 'users:write'),
+  ('admin', 'sessions:revoke'),
+  ('support', 'users:read'),
+  ('support', 'sessions:revoke');
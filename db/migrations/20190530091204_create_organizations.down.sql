@@ -0,0 +1,18 @@
+-- Roles held outside a user's own organization have nowhere to go
+delete from user_roles using users where users.uuid = user_roles.user_uuid AND users.organization_uuid <> user_roles.organization_uuid;
+alter table user_roles drop constraint user_roles_organization_uuid_user_uuid_fkey;
+alter table user_roles drop constraint user_roles_pkey;
+alter table user_roles drop column organization_uuid;
+alter table user_roles add PRIMARY KEY (user_uuid, role_name);
+
+alter table mfa_challenges drop column organization_uuid;
+alter table sessions drop column organization_uuid;
+
+drop table memberships cascade;
+
+-- This fails if two organizations have users with the same email address, they have to be merged by hand first
+alter table users drop constraint users_organization_uuid_email_key;
+alter table users add constraint users_email_key UNIQUE (email);
+alter table users drop column organization_uuid;
+
+drop table organizations cascade;
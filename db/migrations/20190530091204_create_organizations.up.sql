@@ -0,0 +1,44 @@
+create table organizations (
+  uuid uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  slug text UNIQUE NOT NULL,
+  name text NOT NULL,
+  created_at timestamptz NOT NULL
+);
+
+-- Everyone who signed up before there were organizations belongs to the default one
+insert into organizations (slug, name, created_at) values ('default', 'Default', now());
+
+alter table users add column organization_uuid uuid REFERENCES organizations (uuid);
+update users set organization_uuid = (select uuid from organizations where slug = 'default');
+alter table users alter column organization_uuid set NOT NULL;
+
+-- Email addresses only have to be unique within an organization
+alter table users drop constraint users_email_key;
+alter table users add constraint users_organization_uuid_email_key UNIQUE (organization_uuid, email);
+
+create table memberships (
+  organization_uuid uuid NOT NULL REFERENCES organizations (uuid),
+  user_uuid uuid NOT NULL REFERENCES users (uuid),
+  created_at timestamptz NOT NULL,
+  PRIMARY KEY (organization_uuid, user_uuid)
+);
+
+create index memberships_user_uuid_idx on memberships (user_uuid);
+
+insert into memberships (organization_uuid, user_uuid, created_at) select organization_uuid, uuid, created_at from users;
+
+alter table sessions add column organization_uuid uuid REFERENCES organizations (uuid);
+update sessions set organization_uuid = users.organization_uuid from users where users.uuid = sessions.user_uuid;
+alter table sessions alter column organization_uuid set NOT NULL;
+
+alter table mfa_challenges add column organization_uuid uuid REFERENCES organizations (uuid);
+update mfa_challenges set organization_uuid = users.organization_uuid from users where users.uuid = mfa_challenges.user_uuid;
+alter table mfa_challenges alter column organization_uuid set NOT NULL;
+
+-- Roles are held in an organization, an admin of one has no say over another
+alter table user_roles add column organization_uuid uuid;
+update user_roles set organization_uuid = users.organization_uuid from users where users.uuid = user_roles.user_uuid;
+alter table user_roles alter column organization_uuid set NOT NULL;
+alter table user_roles drop constraint user_roles_pkey;
+alter table user_roles add PRIMARY KEY (organization_uuid, user_uuid, role_name);
+alter table user_roles add FOREIGN KEY (organization_uuid, user_uuid) REFERENCES memberships (organization_uuid, user_uuid) ON DELETE CASCADE;
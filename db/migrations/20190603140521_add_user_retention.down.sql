@@ -0,0 +1,20 @@
+drop index users_deleted_at_idx;
+
+alter table sessions drop constraint sessions_user_uuid_fkey, add constraint sessions_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table refresh_tokens drop constraint refresh_tokens_session_uuid_fkey, add constraint refresh_tokens_session_uuid_fkey FOREIGN KEY (session_uuid) REFERENCES sessions (uuid);
+alter table verification_tokens drop constraint verification_tokens_user_uuid_fkey, add constraint verification_tokens_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table password_resets drop constraint password_resets_user_uuid_fkey, add constraint password_resets_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table password_history drop constraint password_history_user_uuid_fkey, add constraint password_history_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table totp_credentials drop constraint totp_credentials_user_uuid_fkey, add constraint totp_credentials_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table recovery_codes drop constraint recovery_codes_user_uuid_fkey, add constraint recovery_codes_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table mfa_challenges drop constraint mfa_challenges_user_uuid_fkey, add constraint mfa_challenges_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table webauthn_credentials drop constraint webauthn_credentials_user_uuid_fkey, add constraint webauthn_credentials_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table webauthn_challenges drop constraint webauthn_challenges_user_uuid_fkey, add constraint webauthn_challenges_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table oauth_authorization_codes drop constraint oauth_authorization_codes_user_uuid_fkey, add constraint oauth_authorization_codes_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table federated_identities drop constraint federated_identities_user_uuid_fkey, add constraint federated_identities_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table memberships drop constraint memberships_user_uuid_fkey, add constraint memberships_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+alter table user_roles drop constraint user_roles_user_uuid_fkey, add constraint user_roles_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid);
+
+-- This fails if a deleted user's address has been signed up with again, one of them has to be purged by hand first
+drop index users_organization_uuid_email_key;
+alter table users add constraint users_organization_uuid_email_key UNIQUE (organization_uuid, email);
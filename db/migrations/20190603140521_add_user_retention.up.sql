@@ -0,0 +1,22 @@
+-- Email addresses only have to be unique among live users, so a deleted user's address can be signed up with again
+alter table users drop constraint users_organization_uuid_email_key;
+create unique index users_organization_uuid_email_key on users (organization_uuid, email) WHERE deleted_at IS NULL;
+
+-- Purging a user takes everything of theirs with it
+alter table sessions drop constraint sessions_user_uuid_fkey, add constraint sessions_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table refresh_tokens drop constraint refresh_tokens_session_uuid_fkey, add constraint refresh_tokens_session_uuid_fkey FOREIGN KEY (session_uuid) REFERENCES sessions (uuid) ON DELETE CASCADE;
+alter table verification_tokens drop constraint verification_tokens_user_uuid_fkey, add constraint verification_tokens_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table password_resets drop constraint password_resets_user_uuid_fkey, add constraint password_resets_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table password_history drop constraint password_history_user_uuid_fkey, add constraint password_history_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table totp_credentials drop constraint totp_credentials_user_uuid_fkey, add constraint totp_credentials_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table recovery_codes drop constraint recovery_codes_user_uuid_fkey, add constraint recovery_codes_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table mfa_challenges drop constraint mfa_challenges_user_uuid_fkey, add constraint mfa_challenges_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table webauthn_credentials drop constraint webauthn_credentials_user_uuid_fkey, add constraint webauthn_credentials_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table webauthn_challenges drop constraint webauthn_challenges_user_uuid_fkey, add constraint webauthn_challenges_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table oauth_authorization_codes drop constraint oauth_authorization_codes_user_uuid_fkey, add constraint oauth_authorization_codes_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table federated_identities drop constraint federated_identities_user_uuid_fkey, add constraint federated_identities_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table memberships drop constraint memberships_user_uuid_fkey, add constraint memberships_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+alter table user_roles drop constraint user_roles_user_uuid_fkey, add constraint user_roles_user_uuid_fkey FOREIGN KEY (user_uuid) REFERENCES users (uuid) ON DELETE CASCADE;
+
+-- So finding who's due to be purged doesn't read every live user
+create index users_deleted_at_idx on users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,8 @@
+delete from roles where name = 'operator';
+delete from permissions where name = 'jobs:read';
+alter table roles drop column global;
+
+drop index sessions_deleted_at_idx;
+drop index sessions_expires_at_idx;
+
+drop table job_runs;
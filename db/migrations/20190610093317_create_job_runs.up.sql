@@ -0,0 +1,25 @@
+create table job_runs (
+  name text PRIMARY KEY,
+  host text NOT NULL DEFAULT '',
+  started_at timestamptz NOT NULL,
+  finished_at timestamptz NOT NULL,
+  processed integer NOT NULL DEFAULT 0,
+  error text NOT NULL DEFAULT ''
+);
+
+-- The session sweeper looks for sessions that ended a while ago, either by expiring or being logged out
+create index sessions_expires_at_idx on sessions (expires_at);
+create index sessions_deleted_at_idx on sessions (deleted_at) WHERE deleted_at IS NOT NULL;
+
+insert into permissions (name, description) values
+  ('jobs:read', 'See how background jobs last ran');
+
+-- Jobs run for the whole deployment rather than one organization, so they get a role of their own. Global roles
+-- are only given out by whoever runs the deployment, never by an organization's admins.
+alter table roles add column global boolean NOT NULL DEFAULT false;
+
+insert into roles (name, description, global) values
+  ('operator', 'Watches over the deployment', true);
+
+insert into role_permissions (role_name, permission_name) values
+  ('operator', 'jobs:read');
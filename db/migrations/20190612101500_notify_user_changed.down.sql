@@ -0,0 +1,2 @@
+drop trigger users_notify_changed on users;
+drop function notify_user_changed();
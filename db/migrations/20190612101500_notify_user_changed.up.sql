@@ -0,0 +1,14 @@
+create function notify_user_changed() returns trigger as $$
+begin
+  perform pg_notify('user_changed', NEW.uuid::text);
+  return NEW;
+end;
+$$ language plpgsql;
+
+-- Sessions are cached along with their user, so every change to a user (including deleting them) has to reach
+-- the caches
+create trigger users_notify_changed
+  after update on users
+  for each row
+  when (OLD IS DISTINCT FROM NEW)
+  execute procedure notify_user_changed();
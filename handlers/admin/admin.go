@@ -0,0 +1,484 @@
+// Package admin has the handlers operators use to manage other users' accounts. Every handler expects to sit behind
+// auth.RequirePermission, they don't check who's calling themselves. An operator only ever sees the users who
+// belong to the organization their session is in, a user anywhere else looks like one that doesn't exist.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/handlers/user"
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/retention"
+)
+
+// DefaultLimit and MaxLimit bound how many users List returns at once
+const (
+	DefaultLimit = 50
+	MaxLimit     = 100
+)
+
+// List is a handler that pages through users, oldest first. They can be narrowed down with the "search",
+// "email" (a prefix), "name", "created_after" and "created_before" (RFC 3339 times) query parameters, and deleted
+// users are included with "include_deleted=true". When there are more users the response has a "next_cursor", and a
+// Link header to the next page, to send back as "cursor" along with the same filters.
+func List(w http.ResponseWriter, r *http.Request) {
+	session, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := DefaultLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > MaxLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf(`{"message": "limit must be between 1 and %v"}`, MaxLimit)))
+			return
+		}
+		limit = parsed
+	}
+
+	filter := postgres.UserFilter{
+		Search:         query.Get("search"),
+		EmailPrefix:    query.Get("email"),
+		Name:           query.Get("name"),
+		IncludeDeleted: query.Get("include_deleted") == "true",
+	}
+
+	for _, param := range []struct {
+		name string
+		time **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+	} {
+		if query.Get(param.name) == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, query.Get(param.name))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v must be an RFC 3339 time"}`, param.name)))
+			return
+		}
+		*param.time = &parsed
+	}
+
+	users, next, err := postgres.DB.ListUsers(session.OrganizationUUID, filter, query.Get("cursor"), limit)
+	if err == postgres.ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "cursor is invalid"}`))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(listResponse{Users: users, NextCursor: next})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// The next page is the same request with the cursor swapped in
+	if next != "" {
+		query.Set("cursor", next)
+		nextURL := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		w.Header().Set("Link", fmt.Sprintf(`<%v>; rel="next"`, nextURL.String()))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Get is a handler that returns a user along with their roles
+func Get(w http.ResponseWriter, r *http.Request) {
+	found, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	writeUser(w, r, found)
+}
+
+// Update is a handler that changes a user's email address, name or roles. The roles are replaced outright when
+// they're given, though none can be added that grant more than the caller has and global roles can't be changed,
+// and a new email address has to be verified by the user like any other.
+func Update(w http.ResponseWriter, r *http.Request) {
+	parsedBody := updateRequest{}
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if parsedBody.Email != "" {
+		// Check email format validation
+		var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+		if !rxEmail.MatchString(parsedBody.Email) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "Email is invalid"}`))
+			return
+		}
+	}
+
+	found, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Roles go first, an unknown one fails the whole request before anything has changed
+	if parsedBody.Roles != nil {
+		session, _ := auth.SessionFromContext(r.Context())
+		roles := unique(*parsedBody.Roles)
+
+		// Nobody can hand out more than they can do themselves, or they could grant their way up, and the deployment's
+		// roles aren't an organization's to hand out
+		allowed, err := canGrant(session, found.UUID, roles)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+			return
+		}
+
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "Can't grant a role with permissions you don't have, or change a global role"}`))
+			return
+		}
+
+		set, err := postgres.DB.SetMembershipRoles(session.OrganizationUUID, found.UUID, roles)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+			return
+		}
+
+		if set != len(roles) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "Unknown role"}`))
+			return
+		}
+	}
+
+	if parsedBody.Email != "" || parsedBody.Name != "" {
+		updated, err := postgres.DB.UpdateUserByUUID(found.UUID, parsedBody.Email, parsedBody.Name, "", nil)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+			return
+		}
+
+		// The user was deleted since we looked them up
+		if updated.UUID == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "User not found"}`))
+			return
+		}
+
+		if parsedBody.Email != "" && parsedBody.Email != found.Email {
+			err = user.SendVerification(updated)
+			if err != nil {
+				log.Printf("couldn't send verification email to user %v: %v", updated.UUID, err)
+			}
+		}
+
+		found = updated
+	}
+
+	writeUser(w, r, found)
+}
+
+// Delete is a handler that deletes a user and logs them out everywhere
+func Delete(w http.ResponseWriter, r *http.Request) {
+	found, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := postgres.DB.SoftDeleteUserByUUID(found.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	_, err = postgres.DB.SoftDeleteSessionsByUserUUID(found.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(&deleted)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Restore is a handler that undoes deleting a user, as long as it was within retention.UserPeriod and nobody has
+// signed up with their email address since. Their sessions stay logged out, however they were deleted.
+func Restore(w http.ResponseWriter, r *http.Request) {
+	session, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	deleted, err := postgres.DB.GetDeletedOrganizationUserByUUID(session.OrganizationUUID, mux.Vars(r)["uuid"])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Users in other organizations, and users that were never deleted or have been purged, look the same
+	if deleted.UUID == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Deleted user not found"}`))
+		return
+	}
+
+	restorableAfter := retention.RestorableAfter(time.Now())
+	if !deleted.DeletedAt.After(restorableAfter) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(`{"message": "User was deleted too long ago to restore"}`))
+		return
+	}
+
+	restored, err := postgres.DB.RestoreUserByUUID(session.OrganizationUUID, deleted.UUID, restorableAfter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Their address was free to sign up with again once they were deleted
+	if restored.UUID == "" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "Another user has the email address now"}`))
+		return
+	}
+
+	// Users who deleted themselves before that logged them out still have live sessions, which would otherwise
+	// come back with them
+	_, err = postgres.DB.SoftDeleteSessionsByUserUUID(restored.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	writeUser(w, r, restored)
+}
+
+// RevokeSessions is a handler that logs a user out of every session, ex: their account was taken over
+func RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	found, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := postgres.DB.SoftDeleteSessionsByUserUUID(found.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Jobs is a handler that shows how each background job last ran, on whichever replica ran it. Jobs aren't scoped
+// to an organization, every operator sees the same runs.
+func Jobs(w http.ResponseWriter, r *http.Request) {
+	runs, err := postgres.DB.ListJobRuns()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(jobsResponse{Jobs: runs})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// findUser looks up the user named in the path in the session's organization, writing out a 404 and returning false
+// if there isn't one
+func findUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	session, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return models.User{}, false
+	}
+
+	found, err := postgres.DB.GetOrganizationUserByUUID(session.OrganizationUUID, mux.Vars(r)["uuid"])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return found, false
+	}
+
+	// Deleted users, and users in other organizations, aren't returned either
+	if found.UUID == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "User not found"}`))
+		return found, false
+	}
+
+	return found, true
+}
+
+// writeUser writes out the user along with the roles they have now in the session's organization
+func writeUser(w http.ResponseWriter, r *http.Request, found models.User) {
+	session, _ := auth.SessionFromContext(r.Context())
+	roles, err := postgres.DB.ListRolesByMembership(session.OrganizationUUID, found.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(userResponse{User: &found, Roles: roles})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// canGrant reports whether the session's user can give the user the roles in place of the ones they have. Global
+// roles can't be added or taken away at all, they're for whoever runs the deployment to hand out. Other roles can
+// only be added if the session's user holds every permission they grant, roles the user already has are left out
+// so an update that doesn't touch them is fine whoever makes it.
+func canGrant(session models.Session, userUUID string, roles []string) (bool, error) {
+	current, err := postgres.DB.ListRolesByMembership(session.OrganizationUUID, userUUID)
+	if err != nil {
+		return false, err
+	}
+
+	added, changed := []string{}, []string{}
+	for _, role := range roles {
+		if !contains(current, role) {
+			added = append(added, role)
+			changed = append(changed, role)
+		}
+	}
+	for _, role := range current {
+		if !contains(roles, role) {
+			changed = append(changed, role)
+		}
+	}
+	if len(changed) == 0 {
+		return true, nil
+	}
+
+	global, err := postgres.DB.ListGlobalRoles(changed)
+	if err != nil {
+		return false, err
+	}
+	if len(global) > 0 {
+		return false, nil
+	}
+	if len(added) == 0 {
+		return true, nil
+	}
+
+	granted, err := postgres.DB.ListPermissionsByRoles(added)
+	if err != nil {
+		return false, err
+	}
+
+	held, err := postgres.DB.ListPermissionsByMembership(session.OrganizationUUID, session.UserUUID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, permission := range granted {
+		if !contains(held, permission) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// unique drops repeated roles, keeping the order they came in
+func unique(roles []string) []string {
+	seen := map[string]bool{}
+	kept := []string{}
+	for _, role := range roles {
+		if !seen[role] {
+			seen[role] = true
+			kept = append(kept, role)
+		}
+	}
+	return kept
+}
+
+type updateRequest struct {
+	Email string    `json:"email,omitempty"`
+	Name  string    `json:"name,omitempty"`
+	Roles *[]string `json:"roles,omitempty"`
+}
+
+type listResponse struct {
+	Users      []models.User `json:"users"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type jobsResponse struct {
+	Jobs []models.JobRun `json:"jobs"`
+}
+
+type userResponse struct {
+	User  *models.User `json:"user"`
+	Roles []string     `json:"roles"`
+}
@@ -0,0 +1,497 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mailer"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/retention"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+)
+
+// directoryDB keeps users, the organization they belong to and their roles in memory, the only roles that exist
+// are the ones in rolePermissions
+type directoryDB struct {
+	postgres.DBMock
+	users         map[string]models.User
+	organizations map[string]string
+	roles         map[string][]string
+	revokedUser   string
+	lastFilter    postgres.UserFilter
+	lastCursor    string
+	lastLimit     int
+	deletedUser   string
+	updatedEmail  string
+	deleted       map[string]models.User
+}
+
+// ListUsers only knows the search filter, and hands out the cursor "next" when there's more than a page
+func (d *directoryDB) ListUsers(organizationUUID string, filter postgres.UserFilter, cursor string, limit int) ([]models.User, string, error) {
+	d.lastFilter, d.lastCursor, d.lastLimit = filter, cursor, limit
+	if cursor != "" && cursor != "next" {
+		return nil, "", postgres.ErrInvalidCursor
+	}
+
+	users := []models.User{}
+	for _, uuid := range []string{"abc", "def", "ghi"} {
+		user, ok := d.users[uuid]
+		if ok && d.organizations[uuid] == organizationUUID && strings.Contains(user.Email, filter.Search) {
+			users = append(users, user)
+		}
+	}
+
+	if cursor == "next" {
+		users = users[limit:]
+	}
+	if len(users) > limit {
+		return users[:limit], "next", nil
+	}
+	return users, "", nil
+}
+
+func (d *directoryDB) GetOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error) {
+	if d.organizations[uuid] != organizationUUID {
+		return models.User{}, nil
+	}
+	return d.users[uuid], nil
+}
+
+func (d *directoryDB) UpdateUserByUUID(uuid, email, name, plaintextPassword string, ifUpdatedAt *time.Time) (models.User, error) {
+	user := d.users[uuid]
+	if email != "" {
+		user.Email = email
+		d.updatedEmail = email
+	}
+	if name != "" {
+		user.Name = name
+	}
+	d.users[uuid] = user
+	return user, nil
+}
+
+func (d *directoryDB) SoftDeleteUserByUUID(uuid string) (models.User, error) {
+	user := d.users[uuid]
+	deletedAt := time.Now()
+	user.DeletedAt = &deletedAt
+	delete(d.users, uuid)
+	d.deleted[uuid] = user
+	d.deletedUser = uuid
+	return user, nil
+}
+
+func (d *directoryDB) GetDeletedOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error) {
+	if d.organizations[uuid] != organizationUUID {
+		return models.User{}, nil
+	}
+	return d.deleted[uuid], nil
+}
+
+// RestoreUserByUUID can't restore anyone whose address is used by a live user
+func (d *directoryDB) RestoreUserByUUID(organizationUUID, uuid string, deletedAfter time.Time) (models.User, error) {
+	user := d.deleted[uuid]
+	for _, live := range d.users {
+		if live.Email == user.Email {
+			return models.User{}, nil
+		}
+	}
+	if d.organizations[uuid] != organizationUUID || !user.DeletedAt.After(deletedAfter) {
+		return models.User{}, nil
+	}
+	user.DeletedAt = nil
+	d.users[uuid] = user
+	delete(d.deleted, uuid)
+	return user, nil
+}
+
+func (d *directoryDB) SoftDeleteSessionsByUserUUID(userUUID string) (int, error) {
+	d.revokedUser = userUUID
+	return 2, nil
+}
+
+func (d *directoryDB) ListRolesByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return d.roles[organizationUUID+"/"+userUUID], nil
+}
+
+func (d *directoryDB) ListPermissionsByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return d.ListPermissionsByRoles(d.roles[organizationUUID+"/"+userUUID])
+}
+
+func (d *directoryDB) ListPermissionsByRoles(roles []string) ([]string, error) {
+	permissions := []string{}
+	for _, role := range roles {
+		permissions = append(permissions, rolePermissions[role]...)
+	}
+	return permissions, nil
+}
+
+// ListGlobalRoles only knows operator is global
+func (d *directoryDB) ListGlobalRoles(roles []string) ([]string, error) {
+	global := []string{}
+	for _, role := range roles {
+		if role == "operator" {
+			global = append(global, role)
+		}
+	}
+	return global, nil
+}
+
+func (d *directoryDB) SetMembershipRoles(organizationUUID, userUUID string, roles []string) (int, error) {
+	for _, role := range roles {
+		if _, ok := rolePermissions[role]; !ok {
+			return 0, nil
+		}
+	}
+	d.roles[organizationUUID+"/"+userUUID] = roles
+	return len(roles), nil
+}
+
+// rolePermissions are the roles the migrations seed, along with one that can do more than an admin
+var rolePermissions = map[string][]string{
+	"admin":    {auth.PermissionReadUsers, auth.PermissionWriteUsers, auth.PermissionRevokeSessions},
+	"support":  {auth.PermissionReadUsers, auth.PermissionRevokeSessions},
+	"owner":    {auth.PermissionReadUsers, auth.PermissionWriteUsers, auth.PermissionRevokeSessions, "organizations:write"},
+	"operator": {auth.PermissionReadJobs},
+}
+
+func newDirectoryDB() *directoryDB {
+	return &directoryDB{
+		users: map[string]models.User{
+			"abc": {UUID: "abc", Email: "admin@test.com", Name: "Admin"},
+			"def": {UUID: "def", Email: "user@test.com", Name: "User"},
+			"ghi": {UUID: "ghi", Email: "user@gibson.com", Name: "Someone Else's User"},
+			"mno": {UUID: "mno", Email: "ops@test.com", Name: "Operator"},
+		},
+		organizations: map[string]string{"abc": "fender", "def": "fender", "ghi": "gibson", "mno": "fender"},
+		deleted:       map[string]models.User{},
+		roles:         map[string][]string{"fender/abc": {"admin"}, "fender/mno": {"operator"}},
+	}
+}
+
+// request is made by the admin, in their session in the fender organization
+func request(method, path, uuid, body string) *http.Request {
+	r := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+	if uuid != "" {
+		r = mux.SetURLVars(r, map[string]string{"uuid": uuid})
+	}
+	session := models.Session{UUID: "session", UserUUID: "abc", OrganizationUUID: "fender"}
+	return r.WithContext(auth.NewContext(r.Context(), session, models.User{UUID: "abc"}))
+}
+
+func TestList(t *testing.T) {
+	after := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantFilter postgres.UserFilter
+		wantLimit  int
+		wantUsers  int
+		wantNext   string
+	}{
+		{name: "everyone in the organization", wantStatus: http.StatusOK, wantLimit: DefaultLimit, wantUsers: 2},
+		{name: "search", query: "?search=admin&limit=10", wantStatus: http.StatusOK, wantFilter: postgres.UserFilter{Search: "admin"}, wantLimit: 10, wantUsers: 1},
+		{
+			name:       "filters",
+			query:      "?email=adm&name=Ad&created_after=2019-05-01T00:00:00Z&include_deleted=true",
+			wantStatus: http.StatusOK,
+			wantFilter: postgres.UserFilter{EmailPrefix: "adm", Name: "Ad", CreatedAfter: &after, IncludeDeleted: true},
+			wantLimit:  DefaultLimit,
+			wantUsers:  2,
+		},
+		{name: "first page", query: "?limit=1&search=test", wantStatus: http.StatusOK, wantFilter: postgres.UserFilter{Search: "test"}, wantLimit: 1, wantUsers: 1, wantNext: "next"},
+		{name: "last page", query: "?limit=1&search=test&cursor=next", wantStatus: http.StatusOK, wantFilter: postgres.UserFilter{Search: "test"}, wantLimit: 1, wantUsers: 1},
+		{name: "invalid cursor", query: "?cursor=made+up", wantStatus: http.StatusBadRequest},
+		{name: "invalid time", query: "?created_before=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "limit too high", query: "?limit=1000", wantStatus: http.StatusBadRequest},
+		{name: "limit not a number", query: "?limit=ten", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newDirectoryDB()
+			postgres.DB = db
+
+			w := httptest.NewRecorder()
+			List(w, request("GET", "/admin/users"+tt.query, "", ""))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("List() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			got := listResponse{}
+			json.Unmarshal(w.Body.Bytes(), &got)
+			if len(got.Users) != tt.wantUsers || !reflect.DeepEqual(db.lastFilter, tt.wantFilter) || db.lastLimit != tt.wantLimit {
+				t.Errorf("List() = %v with filter %+v limit %v, want %v users with filter %+v limit %v", w.Body.String(), db.lastFilter, db.lastLimit, tt.wantUsers, tt.wantFilter, tt.wantLimit)
+			}
+
+			if got.NextCursor != tt.wantNext {
+				t.Errorf("List() next_cursor = %q, want %q", got.NextCursor, tt.wantNext)
+			}
+
+			// The Link header is the same request for the next page
+			link := w.Header().Get("Link")
+			if tt.wantNext == "" && link != "" {
+				t.Errorf("List() Link = %q on the last page", link)
+			}
+			if tt.wantNext != "" && link != `</admin/users?cursor=next&limit=1&search=test>; rel="next"` {
+				t.Errorf("List() Link = %q, want the next page", link)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	postgres.DB = newDirectoryDB()
+
+	w := httptest.NewRecorder()
+	Get(w, request("GET", "/admin/users/abc", "abc", ""))
+
+	got := struct {
+		User  models.User `json:"user"`
+		Roles []string    `json:"roles"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.User.UUID != "abc" || !reflect.DeepEqual(got.Roles, []string{"admin"}) {
+		t.Errorf("Get() = %v %v, want the user with their roles", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	Get(w, request("GET", "/admin/users/jkl", "jkl", ""))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Get() for a missing user status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	// Another organization's users look like they don't exist
+	w = httptest.NewRecorder()
+	Get(w, request("GET", "/admin/users/ghi", "ghi", ""))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Get() for another organization's user status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	outbox := &mailer.Memory{}
+	mailer.Default = outbox
+
+	tests := []struct {
+		name       string
+		uuid       string
+		body       string
+		wantStatus int
+		wantRoles  []string
+		wantEmail  string
+	}{
+		{name: "grant a role", uuid: "def", body: `{"roles": ["support", "support"]}`, wantStatus: http.StatusOK, wantRoles: []string{"support"}},
+		{name: "take every role away", uuid: "abc", body: `{"roles": []}`, wantStatus: http.StatusOK, wantRoles: []string{}},
+		{name: "leave roles alone", uuid: "abc", body: `{"name": "Boss"}`, wantStatus: http.StatusOK, wantRoles: []string{"admin"}},
+		{name: "change email", uuid: "def", body: `{"email": "new@test.com"}`, wantStatus: http.StatusOK, wantEmail: "new@test.com"},
+		{name: "grant a role above your own", uuid: "def", body: `{"roles": ["owner"], "email": "new@test.com"}`, wantStatus: http.StatusForbidden},
+		{name: "grant yourself a role above your own", uuid: "abc", body: `{"roles": ["admin", "owner"]}`, wantStatus: http.StatusForbidden, wantRoles: []string{"admin"}},
+		{name: "grant a global role", uuid: "abc", body: `{"roles": ["admin", "operator"]}`, wantStatus: http.StatusForbidden, wantRoles: []string{"admin"}},
+		{name: "take a global role away", uuid: "mno", body: `{"roles": ["support"]}`, wantStatus: http.StatusForbidden, wantRoles: []string{"operator"}},
+		{name: "leave a global role alone", uuid: "mno", body: `{"roles": ["operator", "support"]}`, wantStatus: http.StatusOK, wantRoles: []string{"operator", "support"}},
+		{name: "unknown role", uuid: "def", body: `{"roles": ["superuser"], "email": "new@test.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid email", uuid: "def", body: `{"email": "nope"}`, wantStatus: http.StatusBadRequest},
+		{name: "missing user", uuid: "jkl", body: `{"name": "Ghost"}`, wantStatus: http.StatusNotFound},
+		{name: "another organization's user", uuid: "ghi", body: `{"roles": ["admin"], "email": "new@test.com"}`, wantStatus: http.StatusNotFound},
+		{name: "bad body", uuid: "def", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newDirectoryDB()
+			postgres.DB = db
+
+			w := httptest.NewRecorder()
+			Update(w, request("PUT", "/admin/users/"+tt.uuid, tt.uuid, tt.body))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Update() status = %v %v, want %v", w.Code, w.Body.String(), tt.wantStatus)
+			}
+
+			if tt.wantRoles != nil && !reflect.DeepEqual(db.roles["fender/"+tt.uuid], tt.wantRoles) {
+				t.Errorf("Update() roles = %v, want %v", db.roles["fender/"+tt.uuid], tt.wantRoles)
+			}
+
+			if roles, ok := db.roles["gibson/"+tt.uuid]; ok {
+				t.Errorf("Update() granted %v in another organization", roles)
+			}
+
+			// Nothing else changes when a role is turned away
+			if db.updatedEmail != tt.wantEmail {
+				t.Errorf("Update() email = %q, want %q", db.updatedEmail, tt.wantEmail)
+			}
+
+			if msg, ok := outbox.Last(); tt.wantEmail != "" && (!ok || msg.To != tt.wantEmail) {
+				t.Errorf("Update() didn't send a verification email to %v", tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db := newDirectoryDB()
+	postgres.DB = db
+
+	w := httptest.NewRecorder()
+	Delete(w, request("DELETE", "/admin/users/def", "def", ""))
+	if w.Code != http.StatusOK || db.deletedUser != "def" || db.revokedUser != "def" {
+		t.Errorf("Delete() = %v, deleted %q revoked %q, want the user deleted and logged out", w.Code, db.deletedUser, db.revokedUser)
+	}
+
+	w = httptest.NewRecorder()
+	Delete(w, request("DELETE", "/admin/users/def", "def", ""))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Delete() for a deleted user status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	db.deletedUser = ""
+	w = httptest.NewRecorder()
+	Delete(w, request("DELETE", "/admin/users/ghi", "ghi", ""))
+	if w.Code != http.StatusNotFound || db.deletedUser != "" {
+		t.Errorf("Delete() for another organization's user status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	db := newDirectoryDB()
+	postgres.DB = db
+
+	w := httptest.NewRecorder()
+	RevokeSessions(w, request("POST", "/admin/users/def/sessions:revoke", "def", ""))
+	if w.Code != http.StatusOK || db.revokedUser != "def" {
+		t.Errorf("RevokeSessions() = %v, revoked %q, want the user logged out", w.Code, db.revokedUser)
+	}
+
+	db.revokedUser = ""
+	w = httptest.NewRecorder()
+	RevokeSessions(w, request("POST", "/admin/users/jkl/sessions:revoke", "jkl", ""))
+	if w.Code != http.StatusNotFound || db.revokedUser != "" {
+		t.Errorf("RevokeSessions() for a missing user status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	longAgo := time.Now().Add(-retention.UserPeriod - time.Hour)
+	tests := []struct {
+		name       string
+		uuid       string
+		deleted    models.User
+		taken      bool
+		wantStatus int
+	}{
+		{name: "recently deleted", uuid: "def", wantStatus: http.StatusOK},
+		{name: "deleted too long ago", uuid: "def", deleted: models.User{UUID: "def", Email: "user@test.com", DeletedAt: &longAgo}, wantStatus: http.StatusGone},
+		{name: "address taken", uuid: "def", taken: true, wantStatus: http.StatusConflict},
+		{name: "never deleted", uuid: "abc", wantStatus: http.StatusNotFound},
+		{name: "another organization's user", uuid: "ghi", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newDirectoryDB()
+			postgres.DB = db
+			db.SoftDeleteUserByUUID("def")
+			db.SoftDeleteUserByUUID("ghi")
+			if tt.deleted.UUID != "" {
+				db.deleted[tt.uuid] = tt.deleted
+			}
+			if tt.taken {
+				db.users["jkl"] = models.User{UUID: "jkl", Email: "user@test.com"}
+			}
+
+			w := httptest.NewRecorder()
+			Restore(w, request("POST", "/admin/users/"+tt.uuid+"/restore", tt.uuid, ""))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Restore() = %v %v, want %v", w.Code, w.Body.String(), tt.wantStatus)
+			}
+
+			_, live := db.users[tt.uuid]
+			if tt.wantStatus == http.StatusOK && !live {
+				t.Errorf("Restore() didn't bring the user back")
+			}
+			if tt.uuid == "ghi" && live {
+				t.Errorf("Restore() brought back another organization's user")
+			}
+		})
+	}
+}
+
+// selfDeletedDB is a directory where user def still has a live session and refresh token, like users who deleted
+// themselves before that logged them out
+type selfDeletedDB struct {
+	*directoryDB
+	session models.Session
+}
+
+func (d *selfDeletedDB) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	return models.RefreshToken{UUID: "refresh", SessionUUID: d.session.UUID, ExpiresAt: d.session.ExpiresAt}, nil
+}
+
+func (d *selfDeletedDB) GetSessionByUUID(uuid string) (models.Session, error) {
+	return d.session, nil
+}
+
+func (d *selfDeletedDB) GetUserByUUID(uuid string) (models.User, error) {
+	return d.users[uuid], nil
+}
+
+func (d *selfDeletedDB) SoftDeleteSessionsByUserUUID(userUUID string) (int, error) {
+	if userUUID != d.session.UserUUID || d.session.DeletedAt != nil {
+		return 0, nil
+	}
+	deletedAt := time.Now()
+	d.session.DeletedAt = &deletedAt
+	return 1, nil
+}
+
+func TestRestore_SelfDeleted(t *testing.T) {
+	db := &selfDeletedDB{directoryDB: newDirectoryDB(), session: models.Session{UUID: "session", UserUUID: "def", ExpiresAt: time.Now().Add(time.Hour)}}
+	postgres.DB = db
+	db.SoftDeleteUserByUUID("def")
+
+	w := httptest.NewRecorder()
+	Restore(w, request("POST", "/admin/users/def/restore", "def", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Restore() = %v %v, want %v", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	if _, err := tokens.Rotate("refresh", "", time.Now()); err != tokens.ErrInvalidRefreshToken {
+		t.Errorf("Rotate() with a refresh token from before the delete error = %v, want %v", err, tokens.ErrInvalidRefreshToken)
+	}
+}
+
+// jobRunsDB has one job that's run
+type jobRunsDB struct {
+	postgres.DBMock
+	runs []models.JobRun
+}
+
+func (d *jobRunsDB) ListJobRuns() ([]models.JobRun, error) {
+	return d.runs, nil
+}
+
+func TestJobs(t *testing.T) {
+	finishedAt := time.Now().UTC().Truncate(time.Second)
+	run := models.JobRun{Name: "purge-sessions", Host: "web-1", StartedAt: finishedAt.Add(-time.Second), FinishedAt: finishedAt, Processed: 12}
+	postgres.DB = &jobRunsDB{runs: []models.JobRun{run}}
+
+	w := httptest.NewRecorder()
+	Jobs(w, httptest.NewRequest("GET", "/admin/jobs", nil))
+
+	response := jobsResponse{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || err != nil || !reflect.DeepEqual(response.Jobs, []models.JobRun{run}) {
+		t.Errorf("Jobs() = %v %v, want %v with %+v", w.Code, w.Body.String(), http.StatusOK, run)
+	}
+}
@@ -0,0 +1,24 @@
+package jwks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+)
+
+// Get is a handler that publishes the public keys our tokens can be verified with
+func Get(w http.ResponseWriter, r *http.Request) {
+	response, err := json.Marshal(signing.Keys.JWKS())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Let verifiers cache the set for a while, but not so long they miss a new key for too long
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
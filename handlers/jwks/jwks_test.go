@@ -0,0 +1,46 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+)
+
+func TestGet(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+
+	key, err := signing.NewKey(private)
+	if err != nil {
+		t.Fatalf("error wrapping key: %v", err)
+	}
+
+	signing.Keys = signing.NewRegister()
+	signing.Keys.AddSecret([]byte("fenderdigital"))
+	signing.Keys.SetSigner(key)
+
+	w := httptest.NewRecorder()
+	Get(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Get() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	set := signing.JWKS{}
+	err = json.Unmarshal(w.Body.Bytes(), &set)
+	if err != nil {
+		t.Fatalf("Get() returned invalid JSON: %v", err)
+	}
+
+	if len(set.Keys) != 1 || set.Keys[0].KeyType != "EC" || set.Keys[0].Algorithm != "ES256" {
+		t.Errorf("Get() = %v, want a single ES256 key and no shared secrets", w.Body.String())
+	}
+}
@@ -0,0 +1,231 @@
+package oauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oauth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+// Authorize is a handler that checks an OAuth client's authorization request, sent on as query parameters by our
+// frontend, and returns what the logged in user is being asked to consent to. The user's answer goes to Approve.
+func Authorize(w http.ResponseWriter, r *http.Request) {
+	_, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	parsedRequest := authorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+		Nonce:               query.Get("nonce"),
+	}
+
+	client, scope, ok := checkAuthorizeRequest(w, &parsedRequest)
+	if !ok {
+		return
+	}
+
+	consent := consentResponse{
+		Client:      consentClient{ClientID: client.ClientID, Name: client.Name},
+		Scopes:      []consentScope{},
+		RedirectURI: parsedRequest.RedirectURI,
+		State:       parsedRequest.State,
+	}
+	for _, name := range strings.Fields(scope) {
+		consent.Scopes = append(consent.Scopes, consentScope{Name: name, Description: oauth.Scopes[name]})
+	}
+
+	response, err := json.Marshal(consent)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Approve is a handler that records the logged in user's answer to an authorization request. Either way it returns
+// where to send the user back to the client, with an authorization code if they approved.
+func Approve(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := approveRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Everything is checked again, the request could have been changed since the user was shown it
+	client, scope, ok := checkAuthorizeRequest(w, &parsedBody.authorizeRequest)
+	if !ok {
+		return
+	}
+
+	if !parsedBody.Approve {
+		writeRedirect(w, http.StatusOK, parsedBody.authorizeRequest, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	code, err := randtoken.Generate()
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	_, err = postgres.DB.CreateOAuthAuthorizationCode(client.ClientID, currentUser.UUID, randtoken.Hash(code), parsedBody.RedirectURI, scope, parsedBody.CodeChallenge, parsedBody.CodeChallengeMethod, parsedBody.Nonce, time.Now().Add(oauth.CodeLifetime))
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	writeRedirect(w, http.StatusOK, parsedBody.authorizeRequest, url.Values{"code": {code}})
+}
+
+// checkAuthorizeRequest checks the request against the client it names, filling in the redirect URI if the client
+// only has one, and returns the client and the scope it'd be granted. If it returns false the error response has
+// already been written: until the redirect URI is known to be the client's, errors can't be sent back to it.
+func checkAuthorizeRequest(w http.ResponseWriter, parsedRequest *authorizeRequest) (models.OAuthClient, string, bool) {
+	if parsedRequest.ClientID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return models.OAuthClient{}, "", false
+	}
+
+	client, err := postgres.DB.GetOAuthClientByClientID(parsedRequest.ClientID)
+	if err != nil {
+		writeServerError(w, err)
+		return client, "", false
+	}
+
+	if client.UUID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Unknown client")
+		return client, "", false
+	}
+
+	if parsedRequest.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		parsedRequest.RedirectURI = client.RedirectURIs[0]
+	}
+
+	if !oauth.AllowsRedirectURI(client, parsedRequest.RedirectURI) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "redirect_uri isn't registered for this client")
+		return client, "", false
+	}
+
+	if parsedRequest.ResponseType != "code" {
+		writeRedirect(w, http.StatusBadRequest, *parsedRequest, url.Values{"error": {"unsupported_response_type"}})
+		return client, "", false
+	}
+
+	if !oauth.AllowsGrant(client, oauth.GrantAuthorizationCode) {
+		writeRedirect(w, http.StatusBadRequest, *parsedRequest, url.Values{"error": {"unauthorized_client"}})
+		return client, "", false
+	}
+
+	// Every client has to use PKCE, a confidential client's secret doesn't stop its codes being injected elsewhere
+	if parsedRequest.CodeChallenge == "" || parsedRequest.CodeChallengeMethod != oauth.PKCEMethodS256 {
+		writeRedirect(w, http.StatusBadRequest, *parsedRequest, url.Values{"error": {"invalid_request"}, "error_description": {"code_challenge with code_challenge_method S256 is required"}})
+		return client, "", false
+	}
+
+	scope, err := oauth.ParseScope(client, parsedRequest.Scope)
+	if err != nil {
+		writeRedirect(w, http.StatusBadRequest, *parsedRequest, url.Values{"error": {"invalid_scope"}})
+		return client, "", false
+	}
+
+	return client, scope, true
+}
+
+// writeRedirect returns where to send the user back to the client, with params and the request's state added to the
+// redirect URI. Errors are included in the body too, so the frontend doesn't have to parse them back out.
+func writeRedirect(w http.ResponseWriter, status int, parsedRequest authorizeRequest, params url.Values) {
+	redirectTo, err := url.Parse(parsedRequest.RedirectURI)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	query := redirectTo.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if parsedRequest.State != "" {
+		query.Set("state", parsedRequest.State)
+	}
+	redirectTo.RawQuery = query.Encode()
+
+	response, err := json.Marshal(redirectResponse{Error: params.Get("error"), Description: params.Get("error_description"), RedirectTo: redirectTo.String()})
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+type authorizeRequest struct {
+	ResponseType        string `json:"response_type,omitempty"`
+	ClientID            string `json:"client_id,omitempty"`
+	RedirectURI         string `json:"redirect_uri,omitempty"`
+	Scope               string `json:"scope,omitempty"`
+	State               string `json:"state,omitempty"`
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+}
+
+type approveRequest struct {
+	authorizeRequest
+	Approve bool `json:"approve"`
+}
+
+type consentResponse struct {
+	Client      consentClient  `json:"client"`
+	Scopes      []consentScope `json:"scopes"`
+	RedirectURI string         `json:"redirect_uri"`
+	State       string         `json:"state,omitempty"`
+}
+
+type consentClient struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+}
+
+type consentScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type redirectResponse struct {
+	Error       string `json:"error,omitempty"`
+	Description string `json:"error_description,omitempty"`
+	RedirectTo  string `json:"redirect_to"`
+}
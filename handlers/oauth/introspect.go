@@ -0,0 +1,129 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/oauth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+)
+
+// Introspect is a handler that tells a confidential client whether a token is still good and what it's for, as RFC
+// 7662 has it. It's how services that only check our signatures find out a session was logged out of.
+func Introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	// Anyone can name a public client, so they'd be able to probe any token
+	if oauth.IsPublic(client) {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "Public clients can't introspect tokens")
+		return
+	}
+
+	if r.PostFormValue("token") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	// The token_type_hint is only an optimisation, both kinds are looked for either way
+	inspection, err := tokens.Inspect(r.PostFormValue("token"), time.Now())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	introspection := introspectionResponse{Active: inspection.Active}
+	if inspection.Active {
+		introspection.Scope = inspection.Scope
+		introspection.ClientID = inspection.ClientID
+		introspection.Subject = inspection.Subject
+		introspection.Issuer = tokens.Issuer
+		introspection.IssuedAt = inspection.IssuedAt.Unix()
+		introspection.ExpiresAt = inspection.ExpiresAt.Unix()
+		introspection.TokenType = "Bearer"
+		if inspection.Refresh {
+			introspection.TokenType = "refresh_token"
+		}
+	}
+
+	response, err := json.Marshal(introspection)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Revoke is a handler that lets a client log out of a session it was issued tokens for, as RFC 7009 has it. Either
+// an access token or a refresh token revokes the whole session. Tokens that are already no good, or belong to
+// someone else, are fine to send and answered the same as ones that were revoked.
+func Revoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	if r.PostFormValue("token") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	inspection, err := tokens.Inspect(r.PostFormValue("token"), time.Now())
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	// Clients can only log out of their own sessions, never another client's or one of our own logins. Anyone can
+	// name a public client, so those tokens are answered like ones that are no good, otherwise the answer would
+	// give away whether any token is live.
+	if !inspection.Active || inspection.ClientID != client.ClientID {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// A client's own tokens have no session to revoke, they just expire
+	if inspection.SessionUUID == "" {
+		writeError(w, http.StatusBadRequest, "unsupported_token_type", "Tokens without a session can't be revoked")
+		return
+	}
+
+	err = tokens.Revoke(inspection.SessionUUID)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+}
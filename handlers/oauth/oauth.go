@@ -0,0 +1,78 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oauth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+// writeError writes an error the way RFC 6749 section 5.2 lays it out, OAuth clients expect this rather than our
+// usual message
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	response, err := json.Marshal(errorResponse{Error: code, Description: description})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// writeServerError writes an unexpected error, ex: the database going away
+func writeServerError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+}
+
+// authenticateClient finds the client making a token request. Confidential clients authenticate with HTTP Basic or
+// client_id and client_secret in the body, public clients only name themselves with client_id. If it returns false
+// the error response has already been written.
+func authenticateClient(w http.ResponseWriter, r *http.Request) (models.OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// The credentials are form encoded before they're put in the header
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+
+	if clientID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return models.OAuthClient{}, false
+	}
+
+	client, err := postgres.DB.GetOAuthClientByClientID(clientID)
+	if err != nil {
+		writeServerError(w, err)
+		return client, false
+	}
+
+	valid := client.UUID != ""
+	if valid && oauth.IsPublic(client) {
+		valid = secret == ""
+	} else if valid {
+		valid = oauth.CheckSecret(client, secret)
+	}
+
+	if !valid {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return client, false
+	}
+
+	return client, true
+}
+
+type errorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
@@ -0,0 +1,457 @@
+package oauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+)
+
+func init() {
+	signing.Keys.AddSecret([]byte("fenderdigital"))
+}
+
+// providerDB keeps registered clients, and the codes, sessions and refresh tokens handed out to them, in memory
+type providerDB struct {
+	postgres.DBMock
+	clients       map[string]models.OAuthClient
+	codes         map[string]*models.OAuthAuthorizationCode
+	sessions      map[string]models.Session
+	refreshTokens map[string]*models.RefreshToken
+}
+
+func newProviderDB() *providerDB {
+	return &providerDB{
+		clients: map[string]models.OAuthClient{
+			"mobile":  {UUID: "1", ClientID: "mobile", Name: "Fender Tune", RedirectURIs: []string{"com.fender.tune:/callback"}, GrantTypes: []string{"authorization_code", "refresh_token"}, Scopes: []string{"profile"}},
+			"partner": {UUID: "2", ClientID: "partner", SecretHash: randtoken.Hash("secret"), Name: "Partner", RedirectURIs: []string{"https://partner.example/a", "https://partner.example/b"}, GrantTypes: []string{"authorization_code", "client_credentials"}, Scopes: []string{"profile"}},
+			"studio":  {UUID: "3", ClientID: "studio", SecretHash: randtoken.Hash("secret"), Name: "Fender Studio", RedirectURIs: []string{"https://studio.fender.com/callback"}, GrantTypes: []string{"authorization_code", "refresh_token"}, Scopes: []string{"openid", "profile", "email"}},
+		},
+		codes:         map[string]*models.OAuthAuthorizationCode{},
+		sessions:      map[string]models.Session{},
+		refreshTokens: map[string]*models.RefreshToken{},
+	}
+}
+
+func (d *providerDB) GetOAuthClientByClientID(clientID string) (models.OAuthClient, error) {
+	return d.clients[clientID], nil
+}
+
+func (d *providerDB) CreateOAuthAuthorizationCode(clientID, userUUID, codeHash, redirectURI, scope, codeChallenge, codeChallengeMethod, nonce string, expiresAt time.Time) (models.OAuthAuthorizationCode, error) {
+	d.codes[codeHash] = &models.OAuthAuthorizationCode{UUID: codeHash, ClientID: clientID, UserUUID: userUUID, RedirectURI: redirectURI, Scope: scope, CodeChallenge: codeChallenge, CodeChallengeMethod: codeChallengeMethod, Nonce: nonce, ExpiresAt: expiresAt}
+	return *d.codes[codeHash], nil
+}
+
+func (d *providerDB) GetOAuthAuthorizationCodeByHash(codeHash string) (models.OAuthAuthorizationCode, error) {
+	if code, ok := d.codes[codeHash]; ok {
+		return *code, nil
+	}
+	return models.OAuthAuthorizationCode{}, nil
+}
+
+func (d *providerDB) UseOAuthAuthorizationCodeByUUID(uuid string) (int, error) {
+	code := d.codes[uuid]
+	if code.UsedAt != nil {
+		return 0, nil
+	}
+	usedAt := time.Now()
+	code.UsedAt = &usedAt
+	return 1, nil
+}
+
+func (d *providerDB) CreateClientSession(userUUID, clientID, scope, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error) {
+	session := models.Session{UUID: randtoken.Hash(time.Now().String()), UserUUID: userUUID, ClientID: clientID, Scope: scope, ExpiresAt: expiresAt}
+	d.sessions[session.UUID] = session
+	return session, nil
+}
+
+func (d *providerDB) GetSessionByUUID(uuid string) (models.Session, error) {
+	return d.sessions[uuid], nil
+}
+
+func (d *providerDB) CreateRefreshToken(sessionUUID, tokenHash string, expiresAt time.Time) (models.RefreshToken, error) {
+	d.refreshTokens[tokenHash] = &models.RefreshToken{UUID: tokenHash, SessionUUID: sessionUUID, ExpiresAt: expiresAt}
+	return *d.refreshTokens[tokenHash], nil
+}
+
+func (d *providerDB) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	if refreshToken, ok := d.refreshTokens[tokenHash]; ok {
+		return *refreshToken, nil
+	}
+	return models.RefreshToken{}, nil
+}
+
+func (d *providerDB) UseRefreshTokenByUUID(uuid string) (int, error) {
+	refreshToken := d.refreshTokens[uuid]
+	if refreshToken.UsedAt != nil {
+		return 0, nil
+	}
+	usedAt := time.Now()
+	refreshToken.UsedAt = &usedAt
+	return 1, nil
+}
+
+func (d *providerDB) SoftDeleteSessionByUUID(uuid string) (int, error) {
+	session := d.sessions[uuid]
+	deletedAt := time.Now()
+	session.DeletedAt = &deletedAt
+	d.sessions[uuid] = session
+	return 1, nil
+}
+
+func (d *providerDB) RevokeRefreshTokensBySessionUUID(sessionUUID string) (int, error) {
+	revoked := 0
+	for _, refreshToken := range d.refreshTokens {
+		if refreshToken.SessionUUID == sessionUUID && refreshToken.RevokedAt == nil {
+			revokedAt := time.Now()
+			refreshToken.RevokedAt = &revokedAt
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// pkce returns a code verifier and its S256 code challenge
+func pkce() (string, string) {
+	verifier, _ := randtoken.Generate()
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorize(query url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil)
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc", Email: "test@test.com"}))
+	Authorize(w, r)
+	return w
+}
+
+func approve(body approveRequest) (*httptest.ResponseRecorder, redirectResponse) {
+	rawBody, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/oauth/authorize", bytes.NewReader(rawBody))
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc", Email: "test@test.com"}))
+	Approve(w, r)
+
+	redirect := redirectResponse{}
+	json.Unmarshal(w.Body.Bytes(), &redirect)
+	return w, redirect
+}
+
+func token(form url.Values, basicUser, basicPassword string) (*httptest.ResponseRecorder, tokenResponse) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicUser != "" {
+		r.SetBasicAuth(basicUser, basicPassword)
+	}
+	Token(w, r)
+
+	response := tokenResponse{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w, response
+}
+
+func TestAuthorize(t *testing.T) {
+	postgres.DB = newProviderDB()
+	_, challenge := pkce()
+	valid := url.Values{"response_type": {"code"}, "client_id": {"mobile"}, "state": {"xyz"}, "code_challenge": {challenge}, "code_challenge_method": {"S256"}}
+
+	w := authorize(valid)
+	consent := consentResponse{}
+	json.Unmarshal(w.Body.Bytes(), &consent)
+	if w.Code != http.StatusOK || consent.Client.Name != "Fender Tune" || consent.RedirectURI != "com.fender.tune:/callback" || len(consent.Scopes) != 1 || consent.Scopes[0].Name != "profile" {
+		t.Fatalf("Authorize() = %v %v, want the consent for Fender Tune", w.Code, w.Body.String())
+	}
+
+	with := func(key, value string) url.Values {
+		query := url.Values{}
+		for k, v := range valid {
+			query[k] = v
+		}
+		query.Set(key, value)
+		return query
+	}
+
+	tests := []struct {
+		name         string
+		query        url.Values
+		wantError    string
+		wantRedirect bool
+	}{
+		{name: "unknown client", query: with("client_id", "unknown"), wantError: "invalid_request"},
+		{name: "unregistered redirect", query: with("redirect_uri", "https://evil.example/callback"), wantError: "invalid_request"},
+		{name: "ambiguous redirect", query: with("client_id", "partner"), wantError: "invalid_request"},
+		{name: "implicit grant", query: with("response_type", "token"), wantError: "unsupported_response_type", wantRedirect: true},
+		{name: "no PKCE", query: with("code_challenge", ""), wantError: "invalid_request", wantRedirect: true},
+		{name: "plain PKCE", query: with("code_challenge_method", "plain"), wantError: "invalid_request", wantRedirect: true},
+		{name: "unregistered scope", query: with("scope", "profile admin"), wantError: "invalid_scope", wantRedirect: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := authorize(tt.query)
+			redirect := redirectResponse{}
+			json.Unmarshal(w.Body.Bytes(), &redirect)
+
+			if w.Code != http.StatusBadRequest || redirect.Error != tt.wantError {
+				t.Errorf("Authorize() = %v %v, want a %v", w.Code, w.Body.String(), tt.wantError)
+			}
+
+			// Errors only go back to the client once we know the redirect URI is its own
+			if (redirect.RedirectTo != "") != tt.wantRedirect {
+				t.Errorf("Authorize() redirect_to = %q, want one %v", redirect.RedirectTo, tt.wantRedirect)
+			}
+			if tt.wantRedirect && !strings.HasPrefix(redirect.RedirectTo, "com.fender.tune:/callback?") {
+				t.Errorf("Authorize() redirect_to = %q, want the client's callback", redirect.RedirectTo)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	db := newProviderDB()
+	postgres.DB = db
+	verifier, challenge := pkce()
+	request := authorizeRequest{ResponseType: "code", ClientID: "mobile", State: "xyz", CodeChallenge: challenge, CodeChallengeMethod: "S256"}
+
+	// Turning the client down sends the user back with an error
+	_, redirect := approve(approveRequest{authorizeRequest: request})
+	if redirect.RedirectTo != "com.fender.tune:/callback?error=access_denied&state=xyz" {
+		t.Errorf("Approve() denied redirect_to = %q", redirect.RedirectTo)
+	}
+
+	w, redirect := approve(approveRequest{authorizeRequest: request, Approve: true})
+	redirectTo, _ := url.Parse(redirect.RedirectTo)
+	code := redirectTo.Query().Get("code")
+	if w.Code != http.StatusOK || code == "" || redirectTo.Query().Get("state") != "xyz" {
+		t.Fatalf("Approve() = %v %v, want a redirect with a code", w.Code, w.Body.String())
+	}
+
+	if _, ok := db.codes[randtoken.Hash(code)]; !ok {
+		t.Fatalf("Approve() didn't store the code hashed")
+	}
+
+	exchange := url.Values{"grant_type": {"authorization_code"}, "client_id": {"mobile"}, "code": {code}, "redirect_uri": {"com.fender.tune:/callback"}, "code_verifier": {verifier}}
+	with := func(key, value string) url.Values {
+		form := url.Values{}
+		for k, v := range exchange {
+			form[k] = v
+		}
+		form.Set(key, value)
+		return form
+	}
+
+	// None of these use the code up
+	if w, _ := token(with("code_verifier", "wrong"), "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Token() with the wrong code_verifier status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if w, _ := token(with("redirect_uri", "com.fender.tune:/other"), "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Token() with another redirect_uri status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if w, _ := token(with("client_secret", "guess"), "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Token() with a secret for a public client status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	w, tokens := token(exchange, "", "")
+	if w.Code != http.StatusOK || tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" || tokens.Scope != "profile" {
+		t.Fatalf("Token() = %v %v, want tokens", w.Code, w.Body.String())
+	}
+
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Token() Cache-Control = %q, want no-store", w.Header().Get("Cache-Control"))
+	}
+
+	// The access token carries the scope and is for a session tied to the client
+	claims, err := signing.Keys.Check([]byte(tokens.AccessToken))
+	if err != nil {
+		t.Fatalf("Token() access token doesn't check: %v", err)
+	}
+	sid, _ := claims.String("sid")
+	scope, _ := claims.String("scope")
+	if claims.Subject != "abc" || scope != "profile" || db.sessions[sid].ClientID != "mobile" {
+		t.Errorf("Token() claims = %+v, want a profile scoped token for abc", claims.Set)
+	}
+
+	if w, _ := token(exchange, "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Token() reusing a code status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	// Refresh tokens rotate, and only work for the client they were issued to
+	refresh := url.Values{"grant_type": {"refresh_token"}, "client_id": {"mobile"}, "refresh_token": {tokens.RefreshToken}}
+	w, refreshed := token(refresh, "", "")
+	if w.Code != http.StatusOK || refreshed.RefreshToken == "" || refreshed.RefreshToken == tokens.RefreshToken || refreshed.Scope != "profile" {
+		t.Fatalf("Token() refresh = %v %v, want new tokens", w.Code, w.Body.String())
+	}
+
+	if w, _ := token(refresh, "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Token() reusing a refresh token status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	postgres.DB = newProviderDB()
+	verifier, challenge := pkce()
+	request := authorizeRequest{ResponseType: "code", ClientID: "studio", Scope: "openid email", State: "xyz", CodeChallenge: challenge, CodeChallengeMethod: "S256", Nonce: "n-0S6_WzA2Mj"}
+
+	_, redirect := approve(approveRequest{authorizeRequest: request, Approve: true})
+	redirectTo, _ := url.Parse(redirect.RedirectTo)
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {redirectTo.Query().Get("code")}, "redirect_uri": {"https://studio.fender.com/callback"}, "code_verifier": {verifier}}
+
+	w, tokens := token(exchange, "studio", "secret")
+	if w.Code != http.StatusOK || tokens.IDToken == "" {
+		t.Fatalf("Token() = %v %v, want an id_token", w.Code, w.Body.String())
+	}
+
+	// The id_token is for the client, echoes the nonce and only has the claims the scope allows
+	claims, err := signing.Keys.Check([]byte(tokens.IDToken))
+	if err != nil {
+		t.Fatalf("Token() id_token doesn't check: %v", err)
+	}
+	nonce, _ := claims.String("nonce")
+	email, _ := claims.String("email")
+	if claims.Subject != "abc" || !reflect.DeepEqual(claims.Audiences, []string{"studio"}) || nonce != "n-0S6_WzA2Mj" || email != "test@test.com" {
+		t.Errorf("Token() id_token claims = %+v, want an id_token for abc", claims.Set)
+	}
+	if _, ok := claims.Set["name"]; ok {
+		t.Errorf("Token() id_token has a name without the profile scope")
+	}
+
+	// Refreshing gets a new id_token, but there's no nonce to echo this time
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}
+	w, refreshed := token(refresh, "studio", "secret")
+	if w.Code != http.StatusOK || refreshed.IDToken == "" {
+		t.Fatalf("Token() refresh = %v %v, want an id_token", w.Code, w.Body.String())
+	}
+	claims, _ = signing.Keys.Check([]byte(refreshed.IDToken))
+	if _, ok := claims.Set["nonce"]; ok {
+		t.Errorf("Token() refreshed id_token has a nonce")
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	postgres.DB = newProviderDB()
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"profile"}}
+
+	w, tokens := token(form, "partner", "secret")
+	if w.Code != http.StatusOK || tokens.AccessToken == "" || tokens.RefreshToken != "" || tokens.Scope != "profile" {
+		t.Fatalf("Token() = %v %v, want an access token only", w.Code, w.Body.String())
+	}
+
+	claims, _ := signing.Keys.Check([]byte(tokens.AccessToken))
+	if _, ok := claims.String("sid"); claims.Subject != "partner" || ok {
+		t.Errorf("Token() claims = %+v, want the client as the subject and no session", claims)
+	}
+
+	if w, _ := token(form, "partner", "guess"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Token() with the wrong secret = %v %v, want a Basic challenge", w.Code, w.Header())
+	}
+
+	form.Set("client_id", "partner")
+	form.Set("client_secret", "secret")
+	if w, _ := token(form, "", ""); w.Code != http.StatusOK {
+		t.Errorf("Token() with the secret in the body status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	// The mobile client isn't registered for it, and couldn't authenticate if it were
+	if w, _ := token(url.Values{"grant_type": {"client_credentials"}, "client_id": {"mobile"}}, "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Token() for an unregistered grant status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := token(url.Values{"grant_type": {"password"}, "client_id": {"mobile"}}, "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Token() with the password grant status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestIntrospectAndRevoke(t *testing.T) {
+	postgres.DB = newProviderDB()
+	verifier, challenge := pkce()
+	request := authorizeRequest{ResponseType: "code", ClientID: "studio", Scope: "profile", CodeChallenge: challenge, CodeChallengeMethod: "S256"}
+
+	_, redirect := approve(approveRequest{authorizeRequest: request, Approve: true})
+	redirectTo, _ := url.Parse(redirect.RedirectTo)
+	_, issued := token(url.Values{"grant_type": {"authorization_code"}, "code": {redirectTo.Query().Get("code")}, "redirect_uri": {"https://studio.fender.com/callback"}, "code_verifier": {verifier}}, "studio", "secret")
+
+	introspect := func(form url.Values, basicUser, basicPassword string) (*httptest.ResponseRecorder, introspectionResponse) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/oauth/introspect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(basicUser, basicPassword)
+		Introspect(w, r)
+
+		introspection := introspectionResponse{}
+		json.Unmarshal(w.Body.Bytes(), &introspection)
+		return w, introspection
+	}
+
+	revoke := func(form url.Values, basicUser, basicPassword string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/oauth/revoke", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(basicUser, basicPassword)
+		Revoke(w, r)
+		return w
+	}
+
+	// Any confidential client can ask about a token, whoever it was issued to
+	w, introspection := introspect(url.Values{"token": {issued.AccessToken}}, "partner", "secret")
+	if w.Code != http.StatusOK || !introspection.Active || introspection.ClientID != "studio" || introspection.Subject != "abc" || introspection.Scope != "profile" || introspection.TokenType != "Bearer" {
+		t.Fatalf("Introspect() = %v %v, want an active token for studio", w.Code, w.Body.String())
+	}
+
+	_, introspection = introspect(url.Values{"token": {issued.RefreshToken}, "token_type_hint": {"refresh_token"}}, "partner", "secret")
+	if !introspection.Active || introspection.TokenType != "refresh_token" {
+		t.Errorf("Introspect() on a refresh token = %+v, want an active refresh token", introspection)
+	}
+
+	if w, _ := introspect(url.Values{"token": {issued.AccessToken}}, "mobile", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Introspect() by a public client status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// Only the client the token was issued to can revoke it, anyone else is answered as though it were no good
+	// already, so it can't be used to find out whether a token is live
+	for _, client := range [][2]string{{"partner", "secret"}, {"mobile", ""}} {
+		if w := revoke(url.Values{"token": {issued.AccessToken}}, client[0], client[1]); w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("Revoke() by %v = %v %v, want %v", client[0], w.Code, w.Body.String(), http.StatusOK)
+		}
+	}
+	if _, introspection := introspect(url.Values{"token": {issued.RefreshToken}}, "partner", "secret"); !introspection.Active {
+		t.Fatalf("Revoke() by another client logged out of the session")
+	}
+
+	if w := revoke(url.Values{"token": {issued.AccessToken}}, "studio", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("Revoke() = %v %v, want %v", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	// Revoking either token logs out of the session, so neither is any good now
+	for _, revoked := range []string{issued.AccessToken, issued.RefreshToken} {
+		if _, introspection := introspect(url.Values{"token": {revoked}}, "partner", "secret"); introspection.Active {
+			t.Errorf("Introspect() after revoking = %+v, want inactive", introspection)
+		}
+	}
+
+	if w := revoke(url.Values{"token": {issued.RefreshToken}}, "studio", "secret"); w.Code != http.StatusOK {
+		t.Errorf("Revoke() on a revoked token status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	// A client's own token is good until it expires
+	_, own := token(url.Values{"grant_type": {"client_credentials"}}, "partner", "secret")
+	if _, introspection := introspect(url.Values{"token": {own.AccessToken}}, "partner", "secret"); !introspection.Active || introspection.Subject != "partner" {
+		t.Errorf("Introspect() on a client_credentials token = %+v, want active", introspection)
+	}
+	if w := revoke(url.Values{"token": {own.AccessToken}}, "partner", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("Revoke() on a client_credentials token status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,223 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oauth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oidc"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+)
+
+// Token is a handler that issues tokens to OAuth clients. Requests are form encoded as RFC 6749 has them, for the
+// authorization_code, refresh_token and client_credentials grants.
+func Token(w http.ResponseWriter, r *http.Request) {
+	// Tokens must never be cached along the way
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, ok := authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	grantType := r.PostFormValue("grant_type")
+	switch grantType {
+	case oauth.GrantAuthorizationCode, oauth.GrantRefreshToken, oauth.GrantClientCredentials:
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	if !oauth.AllowsGrant(client, grantType) {
+		writeError(w, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	switch grantType {
+	case oauth.GrantAuthorizationCode:
+		exchangeAuthorizationCode(w, r, client)
+	case oauth.GrantRefreshToken:
+		exchangeRefreshToken(w, r, client)
+	case oauth.GrantClientCredentials:
+		exchangeClientCredentials(w, r, client)
+	}
+}
+
+// exchangeAuthorizationCode swaps an authorization code for a new session for the user that approved it
+func exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client models.OAuthClient) {
+	if r.PostFormValue("code") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "code is required")
+		return
+	}
+
+	code, err := postgres.DB.GetOAuthAuthorizationCodeByHash(randtoken.Hash(r.PostFormValue("code")))
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	// The code has to be exchanged by the client it was issued to, for the redirect URI it was sent to, by
+	// whoever made the code challenge
+	currentTime := time.Now()
+	if code.UUID == "" || code.UsedAt != nil || currentTime.After(code.ExpiresAt) || code.ClientID != client.ClientID || code.RedirectURI != r.PostFormValue("redirect_uri") || !oauth.VerifyPKCE(r.PostFormValue("code_verifier"), code.CodeChallenge, code.CodeChallengeMethod) {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "Authorization code is invalid or expired")
+		return
+	}
+
+	used, err := postgres.DB.UseOAuthAuthorizationCodeByUUID(code.UUID)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	if used == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "Authorization code is invalid or expired")
+		return
+	}
+
+	// The user may have been deleted since they approved
+	user, err := postgres.DB.GetUserByUUID(code.UserUUID)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	if user.UUID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "Authorization code is invalid or expired")
+		return
+	}
+
+	session, err := postgres.DB.CreateClientSession(user.UUID, client.ClientID, code.Scope, r.UserAgent(), auth.ClientIP(r), currentTime.Add(tokens.SessionLifetime))
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	writeSessionTokens(w, client, session, user, code.Nonce, currentTime)
+}
+
+// exchangeRefreshToken rotates a refresh token issued to the client, the scope stays what the user approved
+func exchangeRefreshToken(w http.ResponseWriter, r *http.Request, client models.OAuthClient) {
+	if r.PostFormValue("refresh_token") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+
+	currentTime := time.Now()
+	session, err := tokens.Rotate(r.PostFormValue("refresh_token"), client.ClientID, currentTime)
+	if err == tokens.ErrInvalidRefreshToken {
+		writeError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	// A new id_token is only issued while the user is still around to describe
+	user := models.User{}
+	if tokens.HasScope(session.Scope, oidc.ScopeOpenID) {
+		user, err = postgres.DB.GetUserByUUID(session.UserUUID)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+
+		if user.UUID == "" {
+			writeError(w, http.StatusBadRequest, "invalid_grant", tokens.ErrInvalidRefreshToken.Error())
+			return
+		}
+	}
+
+	writeSessionTokens(w, client, session, user, "", currentTime)
+}
+
+// exchangeClientCredentials issues a token to a confidential client acting for itself, there's no user or session
+// behind it so it can't be refreshed, the client just asks again
+func exchangeClientCredentials(w http.ResponseWriter, r *http.Request, client models.OAuthClient) {
+	if oauth.IsPublic(client) {
+		writeError(w, http.StatusBadRequest, "unauthorized_client", "Public clients can't use client_credentials")
+		return
+	}
+
+	scope, err := oauth.ParseScope(client, r.PostFormValue("scope"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_scope", "")
+		return
+	}
+
+	access := tokens.Access{Subject: client.ClientID, ClientID: client.ClientID, Scope: scope}
+	writeTokenResponse(w, access, "", "", time.Now())
+}
+
+// writeSessionTokens issues an access token for the session, a refresh token too if the client is allowed them, and
+// an id_token for the user if the client asked for openid
+func writeSessionTokens(w http.ResponseWriter, client models.OAuthClient, session models.Session, user models.User, nonce string, currentTime time.Time) {
+	refreshToken := ""
+	if oauth.AllowsGrant(client, oauth.GrantRefreshToken) {
+		var err error
+		refreshToken, err = tokens.NewRefreshToken(session)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+	}
+
+	idToken := ""
+	if tokens.HasScope(session.Scope, oidc.ScopeOpenID) {
+		var err error
+		idToken, err = oidc.IDToken(user, client.ClientID, session.Scope, nonce, currentTime)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+	}
+
+	writeTokenResponse(w, tokens.ForSession(session), refreshToken, idToken, currentTime)
+}
+
+// writeTokenResponse signs the access token and writes it out as RFC 6749 section 5.1 has it
+func writeTokenResponse(w http.ResponseWriter, access tokens.Access, refreshToken, idToken string, currentTime time.Time) {
+	accessToken, err := access.Sign(currentTime)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	response, err := json.Marshal(tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.AccessTokenLifetime / time.Second),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		Scope:        access.Scope,
+	})
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
@@ -0,0 +1,111 @@
+package openid
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oauth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oidc"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+)
+
+type configuration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Discovery is a handler that publishes the OpenID Provider configuration, so client libraries can find everything
+// else from just our issuer URL
+func Discovery(w http.ResponseWriter, r *http.Request) {
+	if !oidc.IsConfigured() {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, "OpenID Connect isn't configured")))
+		return
+	}
+
+	authorizationURL := oidc.AuthorizationURL
+	if authorizationURL == "" {
+		authorizationURL = tokens.Issuer + "/oauth/authorize"
+	}
+
+	scopes := []string{}
+	for scope := range oauth.Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	response, err := json.Marshal(configuration{
+		Issuer:                            tokens.Issuer,
+		AuthorizationEndpoint:             authorizationURL,
+		TokenEndpoint:                     tokens.Issuer + "/oauth/token",
+		UserInfoEndpoint:                  tokens.Issuer + "/userinfo",
+		JWKSURI:                           tokens.Issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             tokens.Issuer + "/oauth/introspect",
+		RevocationEndpoint:                tokens.Issuer + "/oauth/revoke",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{signing.Keys.SigningAlgorithm()},
+		ScopesSupported:                   scopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		GrantTypesSupported:               oauth.GrantTypes,
+		CodeChallengeMethodsSupported:     []string{oauth.PKCEMethodS256},
+		ClaimsSupported:                   oidc.ClaimsSupported,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// UserInfo is a handler that returns the claims about the user the session's scope allows
+func UserInfo(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	currentSession, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Our own logins haven't consented to anything, they can see everything about themselves
+	scope := currentSession.Scope
+	if currentSession.ClientID == "" {
+		scope = oidc.AllScopes
+	}
+
+	response, err := json.Marshal(oidc.UserClaims(currentUser, scope))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
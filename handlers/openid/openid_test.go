@@ -0,0 +1,80 @@
+package openid
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/oidc"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+)
+
+func TestDiscovery(t *testing.T) {
+	signing.Keys = signing.NewRegister()
+	signing.Keys.AddSecret([]byte("fenderdigital"))
+	defer func(issuer string) { tokens.Issuer = issuer }(tokens.Issuer)
+
+	// Without an issuer URL there's nothing a client could check our tokens against
+	tokens.Issuer = "fender"
+	w := httptest.NewRecorder()
+	Discovery(w, httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Discovery() without an issuer URL status = %v, want %v", w.Code, http.StatusNotImplemented)
+	}
+
+	tokens.Issuer = "https://auth.fender.com"
+	w = httptest.NewRecorder()
+	Discovery(w, httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Discovery() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	config := configuration{}
+	err := json.Unmarshal(w.Body.Bytes(), &config)
+	if err != nil {
+		t.Fatalf("Discovery() returned invalid JSON: %v", err)
+	}
+
+	if config.Issuer != "https://auth.fender.com" || config.AuthorizationEndpoint != "https://auth.fender.com/oauth/authorize" || config.JWKSURI != "https://auth.fender.com/.well-known/jwks.json" || config.UserInfoEndpoint != "https://auth.fender.com/userinfo" {
+		t.Errorf("Discovery() = %+v, want endpoints under the issuer", config)
+	}
+	if !reflect.DeepEqual(config.IDTokenSigningAlgValuesSupported, []string{"HS512"}) || !reflect.DeepEqual(config.CodeChallengeMethodsSupported, []string{"S256"}) {
+		t.Errorf("Discovery() = %+v, want HS512 id_tokens and S256 PKCE", config)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	verifiedAt := time.Now()
+	user := models.User{UUID: "abc", Email: "test@test.com", Name: "Testy McTesterson", VerifiedAt: &verifiedAt}
+
+	tests := []struct {
+		name    string
+		session models.Session
+		want    map[string]interface{}
+	}{
+		{name: "first party", session: models.Session{UUID: "abc", UserUUID: "abc"}, want: map[string]interface{}{"sub": "abc", "name": "Testy McTesterson", "email": "test@test.com", "email_verified": true}},
+		{name: "openid only", session: models.Session{UUID: "abc", UserUUID: "abc", ClientID: "studio", Scope: oidc.ScopeOpenID}, want: map[string]interface{}{"sub": "abc"}},
+		{name: "openid email", session: models.Session{UUID: "abc", UserUUID: "abc", ClientID: "studio", Scope: "openid email"}, want: map[string]interface{}{"sub": "abc", "email": "test@test.com", "email_verified": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/userinfo", nil)
+			r = r.WithContext(auth.NewContext(r.Context(), tt.session, user))
+			UserInfo(w, r)
+
+			got := map[string]interface{}{}
+			json.Unmarshal(w.Body.Bytes(), &got)
+			if w.Code != http.StatusOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UserInfo() = %v %v, want %v", w.Code, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package passwordreset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/handlers/session"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mailer"
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/ratelimit"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tenant"
+)
+
+// resetLifetime is how long the link in a password reset email works for
+const resetLifetime = time.Hour
+
+// ResetURL is the page password reset links point at, the token is added as a "token" query parameter.
+// When it's empty the email just contains the token to POST to /password-resets/{token}.
+var ResetURL string
+
+// DefaultEmailRate lets an address be sent 3 reset emails at once and one every 20 minutes after that
+var DefaultEmailRate = ratelimit.Rate{Burst: 3, Every: 20 * time.Minute}
+
+// EmailLimiter throttles reset requests per email address, whether or not there's an account for it, so nobody can
+// fill someone's inbox. Requests are throttled per client address by session.IPLimiter as well.
+var EmailLimiter ratelimit.Limiter = ratelimit.NewMemory(DefaultEmailRate)
+
+// async runs work the response shouldn't wait for, it's swapped out in tests to run it inline
+var async = func(f func()) { go f() }
+
+// Create is a handler that emails a password reset token to the given address if it belongs to a user in the
+// organization named by the request, or the default one. It answers 202 whether or not it does, so it can't be used
+// to find out who has an account.
+func Create(w http.ResponseWriter, r *http.Request) {
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := createRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil || parsedBody.Email == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Every request stores a reset and sends an email, so they're throttled like logins
+	if session.Throttled(w, session.IPLimiter, auth.ClientIP(r)) || session.Throttled(w, EmailLimiter, strings.ToLower(strings.TrimSpace(parsedBody.Email))) {
+		return
+	}
+
+	// Which organizations exist isn't a secret, only who has an account in them
+	organization, err := tenant.Find(parsedBody.Organization)
+	if err == tenant.ErrUnknownOrganization {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Unknown organization"}`))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	user, err := postgres.DB.GetUserByEmail(organization.UUID, parsedBody.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Sending is left out of the response, otherwise how long the mail server takes would give away which emails
+	// have an account
+	if user.UUID != "" {
+		async(func() {
+			err := sendReset(user.UUID, user.Email)
+			if err != nil {
+				log.Printf("couldn't send password reset email to user %v: %v", user.UUID, err)
+			}
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"message": "If an account exists for that email, a password reset link has been sent to it"}`))
+}
+
+// Reset is a handler that sets a new password for the user a reset token was sent to, logging them out everywhere
+func Reset(w http.ResponseWriter, r *http.Request) {
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := resetRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Check password
+	if parsedBody.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Password must be set"}`))
+		return
+	}
+
+	passwordReset, err := postgres.DB.GetPasswordResetByHash(randtoken.Hash(mux.Vars(r)["token"]))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if passwordReset.UUID == "" || passwordReset.UsedAt != nil || time.Now().After(passwordReset.ExpiresAt) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Password reset token is invalid or expired"}`))
+		return
+	}
+
+	user, err := postgres.DB.GetUserByUUID(passwordReset.UserUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if user.UUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Password reset token is invalid or expired"}`))
+		return
+	}
+
+	// Check the password against the policy, the same as changing it any other way
+	candidate := password.Candidate{Password: parsedBody.Password, Email: user.Email, Name: user.Name}
+	if password.DefaultPolicy.HistorySize > 0 {
+		candidate.History, err = postgres.DB.GetPasswordHistoryByUserUUID(user.UUID, password.DefaultPolicy.HistorySize)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+			return
+		}
+	}
+
+	violations, err := password.DefaultPolicy.Check(candidate)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if len(violations) > 0 {
+		response, err := json.Marshal(violationsResponse{Message: "Password doesn't meet the password policy", Violations: violations})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+			return
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(response)
+		return
+	}
+
+	// Use up the token before changing anything, so two requests racing with it can't both succeed
+	numRows, err := postgres.DB.UsePasswordResetByUUID(passwordReset.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if numRows == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Password reset token is invalid or expired"}`))
+		return
+	}
+
+	user, err = postgres.DB.UpdateUserByUUID(user.UUID, "", "", parsedBody.Password, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if user.UUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Password reset token is invalid or expired"}`))
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay logged in
+	_, err = postgres.DB.SoftDeleteSessionsByUserUUID(user.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Nor should their failed attempts keep the new password locked out
+	_, err = postgres.DB.ResetFailedLoginsByUUID(user.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Password has been reset"}`))
+}
+
+// sendReset mails the user a token that lets them set a new password
+func sendReset(userUUID, email string) error {
+	token, err := randtoken.Generate()
+	if err != nil {
+		return err
+	}
+
+	_, err = postgres.DB.CreatePasswordReset(userUUID, randtoken.Hash(token), time.Now().Add(resetLifetime))
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Someone asked to reset your password, if it wasn't you you can ignore this email. This reset token expires in %v:\n\n%v\n", resetLifetime, token)
+	if ResetURL != "" {
+		body = fmt.Sprintf("Someone asked to reset your password, if it wasn't you you can ignore this email. This link expires in %v:\n\n%v?token=%v\n", resetLifetime, ResetURL, url.QueryEscape(token))
+	}
+
+	return mailer.Default.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body:    body,
+	})
+}
+
+type createRequest struct {
+	Organization string `json:"organization,omitempty"`
+	Email        string `json:"email"`
+}
+
+type resetRequest struct {
+	Password string `json:"password"`
+}
+
+type violationsResponse struct {
+	Message    string               `json:"message"`
+	Violations []password.Violation `json:"violations"`
+}
@@ -0,0 +1,283 @@
+package passwordreset
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/handlers/session"
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mailer"
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/ratelimit"
+)
+
+// resetDB records what a password reset does, and can pretend the email doesn't belong to anyone
+type resetDB struct {
+	postgres.DBMock
+	unknownEmail    bool
+	reset           models.PasswordReset
+	createdHash     string
+	used            bool
+	updatedPassword string
+	sessionsRevoked bool
+	loginsReset     bool
+}
+
+func (d *resetDB) GetUserByEmail(organizationUUID, email string) (models.User, error) {
+	if d.unknownEmail {
+		return models.User{}, nil
+	}
+	return d.DBMock.GetUserByEmail(organizationUUID, email)
+}
+
+func (d *resetDB) CreatePasswordReset(userUUID, tokenHash string, expiresAt time.Time) (models.PasswordReset, error) {
+	d.createdHash = tokenHash
+	return d.DBMock.CreatePasswordReset(userUUID, tokenHash, expiresAt)
+}
+
+func (d *resetDB) GetPasswordResetByHash(tokenHash string) (models.PasswordReset, error) {
+	return d.reset, nil
+}
+
+func (d *resetDB) UsePasswordResetByUUID(uuid string) (int, error) {
+	d.used = true
+	return 1, nil
+}
+
+func (d *resetDB) UpdateUserByUUID(uuid, email, name, plaintextPassword string, ifUpdatedAt *time.Time) (models.User, error) {
+	d.updatedPassword = plaintextPassword
+	return d.DBMock.UpdateUserByUUID(uuid, email, name, plaintextPassword, ifUpdatedAt)
+}
+
+func (d *resetDB) SoftDeleteSessionsByUserUUID(userUUID string) (int, error) {
+	d.sessionsRevoked = true
+	return 2, nil
+}
+
+func (d *resetDB) ResetFailedLoginsByUUID(uuid string) (int, error) {
+	d.loginsReset = true
+	return 1, nil
+}
+
+// pwnedChecker finds every password in a breach
+type pwnedChecker struct{}
+
+func (pwnedChecker) Range(prefix string) (map[string]int, error) {
+	// The suffix of "password", which is what the pwned test case sends
+	return map[string]int{"1E4C9B93F3F0682250B6CF8331B7EE68FD8": 1}, nil
+}
+
+func init() {
+	async = func(f func()) { f() }
+	session.IPLimiter, EmailLimiter = ratelimit.Unlimited{}, ratelimit.Unlimited{}
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name         string
+		unknownEmail bool
+		wantEmail    bool
+	}{
+		{
+			name:      "test known email",
+			wantEmail: true,
+		},
+		{
+			name:         "test unknown email",
+			unknownEmail: true,
+			wantEmail:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := &mailer.Memory{}
+			mailer.Default = outbox
+			db := &resetDB{unknownEmail: tt.unknownEmail}
+			postgres.DB = db
+
+			w := httptest.NewRecorder()
+			Create(w, httptest.NewRequest("POST", "/password-resets", bytes.NewReader([]byte(`{"email": "test@test.com"}`))))
+
+			if w.Code != http.StatusAccepted {
+				t.Errorf("Create() status = %v, want %v", w.Code, http.StatusAccepted)
+			}
+
+			msg, sent := outbox.Last()
+			if sent != tt.wantEmail {
+				t.Fatalf("Create() sent an email = %v, want %v", sent, tt.wantEmail)
+			}
+
+			if sent {
+				token := strings.TrimSpace(msg.Body[strings.LastIndex(msg.Body, "\n\n"):])
+				if randtoken.Hash(token) != db.createdHash {
+					t.Errorf("Create() mailed token %q, which doesn't match the stored hash", token)
+				}
+			}
+		})
+	}
+}
+
+func TestCreate_Throttled(t *testing.T) {
+	postgres.DB = &resetDB{}
+	session.IPLimiter = ratelimit.NewMemory(ratelimit.Rate{Burst: 2, Every: time.Minute})
+	EmailLimiter = ratelimit.NewMemory(ratelimit.Rate{Burst: 1, Every: 20 * time.Minute})
+	defer func() { session.IPLimiter, EmailLimiter = ratelimit.Unlimited{}, ratelimit.Unlimited{} }()
+
+	tests := []struct {
+		name           string
+		email          string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:       "test first request",
+			email:      "test@test.com",
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:           "test same email differently cased",
+			email:          " TEST@test.com",
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "1200",
+		},
+		{
+			name:           "test same address",
+			email:          "other@test.com",
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := &mailer.Memory{}
+			mailer.Default = outbox
+			w := httptest.NewRecorder()
+			Create(w, httptest.NewRequest("POST", "/password-resets", bytes.NewReader([]byte(`{"email": "`+tt.email+`"}`))))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Create() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Create() Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			if _, sent := outbox.Last(); sent != (tt.wantStatus == http.StatusAccepted) {
+				t.Errorf("Create() sent an email = %v with status %v", sent, w.Code)
+			}
+		})
+	}
+}
+
+// TestCreate_DoesNotWaitToSend checks the email is sent after the response, so a slow mail server doesn't make
+// known emails answer slower than unknown ones
+func TestCreate_DoesNotWaitToSend(t *testing.T) {
+	var deferred []func()
+	async = func(f func()) { deferred = append(deferred, f) }
+	defer func() { async = func(f func()) { f() } }()
+
+	outbox := &mailer.Memory{}
+	mailer.Default = outbox
+	postgres.DB = &resetDB{}
+
+	w := httptest.NewRecorder()
+	Create(w, httptest.NewRequest("POST", "/password-resets", bytes.NewReader([]byte(`{"email": "test@test.com"}`))))
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Create() status = %v, want %v", w.Code, http.StatusAccepted)
+	}
+	if _, sent := outbox.Last(); sent {
+		t.Fatalf("Create() sent the email before responding")
+	}
+
+	for _, f := range deferred {
+		f()
+	}
+	if _, sent := outbox.Last(); !sent {
+		t.Errorf("Create() never sent the email")
+	}
+}
+
+func TestReset(t *testing.T) {
+	breaches := password.Breaches
+	defer func() { password.Breaches = breaches }()
+
+	usedAt := time.Now().Add(-time.Minute)
+	valid := models.PasswordReset{UUID: "abc", UserUUID: "abc", ExpiresAt: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name       string
+		body       string
+		reset      models.PasswordReset
+		pwned      bool
+		wantStatus int
+	}{
+		{
+			name:       "test success",
+			body:       `{"password": "9X&5eQ#TI9IzBM"}`,
+			reset:      valid,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "test missing password",
+			body:       `{}`,
+			reset:      valid,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "test pwned password",
+			body:       `{"password": "password"}`,
+			reset:      valid,
+			pwned:      true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "test unknown token",
+			body:       `{"password": "9X&5eQ#TI9IzBM"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "test used token",
+			body:       `{"password": "9X&5eQ#TI9IzBM"}`,
+			reset:      models.PasswordReset{UUID: "abc", UserUUID: "abc", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "test expired token",
+			body:       `{"password": "9X&5eQ#TI9IzBM"}`,
+			reset:      models.PasswordReset{UUID: "abc", UserUUID: "abc", ExpiresAt: time.Now().Add(-time.Minute)},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			password.Breaches = password.NoopChecker{}
+			if tt.pwned {
+				password.Breaches = pwnedChecker{}
+			}
+			db := &resetDB{reset: tt.reset}
+			postgres.DB = db
+
+			r := httptest.NewRequest("POST", "/password-resets/abc", bytes.NewReader([]byte(tt.body)))
+			r = mux.SetURLVars(r, map[string]string{"token": "abc"})
+			w := httptest.NewRecorder()
+			Reset(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Reset() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+
+			succeeded := tt.wantStatus == http.StatusOK
+			if db.used != succeeded || (db.updatedPassword != "") != succeeded || db.sessionsRevoked != succeeded || db.loginsReset != succeeded {
+				t.Errorf("Reset() used token = %v, updated password = %v, revoked sessions = %v, unlocked = %v, want all %v", db.used, db.updatedPassword != "", db.sessionsRevoked, db.loginsReset, succeeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/federation"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+// BeginFederatedLogin is a handler that starts a login with one of the configured identity providers. It returns
+// the URL to send the user to, the code and state they come back with are sent to Create along with the provider.
+// The frontend should hold on to the state and only send back a state it started with.
+func BeginFederatedLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := federation.Providers[mux.Vars(r)["provider"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Unknown identity provider"}`))
+		return
+	}
+
+	// Every call writes a login state, so it's throttled like a login attempt
+	if Throttled(w, IPLimiter, auth.ClientIP(r)) {
+		return
+	}
+
+	state, err := randtoken.Generate()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	codeVerifier, err := randtoken.Generate()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(state, codeVerifier)
+	if err != nil {
+		log.Printf("couldn't start a login with %v: %v", provider.Name, err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"message": "Identity provider is unavailable"}`))
+		return
+	}
+
+	_, err = postgres.DB.CreateFederatedLoginState(provider.Name, randtoken.Hash(state), codeVerifier, time.Now().Add(federation.LoginTimeout))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(federatedLoginResponse{AuthorizationURL: authorizationURL, State: state})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// createWithProvider is the identity provider half of Create. It swaps the code for who the provider says the user
+// is, and finds the account linked to them. Failing that the account in the organization with their email address
+// is linked if the provider allows it, or a new account is created there if there isn't one.
+func createWithProvider(w http.ResponseWriter, r *http.Request, organization models.Organization, request sessionRequest) {
+	provider, ok := federation.Providers[request.Provider]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Unknown identity provider"}`))
+		return
+	}
+
+	if Throttled(w, IPLimiter, auth.ClientIP(r)) {
+		return
+	}
+
+	state, err := postgres.DB.GetFederatedLoginStateByHash(randtoken.Hash(request.State))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	currentTime := time.Now()
+	if state.UUID == "" || state.Provider != provider.Name || state.UsedAt != nil || currentTime.After(state.ExpiresAt) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Login state is invalid or expired"}`))
+		return
+	}
+
+	used, err := postgres.DB.UseFederatedLoginStateByUUID(state.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if used == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Login state is invalid or expired"}`))
+		return
+	}
+
+	identity, err := provider.Exchange(request.Code, state.CodeVerifier)
+	if err == federation.ErrInvalidGrant {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Identity provider rejected the login"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't finish a login with %v: %v", provider.Name, err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"message": "Identity provider is unavailable"}`))
+		return
+	}
+
+	user, status, message, err := federatedUser(organization, provider, identity)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if user.UUID == "" {
+		w.WriteHeader(status)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, message)))
+		return
+	}
+
+	if RequireVerifiedEmail && user.VerifiedAt == nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Email address has not been verified"}`))
+		return
+	}
+
+	// The provider only stands in for the password, a second factor is still asked for
+	credential, err := postgres.DB.GetTOTPCredentialByUserUUID(user.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if credential.ConfirmedAt != nil {
+		writeMFAChallenge(w, user.UUID, organization.UUID, currentTime)
+		return
+	}
+
+	startSession(w, r, user.UUID, organization.UUID, currentTime)
+}
+
+// federatedUser finds or creates the account for the provider's identity. A linked account is found wherever it
+// belongs, it's up to startSession whether it can log in to the organization. When there's no account to log in to
+// it returns an empty user, with the status and message to reject the login with.
+func federatedUser(organization models.Organization, provider *federation.Provider, identity federation.Identity) (models.User, int, string, error) {
+	linked, err := postgres.DB.GetFederatedIdentity(provider.Name, identity.Subject)
+	if err != nil {
+		return models.User{}, 0, "", err
+	}
+
+	if linked.UUID != "" {
+		user, err := postgres.DB.GetUserByUUID(linked.UserUUID)
+		return user, http.StatusUnauthorized, "Account has been deleted", err
+	}
+
+	if identity.Email == "" {
+		return models.User{}, http.StatusBadRequest, "Identity provider didn't share an email address", nil
+	}
+
+	existing, err := postgres.DB.GetUserByEmail(organization.UUID, identity.Email)
+	if err != nil {
+		return models.User{}, 0, "", err
+	}
+
+	if existing.UUID == "" {
+		user, err := postgres.DB.CreateFederatedUser(organization.UUID, identity.Email, identity.Name, identity.EmailVerified, provider.Name, identity.Subject)
+		return user, http.StatusConflict, "An account already uses this email address", err
+	}
+
+	// Both sides have to have verified the address, otherwise whoever signed up with it first, here or at the
+	// provider, could take over the other's account
+	if !provider.LinkByEmail || !identity.EmailVerified || existing.VerifiedAt == nil {
+		return models.User{}, http.StatusConflict, "An account already uses this email address", nil
+	}
+
+	created, err := postgres.DB.CreateFederatedIdentity(existing.UUID, provider.Name, identity.Subject, identity.Email)
+	if err != nil || created.UUID == "" {
+		return models.User{}, http.StatusConflict, "Identity is linked to another account", err
+	}
+
+	return existing, 0, "", nil
+}
+
+type federatedLoginResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/federation"
+	"github.com/kylegrantlucas/platform-exercise/pkg/federation/federationtest"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+// federatedDB keeps users, the identities linked to them and the login states handed out in memory
+type federatedDB struct {
+	postgres.DBMock
+	users      map[string]models.User
+	identities map[string]models.FederatedIdentity
+	states     map[string]*models.FederatedLoginState
+}
+
+func (d *federatedDB) CreateFederatedLoginState(provider, stateHash, codeVerifier string, expiresAt time.Time) (models.FederatedLoginState, error) {
+	d.states[stateHash] = &models.FederatedLoginState{UUID: stateHash, Provider: provider, CodeVerifier: codeVerifier, ExpiresAt: expiresAt}
+	return *d.states[stateHash], nil
+}
+
+func (d *federatedDB) GetFederatedLoginStateByHash(stateHash string) (models.FederatedLoginState, error) {
+	if state, ok := d.states[stateHash]; ok {
+		return *state, nil
+	}
+	return models.FederatedLoginState{}, nil
+}
+
+func (d *federatedDB) UseFederatedLoginStateByUUID(uuid string) (int, error) {
+	state := d.states[uuid]
+	if state.UsedAt != nil {
+		return 0, nil
+	}
+	usedAt := time.Now()
+	state.UsedAt = &usedAt
+	return 1, nil
+}
+
+func (d *federatedDB) GetFederatedIdentity(provider, subject string) (models.FederatedIdentity, error) {
+	return d.identities[provider+"/"+subject], nil
+}
+
+func (d *federatedDB) CreateFederatedIdentity(userUUID, provider, subject, email string) (models.FederatedIdentity, error) {
+	if _, ok := d.identities[provider+"/"+subject]; ok {
+		return models.FederatedIdentity{}, nil
+	}
+	d.identities[provider+"/"+subject] = models.FederatedIdentity{UUID: subject, UserUUID: userUUID, Provider: provider, Subject: subject, Email: email}
+	return d.identities[provider+"/"+subject], nil
+}
+
+func (d *federatedDB) CreateFederatedUser(organizationUUID, email, name string, verified bool, provider, subject string) (models.User, error) {
+	if _, ok := d.users[email]; ok {
+		return models.User{}, nil
+	}
+	user := models.User{UUID: email, Email: email, Name: name}
+	d.users[email] = user
+	d.CreateFederatedIdentity(user.UUID, provider, subject, email)
+	return user, nil
+}
+
+func (d *federatedDB) GetUserByEmail(organizationUUID, email string) (models.User, error) {
+	return d.users[email], nil
+}
+
+func (d *federatedDB) GetUserByUUID(uuid string) (models.User, error) {
+	for _, user := range d.users {
+		if user.UUID == uuid {
+			return user, nil
+		}
+	}
+	return models.User{}, nil
+}
+
+func TestCreate_Federated(t *testing.T) {
+	idp := federationtest.NewIdP("fender", "secret")
+	defer idp.Close()
+
+	provider := federation.NewProvider("test", idp.URL, "fender", "secret", "https://fender.com/login/callback")
+	federation.Providers = map[string]*federation.Provider{"test": provider}
+	defer func() { federation.Providers = map[string]*federation.Provider{} }()
+
+	verifiedAt := time.Now()
+	db := &federatedDB{
+		users: map[string]models.User{
+			"verified@test.com":   {UUID: "abc", Email: "verified@test.com", VerifiedAt: &verifiedAt},
+			"unverified@test.com": {UUID: "def", Email: "unverified@test.com"},
+		},
+		identities: map[string]models.FederatedIdentity{},
+		states:     map[string]*models.FederatedLoginState{},
+	}
+	postgres.DB = db
+
+	begin := func() federatedLoginResponse {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/sessions/providers/test", nil)
+		BeginFederatedLogin(w, mux.SetURLVars(r, map[string]string{"provider": "test"}))
+
+		started := federatedLoginResponse{}
+		json.Unmarshal(w.Body.Bytes(), &started)
+		if w.Code != http.StatusOK || started.AuthorizationURL == "" || started.State == "" {
+			t.Fatalf("BeginFederatedLogin() = %v %v, want an authorization URL", w.Code, w.Body.String())
+		}
+		return started
+	}
+
+	callback := func(identity federation.Identity) sessionRequest {
+		started := begin()
+		redirect, err := idp.Login(started.AuthorizationURL, identity)
+		if err != nil {
+			t.Fatalf("IdP.Login() error = %v", err)
+		}
+		return sessionRequest{Provider: "test", Code: redirect.Query().Get("code"), State: redirect.Query().Get("state")}
+	}
+
+	login := func(request sessionRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader(body)))
+		return w
+	}
+
+	// Someone new gets an account, and the same subject logs in to it next time even if their email changed
+	request := callback(federation.Identity{Subject: "1", Email: "new@test.com", EmailVerified: true, Name: "New"})
+	w := login(request)
+	tokens := tokenResponse{}
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if w.Code != http.StatusOK || tokens.Token == "" || db.users["new@test.com"].UUID == "" {
+		t.Fatalf("Create() for a new user = %v %v, want tokens for a new account", w.Code, w.Body.String())
+	}
+
+	if w := login(request); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() replaying a callback status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := login(callback(federation.Identity{Subject: "1", Email: "changed@test.com"})); w.Code != http.StatusOK {
+		t.Errorf("Create() for a linked subject = %v %v, want %v", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	// An existing account isn't linked unless the provider is trusted to
+	if w := login(callback(federation.Identity{Subject: "2", Email: "verified@test.com", EmailVerified: true})); w.Code != http.StatusConflict {
+		t.Errorf("Create() for an existing email without linking status = %v, want %v", w.Code, http.StatusConflict)
+	}
+
+	provider.LinkByEmail = true
+
+	// and then only if both sides verified the address
+	if w := login(callback(federation.Identity{Subject: "2", Email: "verified@test.com"})); w.Code != http.StatusConflict {
+		t.Errorf("Create() for an address the provider didn't verify status = %v, want %v", w.Code, http.StatusConflict)
+	}
+	if w := login(callback(federation.Identity{Subject: "3", Email: "unverified@test.com", EmailVerified: true})); w.Code != http.StatusConflict {
+		t.Errorf("Create() for an account that didn't verify its address status = %v, want %v", w.Code, http.StatusConflict)
+	}
+
+	if w := login(callback(federation.Identity{Subject: "2", Email: "verified@test.com", EmailVerified: true})); w.Code != http.StatusOK || db.identities["test/2"].UserUUID != "abc" {
+		t.Errorf("Create() linking by email = %v %v, want the existing account linked", w.Code, w.Body.String())
+	}
+
+	// A state from some other login, or none at all, is turned away before the provider is asked
+	if w := login(sessionRequest{Provider: "test", Code: "code", State: "made up"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() with an unknown state status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// So is a code the provider didn't issue
+	request = callback(federation.Identity{Subject: "1"})
+	request.Code = "made up"
+	if w := login(request); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() with an unknown code status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := login(sessionRequest{Provider: "other", Code: "code", State: "state"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Create() with an unknown provider status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mfa"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+const (
+	// mfaChallengeLifetime is how long a user has to send their second factor after getting their password right
+	mfaChallengeLifetime = 5 * time.Minute
+	// maxMFAAttempts is how many codes can be tried against one challenge, after that the password is needed again
+	maxMFAAttempts = 5
+)
+
+// MFA is a handler that exchanges the mfa_token Create hands out for a session, given a TOTP code or one of the
+// user's recovery codes
+func MFA(w http.ResponseWriter, r *http.Request) {
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := mfaRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil || parsedBody.MFAToken == "" || (parsedBody.Code == "" && parsedBody.RecoveryCode == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if Throttled(w, IPLimiter, auth.ClientIP(r)) {
+		return
+	}
+
+	challenge, err := postgres.DB.GetMFAChallengeByHash(randtoken.Hash(parsedBody.MFAToken))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	currentTime := time.Now()
+	if challenge.UUID == "" || challenge.UsedAt != nil || currentTime.After(challenge.ExpiresAt) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "MFA token is invalid or expired"}`))
+		return
+	}
+
+	// Count the attempt before checking it, so concurrent guesses can't get around the limit
+	attempted, err := postgres.DB.AttemptMFAChallengeByUUID(challenge.UUID, maxMFAAttempts)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if attempted == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "MFA token is invalid or expired"}`))
+		return
+	}
+
+	valid, err := checkSecondFactor(challenge.UserUUID, parsedBody, currentTime)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Invalid MFA code"}`))
+		return
+	}
+
+	used, err := postgres.DB.UseMFAChallengeByUUID(challenge.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if used == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "MFA token is invalid or expired"}`))
+		return
+	}
+
+	startSession(w, r, challenge.UserUUID, challenge.OrganizationUUID, currentTime)
+}
+
+// checkSecondFactor reports whether the request has a valid TOTP code or recovery code for the user, using it up
+// either way so it can't be used again
+func checkSecondFactor(userUUID string, parsedBody mfaRequest, currentTime time.Time) (bool, error) {
+	if parsedBody.RecoveryCode != "" {
+		used, err := postgres.DB.UseRecoveryCode(userUUID, mfa.HashRecoveryCode(parsedBody.RecoveryCode))
+		return used == 1, err
+	}
+
+	credential, err := postgres.DB.GetTOTPCredentialByUserUUID(userUUID)
+	if err != nil || credential.ConfirmedAt == nil {
+		return false, err
+	}
+
+	step, valid, err := mfa.CheckTOTP(credential, parsedBody.Code, currentTime)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	// Another request may have used the same code a moment ago
+	used, err := postgres.DB.UseTOTPStep(userUUID, step)
+	return used == 1, err
+}
+
+// writeMFAChallenge hands out a short lived token the user exchanges at MFA along with their second factor, for a
+// session in the organization they're logging in to
+func writeMFAChallenge(w http.ResponseWriter, userUUID, organizationUUID string, currentTime time.Time) {
+	token, err := randtoken.Generate()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	challenge, err := postgres.DB.CreateMFAChallenge(userUUID, organizationUUID, randtoken.Hash(token), currentTime.Add(mfaChallengeLifetime))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(mfaPendingResponse{MFAPending: true, MFAToken: token, ExpiresAt: challenge.ExpiresAt})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+type mfaRequest struct {
+	MFAToken     string `json:"mfa_token,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type mfaPendingResponse struct {
+	MFAPending bool      `json:"mfa_pending"`
+	MFAToken   string    `json:"mfa_token"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
@@ -0,0 +1,172 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mfa"
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/secrets"
+	"github.com/kylegrantlucas/platform-exercise/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mfaDB is a user with TOTP turned on, it keeps the challenges handed out and what's been used in memory
+type mfaDB struct {
+	postgres.DBMock
+	credential    models.TOTPCredential
+	challenges    map[string]*models.MFAChallenge
+	recoveryCodes map[string]bool
+}
+
+func (d *mfaDB) GetTOTPCredentialByUserUUID(userUUID string) (models.TOTPCredential, error) {
+	return d.credential, nil
+}
+
+func (d *mfaDB) UseTOTPStep(userUUID string, step int64) (int, error) {
+	if step <= d.credential.LastUsedStep {
+		return 0, nil
+	}
+	d.credential.LastUsedStep = step
+	return 1, nil
+}
+
+func (d *mfaDB) UseRecoveryCode(userUUID, codeHash string) (int, error) {
+	if !d.recoveryCodes[codeHash] {
+		return 0, nil
+	}
+	d.recoveryCodes[codeHash] = false
+	return 1, nil
+}
+
+func (d *mfaDB) CreateMFAChallenge(userUUID, organizationUUID, tokenHash string, expiresAt time.Time) (models.MFAChallenge, error) {
+	d.challenges[tokenHash] = &models.MFAChallenge{UUID: tokenHash, UserUUID: userUUID, OrganizationUUID: organizationUUID, ExpiresAt: expiresAt}
+	return *d.challenges[tokenHash], nil
+}
+
+func (d *mfaDB) GetMFAChallengeByHash(tokenHash string) (models.MFAChallenge, error) {
+	if challenge, ok := d.challenges[tokenHash]; ok {
+		return *challenge, nil
+	}
+	return models.MFAChallenge{}, nil
+}
+
+func (d *mfaDB) AttemptMFAChallengeByUUID(uuid string, maxAttempts int) (int, error) {
+	challenge := d.challenges[uuid]
+	if challenge.UsedAt != nil || challenge.Attempts >= maxAttempts {
+		return 0, nil
+	}
+	challenge.Attempts++
+	return 1, nil
+}
+
+func (d *mfaDB) UseMFAChallengeByUUID(uuid string) (int, error) {
+	challenge := d.challenges[uuid]
+	if challenge.UsedAt != nil {
+		return 0, nil
+	}
+	usedAt := time.Now()
+	challenge.UsedAt = &usedAt
+	return 1, nil
+}
+
+func TestMFA(t *testing.T) {
+	defaultHasher := password.Default
+	password.Default = &password.Bcrypt{Cost: bcrypt.MinCost}
+	defer func() { password.Default = defaultHasher }()
+
+	mfa.Secrets, _ = secrets.NewBox(bytes.Repeat([]byte{1}, secrets.KeySize))
+	defer func() { mfa.Secrets = nil }()
+
+	secret, _ := totp.GenerateSecret()
+	sealed, _ := mfa.SealSecret("abc", secret)
+	confirmedAt := time.Now()
+	db := &mfaDB{
+		credential:    models.TOTPCredential{UserUUID: "abc", Secret: sealed, ConfirmedAt: &confirmedAt},
+		challenges:    map[string]*models.MFAChallenge{},
+		recoveryCodes: map[string]bool{mfa.HashRecoveryCode("abcde-fghij"): true},
+	}
+	postgres.DB = db
+
+	login := func() string {
+		w := httptest.NewRecorder()
+		Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(`{"email": "test@gmail.com", "password": "test"}`))))
+
+		pending := mfaPendingResponse{}
+		json.Unmarshal(w.Body.Bytes(), &pending)
+		if w.Code != http.StatusOK || !pending.MFAPending || pending.MFAToken == "" {
+			t.Fatalf("Create() with MFA on = %v %v, want an mfa_token", w.Code, w.Body.String())
+		}
+
+		return pending.MFAToken
+	}
+
+	exchange := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		MFA(w, httptest.NewRequest("POST", "/sessions/mfa", bytes.NewReader([]byte(body))))
+		return w
+	}
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	token := login()
+
+	// The mfa_token is only good for a challenge, it's not a session
+	if _, ok := db.challenges[randtoken.Hash(token)]; !ok {
+		t.Fatalf("Create() didn't store the challenge hashed")
+	}
+
+	if w := exchange(`{"mfa_token": "` + token + `", "code": "000000"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("MFA() with a wrong code status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	w := exchange(`{"mfa_token": "` + token + `", "code": "` + code + `"}`)
+	tokens := tokenResponse{}
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if w.Code != http.StatusOK || tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("MFA() with the right code = %v %v, want tokens", w.Code, w.Body.String())
+	}
+
+	// Neither the challenge nor the code can be used twice
+	if w := exchange(`{"mfa_token": "` + token + `", "code": "` + code + `"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("MFA() with a used challenge status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := exchange(`{"mfa_token": "` + login() + `", "code": "` + code + `"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("MFA() replaying a code status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// Recovery codes work once, in any case
+	if w := exchange(`{"mfa_token": "` + login() + `", "recovery_code": "ABCDEFGHIJ"}`); w.Code != http.StatusOK {
+		t.Errorf("MFA() with a recovery code status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	if w := exchange(`{"mfa_token": "` + login() + `", "recovery_code": "abcde-fghij"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("MFA() with a used recovery code status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// A challenge only takes so many guesses
+	token = login()
+	for i := 0; i < maxMFAAttempts; i++ {
+		exchange(`{"mfa_token": "` + token + `", "code": "000000"}`)
+	}
+
+	next, _ := totp.Code(secret, totp.Step(time.Now())+1)
+	if w := exchange(`{"mfa_token": "` + token + `", "code": "` + next + `"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("MFA() after too many attempts status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := exchange(`{"mfa_token": "unknown", "code": "` + next + `"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("MFA() with an unknown token status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := exchange(`{"mfa_token": "` + token + `"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("MFA() without a code status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
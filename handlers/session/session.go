@@ -4,17 +4,53 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
 	"github.com/kylegrantlucas/platform-exercise/pkg/password"
-	"github.com/pascaldekloe/jwt"
+	"github.com/kylegrantlucas/platform-exercise/pkg/ratelimit"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tenant"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
 
 	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
 )
 
-// Create is a handler that creates a new user session
+// RequireVerifiedEmail makes Create refuse to log in users who haven't verified their email address yet
+var RequireVerifiedEmail bool
+
+var (
+	// DefaultIPRate lets a client address try 20 logins at once and one every 3 seconds after that
+	DefaultIPRate = ratelimit.Rate{Burst: 20, Every: 3 * time.Second}
+	// DefaultEmailRate lets an account be tried 5 times at once and once a minute after that, from anywhere
+	DefaultEmailRate = ratelimit.Rate{Burst: 5, Every: time.Minute}
+	// DefaultLockout locks an account for a minute after 5 failed logins in a row, doubling with each further failure up to an hour
+	DefaultLockout = ratelimit.Backoff{Threshold: 5, Base: time.Minute, Max: time.Hour}
+)
+
+var (
+	// IPLimiter throttles login attempts per client address
+	IPLimiter ratelimit.Limiter = ratelimit.NewMemory(DefaultIPRate)
+	// EmailLimiter throttles login attempts per email address, whether or not there's an account for it
+	EmailLimiter ratelimit.Limiter = ratelimit.NewMemory(DefaultEmailRate)
+	// Lockout decides how long an account is locked after consecutive failed logins
+	Lockout = DefaultLockout
+)
+
+var (
+	// compare checks a password against a hash, it's swapped out in tests to count comparisons
+	compare = password.ComparePlaintextWithEncypted
+	// async runs work the response shouldn't wait for, it's swapped out in tests to run it inline
+	async = func(f func()) { go f() }
+)
+
+// Create is a handler that creates a new user session in the organization named by the request, or the default one
 func Create(w http.ResponseWriter, r *http.Request) {
 	rawBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -29,36 +65,313 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := postgres.DB.GetUserByEmail(parsedBody.Email)
+	organization, ok := findOrganization(w, parsedBody.Organization)
+	if !ok {
+		return
+	}
+
+	// A passkey stands in for the email and password
+	if parsedBody.Credential != nil {
+		createWithWebAuthn(w, r, organization, *parsedBody.Credential)
+		return
+	}
+
+	// So does a code from an identity provider
+	if parsedBody.Provider != "" {
+		createWithProvider(w, r, organization, parsedBody)
+		return
+	}
+
+	// Throttle before touching the database or bcrypt, otherwise guessing is only as slow as we can hash
+	if Throttled(w, IPLimiter, auth.ClientIP(r)) || Throttled(w, EmailLimiter, strings.ToLower(strings.TrimSpace(parsedBody.Email))) {
+		return
+	}
+
+	// Email addresses are only unique within an organization, members from elsewhere log in to their own and switch
+	user, err := postgres.DB.GetUserByEmail(organization.UUID, parsedBody.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Every rejected login has to look the same, so an unknown or locked account is still compared, against a
+	// dummy hash of the same cost, and gets the same 401 as a wrong password. Neither the response nor how long
+	// it took says which it was.
+	dummyHash, err := password.DummyHash()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
 		return
 	}
 
-	if !password.ComparePlaintextWithEncypted(parsedBody.Password, user.Password) {
+	currentTime := time.Now()
+	locked := user.LockedUntil != nil && currentTime.Before(*user.LockedUntil)
+	hash := user.Password
+	if user.UUID == "" || locked {
+		hash = dummyHash
+	}
+
+	// Hashes made before the Default hasher got stronger are quicker to check than the dummy, so they're topped up
+	// with a comparison against it too. Otherwise a wrong password for an old account would be rejected sooner than
+	// one for an email nobody has.
+	matched := compare(parsedBody.Password, hash)
+	if hash != dummyHash && password.NeedsRehash(hash) {
+		compare(parsedBody.Password, dummyHash)
+	}
+
+	if !matched || user.UUID == "" || locked {
+		// Counting the failure is a write only real accounts get, so it's kept off the response's clock
+		if user.UUID != "" && !locked {
+			async(func() { recordFailedLogin(user, currentTime) })
+		}
+
 		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Invalid email or password"}`))
+		return
+	}
+
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		_, err = postgres.DB.ResetFailedLoginsByUUID(user.UUID)
+		if err != nil {
+			log.Printf("couldn't reset failed logins for user %v: %v", user.UUID, err)
+		}
+	}
+
+	// Now that we know the password, upgrade hashes made with an old algorithm or weaker parameters
+	if password.NeedsRehash(user.Password) {
+		rehashPassword(user, parsedBody.Password)
+	}
+
+	if RequireVerifiedEmail && user.VerifiedAt == nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Email address has not been verified"}`))
+		return
+	}
+
+	// With MFA on the password only earns a challenge, which is exchanged along with a second factor at MFA
+	credential, err := postgres.DB.GetTOTPCredentialByUserUUID(user.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if credential.ConfirmedAt != nil {
+		writeMFAChallenge(w, user.UUID, organization.UUID, currentTime)
+		return
+	}
+
+	startSession(w, r, user.UUID, organization.UUID, currentTime)
+}
+
+// startSession creates a new session for the user in the organization on the requesting device and writes out its
+// tokens, as long as they're still a member of it
+func startSession(w http.ResponseWriter, r *http.Request, userUUID, organizationUUID string, currentTime time.Time) {
+	membership, err := postgres.DB.GetMembership(organizationUUID, userUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if membership.UserUUID == "" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Not a member of this organization"}`))
+		return
+	}
+
+	session, err := postgres.DB.CreateSession(userUUID, organizationUUID, r.UserAgent(), auth.ClientIP(r), currentTime.Add(tokens.SessionLifetime))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	writeTokens(w, session, currentTime)
+}
+
+// findOrganization looks up the organization by its slug, writing out a 400 and returning false if there isn't one
+func findOrganization(w http.ResponseWriter, slug string) (models.Organization, bool) {
+	organization, err := tenant.Find(slug)
+	if err == tenant.ErrUnknownOrganization {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Unknown organization"}`))
+		return organization, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return organization, false
+	}
+
+	return organization, true
+}
+
+// Throttled takes a token for the key, if there isn't one it writes a 429 and returns true
+func Throttled(w http.ResponseWriter, limiter ratelimit.Limiter, key string) bool {
+	wait, err := limiter.Take(key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return true
+	}
+
+	if wait > 0 {
+		tooManyRequests(w, wait)
+		return true
+	}
+
+	return false
+}
+
+// tooManyRequests writes a 429 with a Retry-After header, rounded up to the next whole second
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{"message": "Too many attempts, try again later"}`))
+}
+
+// recordFailedLogin counts the failure against the user and locks them out once Lockout says so, failing to is
+// logged rather than failing the request since the rate limiters still apply
+func recordFailedLogin(user models.User, currentTime time.Time) {
+	failures, err := postgres.DB.RecordFailedLoginByUUID(user.UUID)
+	if err != nil {
+		log.Printf("couldn't record failed login for user %v: %v", user.UUID, err)
+		return
+	}
+
+	delay := Lockout.Delay(failures)
+	if delay == 0 {
+		return
+	}
+
+	_, err = postgres.DB.LockUserByUUID(user.UUID, currentTime.Add(delay))
+	if err != nil {
+		log.Printf("couldn't lock out user %v: %v", user.UUID, err)
+	}
+}
+
+// rehashPassword replaces the user's stored hash with one from the current hasher, failing to is logged
+// rather than failing the login since the old hash still works
+func rehashPassword(user models.User, plaintextPassword string) {
+	newHash, err := password.HashAndSalt(plaintextPassword)
+	if err != nil {
+		log.Printf("couldn't rehash password for user %v: %v", user.UUID, err)
+		return
+	}
+
+	_, err = postgres.DB.RehashUserPasswordByUUID(user.UUID, user.Password, newHash)
+	if err != nil {
+		log.Printf("couldn't rehash password for user %v: %v", user.UUID, err)
+	}
+}
+
+// Refresh is a handler that exchanges a refresh token for a new access token, rotating the refresh token in the process
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := refreshRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil || parsedBody.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	currentTime := time.Now()
-	expireTime := currentTime.Add(24 * time.Hour)
-	session, err := postgres.DB.CreateSession(user.UUID, expireTime)
+	session, err := tokens.Rotate(parsedBody.RefreshToken, "", currentTime)
+	if err == tokens.ErrInvalidRefreshToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	writeTokens(w, session, currentTime)
+}
+
+// SwitchOrganization is a handler that moves the user to another organization they're a member of. The session the
+// JWT token belongs to is logged out and a new one is started in the other organization, so tokens for the old one
+// stop working straight away.
+func SwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	current, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := switchOrganizationRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil || parsedBody.Organization == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	organization, ok := findOrganization(w, parsedBody.Organization)
+	if !ok {
+		return
+	}
+
+	// Check before logging out, someone switching to an organization they aren't in keeps the session they have
+	membership, err := postgres.DB.GetMembership(organization.UUID, current.UserUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if membership.UserUUID == "" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Not a member of this organization"}`))
+		return
+	}
+
+	_, err = postgres.DB.SoftDeleteSessionByUUID(current.UUID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
 		return
 	}
 
-	var claims jwt.Claims
-	claims.Issuer = "fender"
-	claims.Subject = user.UUID
-	claims.NotBefore = jwt.NewNumericTime(currentTime)
-	claims.Issued = jwt.NewNumericTime(currentTime)
-	claims.Expires = jwt.NewNumericTime(expireTime)
-	claims.Set = map[string]interface{}{"sid": session.UUID}
+	startSession(w, r, current.UserUUID, organization.UUID, time.Now())
+}
+
+// List is a handler that lists the active sessions of the user the JWT token belongs to
+func List(w http.ResponseWriter, r *http.Request) {
+	current, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := postgres.DB.ListActiveSessionsByUserUUID(current.UserUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
 
-	token, err := claims.HMACSign(jwt.HS512, []byte(os.Getenv("JWT_KEY")))
+	// Flag the session making the request so clients can tell "this device" apart
+	listed := []listedSession{}
+	for _, session := range sessions {
+		listed = append(listed, listedSession{Session: session, Current: session.UUID == current.UUID})
+	}
+
+	response, err := json.Marshal(listed)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
@@ -66,12 +379,84 @@ func Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf(`{"token": "%v"}`, string(token))))
+	w.Write(response)
 }
 
-// Delete is a handler that deletes the session by the UUID in the JWT token
+// Delete is a handler that deletes the session the JWT token belongs to, or every session of its user with ?all=true
 func Delete(w http.ResponseWriter, r *http.Request) {
-	_, err := postgres.DB.SoftDeleteSessionByUUID(r.Header["X-Verified-Session-Uuid"][0])
+	session, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var err error
+	if r.URL.Query().Get("all") == "true" {
+		_, err = postgres.DB.SoftDeleteSessionsByUserUUID(session.UserUUID)
+	} else {
+		_, err = postgres.DB.SoftDeleteSessionByUUID(session.UUID)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteByUUID is a handler that deletes one of the sessions of the user the JWT token belongs to, ex: a lost phone
+func DeleteByUUID(w http.ResponseWriter, r *http.Request) {
+	current, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	deleted, err := postgres.DB.SoftDeleteUserSessionByUUID(current.UserUUID, mux.Vars(r)["uuid"])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Sessions belonging to someone else look exactly like sessions that don't exist
+	if deleted == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeTokens mints a new access token and refresh token for the session and writes them out as the response
+func writeTokens(w http.ResponseWriter, session models.Session, currentTime time.Time) {
+	access := tokens.ForSession(session)
+
+	// The roles are stamped in so frontends know what to show, they're read again on every refresh
+	roles, err := postgres.DB.ListRolesByMembership(session.OrganizationUUID, session.UserUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+	access.Roles = roles
+
+	token, err := access.Sign(currentTime)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	refreshToken, err := tokens.NewRefreshToken(session)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(tokenResponse{Token: token, RefreshToken: refreshToken})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
@@ -79,9 +464,33 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
+	w.Write(response)
 }
 
 type sessionRequest struct {
-	Email    string `json:"email,omitempty"`
-	Password string `json:"password,omitempty"`
+	Organization string                      `json:"organization,omitempty"`
+	Email        string                      `json:"email,omitempty"`
+	Password     string                      `json:"password,omitempty"`
+	Credential   *webauthn.AssertionResponse `json:"credential,omitempty"`
+	Provider     string                      `json:"provider,omitempty"`
+	Code         string                      `json:"code,omitempty"`
+	State        string                      `json:"state,omitempty"`
+}
+
+type switchOrganizationRequest struct {
+	Organization string `json:"organization,omitempty"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type listedSession struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
@@ -2,13 +2,29 @@ package session
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
 	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/ratelimit"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+	"golang.org/x/crypto/bcrypt"
 )
 
+func init() {
+	signing.Keys.AddSecret([]byte("fenderdigital"))
+	IPLimiter, EmailLimiter = ratelimit.Unlimited{}, ratelimit.Unlimited{}
+	async = func(f func()) { f() }
+}
+
 func TestCreate(t *testing.T) {
 	postgres.DB = &postgres.DBMock{}
 
@@ -35,11 +51,599 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+// adminDB gives every user the admin role, in every organization
+type adminDB struct {
+	postgres.DBMock
+}
+
+func (d *adminDB) ListRolesByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return []string{"admin"}, nil
+}
+
+func TestCreate_Roles(t *testing.T) {
+	postgres.DB = &adminDB{}
+
+	w := httptest.NewRecorder()
+	Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(`{"email": "test@gmail.com", "password": "test"}`))))
+
+	response := tokenResponse{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	claims, err := signing.Keys.Check([]byte(response.Token))
+	if err != nil {
+		t.Fatalf("Create() = %v %v, want a token", w.Code, w.Body.String())
+	}
+
+	if roles := claims.Set["roles"]; !reflect.DeepEqual(roles, []interface{}{"admin"}) {
+		t.Errorf("Create() roles claim = %v, want [admin]", roles)
+	}
+}
+
+// organizationDB has two organizations, fender and gibson, with the user abc only a member of fender. It remembers
+// the sessions started and logged out of.
+type organizationDB struct {
+	postgres.DBMock
+	created []models.Session
+	deleted []string
+}
+
+func (d *organizationDB) GetOrganizationBySlug(slug string) (models.Organization, error) {
+	if slug != "fender" && slug != "gibson" {
+		return models.Organization{}, nil
+	}
+	return models.Organization{UUID: slug + "-uuid", Slug: slug}, nil
+}
+
+func (d *organizationDB) GetMembership(organizationUUID, userUUID string) (models.Membership, error) {
+	if organizationUUID != "fender-uuid" || userUUID != "abc" {
+		return models.Membership{}, nil
+	}
+	return models.Membership{OrganizationUUID: organizationUUID, UserUUID: userUUID}, nil
+}
+
+func (d *organizationDB) CreateSession(userUUID, organizationUUID, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error) {
+	session := models.Session{UUID: "new", UserUUID: userUUID, OrganizationUUID: organizationUUID, ExpiresAt: expiresAt}
+	d.created = append(d.created, session)
+	return session, nil
+}
+
+func (d *organizationDB) SoftDeleteSessionByUUID(uuid string) (int, error) {
+	d.deleted = append(d.deleted, uuid)
+	return 1, nil
+}
+
+func TestCreate_Organization(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantOrg    string
+	}{
+		{name: "member", body: `{"organization": "fender", "email": "test@gmail.com", "password": "test"}`, wantStatus: http.StatusOK, wantOrg: "fender-uuid"},
+		{name: "not a member", body: `{"organization": "gibson", "email": "test@gmail.com", "password": "test"}`, wantStatus: http.StatusForbidden},
+		{name: "unknown organization", body: `{"organization": "martin", "email": "test@gmail.com", "password": "test"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &organizationDB{}
+			postgres.DB = db
+
+			w := httptest.NewRecorder()
+			Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(tt.body))))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Create() = %v %v, want %v", w.Code, w.Body.String(), tt.wantStatus)
+			}
+
+			if tt.wantOrg == "" {
+				if len(db.created) != 0 {
+					t.Errorf("Create() started a session %+v", db.created)
+				}
+				return
+			}
+
+			response := tokenResponse{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			claims, err := signing.Keys.Check([]byte(response.Token))
+			if err != nil {
+				t.Fatalf("Create() made a token that doesn't check: %v", err)
+			}
+
+			if org, _ := claims.String("org"); org != tt.wantOrg || db.created[0].OrganizationUUID != tt.wantOrg {
+				t.Errorf("Create() org claim = %q, session %+v, want %q", org, db.created[0], tt.wantOrg)
+			}
+		})
+	}
+}
+
+func TestSwitchOrganization(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantDeleted bool
+	}{
+		{name: "member", body: `{"organization": "fender"}`, wantStatus: http.StatusOK, wantDeleted: true},
+		{name: "not a member", body: `{"organization": "gibson"}`, wantStatus: http.StatusForbidden},
+		{name: "unknown organization", body: `{"organization": "martin"}`, wantStatus: http.StatusBadRequest},
+		{name: "no organization", body: `{}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &organizationDB{}
+			postgres.DB = db
+
+			r := httptest.NewRequest("POST", "/sessions/switch-org", bytes.NewReader([]byte(tt.body)))
+			current := models.Session{UUID: "old", UserUUID: "abc", OrganizationUUID: "gibson-uuid"}
+			r = r.WithContext(auth.NewContext(r.Context(), current, models.User{UUID: "abc"}))
+
+			w := httptest.NewRecorder()
+			SwitchOrganization(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("SwitchOrganization() = %v %v, want %v", w.Code, w.Body.String(), tt.wantStatus)
+			}
+
+			// The old session is only logged out of once there's a new one to replace it
+			if deleted := len(db.deleted) == 1 && db.deleted[0] == "old"; deleted != tt.wantDeleted {
+				t.Errorf("SwitchOrganization() logged out of %v, want the old session logged out %v", db.deleted, tt.wantDeleted)
+			}
+
+			if tt.wantDeleted && (len(db.created) != 1 || db.created[0].OrganizationUUID != "fender-uuid") {
+				t.Errorf("SwitchOrganization() started %+v, want a session in fender", db.created)
+			}
+		})
+	}
+}
+
+// verifiedUserDB returns a user that has verified their email address
+type verifiedUserDB struct {
+	postgres.DBMock
+}
+
+func (d *verifiedUserDB) GetUserByEmail(organizationUUID, email string) (models.User, error) {
+	user, err := d.DBMock.GetUserByEmail(organizationUUID, email)
+	verifiedAt := time.Now()
+	user.VerifiedAt = &verifiedAt
+	return user, err
+}
+
+func TestCreate_RequireVerifiedEmail(t *testing.T) {
+	RequireVerifiedEmail = true
+	defer func() { RequireVerifiedEmail = false }()
+
+	tests := []struct {
+		name       string
+		db         postgres.Databaser
+		wantStatus int
+	}{
+		{
+			name:       "test unverified",
+			db:         &postgres.DBMock{},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "test verified",
+			db:         &verifiedUserDB{},
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postgres.DB = tt.db
+
+			w := httptest.NewRecorder()
+			Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(`{"email": "test@gmail.com", "password": "test"}`))))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Create() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCreate_Throttled(t *testing.T) {
+	postgres.DB = &postgres.DBMock{}
+	IPLimiter = ratelimit.NewMemory(ratelimit.Rate{Burst: 2, Every: time.Minute})
+	EmailLimiter = ratelimit.NewMemory(ratelimit.Rate{Burst: 1, Every: 90 * time.Second})
+	defer func() { IPLimiter, EmailLimiter = ratelimit.Unlimited{}, ratelimit.Unlimited{} }()
+
+	tests := []struct {
+		name           string
+		email          string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:       "test first attempt",
+			email:      "test@gmail.com",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:           "test same email differently cased",
+			email:          " TEST@gmail.com",
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "90",
+		},
+		{
+			name:           "test same address",
+			email:          "other@gmail.com",
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(`{"email": "`+tt.email+`", "password": "test"}`))))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Create() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Create() Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+// lockoutDB returns a user with the given failed logins and lock, and records changes to them
+type lockoutDB struct {
+	postgres.DBMock
+	failures    int
+	lockedUntil *time.Time
+	reset       bool
+}
+
+func (d *lockoutDB) GetUserByEmail(organizationUUID, email string) (models.User, error) {
+	user, err := d.DBMock.GetUserByEmail(organizationUUID, email)
+	user.FailedLoginCount, user.LockedUntil = d.failures, d.lockedUntil
+	return user, err
+}
+
+func (d *lockoutDB) RecordFailedLoginByUUID(uuid string) (int, error) {
+	d.failures++
+	return d.failures, nil
+}
+
+func (d *lockoutDB) LockUserByUUID(uuid string, until time.Time) (int, error) {
+	d.lockedUntil = &until
+	return 1, nil
+}
+
+func (d *lockoutDB) ResetFailedLoginsByUUID(uuid string) (int, error) {
+	d.failures, d.lockedUntil, d.reset = 0, nil, true
+	return 1, nil
+}
+
+func TestCreate_Lockout(t *testing.T) {
+	db := &lockoutDB{failures: DefaultLockout.Threshold - 1}
+	postgres.DB = db
+
+	login := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(`{"email": "test@gmail.com", "password": "`+password+`"}`))))
+		return w
+	}
+
+	// The failure that reaches the threshold is still a 401, but it locks the account
+	if w := login("wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Create() status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	if db.lockedUntil == nil || time.Until(*db.lockedUntil) <= DefaultLockout.Base-time.Second {
+		t.Fatalf("Create() locked until %v, want about %v from now", db.lockedUntil, DefaultLockout.Base)
+	}
+
+	// Even the right password is refused while locked, the same way a wrong one is
+	if w := login("test"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() while locked status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// Once the lock has passed a successful login clears the count
+	expired := time.Now().Add(-time.Second)
+	db.lockedUntil = &expired
+	if w := login("test"); w.Code != http.StatusOK {
+		t.Errorf("Create() after the lock status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	if !db.reset || db.failures != 0 {
+		t.Errorf("Create() after the lock didn't reset failed logins, failures = %v", db.failures)
+	}
+}
+
+// tracingDB records the database calls a login makes, returning the user it's given for any email
+type tracingDB struct {
+	postgres.DBMock
+	user  models.User
+	calls []string
+}
+
+func (d *tracingDB) GetUserByEmail(organizationUUID, email string) (models.User, error) {
+	d.calls = append(d.calls, "GetUserByEmail")
+	return d.user, nil
+}
+
+func (d *tracingDB) RecordFailedLoginByUUID(uuid string) (int, error) {
+	d.calls = append(d.calls, "RecordFailedLoginByUUID")
+	return 1, nil
+}
+
+func (d *tracingDB) LockUserByUUID(uuid string, until time.Time) (int, error) {
+	d.calls = append(d.calls, "LockUserByUUID")
+	return 1, nil
+}
+
+// loginTrace is everything about a login a client could observe or time
+type loginTrace struct {
+	status      int
+	body        string
+	header      http.Header
+	calls       []string
+	comparisons []int
+}
+
+// traceLogin runs a login, recording the database calls and password comparisons made before the response,
+// along with their costs. Work handed to async is left out, the response doesn't wait for it.
+func traceLogin(t *testing.T, user models.User, body string) loginTrace {
+	db := &tracingDB{user: user}
+	postgres.DB = db
+
+	trace := loginTrace{}
+	compare = func(plaintextPassword, encryptedPassword string) bool {
+		cost, err := bcrypt.Cost([]byte(encryptedPassword))
+		if err != nil {
+			t.Fatalf("compared against something that isn't a bcrypt hash: %q", encryptedPassword)
+		}
+		trace.comparisons = append(trace.comparisons, cost)
+		return password.ComparePlaintextWithEncypted(plaintextPassword, encryptedPassword)
+	}
+
+	var deferred []func()
+	async = func(f func()) { deferred = append(deferred, f) }
+	defer func() {
+		compare = password.ComparePlaintextWithEncypted
+		async = func(f func()) { f() }
+	}()
+
+	w := httptest.NewRecorder()
+	Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(body))))
+	trace.status, trace.body, trace.header, trace.calls = w.Code, w.Body.String(), w.Header(), db.calls
+
+	for _, f := range deferred {
+		f()
+	}
+
+	return trace
+}
+
+func TestCreate_Indistinguishable(t *testing.T) {
+	defaultHasher := password.Default
+	password.Default = &password.Bcrypt{Cost: bcrypt.MinCost + 1}
+	defer func() { password.Default = defaultHasher }()
+
+	hash, _ := password.HashAndSalt("test")
+	lockedUntil := time.Now().Add(time.Hour)
+	user := models.User{UUID: "abc", Email: "test@gmail.com", Password: hash}
+	lockedUser := models.User{UUID: "abc", Email: "test@gmail.com", Password: hash, FailedLoginCount: 7, LockedUntil: &lockedUntil}
+
+	want := traceLogin(t, user, `{"email": "test@gmail.com", "password": "wrong"}`)
+	if want.status != http.StatusUnauthorized {
+		t.Fatalf("Create() with a wrong password status = %v, want %v", want.status, http.StatusUnauthorized)
+	}
+
+	tests := []struct {
+		name string
+		user models.User
+		body string
+	}{
+		{
+			name: "test unknown email",
+			user: models.User{},
+			body: `{"email": "nobody@gmail.com", "password": "wrong"}`,
+		},
+		{
+			name: "test locked account with a wrong password",
+			user: lockedUser,
+			body: `{"email": "test@gmail.com", "password": "wrong"}`,
+		},
+		{
+			name: "test locked account with the right password",
+			user: lockedUser,
+			body: `{"email": "test@gmail.com", "password": "test"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := traceLogin(t, tt.user, tt.body)
+
+			if got.status != want.status || got.body != want.body || !reflect.DeepEqual(got.header, want.header) {
+				t.Errorf("Create() responded %v %v %v, a wrong password gets %v %v %v", got.status, got.header, got.body, want.status, want.header, want.body)
+			}
+
+			if !reflect.DeepEqual(got.calls, want.calls) {
+				t.Errorf("Create() made database calls %v, a wrong password makes %v", got.calls, want.calls)
+			}
+
+			if !reflect.DeepEqual(got.comparisons, want.comparisons) {
+				t.Errorf("Create() compared at costs %v, a wrong password compares at %v", got.comparisons, want.comparisons)
+			}
+		})
+	}
+}
+
+func TestCreate_IndistinguishableLegacyHash(t *testing.T) {
+	defaultHasher := password.Default
+	password.Default = &password.Bcrypt{Cost: bcrypt.MinCost + 1}
+	defer func() { password.Default = defaultHasher }()
+
+	// Accounts from before the cost went up keep their cheaper hash until they next log in
+	legacy, _ := (&password.Bcrypt{Cost: bcrypt.MinCost}).Hash("test")
+	user := models.User{UUID: "abc", Email: "test@gmail.com", Password: legacy}
+
+	want := traceLogin(t, models.User{}, `{"email": "nobody@gmail.com", "password": "wrong"}`)
+	got := traceLogin(t, user, `{"email": "test@gmail.com", "password": "wrong"}`)
+
+	if got.status != want.status || got.body != want.body || !reflect.DeepEqual(got.header, want.header) {
+		t.Errorf("Create() responded %v %v %v, an unknown email gets %v %v %v", got.status, got.header, got.body, want.status, want.header, want.body)
+	}
+
+	if !reflect.DeepEqual(got.calls, want.calls) {
+		t.Errorf("Create() made database calls %v, an unknown email makes %v", got.calls, want.calls)
+	}
+
+	// It can't take less work than comparing against the dummy an unknown email is compared against
+	if !reflect.DeepEqual(want.comparisons, []int{bcrypt.MinCost + 1}) || !reflect.DeepEqual(got.comparisons, []int{bcrypt.MinCost, bcrypt.MinCost + 1}) {
+		t.Errorf("Create() compared at costs %v, want %v along with the legacy hash's", got.comparisons, want.comparisons)
+	}
+}
+
+// legacyHashDB returns a user whose password hash was made by an older hasher, and records rehashes
+type legacyHashDB struct {
+	postgres.DBMock
+	hash    string
+	oldHash string
+	newHash string
+}
+
+func (d *legacyHashDB) GetUserByEmail(organizationUUID, email string) (models.User, error) {
+	return models.User{Email: email, UUID: "abc", Password: d.hash}, nil
+}
+
+func (d *legacyHashDB) RehashUserPasswordByUUID(uuid, oldHash, newHash string) (int, error) {
+	d.oldHash, d.newHash = oldHash, newHash
+	return 1, nil
+}
+
+func TestCreate_Rehash(t *testing.T) {
+	defaultHasher := password.Default
+	password.Default = &password.Bcrypt{Cost: bcrypt.MinCost + 1}
+	defer func() { password.Default = defaultHasher }()
+
+	legacy, _ := (&password.Bcrypt{Cost: bcrypt.MinCost}).Hash("test")
+	current, _ := password.HashAndSalt("test")
+
+	tests := []struct {
+		name       string
+		hash       string
+		wantRehash bool
+	}{
+		{
+			name:       "test legacy hash",
+			hash:       legacy,
+			wantRehash: true,
+		},
+		{
+			name:       "test current hash",
+			hash:       current,
+			wantRehash: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &legacyHashDB{hash: tt.hash}
+			postgres.DB = db
+
+			w := httptest.NewRecorder()
+			Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader([]byte(`{"email": "test@gmail.com", "password": "test"}`))))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Create() status = %v, want %v", w.Code, http.StatusOK)
+			}
+
+			if rehashed := db.newHash != ""; rehashed != tt.wantRehash {
+				t.Fatalf("Create() rehashed = %v, want %v", rehashed, tt.wantRehash)
+			}
+
+			if tt.wantRehash && (db.oldHash != tt.hash || password.NeedsRehash(db.newHash) || !password.Verify("test", db.newHash)) {
+				t.Errorf("Create() replaced %q with %q, want a current hash of the same password", db.oldHash, db.newHash)
+			}
+		})
+	}
+}
+
+// usedRefreshTokenDB returns a refresh token that has already been exchanged once
+type usedRefreshTokenDB struct {
+	postgres.DBMock
+	revoked bool
+}
+
+func (d *usedRefreshTokenDB) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	usedAt := time.Now().Add(-time.Minute)
+	return models.RefreshToken{UUID: "abc", SessionUUID: "abc", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt}, nil
+}
+
+func (d *usedRefreshTokenDB) RevokeRefreshTokensBySessionUUID(sessionUUID string) (int, error) {
+	d.revoked = true
+	return 1, nil
+}
+
+// deletedUserDB has a live session and refresh token whose user has since been deleted
+type deletedUserDB struct {
+	postgres.DBMock
+}
+
+func (d *deletedUserDB) GetUserByUUID(uuid string) (models.User, error) {
+	return models.User{}, nil
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		db          postgres.Databaser
+		body        string
+		wantStatus  int
+		wantRevoked bool
+	}{
+		{
+			name:       "test success",
+			db:         &postgres.DBMock{},
+			body:       `{"refresh_token": "abc"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "test missing token",
+			db:         &postgres.DBMock{},
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "test reused token revokes session",
+			db:          &usedRefreshTokenDB{},
+			body:        `{"refresh_token": "abc"}`,
+			wantStatus:  http.StatusUnauthorized,
+			wantRevoked: true,
+		},
+		{
+			name:       "test deleted user",
+			db:         &deletedUserDB{},
+			body:       `{"refresh_token": "abc"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postgres.DB = tt.db
+			w := httptest.NewRecorder()
+			Refresh(w, httptest.NewRequest("POST", "/sessions/refresh", bytes.NewReader([]byte(tt.body))))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Refresh() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+
+			if db, ok := tt.db.(*usedRefreshTokenDB); ok && db.revoked != tt.wantRevoked {
+				t.Errorf("Refresh() revoked = %v, want %v", db.revoked, tt.wantRevoked)
+			}
+		})
+	}
+}
+
 func TestDelete(t *testing.T) {
 	postgres.DB = &postgres.DBMock{}
 
 	r := httptest.NewRequest("DELETE", "/sessions", nil)
-	r.Header.Add("X-Verified-Session-Uuid", "abc")
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
+
+	all := httptest.NewRequest("DELETE", "/sessions?all=true", nil)
+	all = all.WithContext(auth.NewContext(all.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
 
 	type args struct {
 		w http.ResponseWriter
@@ -56,6 +660,13 @@ func TestDelete(t *testing.T) {
 				r: r,
 			},
 		},
+		{
+			name: "test all sessions",
+			args: args{
+				w: httptest.NewRecorder(),
+				r: all,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -63,3 +674,69 @@ func TestDelete(t *testing.T) {
 		})
 	}
 }
+
+// foreignSessionDB doesn't delete any sessions, as if they all belonged to someone else
+type foreignSessionDB struct {
+	postgres.DBMock
+}
+
+func (d *foreignSessionDB) SoftDeleteUserSessionByUUID(userUUID, uuid string) (int, error) {
+	return 0, nil
+}
+
+func TestList(t *testing.T) {
+	postgres.DB = &postgres.DBMock{}
+
+	r := httptest.NewRequest("GET", "/sessions", nil)
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
+	w := httptest.NewRecorder()
+	List(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("List() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	got := []listedSession{}
+	err := json.Unmarshal(w.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("List() returned invalid JSON: %v", err)
+	}
+
+	if len(got) != 2 || !got[0].Current || got[1].Current {
+		t.Errorf("List() = %v, want two sessions with only the first marked current", w.Body.String())
+	}
+}
+
+func TestDeleteByUUID(t *testing.T) {
+	tests := []struct {
+		name       string
+		db         postgres.Databaser
+		wantStatus int
+	}{
+		{
+			name:       "test success",
+			db:         &postgres.DBMock{},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "test another user's session",
+			db:         &foreignSessionDB{},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postgres.DB = tt.db
+
+			r := httptest.NewRequest("DELETE", "/sessions/def", nil)
+			r = mux.SetURLVars(r, map[string]string{"uuid": "def"})
+			r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
+			w := httptest.NewRecorder()
+			DeleteByUUID(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("DeleteByUUID() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
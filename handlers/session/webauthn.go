@@ -0,0 +1,167 @@
+package session
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
+)
+
+// BeginWebAuthnLogin is a handler that starts a passkey login. It returns the options to hand to
+// navigator.credentials.get(), the result is sent to Create as its credential.
+func BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if webauthn.Default == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte(`{"message": "WebAuthn isn't configured"}`))
+		return
+	}
+
+	// Every call writes a challenge, so it's throttled like a login attempt
+	if Throttled(w, IPLimiter, auth.ClientIP(r)) {
+		return
+	}
+
+	challenge, err := randtoken.Generate()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// We don't know who's logging in yet, the credential they pick tells us
+	_, err = postgres.DB.CreateWebAuthnChallenge("", "login", randtoken.Hash(challenge), time.Now().Add(webauthn.Timeout))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	options, err := webauthn.Default.NewRequestOptions(challenge)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(options)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// createWithWebAuthn is the passkey half of Create, it checks the assertion against the stored credential and
+// starts a session for its owner. A passkey is already something the user has and is or knows, so TOTP isn't asked for.
+func createWithWebAuthn(w http.ResponseWriter, r *http.Request, organization models.Organization, assertion webauthn.AssertionResponse) {
+	if webauthn.Default == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte(`{"message": "WebAuthn isn't configured"}`))
+		return
+	}
+
+	if Throttled(w, IPLimiter, auth.ClientIP(r)) {
+		return
+	}
+
+	clientData, err := webauthn.ParseClientData(assertion.Response.ClientDataJSON)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	challenge, err := postgres.DB.GetWebAuthnChallengeByHash(randtoken.Hash(clientData.Challenge))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	currentTime := time.Now()
+	if challenge.UUID == "" || challenge.Ceremony != "login" || challenge.UsedAt != nil || currentTime.After(challenge.ExpiresAt) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "WebAuthn challenge is invalid or expired"}`))
+		return
+	}
+
+	credential, err := postgres.DB.GetWebAuthnCredentialByCredentialID(base64.RawURLEncoding.EncodeToString(assertion.RawID))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// The user handle is the user's UUID, if the authenticator sent one it has to be the credential's owner
+	if credential.UUID == "" || (len(assertion.Response.UserHandle) > 0 && string(assertion.Response.UserHandle) != credential.UserUUID) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Invalid credential"}`))
+		return
+	}
+
+	signCount, err := webauthn.Default.VerifyAssertion(clientData.Challenge, credential.PublicKey, uint32(credential.SignCount), assertion.Response.ClientDataJSON, assertion.Response.AuthenticatorData, assertion.Response.Signature)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Invalid credential"}`))
+		return
+	}
+
+	// Use the challenge before anything else, the counter alone can't stop a replay from an authenticator that doesn't count
+	used, err := postgres.DB.UseWebAuthnChallengeByUUID(challenge.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if used == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "WebAuthn challenge is invalid or expired"}`))
+		return
+	}
+
+	// If the counter moved since we read it another login raced us with a cloned authenticator, or a replay
+	updated, err := postgres.DB.UpdateWebAuthnSignCountByUUID(credential.UUID, credential.SignCount, int64(signCount))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if updated == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Invalid credential"}`))
+		return
+	}
+
+	user, err := postgres.DB.GetUserByUUID(credential.UserUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if user.UUID == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Invalid credential"}`))
+		return
+	}
+
+	if RequireVerifiedEmail && user.VerifiedAt == nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Email address has not been verified"}`))
+		return
+	}
+
+	startSession(w, r, user.UUID, organization.UUID, currentTime)
+}
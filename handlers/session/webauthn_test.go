@@ -0,0 +1,142 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn/webauthntest"
+)
+
+// passkeyDB is a user with one registered passkey, it keeps the login challenges handed out in memory
+type passkeyDB struct {
+	postgres.DBMock
+	credential models.WebAuthnCredential
+	challenges map[string]*models.WebAuthnChallenge
+}
+
+func (d *passkeyDB) CreateWebAuthnChallenge(userUUID, ceremony, challengeHash string, expiresAt time.Time) (models.WebAuthnChallenge, error) {
+	d.challenges[challengeHash] = &models.WebAuthnChallenge{UUID: challengeHash, UserUUID: userUUID, Ceremony: ceremony, ExpiresAt: expiresAt}
+	return *d.challenges[challengeHash], nil
+}
+
+func (d *passkeyDB) GetWebAuthnChallengeByHash(challengeHash string) (models.WebAuthnChallenge, error) {
+	if challenge, ok := d.challenges[challengeHash]; ok {
+		return *challenge, nil
+	}
+	return models.WebAuthnChallenge{}, nil
+}
+
+func (d *passkeyDB) UseWebAuthnChallengeByUUID(uuid string) (int, error) {
+	challenge := d.challenges[uuid]
+	if challenge.UsedAt != nil {
+		return 0, nil
+	}
+	usedAt := time.Now()
+	challenge.UsedAt = &usedAt
+	return 1, nil
+}
+
+func (d *passkeyDB) GetWebAuthnCredentialByCredentialID(credentialID string) (models.WebAuthnCredential, error) {
+	if credentialID != d.credential.CredentialID {
+		return models.WebAuthnCredential{}, nil
+	}
+	return d.credential, nil
+}
+
+func (d *passkeyDB) UpdateWebAuthnSignCountByUUID(uuid string, oldCount, newCount int64) (int, error) {
+	if d.credential.SignCount != oldCount {
+		return 0, nil
+	}
+	d.credential.SignCount = newCount
+	return 1, nil
+}
+
+func TestCreate_WebAuthn(t *testing.T) {
+	webauthn.Default = &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+	defer func() { webauthn.Default = nil }()
+
+	authenticator, err := webauthntest.New("example.com", "https://example.com")
+	if err != nil {
+		t.Fatalf("webauthntest.New() error = %v", err)
+	}
+	authenticator.UserHandle = []byte("abc")
+
+	db := &passkeyDB{
+		credential: models.WebAuthnCredential{UUID: "def", UserUUID: "abc", CredentialID: base64.RawURLEncoding.EncodeToString(authenticator.CredentialID), PublicKey: authenticator.PublicKey()},
+		challenges: map[string]*models.WebAuthnChallenge{},
+	}
+	postgres.DB = db
+
+	begin := func() string {
+		w := httptest.NewRecorder()
+		BeginWebAuthnLogin(w, httptest.NewRequest("POST", "/sessions/webauthn", nil))
+
+		options := webauthn.RequestOptions{}
+		json.Unmarshal(w.Body.Bytes(), &options)
+		if w.Code != http.StatusOK || len(options.PublicKey.Challenge) == 0 {
+			t.Fatalf("BeginWebAuthnLogin() = %v %v, want a challenge", w.Code, w.Body.String())
+		}
+
+		return base64.RawURLEncoding.EncodeToString(options.PublicKey.Challenge)
+	}
+
+	login := func(assertion webauthn.AssertionResponse) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(sessionRequest{Credential: &assertion})
+		w := httptest.NewRecorder()
+		Create(w, httptest.NewRequest("POST", "/sessions", bytes.NewReader(body)))
+		return w
+	}
+
+	assertion, _ := authenticator.Login(begin())
+	w := login(assertion)
+	tokens := tokenResponse{}
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	if w.Code != http.StatusOK || tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("Create() with a passkey = %v %v, want tokens", w.Code, w.Body.String())
+	}
+
+	if db.credential.SignCount != 1 {
+		t.Errorf("Create() stored sign count %v, want %v", db.credential.SignCount, 1)
+	}
+
+	// The same assertion can't be used twice
+	if w := login(assertion); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() replaying an assertion status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// A cloned authenticator shows up as a counter that didn't move on
+	authenticator.SignCount = 0
+	assertion, _ = authenticator.Login(begin())
+	if w := login(assertion); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() with a stale counter status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// The user handle has to be the credential's owner
+	authenticator.SignCount, authenticator.UserHandle = 5, []byte("ghi")
+	assertion, _ = authenticator.Login(begin())
+	if w := login(assertion); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() with someone else's user handle status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// A key that isn't registered is rejected
+	stranger, _ := webauthntest.New("example.com", "https://example.com")
+	assertion, _ = stranger.Login(begin())
+	if w := login(assertion); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() with an unknown credential status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// So is a signature over a challenge we never handed out
+	authenticator.UserHandle = []byte("abc")
+	assertion, _ = authenticator.Login(base64.RawURLEncoding.EncodeToString([]byte("made up")))
+	if w := login(assertion); w.Code != http.StatusUnauthorized {
+		t.Errorf("Create() with an unknown challenge status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
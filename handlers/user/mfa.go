@@ -0,0 +1,172 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mfa"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/totp"
+)
+
+// EnrollTOTP is a handler that starts turning on TOTP for the user that owns the session. It returns a new secret,
+// and an otpauth:// URI for it, to add to an authenticator app; nothing changes at login until it's confirmed.
+func EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if mfa.Secrets == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte(`{"message": "MFA isn't configured"}`))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	sealed, err := mfa.SealSecret(currentUser.UUID, secret)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Restarting an unconfirmed enrollment replaces its secret, a confirmed one is left alone
+	numRows, err := postgres.DB.UpsertTOTPCredential(currentUser.UUID, sealed)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if numRows == 0 {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "TOTP is already enabled"}`))
+		return
+	}
+
+	response, err := json.Marshal(enrollTOTPResponse{Secret: secret, URI: totp.URI(mfa.Issuer, currentUser.Email, secret)})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// ConfirmTOTP is a handler that turns on TOTP for the user that owns the session, once they've shown their
+// authenticator app works by sending a code from it. It returns the user's recovery codes, the only time they're shown.
+func ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := confirmTOTPRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil || parsedBody.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	credential, err := postgres.DB.GetTOTPCredentialByUserUUID(currentUser.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if credential.UserUUID == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "TOTP enrollment hasn't been started"}`))
+		return
+	}
+
+	if credential.ConfirmedAt != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "TOTP is already enabled"}`))
+		return
+	}
+
+	step, valid, err := mfa.CheckTOTP(credential, parsedBody.Code, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Invalid TOTP code"}`))
+		return
+	}
+
+	recoveryCodes, err := mfa.GenerateRecoveryCodes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	recoveryCodeHashes := []string{}
+	for _, code := range recoveryCodes {
+		recoveryCodeHashes = append(recoveryCodeHashes, mfa.HashRecoveryCode(code))
+	}
+
+	// If another request confirmed it first then its recovery codes are the ones that were kept
+	numRows, err := postgres.DB.ConfirmTOTPCredential(currentUser.UUID, step, recoveryCodeHashes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if numRows == 0 {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "TOTP is already enabled"}`))
+		return
+	}
+
+	response, err := json.Marshal(confirmTOTPResponse{RecoveryCodes: recoveryCodes})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+type enrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type confirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type confirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
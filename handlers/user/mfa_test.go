@@ -0,0 +1,107 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mfa"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/secrets"
+	"github.com/kylegrantlucas/platform-exercise/pkg/totp"
+)
+
+// enrollmentDB keeps a single TOTP credential in memory, along with the recovery codes it was confirmed with
+type enrollmentDB struct {
+	postgres.DBMock
+	credential         models.TOTPCredential
+	recoveryCodeHashes []string
+}
+
+func (d *enrollmentDB) UpsertTOTPCredential(userUUID, secret string) (int, error) {
+	if d.credential.ConfirmedAt != nil {
+		return 0, nil
+	}
+	d.credential = models.TOTPCredential{UserUUID: userUUID, Secret: secret, CreatedAt: time.Now()}
+	return 1, nil
+}
+
+func (d *enrollmentDB) GetTOTPCredentialByUserUUID(userUUID string) (models.TOTPCredential, error) {
+	return d.credential, nil
+}
+
+func (d *enrollmentDB) ConfirmTOTPCredential(userUUID string, step int64, recoveryCodeHashes []string) (int, error) {
+	confirmedAt := time.Now()
+	d.credential.ConfirmedAt, d.credential.LastUsedStep, d.recoveryCodeHashes = &confirmedAt, step, recoveryCodeHashes
+	return 1, nil
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	db := &enrollmentDB{}
+	postgres.DB = db
+
+	request := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/users/mfa/totp", bytes.NewReader([]byte(body)))
+		r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc", Email: "test@test.com"}))
+		handler(w, r)
+		return w
+	}
+
+	// Without a key secrets can't be stored
+	if w := request(EnrollTOTP, ""); w.Code != http.StatusNotImplemented {
+		t.Fatalf("EnrollTOTP() without a key status = %v, want %v", w.Code, http.StatusNotImplemented)
+	}
+
+	mfa.Secrets, _ = secrets.NewBox(bytes.Repeat([]byte{1}, secrets.KeySize))
+	defer func() { mfa.Secrets = nil }()
+
+	w := request(EnrollTOTP, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("EnrollTOTP() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	enrollment := enrollTOTPResponse{}
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+	if enrollment.Secret == "" || enrollment.URI != totp.URI(mfa.Issuer, "test@test.com", enrollment.Secret) {
+		t.Fatalf("EnrollTOTP() = %v, want a secret and its URI", w.Body.String())
+	}
+
+	if db.credential.Secret == enrollment.Secret {
+		t.Errorf("EnrollTOTP() stored the secret in the clear")
+	}
+
+	if w := request(ConfirmTOTP, `{"code": "000000"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("ConfirmTOTP() with a wrong code status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	w = request(ConfirmTOTP, `{"code": "`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ConfirmTOTP() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	confirmation := confirmTOTPResponse{}
+	json.Unmarshal(w.Body.Bytes(), &confirmation)
+	if len(confirmation.RecoveryCodes) != mfa.RecoveryCodeCount || len(db.recoveryCodeHashes) != mfa.RecoveryCodeCount {
+		t.Fatalf("ConfirmTOTP() gave %v recovery codes and stored %v, want %v", len(confirmation.RecoveryCodes), len(db.recoveryCodeHashes), mfa.RecoveryCodeCount)
+	}
+
+	if db.recoveryCodeHashes[0] != mfa.HashRecoveryCode(confirmation.RecoveryCodes[0]) {
+		t.Errorf("ConfirmTOTP() didn't store recovery codes hashed")
+	}
+
+	// Once it's on, enrolling again can't silently swap the secret
+	if w := request(EnrollTOTP, ""); w.Code != http.StatusConflict {
+		t.Errorf("EnrollTOTP() when enabled status = %v, want %v", w.Code, http.StatusConflict)
+	}
+
+	if w := request(ConfirmTOTP, `{"code": "`+code+`"}`); w.Code != http.StatusConflict {
+		t.Errorf("ConfirmTOTP() when enabled status = %v, want %v", w.Code, http.StatusConflict)
+	}
+}
@@ -4,14 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
 	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
-	hibp "github.com/mattevans/pwned-passwords"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tenant"
 )
 
-// Create is a handler that creates a user with the given parameters
+// Create is a handler that creates a user with the given parameters, in the organization named by the request or
+// the default one
 func Create(w http.ResponseWriter, r *http.Request) {
 	parsedBody, err := parseUserRequest(r)
 	if err != nil {
@@ -26,18 +34,8 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check the password against HaveIBeenPwned
-	client := hibp.NewClient()
-	pwned, err := client.Pwned.Compromised(parsedBody.Password)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
-		return
-	}
-
-	if pwned {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message": "Password is in the HaveIBeenPwned database"}`))
+	// Check the password against the policy
+	if !checkPassword(w, password.Candidate{Password: parsedBody.Password, Email: parsedBody.Email, Name: parsedBody.Name}) {
 		return
 	}
 
@@ -49,14 +47,32 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create the new user
-	newUser, err := postgres.DB.CreateUser(parsedBody.Email, parsedBody.Name, parsedBody.Password)
+	organization, err := tenant.Find(parsedBody.Organization)
+	if err == tenant.ErrUnknownOrganization {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Unknown organization"}`))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
 		return
 	}
 
+	// Create the new user, they're a member of the organization from the start
+	newUser, err := postgres.DB.CreateUser(organization.UUID, parsedBody.Email, parsedBody.Name, parsedBody.Password)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// The account has been created either way, so a failed email is logged rather than failing the request
+	err = SendVerification(newUser)
+	if err != nil {
+		log.Printf("couldn't send verification email to user %v: %v", newUser.UUID, err)
+	}
+
 	// Marshal the user for response
 	response, err := json.Marshal(newUser)
 	if err != nil {
@@ -69,16 +85,52 @@ func Create(w http.ResponseWriter, r *http.Request) {
 	w.Write(response)
 }
 
-// Delete is a handler that deletes a user with the UUID provided in the JWT token
-func Delete(w http.ResponseWriter, r *http.Request) {
-	_, err := postgres.DB.GetSessionByUUID(r.Header["X-Verified-Session-Uuid"][0])
+// Get is a handler that returns the user that owns the session, along with an ETag to make conditional updates with
+func Get(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	tag := etag(currentUser)
+	w.Header().Set("ETag", tag)
+
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Marshal the user for response
+	response, err := json.Marshal(&currentUser)
 	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Delete is a handler that deletes the user that owns the session and logs them out everywhere
+func Delete(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
 	// Delete the user
-	user, err := postgres.DB.SoftDeleteUserByUUID(r.Header["X-Verified-User-Uuid"][0])
+	user, err := postgres.DB.SoftDeleteUserByUUID(currentUser.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// Along with their sessions, so neither they nor a restore bring them back
+	_, err = postgres.DB.SoftDeleteSessionsByUserUUID(currentUser.UUID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
@@ -97,39 +149,52 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 	w.Write(response)
 }
 
-// Update is a handler that updates a user with the UUID provided in the JWT token with the given parameters
+// Update is a handler that updates the user that owns the session with the given parameters
 func Update(w http.ResponseWriter, r *http.Request) {
-	parsedBody, err := parseUserRequest(r)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	session, err := postgres.DB.GetSessionByUUID(r.Header["X-Verified-Session-Uuid"][0])
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
-		return
+	// An If-Match header only lets the update through if nobody changed the user since the client last read it
+	var ifUpdatedAt *time.Time
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != "*" {
+		updatedAt, ok := parseETag(ifMatch)
+		if !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`{"message": "User has been modified"}`))
+			return
+		}
+		ifUpdatedAt = &updatedAt
 	}
 
-	if session.DeletedAt != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+	parsedBody, err := parseUserRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	if parsedBody.Password != "" {
-		// Check the password against HaveIBeenPwned
-		client := hibp.NewClient()
-		pwned, err := client.Pwned.Compromised(parsedBody.Password)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
-			return
+		// Check the password against the policy, judged against the details the user will have once updated
+		candidate := password.Candidate{Password: parsedBody.Password, Email: currentUser.Email, Name: currentUser.Name}
+		if parsedBody.Email != "" {
+			candidate.Email = parsedBody.Email
+		}
+		if parsedBody.Name != "" {
+			candidate.Name = parsedBody.Name
 		}
 
-		if pwned {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"message": "Password is in the HaveIBeenPwned database"}`))
+		if password.DefaultPolicy.HistorySize > 0 {
+			candidate.History, err = postgres.DB.GetPasswordHistoryByUserUUID(currentUser.UUID, password.DefaultPolicy.HistorySize)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+				return
+			}
+		}
+
+		if !checkPassword(w, candidate) {
 			return
 		}
 	}
@@ -144,18 +209,34 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	user, err := postgres.DB.UpdateUserByUUID(r.Header["X-Verified-User-Uuid"][0], parsedBody.Email, parsedBody.Name, parsedBody.Password)
+	user, err := postgres.DB.UpdateUserByUUID(currentUser.UUID, parsedBody.Email, parsedBody.Name, parsedBody.Password, ifUpdatedAt)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
 		return
 	}
 
+	// The session middleware already found the user, so nothing matching means the condition failed
+	if user.UUID == "" && ifUpdatedAt != nil {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(`{"message": "User has been modified"}`))
+		return
+	}
+
 	if user.UUID == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
+	if parsedBody.Email != "" && parsedBody.Email != currentUser.Email {
+		err = SendVerification(user)
+		if err != nil {
+			log.Printf("couldn't send verification email to user %v: %v", user.UUID, err)
+		}
+	}
+
+	w.Header().Set("ETag", etag(user))
+
 	response, err := json.Marshal(user)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -167,6 +248,52 @@ func Update(w http.ResponseWriter, r *http.Request) {
 	w.Write(response)
 }
 
+// checkPassword runs the candidate through the password policy, writing out every violation and returning
+// false if there are any
+func checkPassword(w http.ResponseWriter, candidate password.Candidate) bool {
+	violations, err := password.DefaultPolicy.Check(candidate)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return false
+	}
+
+	if len(violations) == 0 {
+		return true
+	}
+
+	response, err := json.Marshal(violationsResponse{Message: "Password doesn't meet the password policy", Violations: violations})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return false
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(response)
+	return false
+}
+
+// etag identifies a version of the user by when it was last updated, to the microsecond since that's all postgres keeps
+func etag(user models.User) string {
+	return `"` + strconv.FormatInt(user.UpdatedAt.UnixNano()/int64(time.Microsecond), 10) + `"`
+}
+
+// parseETag returns the updated_at an ETag was made from
+func parseETag(tag string) (time.Time, bool) {
+	tag = strings.TrimPrefix(tag, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return time.Time{}, false
+	}
+
+	micros, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, micros*int64(time.Microsecond)), true
+}
+
 func parseUserRequest(r *http.Request) (userRequest, error) {
 	parsedBody := userRequest{}
 	rawBody, err := ioutil.ReadAll(r.Body)
@@ -183,7 +310,13 @@ func parseUserRequest(r *http.Request) (userRequest, error) {
 }
 
 type userRequest struct {
-	Email    string `json:"email,omitempty"`
-	Password string `json:"password,omitempty"`
-	Name     string `json:"name,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Password     string `json:"password,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+type violationsResponse struct {
+	Message    string               `json:"message"`
+	Violations []password.Violation `json:"violations"`
 }
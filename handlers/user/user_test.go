@@ -2,14 +2,24 @@ package user
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/password"
 	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
 )
 
+func init() {
+	// Keep the tests off the network
+	password.Breaches = password.NoopChecker{}
+}
+
 func TestCreate(t *testing.T) {
 	postgres.DB = &postgres.DBMock{}
 
@@ -36,12 +46,47 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+// tenantDB only has the fender organization, and remembers which one users were created in
+type tenantDB struct {
+	postgres.DBMock
+	createdIn string
+}
+
+func (d *tenantDB) GetOrganizationBySlug(slug string) (models.Organization, error) {
+	if slug != "fender" {
+		return models.Organization{}, nil
+	}
+	return models.Organization{UUID: "fender-uuid", Slug: slug}, nil
+}
+
+func (d *tenantDB) CreateUser(organizationUUID, email, name, plaintextPassword string) (models.User, error) {
+	d.createdIn = organizationUUID
+	return d.DBMock.CreateUser(organizationUUID, email, name, plaintextPassword)
+}
+
+func TestCreate_Organization(t *testing.T) {
+	db := &tenantDB{}
+	postgres.DB = db
+
+	w := httptest.NewRecorder()
+	Create(w, httptest.NewRequest("POST", "/users", bytes.NewReader([]byte(`{"organization": "fender", "email": "test@gmail.com", "password": "9X&5eQ#TI9IzBM"}`))))
+	if w.Code != http.StatusOK || db.createdIn != "fender-uuid" {
+		t.Errorf("Create() = %v %v in %q, want the user created in fender", w.Code, w.Body.String(), db.createdIn)
+	}
+
+	db.createdIn = ""
+	w = httptest.NewRecorder()
+	Create(w, httptest.NewRequest("POST", "/users", bytes.NewReader([]byte(`{"organization": "gibson", "email": "test@gmail.com", "password": "9X&5eQ#TI9IzBM"}`))))
+	if w.Code != http.StatusBadRequest || db.createdIn != "" {
+		t.Errorf("Create() in an unknown organization status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	postgres.DB = &postgres.DBMock{}
 
 	r := httptest.NewRequest("DELETE", "/users", nil)
-	r.Header.Add("X-Verified-User-Uuid", "abc")
-	r.Header.Add("X-Verified-Session-Uuid", "abc")
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
 
 	type args struct {
 		w http.ResponseWriter
@@ -66,12 +111,36 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+// logoutDB remembers whose sessions were logged out
+type logoutDB struct {
+	postgres.DBMock
+	loggedOut string
+}
+
+func (d *logoutDB) SoftDeleteSessionsByUserUUID(userUUID string) (int, error) {
+	d.loggedOut = userUUID
+	return 2, nil
+}
+
+func TestDelete_LogsOut(t *testing.T) {
+	db := &logoutDB{}
+	postgres.DB = db
+
+	r := httptest.NewRequest("DELETE", "/users", nil)
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
+	w := httptest.NewRecorder()
+	Delete(w, r)
+
+	if w.Code != http.StatusOK || db.loggedOut != "abc" {
+		t.Errorf("Delete() = %v, logged out %q, want %v and every session of %q logged out", w.Code, db.loggedOut, http.StatusOK, "abc")
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	postgres.DB = &postgres.DBMock{}
 
 	r := httptest.NewRequest("PUT", "/users", bytes.NewReader([]byte(`{"email": "test@gmail.com", "password": "9X&5eQ#TI9IzBM", "name": "Testers"}`)))
-	r.Header.Add("X-Verified-User-Uuid", "abc")
-	r.Header.Add("X-Verified-Session-Uuid", "abc")
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
 
 	type args struct {
 		w http.ResponseWriter
@@ -121,3 +190,168 @@ func Test_parseUserRequest(t *testing.T) {
 		})
 	}
 }
+
+// staleUserDB fails every conditional update, as if the user had been changed in the meantime
+type staleUserDB struct {
+	postgres.DBMock
+}
+
+func (d *staleUserDB) UpdateUserByUUID(uuid, email, name, plaintextPassword string, ifUpdatedAt *time.Time) (models.User, error) {
+	if ifUpdatedAt != nil {
+		return models.User{}, nil
+	}
+	return d.DBMock.UpdateUserByUUID(uuid, email, name, plaintextPassword, ifUpdatedAt)
+}
+
+func TestGet(t *testing.T) {
+	user := models.User{UUID: "abc", Email: "test@test.com", Password: "hash", UpdatedAt: time.Unix(1556000000, 123456000)}
+
+	r := httptest.NewRequest("GET", "/users/me", nil)
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, user))
+	w := httptest.NewRecorder()
+	Get(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Get() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	if got := w.Header().Get("ETag"); got != `"1556000000123456"` {
+		t.Errorf("Get() ETag = %v, want %v", got, `"1556000000123456"`)
+	}
+
+	if bytes.Contains(w.Body.Bytes(), []byte("hash")) {
+		t.Errorf("Get() leaked the password hash: %v", w.Body.String())
+	}
+
+	r = httptest.NewRequest("GET", "/users/me", nil)
+	r.Header.Set("If-None-Match", `"1556000000123456"`)
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, user))
+	w = httptest.NewRecorder()
+	Get(w, r)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Get() with matching If-None-Match status = %v, want %v", w.Code, http.StatusNotModified)
+	}
+}
+
+func TestUpdate_IfMatch(t *testing.T) {
+	postgres.DB = &staleUserDB{}
+
+	tests := []struct {
+		name       string
+		ifMatch    string
+		wantStatus int
+	}{
+		{
+			name:       "test unconditional",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "test any version",
+			ifMatch:    "*",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "test stale version",
+			ifMatch:    `"1556000000123456"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "test malformed version",
+			ifMatch:    "abc",
+			wantStatus: http.StatusPreconditionFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/users", bytes.NewReader([]byte(`{"name": "Testers"}`)))
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc"}))
+			w := httptest.NewRecorder()
+			Update(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Update() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func Test_parseETag(t *testing.T) {
+	updatedAt := time.Unix(1556000000, 123456000)
+
+	got, ok := parseETag(etag(models.User{UpdatedAt: updatedAt}))
+	if !ok || !got.Equal(updatedAt) {
+		t.Errorf("parseETag(etag()) = %v, %v, want %v, true", got, ok, updatedAt)
+	}
+
+	got, ok = parseETag(`W/"1556000000123456"`)
+	if !ok || !got.Equal(updatedAt) {
+		t.Errorf("parseETag() weak = %v, %v, want %v, true", got, ok, updatedAt)
+	}
+}
+
+// historyDB says the user's only previous password was "9X&5eQ#TI9IzBM"
+type historyDB struct {
+	postgres.DBMock
+}
+
+func (d *historyDB) GetPasswordHistoryByUserUUID(userUUID string, limit int) ([]string, error) {
+	hash, err := password.HashAndSalt("9X&5eQ#TI9IzBM")
+	return []string{hash}, err
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	postgres.DB = &historyDB{}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		want    []string
+	}{
+		{
+			name:    "test create with a weak password",
+			handler: Create,
+			method:  "POST",
+			body:    `{"email": "testy@gmail.com", "password": "testy1", "name": "Testers"}`,
+			want:    []string{"min_length", "min_strength", "personal_info"},
+		},
+		{
+			name:    "test update with a reused password",
+			handler: Update,
+			method:  "PUT",
+			body:    `{"password": "9X&5eQ#TI9IzBM"}`,
+			want:    []string{"history"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/users", bytes.NewReader([]byte(tt.body)))
+			r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc", Email: "test@test.com"}))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %v, want %v", w.Code, http.StatusBadRequest)
+			}
+
+			response := violationsResponse{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatalf("invalid violations JSON %v: %v", w.Body.String(), err)
+			}
+
+			got := []string{}
+			for _, violation := range response.Violations {
+				got = append(got, violation.Rule)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violated %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mailer"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+// verificationTokenLifetime is how long the link in a verification email works for
+const verificationTokenLifetime = 24 * time.Hour
+
+// VerificationURL is the page verification links point at, the token is added as a "token" query parameter.
+// When it's empty the email just contains the token to POST to /users/verify.
+var VerificationURL string
+
+// Verify is a handler that confirms the user owns their email address, using the token that was mailed to it
+func Verify(w http.ResponseWriter, r *http.Request) {
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := verifyRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil || parsedBody.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	verificationToken, err := postgres.DB.GetVerificationTokenByHash(randtoken.Hash(parsedBody.Token))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if verificationToken.UUID == "" || verificationToken.UsedAt != nil || time.Now().After(verificationToken.ExpiresAt) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Verification token is invalid or expired"}`))
+		return
+	}
+
+	numRows, err := postgres.DB.UseVerificationTokenByUUID(verificationToken.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if numRows == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Verification token is invalid or expired"}`))
+		return
+	}
+
+	// Only verifies the user if they haven't changed their email since the token was sent
+	numRows, err = postgres.DB.VerifyUserByUUID(verificationToken.UserUUID, verificationToken.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if numRows == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Verification token is invalid or expired"}`))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Email verified"}`))
+}
+
+// SendVerification mails the user a token proving they own their current email address, it's also used when an
+// admin changes the address for them
+func SendVerification(user models.User) error {
+	token, err := randtoken.Generate()
+	if err != nil {
+		return err
+	}
+
+	_, err = postgres.DB.CreateVerificationToken(user.UUID, user.Email, randtoken.Hash(token), time.Now().Add(verificationTokenLifetime))
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Confirm your email address with this verification token, it expires in %v:\n\n%v\n", verificationTokenLifetime, token)
+	if VerificationURL != "" {
+		body = fmt.Sprintf("Confirm your email address by following this link, it expires in %v:\n\n%v?token=%v\n", verificationTokenLifetime, VerificationURL, url.QueryEscape(token))
+	}
+
+	return mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    body,
+	})
+}
+
+type verifyRequest struct {
+	Token string `json:"token"`
+}
@@ -0,0 +1,148 @@
+package user
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/mailer"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+// verificationDB hands out a configurable verification token and records what gets verified
+type verificationDB struct {
+	postgres.DBMock
+	token        models.VerificationToken
+	emailChanged bool
+	createdHash  string
+	verified     bool
+}
+
+func (d *verificationDB) CreateVerificationToken(userUUID, email, tokenHash string, expiresAt time.Time) (models.VerificationToken, error) {
+	d.createdHash = tokenHash
+	return d.DBMock.CreateVerificationToken(userUUID, email, tokenHash, expiresAt)
+}
+
+func (d *verificationDB) GetVerificationTokenByHash(tokenHash string) (models.VerificationToken, error) {
+	return d.token, nil
+}
+
+func (d *verificationDB) VerifyUserByUUID(uuid, email string) (int, error) {
+	if d.emailChanged {
+		return 0, nil
+	}
+	d.verified = true
+	return 1, nil
+}
+
+func TestVerify(t *testing.T) {
+	usedAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name         string
+		body         string
+		token        models.VerificationToken
+		emailChanged bool
+		wantStatus   int
+	}{
+		{
+			name:       "test success",
+			body:       `{"token": "abc"}`,
+			token:      models.VerificationToken{UUID: "abc", UserUUID: "abc", Email: "test@test.com", ExpiresAt: time.Now().Add(time.Hour)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "test missing token",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "test unknown token",
+			body:       `{"token": "abc"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "test expired token",
+			body:       `{"token": "abc"}`,
+			token:      models.VerificationToken{UUID: "abc", UserUUID: "abc", Email: "test@test.com", ExpiresAt: time.Now().Add(-time.Hour)},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "test used token",
+			body:       `{"token": "abc"}`,
+			token:      models.VerificationToken{UUID: "abc", UserUUID: "abc", Email: "test@test.com", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "test email changed since",
+			body:         `{"token": "abc"}`,
+			token:        models.VerificationToken{UUID: "abc", UserUUID: "abc", Email: "old@test.com", ExpiresAt: time.Now().Add(time.Hour)},
+			emailChanged: true,
+			wantStatus:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &verificationDB{token: tt.token, emailChanged: tt.emailChanged}
+			postgres.DB = db
+
+			w := httptest.NewRecorder()
+			Verify(w, httptest.NewRequest("POST", "/users/verify", bytes.NewReader([]byte(tt.body))))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Verify() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+
+			if db.verified != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("Verify() verified = %v, want %v", db.verified, tt.wantStatus == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestUpdate_ChangedEmailSendsVerification(t *testing.T) {
+	outbox := &mailer.Memory{}
+	mailer.Default = outbox
+	db := &verificationDB{}
+	postgres.DB = db
+
+	r := httptest.NewRequest("PUT", "/users", bytes.NewReader([]byte(`{"email": "new@test.com"}`)))
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc", Email: "old@test.com"}))
+	w := httptest.NewRecorder()
+	Update(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Update() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	msg, ok := outbox.Last()
+	if !ok {
+		t.Fatalf("Update() didn't send a verification email")
+	}
+
+	// The mock always hands back the same user, what matters is the token in the email is the one stored
+	token := strings.TrimSpace(msg.Body[strings.LastIndex(msg.Body, "\n\n"):])
+	if randtoken.Hash(token) != db.createdHash {
+		t.Errorf("Update() mailed token %q, which doesn't match the stored hash", token)
+	}
+}
+
+func TestUpdate_SameEmailDoesNotSendVerification(t *testing.T) {
+	outbox := &mailer.Memory{}
+	mailer.Default = outbox
+	postgres.DB = &postgres.DBMock{}
+
+	r := httptest.NewRequest("PUT", "/users", bytes.NewReader([]byte(`{"email": "test@test.com", "name": "Testers"}`)))
+	r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: "abc"}, models.User{UUID: "abc", Email: "test@test.com"}))
+	Update(httptest.NewRecorder(), r)
+
+	if sent := outbox.Sent(); len(sent) != 0 {
+		t.Errorf("Update() sent %v emails without an address change", len(sent))
+	}
+}
@@ -0,0 +1,175 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
+)
+
+// BeginWebAuthnRegistration is a handler that starts registering a passkey for the user that owns the session. It
+// returns the options to hand to navigator.credentials.create(), the result goes to FinishWebAuthnRegistration.
+func BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if webauthn.Default == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte(`{"message": "WebAuthn isn't configured"}`))
+		return
+	}
+
+	credentials, err := postgres.DB.ListWebAuthnCredentialsByUserUUID(currentUser.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	excluded := [][]byte{}
+	for _, credential := range credentials {
+		id, err := base64.RawURLEncoding.DecodeString(credential.CredentialID)
+		if err == nil {
+			excluded = append(excluded, id)
+		}
+	}
+
+	challenge, err := randtoken.Generate()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	// The challenge is bound to the user so a registration can't be finished from someone else's session
+	_, err = postgres.DB.CreateWebAuthnChallenge(currentUser.UUID, "registration", randtoken.Hash(challenge), time.Now().Add(webauthn.Timeout))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	options, err := webauthn.Default.NewCreationOptions(challenge, currentUser.UUID, currentUser.Email, currentUser.Name, excluded)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	response, err := json.Marshal(options)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// FinishWebAuthnRegistration is a handler that verifies the credential navigator.credentials.create() made and
+// stores it against the user that owns the session, after which it can be used to log in
+func FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	currentUser, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if webauthn.Default == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte(`{"message": "WebAuthn isn't configured"}`))
+		return
+	}
+
+	rawBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parsedBody := webAuthnRegistrationRequest{}
+	err = json.Unmarshal(rawBody, &parsedBody)
+	if err != nil || parsedBody.Credential == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	clientData, err := webauthn.ParseClientData(parsedBody.Credential.Response.ClientDataJSON)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	challenge, err := postgres.DB.GetWebAuthnChallengeByHash(randtoken.Hash(clientData.Challenge))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if challenge.UUID == "" || challenge.UserUUID != currentUser.UUID || challenge.Ceremony != "registration" || challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "WebAuthn challenge is invalid or expired"}`))
+		return
+	}
+
+	credential, err := webauthn.Default.VerifyRegistration(clientData.Challenge, parsedBody.Credential.Response.ClientDataJSON, parsedBody.Credential.Response.AttestationObject)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	used, err := postgres.DB.UseWebAuthnChallengeByUUID(challenge.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if used == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "WebAuthn challenge is invalid or expired"}`))
+		return
+	}
+
+	stored, err := postgres.DB.CreateWebAuthnCredential(currentUser.UUID, base64.RawURLEncoding.EncodeToString(credential.ID), credential.PublicKey, int64(credential.SignCount), parsedBody.Name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	if stored.UUID == "" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "Credential is already registered"}`))
+		return
+	}
+
+	response, err := json.Marshal(stored)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+type webAuthnRegistrationRequest struct {
+	Name       string                        `json:"name,omitempty"`
+	Credential *webauthn.AttestationResponse `json:"credential,omitempty"`
+}
@@ -0,0 +1,150 @@
+package user
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/auth"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn/webauthntest"
+)
+
+// registrationDB keeps WebAuthn challenges and credentials in memory, keyed by hash and credential ID
+type registrationDB struct {
+	postgres.DBMock
+	challenges  map[string]models.WebAuthnChallenge
+	credentials map[string]models.WebAuthnCredential
+}
+
+func (d *registrationDB) CreateWebAuthnChallenge(userUUID, ceremony, challengeHash string, expiresAt time.Time) (models.WebAuthnChallenge, error) {
+	challenge := models.WebAuthnChallenge{UUID: challengeHash, UserUUID: userUUID, Ceremony: ceremony, ExpiresAt: expiresAt}
+	d.challenges[challengeHash] = challenge
+	return challenge, nil
+}
+
+func (d *registrationDB) GetWebAuthnChallengeByHash(challengeHash string) (models.WebAuthnChallenge, error) {
+	return d.challenges[challengeHash], nil
+}
+
+func (d *registrationDB) UseWebAuthnChallengeByUUID(uuid string) (int, error) {
+	challenge := d.challenges[uuid]
+	if challenge.UsedAt != nil {
+		return 0, nil
+	}
+	usedAt := time.Now()
+	challenge.UsedAt = &usedAt
+	d.challenges[uuid] = challenge
+	return 1, nil
+}
+
+func (d *registrationDB) CreateWebAuthnCredential(userUUID, credentialID string, publicKey []byte, signCount int64, name string) (models.WebAuthnCredential, error) {
+	if _, ok := d.credentials[credentialID]; ok {
+		return models.WebAuthnCredential{}, nil
+	}
+	credential := models.WebAuthnCredential{UUID: credentialID, UserUUID: userUUID, CredentialID: credentialID, PublicKey: publicKey, SignCount: signCount, Name: name}
+	d.credentials[credentialID] = credential
+	return credential, nil
+}
+
+func (d *registrationDB) ListWebAuthnCredentialsByUserUUID(userUUID string) ([]models.WebAuthnCredential, error) {
+	credentials := []models.WebAuthnCredential{}
+	for _, credential := range d.credentials {
+		if credential.UserUUID == userUUID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	db := &registrationDB{challenges: map[string]models.WebAuthnChallenge{}, credentials: map[string]models.WebAuthnCredential{}}
+	postgres.DB = db
+
+	request := func(handler http.HandlerFunc, userUUID string, body interface{}) *httptest.ResponseRecorder {
+		rawBody, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/users/webauthn", bytes.NewReader(rawBody))
+		r = r.WithContext(auth.NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: userUUID}, models.User{UUID: userUUID, Email: "test@test.com"}))
+		handler(w, r)
+		return w
+	}
+
+	begin := func(userUUID string) webauthn.CreationOptions {
+		w := request(BeginWebAuthnRegistration, userUUID, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("BeginWebAuthnRegistration() status = %v, want %v", w.Code, http.StatusOK)
+		}
+		options := webauthn.CreationOptions{}
+		json.Unmarshal(w.Body.Bytes(), &options)
+		return options
+	}
+
+	if w := request(BeginWebAuthnRegistration, "abc", nil); w.Code != http.StatusNotImplemented {
+		t.Fatalf("BeginWebAuthnRegistration() unconfigured status = %v, want %v", w.Code, http.StatusNotImplemented)
+	}
+
+	webauthn.Default = &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+	defer func() { webauthn.Default = nil }()
+
+	authenticator, err := webauthntest.New("example.com", "https://example.com")
+	if err != nil {
+		t.Fatalf("webauthntest.New() error = %v", err)
+	}
+
+	options := begin("abc")
+	if string(options.PublicKey.User.ID) != "abc" || options.PublicKey.RP.ID != "example.com" {
+		t.Fatalf("BeginWebAuthnRegistration() = %+v, want options for user abc on example.com", options.PublicKey)
+	}
+
+	attestation, err := authenticator.Register(base64.RawURLEncoding.EncodeToString(options.PublicKey.Challenge), options.PublicKey.User.ID)
+	if err != nil {
+		t.Fatalf("Authenticator.Register() error = %v", err)
+	}
+
+	// The challenge was handed to abc, so another user's session can't finish with it
+	if w := request(FinishWebAuthnRegistration, "def", webAuthnRegistrationRequest{Credential: &attestation}); w.Code != http.StatusBadRequest {
+		t.Errorf("FinishWebAuthnRegistration() as another user status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	w := request(FinishWebAuthnRegistration, "abc", webAuthnRegistrationRequest{Name: "laptop", Credential: &attestation})
+	if w.Code != http.StatusOK {
+		t.Fatalf("FinishWebAuthnRegistration() status = %v, body = %v, want %v", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(authenticator.CredentialID)
+	stored := db.credentials[credentialID]
+	if stored.UserUUID != "abc" || stored.Name != "laptop" || !bytes.Equal(stored.PublicKey, authenticator.PublicKey()) {
+		t.Errorf("FinishWebAuthnRegistration() stored %+v, want the authenticator's key for abc", stored)
+	}
+
+	// Challenges are single use
+	if w := request(FinishWebAuthnRegistration, "abc", webAuthnRegistrationRequest{Credential: &attestation}); w.Code != http.StatusBadRequest {
+		t.Errorf("FinishWebAuthnRegistration() replayed status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	// Existing credentials are excluded, and registering one again is a conflict
+	options = begin("abc")
+	if len(options.PublicKey.ExcludeCredentials) != 1 || !bytes.Equal(options.PublicKey.ExcludeCredentials[0].ID, authenticator.CredentialID) {
+		t.Errorf("BeginWebAuthnRegistration() excluded = %v, want the registered credential", options.PublicKey.ExcludeCredentials)
+	}
+
+	attestation, _ = authenticator.Register(base64.RawURLEncoding.EncodeToString(options.PublicKey.Challenge), options.PublicKey.User.ID)
+	if w := request(FinishWebAuthnRegistration, "abc", webAuthnRegistrationRequest{Credential: &attestation}); w.Code != http.StatusConflict {
+		t.Errorf("FinishWebAuthnRegistration() duplicate status = %v, want %v", w.Code, http.StatusConflict)
+	}
+
+	// A response for the wrong origin doesn't verify
+	options = begin("abc")
+	phishing, _ := webauthntest.New("example.com", "https://evil.example")
+	attestation, _ = phishing.Register(base64.RawURLEncoding.EncodeToString(options.PublicKey.Challenge), options.PublicKey.User.ID)
+	if w := request(FinishWebAuthnRegistration, "abc", webAuthnRegistrationRequest{Credential: &attestation}); w.Code != http.StatusBadRequest {
+		t.Errorf("FinishWebAuthnRegistration() from another origin status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type FederatedIdentity struct {
+	UUID      string    `json:"uuid,omitempty"`
+	UserUUID  string    `json:"user_uuid,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type FederatedLoginState struct {
+	UUID         string     `json:"uuid,omitempty"`
+	Provider     string     `json:"provider,omitempty"`
+	CodeVerifier string     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at,omitempty"`
+	UsedAt       *time.Time `json:"used_at,omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type JobRun struct {
+	Name       string    `json:"name,omitempty"`
+	Host       string    `json:"host,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Processed  int       `json:"processed"`
+	Error      string    `json:"error,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type MFAChallenge struct {
+	UUID             string     `json:"uuid,omitempty"`
+	UserUUID         string     `json:"user_uuid,omitempty"`
+	OrganizationUUID string     `json:"organization_uuid,omitempty"`
+	CreatedAt        time.Time  `json:"created_at,omitempty"`
+	ExpiresAt        time.Time  `json:"expires_at,omitempty"`
+	UsedAt           *time.Time `json:"used_at,omitempty"`
+	Attempts         int        `json:"attempts"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type OAuthAuthorizationCode struct {
+	UUID                string     `json:"uuid,omitempty"`
+	ClientID            string     `json:"client_id,omitempty"`
+	UserUUID            string     `json:"user_uuid,omitempty"`
+	RedirectURI         string     `json:"redirect_uri,omitempty"`
+	Scope               string     `json:"scope,omitempty"`
+	CodeChallenge       string     `json:"-"`
+	CodeChallengeMethod string     `json:"-"`
+	Nonce               string     `json:"-"`
+	CreatedAt           time.Time  `json:"created_at,omitempty"`
+	ExpiresAt           time.Time  `json:"expires_at,omitempty"`
+	UsedAt              *time.Time `json:"used_at,omitempty"`
+}
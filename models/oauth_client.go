@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type OAuthClient struct {
+	UUID         string     `json:"uuid,omitempty"`
+	ClientID     string     `json:"client_id,omitempty"`
+	SecretHash   string     `json:"-"`
+	Name         string     `json:"name,omitempty"`
+	RedirectURIs []string   `json:"redirect_uris,omitempty"`
+	GrantTypes   []string   `json:"grant_types,omitempty"`
+	Scopes       []string   `json:"scopes,omitempty"`
+	CreatedAt    time.Time  `json:"created_at,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type Organization struct {
+	UUID      string    `json:"uuid,omitempty"`
+	Slug      string    `json:"slug,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+type Membership struct {
+	OrganizationUUID string    `json:"organization_uuid,omitempty"`
+	UserUUID         string    `json:"user_uuid,omitempty"`
+	CreatedAt        time.Time `json:"created_at,omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	UUID        string     `json:"uuid,omitempty"`
+	SessionUUID string     `json:"session_uuid,omitempty"`
+	CreatedAt   time.Time  `json:"created_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at,omitempty"`
+	UsedAt      *time.Time `json:"used_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
@@ -3,9 +3,15 @@ package models
 import "time"
 
 type Session struct {
-	UUID      string     `json:"uuid,omitempty"`
-	CreatedAt time.Time  `json:"created_at,omitempty"`
-	ExpiresAt time.Time  `json:"expires_at,omitempty"`
-	DeletedAt *time.Time `json:"deleted_at,omitempty"`
-	UserUUID  string     `json:"user_uuid,omitempty"`
+	UUID             string     `json:"uuid,omitempty"`
+	CreatedAt        time.Time  `json:"created_at,omitempty"`
+	ExpiresAt        time.Time  `json:"expires_at,omitempty"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	UserUUID         string     `json:"user_uuid,omitempty"`
+	OrganizationUUID string     `json:"organization_uuid,omitempty"`
+	UserAgent        string     `json:"user_agent,omitempty"`
+	IPAddress        string     `json:"ip_address,omitempty"`
+	LastSeenAt       time.Time  `json:"last_seen_at,omitempty"`
+	ClientID         string     `json:"client_id,omitempty"`
+	Scope            string     `json:"scope,omitempty"`
 }
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type TOTPCredential struct {
+	UserUUID     string     `json:"user_uuid,omitempty"`
+	Secret       string     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at,omitempty"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+	LastUsedStep int64      `json:"-"`
+}
@@ -6,13 +6,17 @@ import (
 )
 
 type User struct {
-	UUID      string     `json:"uuid,omitempty"`
-	Email     string     `json:"email,omitempty"`
-	Password  string     `json:"password,omitempty"`
-	Name      string     `json:"name,omitempty"`
-	CreatedAt time.Time  `json:"created_at,omitempty"`
-	DeletedAt *time.Time `json:"deleted_at,omitempty"`
-	UpdatedAt time.Time  `json:"updated_at,omitempty"`
+	UUID       string     `json:"uuid,omitempty"`
+	Email      string     `json:"email,omitempty"`
+	Password   string     `json:"password,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	CreatedAt  time.Time  `json:"created_at,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at,omitempty"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+
+	FailedLoginCount int        `json:"-"`
+	LockedUntil      *time.Time `json:"locked_until,omitempty"`
 }
 
 // MarshalJSON is a custom marshaler for the User struct that strips the password before marshaling
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type VerificationToken struct {
+	UUID      string     `json:"uuid,omitempty"`
+	UserUUID  string     `json:"user_uuid,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	CreatedAt time.Time  `json:"created_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type WebAuthnChallenge struct {
+	UUID      string     `json:"uuid,omitempty"`
+	UserUUID  string     `json:"user_uuid,omitempty"`
+	Ceremony  string     `json:"ceremony,omitempty"`
+	CreatedAt time.Time  `json:"created_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type WebAuthnCredential struct {
+	UUID         string     `json:"uuid,omitempty"`
+	UserUUID     string     `json:"user_uuid,omitempty"`
+	CredentialID string     `json:"credential_id,omitempty"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    int64      `json:"-"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/sessioncache"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+)
+
+// SessionGetter is where RequireSession looks sessions and their users up, sessioncache.Cache satisfies it
+type SessionGetter interface {
+	GetSessionAndUser(uuid string) (models.Session, models.User, error)
+}
+
+// Sessions overrides where sessions are looked up, when nil they're read straight from postgres.DB
+var Sessions SessionGetter
+
+// lastSeenResolution is how stale a session's last seen time can get before a request bumps it,
+// so we aren't writing to the sessions table on every single request
+const lastSeenResolution = time.Minute
+
+type contextKey int
+
+const (
+	sessionContextKey contextKey = iota
+	userContextKey
+)
+
+// RequireSession is a middleware that sits behind the JWT handler, it loads the session named by the token's "sid" claim
+// and rejects the request unless the session is live and belongs to the token's subject. A signature check alone can't
+// tell us the user logged out (or was deleted) after the token was issued.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userUUID := r.Header.Get("X-Verified-User-Uuid")
+		session, user, err := getSession(r.Header.Get("X-Verified-Session-Uuid"))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+			return
+		}
+
+		if session.UUID == "" || session.DeletedAt != nil || !time.Now().Before(session.ExpiresAt) || session.UserUUID != userUUID {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Deleted users aren't returned, their sessions are dead too
+		if user.UUID == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if time.Since(session.LastSeenAt) > lastSeenResolution {
+			_, err = postgres.DB.TouchSessionByUUID(session.UUID)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+				return
+			}
+
+			// Make sure a cached copy doesn't keep claiming the session needs touching
+			if cache, ok := Sessions.(interface{ Invalidate(uuid string) }); ok {
+				cache.Invalidate(session.UUID)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), session, user)))
+	})
+}
+
+// getSession looks up the session and its user in Sessions, or straight from postgres.DB when it isn't set
+func getSession(uuid string) (models.Session, models.User, error) {
+	if Sessions != nil {
+		return Sessions.GetSessionAndUser(uuid)
+	}
+
+	return sessioncache.Load(postgres.DB, uuid)
+}
+
+// RequireScope is a middleware that sits behind RequireSession, it lets our own logins through and sessions an OAuth
+// client started only if the client was granted scope. With an empty scope clients are kept out altogether.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := SessionFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if session.ClientID != "" && (scope == "" || !tokens.HasScope(session.Scope, scope)) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The permissions roles can be granted, they're seeded by the migration that creates the roles tables
+const (
+	PermissionReadUsers      = "users:read"
+	PermissionWriteUsers     = "users:write"
+	PermissionRevokeSessions = "sessions:revoke"
+	PermissionReadJobs       = "jobs:read"
+)
+
+// RequirePermission is a middleware that sits behind RequireSession, it only lets the request through if one of the
+// user's roles in the session's organization grants permission. They're looked up on every request rather than read
+// from the token's "roles" claim, so taking a role away takes effect straight away.
+func RequirePermission(permission string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := SessionFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		permissions, err := postgres.DB.ListPermissionsByMembership(session.OrganizationUUID, session.UserUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(`{"message": "%v"}`, err)))
+			return
+		}
+
+		for _, granted := range permissions {
+			if granted == permission {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Permission denied"}`))
+	})
+}
+
+// NewContext returns a copy of the context carrying the authenticated session and user
+func NewContext(ctx context.Context, session models.Session, user models.User) context.Context {
+	ctx = context.WithValue(ctx, sessionContextKey, session)
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// SessionFromContext returns the session loaded by RequireSession
+func SessionFromContext(ctx context.Context) (models.Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(models.Session)
+	return session, ok
+}
+
+// UserFromContext returns the user loaded by RequireSession
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(models.User)
+	return user, ok
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/sessioncache"
+)
+
+// sessionDB returns a fixed session and, unless deletedUser is set, a live user, counting the queries made
+type sessionDB struct {
+	postgres.DBMock
+	session     models.Session
+	deletedUser bool
+	queries     int
+}
+
+func (d *sessionDB) GetSessionByUUID(uuid string) (models.Session, error) {
+	d.queries++
+	return d.session, nil
+}
+
+func (d *sessionDB) GetUserByUUID(uuid string) (models.User, error) {
+	d.queries++
+	if d.deletedUser {
+		return models.User{}, nil
+	}
+	return models.User{UUID: uuid, Email: "test@test.com"}, nil
+}
+
+func TestRequireSession(t *testing.T) {
+	currentTime := time.Now()
+	live := models.Session{UUID: "abc", UserUUID: "abc", ExpiresAt: currentTime.Add(time.Hour)}
+	deleted := live
+	deleted.DeletedAt = &currentTime
+	expired := live
+	expired.ExpiresAt = currentTime.Add(-time.Minute)
+	foreign := live
+	foreign.UserUUID = "def"
+
+	tests := []struct {
+		name        string
+		db          *sessionDB
+		wantStatus  int
+		wantSession string
+	}{
+		{
+			name:        "live session",
+			db:          &sessionDB{session: live},
+			wantStatus:  http.StatusOK,
+			wantSession: "abc",
+		},
+		{
+			name:       "missing session",
+			db:         &sessionDB{},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "deleted session",
+			db:         &sessionDB{session: deleted},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "expired session",
+			db:         &sessionDB{session: expired},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "session belonging to another user",
+			db:         &sessionDB{session: foreign},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "deleted user",
+			db:         &sessionDB{session: live, deletedUser: true},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postgres.DB = tt.db
+
+			var gotSession string
+			h := RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				session, _ := SessionFromContext(r.Context())
+				user, _ := UserFromContext(r.Context())
+				if user.UUID != session.UserUUID {
+					t.Errorf("RequireSession() user = %v, want the session's user %v", user.UUID, session.UserUUID)
+				}
+				gotSession = session.UUID
+			}))
+
+			r := httptest.NewRequest("GET", "/users", nil)
+			r.Header.Set("X-Verified-User-Uuid", "abc")
+			r.Header.Set("X-Verified-Session-Uuid", "abc")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("RequireSession() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if gotSession != tt.wantSession {
+				t.Errorf("RequireSession() session = %v, want %v", gotSession, tt.wantSession)
+			}
+		})
+	}
+}
+
+func TestRequireSession_Cached(t *testing.T) {
+	currentTime := time.Now()
+	db := &sessionDB{session: models.Session{UUID: "abc", UserUUID: "abc", ExpiresAt: currentTime.Add(time.Hour), LastSeenAt: currentTime}}
+	postgres.DB = db
+	cache := sessioncache.New(db, 10, time.Minute)
+	Sessions = cache
+	defer func() { Sessions = nil }()
+
+	serve := func() int {
+		r := httptest.NewRequest("GET", "/users", nil)
+		r.Header.Set("X-Verified-User-Uuid", "abc")
+		r.Header.Set("X-Verified-Session-Uuid", "abc")
+		w := httptest.NewRecorder()
+		RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Only the first request goes to the database, for the session and the user both
+	for i := 0; i < 3; i++ {
+		if status := serve(); status != http.StatusOK {
+			t.Fatalf("RequireSession() status = %v, want %v", status, http.StatusOK)
+		}
+	}
+	if db.queries != 2 {
+		t.Errorf("RequireSession() made %v queries for 3 requests, want 2", db.queries)
+	}
+
+	// Once the user is deleted their cached sessions go with them
+	db.deletedUser = true
+	cache.InvalidateUser("abc")
+	if status := serve(); status != http.StatusUnauthorized {
+		t.Errorf("RequireSession() for a deleted user status = %v, want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	login := models.Session{UUID: "abc", UserUUID: "abc"}
+	client := models.Session{UUID: "def", UserUUID: "abc", ClientID: "mobile", Scope: "openid profile"}
+
+	tests := []struct {
+		name       string
+		session    models.Session
+		scope      string
+		wantStatus int
+	}{
+		{name: "login", session: login, scope: "profile", wantStatus: http.StatusOK},
+		{name: "login on a first party endpoint", session: login, wantStatus: http.StatusOK},
+		{name: "client granted the scope", session: client, scope: "profile", wantStatus: http.StatusOK},
+		{name: "client without the scope", session: client, scope: "admin", wantStatus: http.StatusForbidden},
+		{name: "client on a first party endpoint", session: client, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequireScope(tt.scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest("GET", "/users/me", nil)
+			r = r.WithContext(NewContext(r.Context(), tt.session, models.User{UUID: "abc"}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("RequireScope() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// permissionDB grants the users in it their permissions, by organization and user
+type permissionDB struct {
+	postgres.DBMock
+	permissions map[string][]string
+}
+
+func (d *permissionDB) ListPermissionsByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return d.permissions[organizationUUID+"/"+userUUID], nil
+}
+
+func TestRequirePermission(t *testing.T) {
+	postgres.DB = &permissionDB{permissions: map[string][]string{
+		"fender/admin":   {PermissionReadUsers, PermissionWriteUsers},
+		"fender/support": {PermissionReadUsers},
+		"gibson/support": {PermissionReadUsers, PermissionWriteUsers},
+	}}
+
+	tests := []struct {
+		name         string
+		user         string
+		organization string
+		permission   string
+		wantStatus   int
+	}{
+		{name: "granted", user: "admin", organization: "fender", permission: PermissionWriteUsers, wantStatus: http.StatusOK},
+		{name: "granted by another role", user: "support", organization: "fender", permission: PermissionReadUsers, wantStatus: http.StatusOK},
+		{name: "not granted", user: "support", organization: "fender", permission: PermissionWriteUsers, wantStatus: http.StatusForbidden},
+		{name: "granted in another organization", user: "support", organization: "gibson", permission: PermissionWriteUsers, wantStatus: http.StatusOK},
+		{name: "no roles in this organization", user: "admin", organization: "gibson", permission: PermissionReadUsers, wantStatus: http.StatusForbidden},
+		{name: "no roles", user: "abc", organization: "fender", permission: PermissionReadUsers, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequirePermission(tt.permission, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest("GET", "/admin/users", nil)
+			r = r.WithContext(NewContext(r.Context(), models.Session{UUID: "abc", UserUUID: tt.user, OrganizationUUID: tt.organization}, models.User{UUID: tt.user}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("RequirePermission() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustProxyHeaders makes ClientIP believe X-Forwarded-For, only turn this on behind a load balancer that sets it
+var TrustProxyHeaders bool
+
+// TrustedProxyHops is how many proxies in front of us append to X-Forwarded-For, ex: 2 for a CDN in front of a
+// load balancer. The client's address is the one the outermost of them appended.
+var TrustedProxyHops = 1
+
+// ClientIP returns the IP address a request came from
+func ClientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		// Clients can send whatever X-Forwarded-For they like, so only the addresses our own proxies appended to the
+		// right of it can be believed. Proxies may append a header of their own rather than add to the last one.
+		forwarded := []string{}
+		for _, header := range r.Header["X-Forwarded-For"] {
+			for _, address := range strings.Split(header, ",") {
+				if address = strings.TrimSpace(address); address != "" {
+					forwarded = append(forwarded, address)
+				}
+			}
+		}
+
+		if len(forwarded) > 0 {
+			i := len(forwarded) - TrustedProxyHops
+			if i < 0 {
+				i = 0
+			}
+			return forwarded[i]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
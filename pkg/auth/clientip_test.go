@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		trustProxy bool
+		hops       int
+		want       string
+	}{
+		{
+			name:       "remote address",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "forwarded header is ignored by default",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  "203.0.113.7",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "forwarded header behind a trusted proxy",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  "203.0.113.7",
+			trustProxy: true,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed leading entry is ignored",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  "198.51.100.66, 203.0.113.7",
+			trustProxy: true,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "two trusted proxies",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  "198.51.100.66, 203.0.113.7, 10.0.0.1",
+			trustProxy: true,
+			hops:       2,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "fewer entries than proxies",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  "203.0.113.7",
+			trustProxy: true,
+			hops:       2,
+			want:       "203.0.113.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			TrustProxyHeaders = tt.trustProxy
+			if tt.hops > 0 {
+				TrustedProxyHops = tt.hops
+			}
+			defer func() { TrustProxyHeaders, TrustedProxyHops = false, 1 }()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
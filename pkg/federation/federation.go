@@ -0,0 +1,260 @@
+// Package federation logs users in with upstream OpenID Connect providers, ex: Google. It sends the user to the
+// provider with PKCE, swaps the code they come back with for an access token and asks the provider who they are.
+package federation
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginTimeout is how long a user has to come back from the provider
+const LoginTimeout = 10 * time.Minute
+
+// DefaultScopes are what's asked for when a provider isn't configured with its own
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+var (
+	// ErrInvalidGrant is returned when the provider won't exchange the code, ex: it's expired or was already used
+	ErrInvalidGrant = errors.New("federation: the provider rejected the authorization code")
+	// ErrNoSubject is returned when the provider doesn't say who the user is
+	ErrNoSubject = errors.New("federation: the provider didn't return a subject")
+)
+
+// Providers are the providers users can log in with, by the name they're referred to in requests
+var Providers = map[string]*Provider{}
+
+// Identity is who the provider says the user is
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an upstream OpenID Connect provider we're registered with as a client
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// RedirectURL is the page on our frontend the provider sends users back to, it has to be registered with them
+	RedirectURL string
+	// LinkByEmail logs users in to the existing account with the same email address, as long as both sides have
+	// verified it. Only turn it on for providers that can be trusted to verify email addresses.
+	LinkByEmail bool
+
+	// The endpoints are discovered from the issuer, they only need setting for providers that don't support discovery
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+
+	Client *http.Client
+
+	mu sync.Mutex
+}
+
+// NewProvider returns a provider that discovers its endpoints from issuer
+func NewProvider(name, issuer, clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       DefaultScopes,
+		RedirectURL:  redirectURL,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a code verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns where to send the user to log in, state and the verifier's challenge come back to us with them
+func (p *Provider) AuthCodeURL(state, codeVerifier string) (string, error) {
+	err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		separator = "&"
+	}
+
+	return p.AuthURL + separator + query.Encode(), nil
+}
+
+// Exchange swaps the code the user came back with for an access token, and uses it to ask the provider who they are
+func (p *Provider) Exchange(code, codeVerifier string) (Identity, error) {
+	err := p.discover()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}{}
+	status, err := p.do(req, &token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	// RFC 6749 has a bad code come back as a 400 invalid_grant, anything else is the provider or our config at fault
+	if status == http.StatusBadRequest && token.Error == "invalid_grant" {
+		return Identity{}, ErrInvalidGrant
+	}
+	if status != http.StatusOK || token.AccessToken == "" {
+		return Identity{}, fmt.Errorf("federation: %v token endpoint returned %v %v", p.Name, status, token.Error)
+	}
+
+	return p.userInfo(token.AccessToken)
+}
+
+// userInfo asks the provider who the access token belongs to. It's fetched straight from the provider over TLS,
+// so unlike an id_token it doesn't need its signature checked.
+func (p *Provider) userInfo(accessToken string) (Identity, error) {
+	req, err := http.NewRequest("GET", p.UserInfoURL, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	claims := struct {
+		Subject       string      `json:"sub"`
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"`
+		Name          string      `json:"name"`
+	}{}
+	status, err := p.do(req, &claims)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if status != http.StatusOK {
+		return Identity{}, fmt.Errorf("federation: %v userinfo endpoint returned %v", p.Name, status)
+	}
+
+	if claims.Subject == "" {
+		return Identity{}, ErrNoSubject
+	}
+
+	// Some providers send email_verified as a string
+	verified := claims.EmailVerified == true || claims.EmailVerified == "true"
+
+	return Identity{Subject: claims.Subject, Email: claims.Email, EmailVerified: verified, Name: claims.Name}, nil
+}
+
+// discover looks up the provider's endpoints from its issuer the first time they're needed, a failure is retried
+// next time rather than kept
+func (p *Provider) discover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.AuthURL != "" && p.TokenURL != "" && p.UserInfoURL != "" {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+
+	config := struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}{}
+	status, err := p.do(req, &config)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("federation: %v discovery returned %v", p.Name, status)
+	}
+
+	// OpenID Connect Discovery 1.0 section 4.3, the document has to be for the issuer we asked about
+	if strings.TrimSuffix(config.Issuer, "/") != p.Issuer {
+		return fmt.Errorf("federation: %v discovery is for issuer %q", p.Name, config.Issuer)
+	}
+
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.UserInfoEndpoint == "" {
+		return fmt.Errorf("federation: %v discovery is missing endpoints", p.Name)
+	}
+
+	// Endpoints that were configured by hand win
+	if p.AuthURL == "" {
+		p.AuthURL = config.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = config.TokenEndpoint
+	}
+	if p.UserInfoURL == "" {
+		p.UserInfoURL = config.UserInfoEndpoint
+	}
+
+	return nil
+}
+
+// do makes the request and decodes a JSON response into v, whatever the status
+func (p *Provider) do(req *http.Request, v interface{}) (int, error) {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	// Error responses don't have to be JSON, the status is enough to go on then
+	if json.Unmarshal(body, v) != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("federation: %v returned invalid JSON from %v", p.Name, req.URL.Path)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,85 @@
+package federation_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/federation"
+	"github.com/kylegrantlucas/platform-exercise/pkg/federation/federationtest"
+)
+
+func TestProvider_Exchange(t *testing.T) {
+	idp := federationtest.NewIdP("fender", "secret")
+	defer idp.Close()
+
+	provider := federation.NewProvider("test", idp.URL+"/", "fender", "secret", "https://fender.com/login/callback")
+	identity := federation.Identity{Subject: "1234", Email: "test@test.com", EmailVerified: true, Name: "Testy McTesterson"}
+
+	authCodeURL, err := provider.AuthCodeURL("xyz", "verifier")
+	if err != nil {
+		t.Fatalf("Provider.AuthCodeURL() error = %v", err)
+	}
+
+	redirect, err := idp.Login(authCodeURL, identity)
+	if err != nil {
+		t.Fatalf("IdP.Login() error = %v", err)
+	}
+	if redirect.Query().Get("state") != "xyz" {
+		t.Errorf("IdP.Login() state = %q, want it passed back", redirect.Query().Get("state"))
+	}
+
+	// The code has to come with the verifier it was started with
+	_, err = provider.Exchange(redirect.Query().Get("code"), "wrong")
+	if err != federation.ErrInvalidGrant {
+		t.Errorf("Provider.Exchange() with the wrong verifier error = %v, want %v", err, federation.ErrInvalidGrant)
+	}
+
+	redirect, _ = idp.Login(authCodeURL, identity)
+	got, err := provider.Exchange(redirect.Query().Get("code"), "verifier")
+	if err != nil {
+		t.Fatalf("Provider.Exchange() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, identity) {
+		t.Errorf("Provider.Exchange() = %+v, want %+v", got, identity)
+	}
+
+	// Codes only work once
+	_, err = provider.Exchange(redirect.Query().Get("code"), "verifier")
+	if err != federation.ErrInvalidGrant {
+		t.Errorf("Provider.Exchange() reusing a code error = %v, want %v", err, federation.ErrInvalidGrant)
+	}
+
+	// A client the provider doesn't know isn't the user's fault
+	provider.ClientSecret = "wrong"
+	redirect, _ = idp.Login(authCodeURL, identity)
+	_, err = provider.Exchange(redirect.Query().Get("code"), "verifier")
+	if err == nil || err == federation.ErrInvalidGrant {
+		t.Errorf("Provider.Exchange() with the wrong secret error = %v, want a configuration error", err)
+	}
+}
+
+func TestProvider_Discovery(t *testing.T) {
+	// A discovery document for some other issuer can't be trusted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer": "https://evil.example", "authorization_endpoint": "https://evil.example/authorize", "token_endpoint": "https://evil.example/token", "userinfo_endpoint": "https://evil.example/userinfo"}`))
+	}))
+	defer server.Close()
+
+	provider := federation.NewProvider("test", server.URL, "fender", "secret", "https://fender.com/login/callback")
+	if _, err := provider.AuthCodeURL("xyz", "verifier"); err == nil {
+		t.Errorf("Provider.AuthCodeURL() with a mismatched issuer, want an error")
+	}
+
+	// Endpoints configured by hand don't need discovery at all
+	provider.AuthURL, provider.TokenURL, provider.UserInfoURL = "https://example.com/authorize?prompt=login", "https://example.com/token", "https://example.com/userinfo"
+	authCodeURL, err := provider.AuthCodeURL("xyz", "verifier")
+	if err != nil {
+		t.Fatalf("Provider.AuthCodeURL() error = %v", err)
+	}
+	want := "https://example.com/authorize?prompt=login&client_id=fender&code_challenge=" + federation.CodeChallenge("verifier") + "&code_challenge_method=S256&redirect_uri=https%3A%2F%2Ffender.com%2Flogin%2Fcallback&response_type=code&scope=openid+email+profile&state=xyz"
+	if authCodeURL != want {
+		t.Errorf("Provider.AuthCodeURL() = %v, want %v", authCodeURL, want)
+	}
+}
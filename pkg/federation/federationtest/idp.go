@@ -0,0 +1,139 @@
+// Package federationtest provides a fake OpenID Connect provider for testing logins without a real one
+package federationtest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/federation"
+)
+
+// IdP is a provider with a single registered client, it serves discovery, token and userinfo endpoints. Users
+// "log in" by calling Login, which does what the provider's login page would.
+type IdP struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu           sync.Mutex
+	codes        map[string]grant
+	accessTokens map[string]federation.Identity
+}
+
+type grant struct {
+	identity      federation.Identity
+	redirectURI   string
+	codeChallenge string
+}
+
+// NewIdP starts a provider with a client registered as clientID and clientSecret, it has to be closed when done
+func NewIdP(clientID, clientSecret string) *IdP {
+	idp := &IdP{ClientID: clientID, ClientSecret: clientSecret, codes: map[string]grant{}, accessTokens: map[string]federation.Identity{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/userinfo", idp.userInfo)
+	idp.Server = httptest.NewServer(mux)
+
+	return idp
+}
+
+// Login logs the user in at the authorization URL a provider built, and returns where the user is sent back to
+func (i *IdP) Login(authCodeURL string, identity federation.Identity) (*url.URL, error) {
+	parsed, err := url.Parse(authCodeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	query := parsed.Query()
+	if query.Get("client_id") != i.ClientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		return nil, errors.New("federationtest: invalid authorization request")
+	}
+
+	code := random()
+	i.mu.Lock()
+	i.codes[code] = grant{identity: identity, redirectURI: query.Get("redirect_uri"), codeChallenge: query.Get("code_challenge")}
+	i.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return nil, err
+	}
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+
+	return redirect, nil
+}
+
+func (i *IdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"userinfo_endpoint":      i.URL + "/userinfo",
+	})
+}
+
+func (i *IdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.PostForm.Get("client_id") != i.ClientID || r.PostForm.Get("client_secret") != i.ClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes work once, for the redirect_uri and verifier they were issued with
+	i.mu.Lock()
+	grant, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+
+	if !ok || grant.redirectURI != r.PostForm.Get("redirect_uri") || grant.codeChallenge != federation.CodeChallenge(r.PostForm.Get("code_verifier")) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	accessToken := random()
+	i.mu.Lock()
+	i.accessTokens[accessToken] = grant.identity
+	i.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": accessToken, "token_type": "Bearer"})
+}
+
+func (i *IdP) userInfo(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	identity, ok := i.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	i.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sub":            identity.Subject,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"name":           identity.Name,
+	})
+}
+
+// random returns an unguessable code or token
+func random() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
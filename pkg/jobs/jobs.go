@@ -0,0 +1,156 @@
+// Package jobs runs housekeeping in the background for as long as the server is up, ex: purging rows that have been
+// kept long enough. Every replica runs the same jobs, so a job has to be safe to run more than once at a time unless
+// it's exclusive, then replicas take turns through a Postgres advisory lock.
+package jobs
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+)
+
+// Job is work that's run every so often
+type Job struct {
+	// Name identifies the job in logs and recorded runs, exclusive jobs are locked by it
+	Name string
+	// Every is how long to wait after a run starts before starting the next one
+	Every time.Duration
+	// Run does a run's worth of work, returning how many records it handled. It should give up early once stop is
+	// closed, the work left over is picked up by the next run.
+	Run func(stop <-chan struct{}) (int, error)
+	// Exclusive jobs are run by one replica at a time, and about once every Every between all of them
+	Exclusive bool
+}
+
+// Store is where replicas coordinate their runs, postgres.DB is one
+type Store interface {
+	TryAdvisoryLock(key int64) (func() error, bool, error)
+	RecordJobRun(run models.JobRun) (int, error)
+	GetJobRun(name string) (models.JobRun, error)
+}
+
+// Runner runs jobs on their own schedules
+type Runner struct {
+	// Store locks exclusive jobs and keeps the last run of every job, without one every job runs on every replica
+	// and runs are only logged
+	Store Store
+	// Host names the replica in recorded runs
+	Host string
+
+	jobs []Job
+	wg   sync.WaitGroup
+}
+
+// Add schedules a job, it has to be added before Start
+func (r *Runner) Add(job Job) {
+	r.jobs = append(r.jobs, job)
+}
+
+// Start runs every job straight away and then every job.Every until stop is closed, each in its own goroutine
+func (r *Runner) Start(stop <-chan struct{}) {
+	for _, job := range r.jobs {
+		r.wg.Add(1)
+		go func(job Job) {
+			defer r.wg.Done()
+			r.loop(job, stop)
+		}(job)
+	}
+}
+
+// Wait blocks until every job has stopped, a run that's in progress when stop is closed is let finish
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+func (r *Runner) loop(job Job, stop <-chan struct{}) {
+	ticker := time.NewTicker(job.Every)
+	defer ticker.Stop()
+
+	for {
+		if job.Exclusive && r.Store != nil {
+			r.runExclusive(job, stop)
+		} else {
+			r.run(job, stop)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// runExclusive runs the job if no other replica is running it and none has started it in the last half of
+// job.Every. The lock is held for the whole run and let go after, so whichever replica's turn comes up next can take
+// it. Only half is waited for since the last run is recorded a little after its tick, so at the next tick slightly
+// less than a whole job.Every has gone by, and replicas' ticks and clocks don't line up either.
+func (r *Runner) runExclusive(job Job, stop <-chan struct{}) {
+	unlock, locked, err := r.Store.TryAdvisoryLock(lockKey(job.Name))
+	if err != nil {
+		log.Printf("job %v couldn't be locked: %v", job.Name, err)
+		return
+	}
+	if !locked {
+		return
+	}
+	defer func() {
+		err := unlock()
+		if err != nil {
+			log.Printf("job %v couldn't be unlocked: %v", job.Name, err)
+		}
+	}()
+
+	last, err := r.Store.GetJobRun(job.Name)
+	if err != nil {
+		log.Printf("job %v couldn't look up its last run: %v", job.Name, err)
+		return
+	}
+	if time.Since(last.StartedAt) < job.Every/2 {
+		return
+	}
+
+	r.run(job, stop)
+}
+
+// run runs the job once, a failure is only logged and recorded since the next run will try again
+func (r *Runner) run(job Job, stop <-chan struct{}) {
+	startedAt := time.Now()
+	processed, err := job.Run(stop)
+	r.record(job, startedAt, processed, err)
+	if err != nil {
+		log.Printf("job %v failed after %v records: %v", job.Name, processed, err)
+		return
+	}
+
+	if processed > 0 {
+		log.Printf("job %v handled %v records", job.Name, processed)
+	}
+}
+
+// record keeps the run in the Store, where GET /admin/jobs reads it from
+func (r *Runner) record(job Job, startedAt time.Time, processed int, runErr error) {
+	if r.Store == nil {
+		return
+	}
+
+	run := models.JobRun{Name: job.Name, Host: r.Host, StartedAt: startedAt, FinishedAt: time.Now(), Processed: processed}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+
+	_, err := r.Store.RecordJobRun(run)
+	if err != nil {
+		log.Printf("job %v couldn't record its run: %v", job.Name, err)
+	}
+}
+
+// lockKey is the advisory lock a job is locked by, it's derived from the name so every replica agrees on it
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("jobs:" + name))
+	return int64(h.Sum64())
+}
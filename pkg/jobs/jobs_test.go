@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+)
+
+// memoryStore coordinates runners in the same process the way Postgres does between replicas
+type memoryStore struct {
+	mu     sync.Mutex
+	locked map[int64]bool
+	runs   map[string]models.JobRun
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{locked: map[int64]bool{}, runs: map[string]models.JobRun{}}
+}
+
+func (s *memoryStore) TryAdvisoryLock(key int64) (func() error, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[key] {
+		return nil, false, nil
+	}
+
+	s.locked[key] = true
+	return func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.locked, key)
+		return nil
+	}, true, nil
+}
+
+func (s *memoryStore) RecordJobRun(run models.JobRun) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[run.Name] = run
+	return 1, nil
+}
+
+func (s *memoryStore) GetJobRun(name string) (models.JobRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[name], nil
+}
+
+func TestRunner(t *testing.T) {
+	runs := make(chan string, 10)
+	record := func(name string) {
+		// Don't block a job once the test has stopped listening
+		select {
+		case runs <- name:
+		default:
+		}
+	}
+
+	runner := &Runner{}
+	runner.Add(Job{Name: "works", Every: time.Millisecond, Run: func(stop <-chan struct{}) (int, error) {
+		record("works")
+		return 1, nil
+	}})
+	runner.Add(Job{Name: "fails", Every: time.Hour, Run: func(stop <-chan struct{}) (int, error) {
+		record("fails")
+		return 0, errors.New("database is down")
+	}})
+
+	stop := make(chan struct{})
+	runner.Start(stop)
+
+	// Both run straight away, and a failure doesn't stop a job from being run again
+	seen := map[string]int{}
+	for seen["works"] < 2 || seen["fails"] < 1 {
+		select {
+		case name := <-runs:
+			seen[name]++
+		case <-time.After(time.Second):
+			t.Fatalf("Runner.Start() ran %v, want works twice and fails once", seen)
+		}
+	}
+
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		runner.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Runner.Wait() didn't return after stop was closed")
+	}
+
+	// The hourly job isn't run again before its time
+	for len(runs) > 0 {
+		seen[<-runs]++
+	}
+	if seen["fails"] != 1 {
+		t.Errorf("Runner.Start() ran the hourly job %v times, want 1", seen["fails"])
+	}
+}
+
+func TestRunner_Exclusive(t *testing.T) {
+	store := newMemoryStore()
+	var runs int32
+	job := Job{Name: "sweep", Every: time.Hour, Exclusive: true, Run: func(stop <-chan struct{}) (int, error) {
+		atomic.AddInt32(&runs, 1)
+		return 3, errors.New("database went away")
+	}}
+
+	// Two replicas start at once, only one of them gets to run it
+	stop := make(chan struct{})
+	replicas := []*Runner{{Store: store, Host: "web-1"}, {Store: store, Host: "web-2"}}
+	for _, runner := range replicas {
+		runner.Add(job)
+		runner.Start(stop)
+	}
+	close(stop)
+	for _, runner := range replicas {
+		runner.Wait()
+	}
+
+	if runs != 1 {
+		t.Errorf("Runner.Start() on two replicas ran an exclusive job %v times, want 1", runs)
+	}
+
+	run, _ := store.GetJobRun("sweep")
+	if run.Processed != 3 || run.Error != "database went away" || run.FinishedAt.Before(run.StartedAt) || (run.Host != "web-1" && run.Host != "web-2") {
+		t.Errorf("Runner.Start() recorded %+v, want the failed run", run)
+	}
+
+	// While another replica holds the lock it isn't run at all, and once it's free it still waits for its turn
+	runs = 0
+	unlock, _, _ := store.TryAdvisoryLock(lockKey("sweep"))
+	runner := &Runner{Store: store}
+	runner.runExclusive(job, nil)
+	unlock()
+	runner.runExclusive(job, nil)
+	if runs != 0 {
+		t.Errorf("Runner.runExclusive() ran %v times, want 0", runs)
+	}
+
+	store.runs["sweep"] = models.JobRun{Name: "sweep", StartedAt: time.Now().Add(-2 * time.Hour)}
+	runner.runExclusive(job, nil)
+	if runs != 1 {
+		t.Errorf("Runner.runExclusive() once the last run was long enough ago ran %v times, want 1", runs)
+	}
+
+	// A tick comes a touch less than Every after the last run was recorded, which is still its turn
+	store.runs["sweep"] = models.JobRun{Name: "sweep", StartedAt: time.Now().Add(-time.Hour + time.Second)}
+	runner.runExclusive(job, nil)
+	if runs != 2 {
+		t.Errorf("Runner.runExclusive() when the last run started just under Every ago ran %v times in all, want 2", runs)
+	}
+}
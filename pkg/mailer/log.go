@@ -0,0 +1,20 @@
+package mailer
+
+import "log"
+
+// Log is a Mailer that logs who each message was for and drops it, for when there's nothing to deliver through.
+// Bodies carry tokens, so they're only logged with ShowBody, ex: in local development.
+type Log struct {
+	ShowBody bool
+}
+
+// Send logs the message
+func (l Log) Send(msg Message) error {
+	if l.ShowBody {
+		log.Printf("not delivering email to %v %q:\n%v", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	log.Printf("not delivering email to %v %q", msg.To, msg.Subject)
+	return nil
+}
@@ -0,0 +1,22 @@
+package mailer
+
+import "errors"
+
+// ErrInvalidHeader is returned for messages with a line break in the recipient or subject, which would let
+// whoever controls them inject their own headers
+var ErrInvalidHeader = errors.New("mailer: header contains a line break")
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails, the implementation is picked in main based on the environment
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer used to send emails across the service
+var Default Mailer = Log{}
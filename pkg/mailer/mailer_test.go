@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	m := &Memory{}
+
+	if _, ok := m.Last(); ok {
+		t.Errorf("Memory.Last() on an empty mailer returned a message")
+	}
+
+	first := Message{To: "a@test.com", Subject: "first", Body: "one"}
+	second := Message{To: "b@test.com", Subject: "second", Body: "two"}
+	m.Send(first)
+	m.Send(second)
+
+	if got := m.Sent(); !reflect.DeepEqual(got, []Message{first, second}) {
+		t.Errorf("Memory.Sent() = %v, want %v", got, []Message{first, second})
+	}
+
+	if got, ok := m.Last(); !ok || got != second {
+		t.Errorf("Memory.Last() = %v, %v, want %v, true", got, ok, second)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	msg := Message{To: "a@test.com", Subject: "Reset your password", Body: "token: secret"}
+	if err := (Log{}).Send(msg); err != nil || !strings.Contains(buf.String(), "a@test.com") || strings.Contains(buf.String(), "secret") {
+		t.Errorf("Log.Send() = %v, logged %q, want the recipient without the body", err, buf.String())
+	}
+
+	buf.Reset()
+	if err := (Log{ShowBody: true}).Send(msg); err != nil || !strings.Contains(buf.String(), "secret") {
+		t.Errorf("Log.Send() with ShowBody = %v, logged %q, want the body", err, buf.String())
+	}
+}
+
+func TestSMTP_format(t *testing.T) {
+	m := NewSMTP("localhost", "25", "", "", "noreply@test.com")
+	date := time.Date(2019, 4, 26, 10, 33, 18, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		msg     Message
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "plain message",
+			msg:  Message{To: "test@test.com", Subject: "Hello", Body: "line one\nline two"},
+			want: "From: noreply@test.com\r\nTo: test@test.com\r\nSubject: Hello\r\nDate: Fri, 26 Apr 2019 10:33:18 +0000\r\n" +
+				"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nline one\r\nline two",
+		},
+		{
+			name:    "header injection",
+			msg:     Message{To: "test@test.com", Subject: "Hello\r\nBcc: everyone@test.com"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.format(tt.msg, date)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SMTP.format() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("SMTP.format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSMTP(t *testing.T) {
+	if m := NewSMTP("localhost", "25", "", "", "noreply@test.com"); m.Auth != nil || m.Addr != "localhost:25" {
+		t.Errorf("NewSMTP() without a username = %+v, want no auth on localhost:25", m)
+	}
+
+	if m := NewSMTP("localhost", "587", "user", "pass", "noreply@test.com"); m.Auth == nil {
+		t.Errorf("NewSMTP() with a username has no auth")
+	}
+}
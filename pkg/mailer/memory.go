@@ -0,0 +1,39 @@
+package mailer
+
+import "sync"
+
+// Memory is a Mailer that keeps every message it's asked to send instead of delivering it, for tests. Nothing is ever
+// let go, so it's no use in a server.
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+// Send records the message
+func (m *Memory) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns every message sent so far, oldest first
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message{}, m.sent...)
+}
+
+// Last returns the most recently sent message, and false if nothing has been sent
+func (m *Memory) Last() (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.sent) == 0 {
+		return Message{}, false
+	}
+
+	return m.sent[len(m.sent)-1], true
+}
@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP is a Mailer that delivers messages through an SMTP relay
+type SMTP struct {
+	// Addr is the host:port of the relay
+	Addr string
+
+	// From is the address messages are sent from
+	From string
+
+	// Auth authenticates with the relay, it can be left nil for relays that don't need it
+	Auth smtp.Auth
+}
+
+// NewSMTP returns an SMTP mailer for the relay at host:port, authenticating with PLAIN auth when a username is given
+func NewSMTP(host, port, username, password, from string) *SMTP {
+	m := &SMTP{Addr: net.JoinHostPort(host, port), From: from}
+	if username != "" {
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+// Send delivers the message to the relay
+func (m *SMTP) Send(msg Message) error {
+	raw, err := m.format(msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, raw)
+}
+
+// format builds the RFC 5322 message handed to the relay
+func (m *SMTP) format(msg Message, date time.Time) ([]byte, error) {
+	for _, header := range []string{m.From, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %v\r\n", m.From)
+	fmt.Fprintf(buf, "To: %v\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %v\r\n", msg.Subject)
+	fmt.Fprintf(buf, "Date: %v\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+
+	// SMTP wants CRLF line endings in the body too
+	buf.WriteString(strings.Replace(strings.Replace(msg.Body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+
+	return buf.Bytes(), nil
+}
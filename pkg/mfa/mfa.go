@@ -0,0 +1,95 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/secrets"
+	"github.com/kylegrantlucas/platform-exercise/pkg/totp"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes a user gets when they turn on MFA
+	RecoveryCodeCount = 10
+	// totpSkew is how many steps either side of now a code is accepted for, covering clock drift
+	totpSkew = 1
+)
+
+// ErrNotConfigured is returned when there's no key to seal TOTP secrets with
+var ErrNotConfigured = errors.New("mfa: no encryption key is configured")
+
+// Secrets seals TOTP secrets before they're stored, MFA can't be enrolled in without it
+var Secrets *secrets.Box
+
+// Issuer is the name authenticator apps list accounts under
+var Issuer = "Fender"
+
+// recoveryEncoding is lowercase base32 without the characters people mix up, see NormalizeRecoveryCode
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// SealSecret encrypts a user's TOTP secret for storage, bound to the user so it can't be moved to another account
+func SealSecret(userUUID, secret string) (string, error) {
+	if Secrets == nil {
+		return "", ErrNotConfigured
+	}
+
+	return Secrets.Seal([]byte(secret), []byte(userUUID))
+}
+
+// CheckTOTP reports whether a code is valid for the credential, returning the step it was valid for. Codes for
+// steps at or before the last one used are refused, so a code can't be replayed while it's still current.
+func CheckTOTP(credential models.TOTPCredential, code string, now time.Time) (int64, bool, error) {
+	if Secrets == nil {
+		return 0, false, ErrNotConfigured
+	}
+
+	secret, err := Secrets.Open(credential.Secret, []byte(credential.UserUUID))
+	if err != nil {
+		return 0, false, err
+	}
+
+	step, ok := totp.Validate(string(secret), code, now, totpSkew)
+	if !ok || step <= credential.LastUsedStep {
+		return 0, false, nil
+	}
+
+	return step, true, nil
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount new single use recovery codes, formatted like "abcde-fghij"
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := []string{}
+	for i := 0; i < RecoveryCodeCount; i++ {
+		buf := make([]byte, 10)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		code := recoveryEncoding.EncodeToString(buf)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode returns what's stored for a recovery code, codes are normalized first so they can be typed in
+// with any case, spacing or dashes
+func HashRecoveryCode(code string) string {
+	return randtoken.Hash(NormalizeRecoveryCode(code))
+}
+
+// NormalizeRecoveryCode lowercases a recovery code and strips everything that isn't part of it
+func NormalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
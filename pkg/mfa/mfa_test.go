@@ -0,0 +1,71 @@
+package mfa
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/secrets"
+	"github.com/kylegrantlucas/platform-exercise/pkg/totp"
+)
+
+func TestCheckTOTP(t *testing.T) {
+	Secrets = nil
+	if _, err := SealSecret("abc", "secret"); err != ErrNotConfigured {
+		t.Fatalf("SealSecret() without a key error = %v, want %v", err, ErrNotConfigured)
+	}
+
+	Secrets, _ = secrets.NewBox(bytes.Repeat([]byte{1}, secrets.KeySize))
+	defer func() { Secrets = nil }()
+
+	secret, _ := totp.GenerateSecret()
+	sealed, err := SealSecret("abc", secret)
+	if err != nil {
+		t.Fatalf("SealSecret() error = %v", err)
+	}
+
+	now := time.Now()
+	code, _ := totp.Code(secret, totp.Step(now))
+	credential := models.TOTPCredential{UserUUID: "abc", Secret: sealed}
+
+	step, ok, err := CheckTOTP(credential, code, now)
+	if err != nil || !ok || step != totp.Step(now) {
+		t.Errorf("CheckTOTP() = %v, %v, %v, want %v, true, nil", step, ok, err, totp.Step(now))
+	}
+
+	// Once a step has been used its code is spent
+	credential.LastUsedStep = step
+	if _, ok, _ := CheckTOTP(credential, code, now); ok {
+		t.Errorf("CheckTOTP() accepted a code for a step that's been used")
+	}
+
+	// A secret sealed for one user doesn't open for another
+	credential = models.TOTPCredential{UserUUID: "def", Secret: sealed}
+	if _, _, err := CheckTOTP(credential, code, now); err != secrets.ErrOpen {
+		t.Errorf("CheckTOTP() for another user error = %v, want %v", err, secrets.ErrOpen)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("GenerateRecoveryCodes() code %q isn't formatted like abcde-fghij", code)
+		}
+		seen[code] = true
+	}
+
+	if len(seen) != RecoveryCodeCount {
+		t.Errorf("GenerateRecoveryCodes() gave %v distinct codes, want %v", len(seen), RecoveryCodeCount)
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+string(bytes.ToUpper([]byte(codes[0])))) {
+		t.Errorf("HashRecoveryCode() depends on case or spacing")
+	}
+}
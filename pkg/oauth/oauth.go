@@ -0,0 +1,112 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+
+	// PKCEMethodS256 is the only code challenge method we accept, "plain" doesn't protect anything
+	PKCEMethodS256 = "S256"
+
+	// CodeLifetime is how long an authorization code can wait to be exchanged, RFC 6749 recommends no more than 10 minutes
+	CodeLifetime = 10 * time.Minute
+)
+
+// GrantTypes are the grants the token endpoint supports, a client is registered for some of them
+var GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials}
+
+// Scopes are the scopes clients can be registered for and users asked to consent to, with what they're shown
+var Scopes = map[string]string{
+	"openid":  "Sign you in",
+	"profile": "See your name and email address",
+	"email":   "See your email address and whether it's verified",
+}
+
+// ErrInvalidScope is returned when a client asks for a scope that doesn't exist or it isn't registered for
+var ErrInvalidScope = errors.New("oauth: scope isn't allowed for this client")
+
+// IsPublic reports whether the client can't keep a secret, ex: a mobile app or a single page app. Public clients
+// don't authenticate at the token endpoint, so they have to use PKCE instead.
+func IsPublic(client models.OAuthClient) bool {
+	return client.SecretHash == ""
+}
+
+// CheckSecret reports whether secret is the confidential client's secret, public clients have none to check
+func CheckSecret(client models.OAuthClient, secret string) bool {
+	if IsPublic(client) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(randtoken.Hash(secret)), []byte(client.SecretHash)) == 1
+}
+
+// AllowsGrant reports whether the client is registered for the grant type
+func AllowsGrant(client models.OAuthClient, grantType string) bool {
+	return contains(client.GrantTypes, grantType)
+}
+
+// AllowsRedirectURI reports whether the redirect URI is one the client registered, it has to match exactly
+func AllowsRedirectURI(client models.OAuthClient, redirectURI string) bool {
+	return contains(client.RedirectURIs, redirectURI)
+}
+
+// ParseScope checks the space separated scopes a client asked for against the ones it's registered for, returning
+// them normalized. Asking for nothing gets everything the client is registered for that we support.
+func ParseScope(client models.OAuthClient, requested string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		for _, scope := range client.Scopes {
+			if _, known := Scopes[scope]; known {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	granted := []string{}
+	for _, scope := range scopes {
+		// A client can be registered for a scope before we support it, it isn't handed out until we do
+		if _, known := Scopes[scope]; !known || !contains(client.Scopes, scope) {
+			return "", ErrInvalidScope
+		}
+		if !contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	sort.Strings(granted)
+	return strings.Join(granted, " "), nil
+}
+
+// VerifyPKCE reports whether the code verifier sent to the token endpoint matches the code challenge the
+// authorization request was made with
+func VerifyPKCE(verifier, challenge, method string) bool {
+	if method != PKCEMethodS256 || verifier == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package oauth
+
+import (
+	"testing"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// The example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name     string
+		verifier string
+		method   string
+		want     bool
+	}{
+		{name: "matching verifier", verifier: verifier, method: PKCEMethodS256, want: true},
+		{name: "wrong verifier", verifier: verifier + "x", method: PKCEMethodS256},
+		{name: "missing verifier", method: PKCEMethodS256},
+		{name: "plain method", verifier: challenge, method: "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, challenge, tt.method); got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	Scopes["email"] = "See your email address"
+	defer delete(Scopes, "email")
+
+	client := models.OAuthClient{Scopes: []string{"profile", "email", "unreleased"}}
+	tests := []struct {
+		name      string
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{name: "nothing requested", requested: "", want: "email profile"},
+		{name: "duplicates", requested: "profile  profile", want: "profile"},
+		{name: "not registered", requested: "profile admin", wantErr: ErrInvalidScope},
+		{name: "registered but unknown", requested: "unreleased", wantErr: ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScope(client, tt.requested)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("ParseScope() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckSecret(t *testing.T) {
+	confidential := models.OAuthClient{SecretHash: randtoken.Hash("secret")}
+	if !CheckSecret(confidential, "secret") || CheckSecret(confidential, "guess") {
+		t.Errorf("CheckSecret() didn't check the confidential client's secret")
+	}
+
+	if CheckSecret(models.OAuthClient{}, "") {
+		t.Errorf("CheckSecret() accepted a public client")
+	}
+}
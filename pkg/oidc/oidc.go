@@ -0,0 +1,73 @@
+// Package oidc is the OpenID Connect layer on top of our OAuth server, it builds the id_tokens and userinfo
+// responses that tell a client who the user is
+package oidc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+	"github.com/pascaldekloe/jwt"
+)
+
+const (
+	// ScopeOpenID is the scope that makes an OAuth request an OpenID Connect one
+	ScopeOpenID = "openid"
+	// IDTokenLifetime is how long a client has to check an id_token after it's issued
+	IDTokenLifetime = 15 * time.Minute
+)
+
+// AllScopes is what our own logins are treated as having, they aren't limited by a consent
+const AllScopes = "openid profile email"
+
+// ClaimsSupported are the claims UserClaims can return, for the discovery document
+var ClaimsSupported = []string{"sub", "name", "email", "email_verified"}
+
+// AuthorizationURL is where clients send users to log in and consent, it's our frontend's page for it rather than
+// an endpoint of ours since that needs the user to be logged in. Defaults to /oauth/authorize under Issuer.
+var AuthorizationURL string
+
+// IsConfigured reports whether Issuer is a URL clients can discover us at, which OpenID Connect requires
+func IsConfigured() bool {
+	return strings.HasPrefix(tokens.Issuer, "https://") || strings.HasPrefix(tokens.Issuer, "http://")
+}
+
+// UserClaims returns the standard claims about the user the space separated scopes allow
+func UserClaims(user models.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user.UUID}
+
+	if tokens.HasScope(scope, "profile") {
+		claims["name"] = user.Name
+	}
+	if tokens.HasScope(scope, "email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.VerifiedAt != nil
+	}
+
+	return claims
+}
+
+// IDToken signs an id_token telling clientID who the user is, with the claims scope allows. nonce is echoed back
+// from the authorization request so the client can tie the token to it.
+func IDToken(user models.User, clientID, scope, nonce string, currentTime time.Time) (string, error) {
+	var claims jwt.Claims
+	claims.Issuer = tokens.Issuer
+	claims.Subject = user.UUID
+	claims.Audiences = []string{clientID}
+	claims.Issued = jwt.NewNumericTime(currentTime)
+	claims.Expires = jwt.NewNumericTime(currentTime.Add(IDTokenLifetime))
+	claims.Set = UserClaims(user, scope)
+
+	if nonce != "" {
+		claims.Set["nonce"] = nonce
+	}
+
+	token, err := signing.Keys.Sign(&claims)
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
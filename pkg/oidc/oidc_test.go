@@ -0,0 +1,87 @@
+package oidc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+	"github.com/kylegrantlucas/platform-exercise/pkg/tokens"
+)
+
+func TestUserClaims(t *testing.T) {
+	verifiedAt := time.Now()
+	user := models.User{UUID: "abc", Email: "test@test.com", Name: "Testy McTesterson", VerifiedAt: &verifiedAt}
+
+	tests := []struct {
+		name  string
+		scope string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "openid only",
+			scope: "openid",
+			want:  map[string]interface{}{"sub": "abc"},
+		},
+		{
+			name:  "profile",
+			scope: "openid profile",
+			want:  map[string]interface{}{"sub": "abc", "name": "Testy McTesterson"},
+		},
+		{
+			name:  "everything",
+			scope: AllScopes,
+			want:  map[string]interface{}{"sub": "abc", "name": "Testy McTesterson", "email": "test@test.com", "email_verified": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UserClaims(user, tt.scope); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UserClaims() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	signing.Keys = signing.NewRegister()
+	signing.Keys.AddSecret([]byte("fenderdigital"))
+
+	tokens.Issuer = "https://auth.fender.com"
+	defer func() { tokens.Issuer = "fender" }()
+
+	currentTime := time.Now()
+	token, err := IDToken(models.User{UUID: "abc", Email: "test@test.com"}, "mobile", "openid email", "n-0S6_WzA2Mj", currentTime)
+	if err != nil {
+		t.Fatalf("IDToken() error = %v", err)
+	}
+
+	claims, err := signing.Keys.Check([]byte(token))
+	if err != nil {
+		t.Fatalf("IDToken() made a token that doesn't check: %v", err)
+	}
+
+	nonce, _ := claims.String("nonce")
+	email, _ := claims.String("email")
+	verified, _ := claims.Set["email_verified"].(bool)
+	if claims.Issuer != "https://auth.fender.com" || claims.Subject != "abc" || !reflect.DeepEqual(claims.Audiences, []string{"mobile"}) || nonce != "n-0S6_WzA2Mj" || email != "test@test.com" || verified {
+		t.Errorf("IDToken() claims = %+v", claims.Set)
+	}
+
+	if !claims.Valid(currentTime) || claims.Valid(currentTime.Add(IDTokenLifetime+time.Second)) {
+		t.Errorf("IDToken() is valid for the wrong time")
+	}
+}
+
+func TestIsConfigured(t *testing.T) {
+	if IsConfigured() {
+		t.Errorf("IsConfigured() = true with issuer %q", tokens.Issuer)
+	}
+
+	tokens.Issuer = "https://auth.fender.com"
+	defer func() { tokens.Issuer = "fender" }()
+	if !IsConfigured() {
+		t.Errorf("IsConfigured() = false with issuer %q", tokens.Issuer)
+	}
+}
@@ -0,0 +1,111 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id defaults, sized to take a few tens of milliseconds per login on a typical server
+const (
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrInvalidArgon2Hash is returned for hashes that start like Argon2id hashes but can't be parsed
+var ErrInvalidArgon2Hash = errors.New("password: invalid argon2id hash")
+
+// Argon2id hashes passwords with Argon2id, its hashes are PHC strings ("$argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>")
+type Argon2id struct {
+	// Time is the number of passes over memory
+	Time uint32
+
+	// Memory is the amount of memory used in KiB
+	Memory uint32
+
+	// Threads is the degree of parallelism
+	Threads uint8
+}
+
+// NewArgon2id returns an Argon2id hasher with the default parameters
+func NewArgon2id() *Argon2id {
+	return &Argon2id{Time: DefaultArgon2Time, Memory: DefaultArgon2Memory, Threads: DefaultArgon2Threads}
+}
+
+// Hash returns the Argon2id hash of the password with a random salt
+func (a *Argon2id) Hash(plaintextPassword string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(plaintextPassword), salt, a.Time, a.Memory, a.Threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether the password matches an Argon2id hash, using the parameters stored in the hash
+func (a *Argon2id) Verify(plaintextPassword, encodedHash string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(plaintextPassword), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash reports whether the hash isn't Argon2id, or was made with weaker parameters
+func (a *Argon2id) NeedsRehash(encodedHash string) bool {
+	params, _, _, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return true
+	}
+
+	return params.Time < a.Time || params.Memory < a.Memory || params.Threads < a.Threads
+}
+
+func decodeArgon2id(encodedHash string) (*Argon2id, []byte, []byte, error) {
+	// The leading "$" leaves an empty first part
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrInvalidArgon2Hash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrInvalidArgon2Hash
+	}
+
+	params := &Argon2id{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil || params.Time == 0 || params.Threads == 0 {
+		return nil, nil, nil, ErrInvalidArgon2Hash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrInvalidArgon2Hash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrInvalidArgon2Hash
+	}
+
+	return params, salt, key, nil
+}
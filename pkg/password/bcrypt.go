@@ -0,0 +1,46 @@
+package password
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is the bcrypt work factor used unless configured otherwise
+const DefaultBcryptCost = 12
+
+// Bcrypt hashes passwords with bcrypt, its hashes are in the usual "$2a$<cost>$..." modular crypt format
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of the password
+func (b *Bcrypt) Hash(plaintextPassword string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// Verify reports whether the password matches a bcrypt hash
+func (b *Bcrypt) Verify(plaintextPassword, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(plaintextPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// NeedsRehash reports whether the hash isn't bcrypt, or was made with a lower cost
+func (b *Bcrypt) NeedsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return true
+	}
+
+	return cost < b.Cost
+}
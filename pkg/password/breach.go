@@ -0,0 +1,75 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"strings"
+)
+
+// BreachChecker looks up breached passwords by k-anonymity: it's only ever given the first 5 hex characters of
+// a password's SHA-1 hash, and hands back every breached hash starting with them for the caller to match
+type BreachChecker interface {
+	// Range returns the remaining 35 uppercase hex characters of every breached hash with the prefix,
+	// along with how many times each was seen
+	Range(prefix string) (map[string]int, error)
+}
+
+// Breaches is the checker new passwords are checked against
+var Breaches BreachChecker = NewBreachCache(NewHIBP(DefaultHIBPURL), DefaultBreachCacheSize, DefaultBreachCacheTTL)
+
+// FailOpen lets passwords through when Breaches can't be reached, instead of failing the request
+var FailOpen bool
+
+// Compromised reports whether the password shows up in Breaches. When the checker fails the error is returned,
+// unless FailOpen is set in which case it's logged and the password is treated as safe.
+func Compromised(plaintextPassword string) (bool, error) {
+	sum := sha1.Sum([]byte(plaintextPassword))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := Breaches.Range(hash[:5])
+	if err != nil {
+		if FailOpen {
+			log.Printf("couldn't check for breached passwords, letting it through: %v", err)
+			return false, nil
+		}
+		return false, err
+	}
+
+	return suffixes[hash[5:]] > 0, nil
+}
+
+// NoopChecker never finds a breach, for when breach checking is turned off
+type NoopChecker struct{}
+
+// Range returns nothing
+func (NoopChecker) Range(prefix string) (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
+// parseRange reads "SUFFIX:COUNT" lines, the format of both the HIBP range API and the downloadable dump.
+// Lines with a count of 0 are padding and skipped.
+func parseRange(lines []string, prefixLength int) map[string]int {
+	suffixes := map[string]int{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		i := strings.IndexByte(line, ':')
+		if i < prefixLength {
+			continue
+		}
+
+		count := 0
+		for _, c := range line[i+1:] {
+			if c < '0' || c > '9' {
+				break
+			}
+			count = count*10 + int(c-'0')
+		}
+
+		if count > 0 {
+			suffixes[strings.ToUpper(line[prefixLength:i])] = count
+		}
+	}
+
+	return suffixes
+}
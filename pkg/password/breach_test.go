@@ -0,0 +1,198 @@
+package password
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+const passwordSuffix = "1E4C9B93F3F0682250B6CF8331B7EE68FD8"
+
+// fakeChecker serves fixed ranges and counts how often it's asked
+type fakeChecker struct {
+	ranges map[string]map[string]int
+	err    error
+	calls  int
+}
+
+func (f *fakeChecker) Range(prefix string) (map[string]int, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.ranges[prefix], nil
+}
+
+func TestCompromised(t *testing.T) {
+	breaches, failOpen := Breaches, FailOpen
+	defer func() { Breaches, FailOpen = breaches, failOpen }()
+
+	breached := &fakeChecker{ranges: map[string]map[string]int{"5BAA6": {passwordSuffix: 3645804}}}
+	down := &fakeChecker{err: errors.New("down")}
+
+	tests := []struct {
+		name     string
+		checker  BreachChecker
+		failOpen bool
+		password string
+		want     bool
+		wantErr  bool
+	}{
+		{"breached password", breached, false, "password", true, false},
+		{"safe password", breached, false, "9X&5eQ#TI9IzBM", false, false},
+		{"checker down, fail closed", down, false, "password", false, true},
+		{"checker down, fail open", down, true, "password", false, false},
+		{"no-op checker", NoopChecker{}, false, "password", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Breaches, FailOpen = tt.checker, tt.failOpen
+
+			got, err := Compromised(tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compromised() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Compromised() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHIBP_Range(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/range/5BAA6" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Add-Padding") != "true" {
+			t.Errorf("HIBP.Range() didn't ask for padding")
+		}
+		w.Write([]byte("003D68EB55068C33ACE09247EE4C639306B:3\r\n" + passwordSuffix + ":3645804\r\n0018A45C4D1DEF81644B54AB7F969B88D65:0"))
+	}))
+	defer server.Close()
+
+	h := NewHIBP(server.URL + "/")
+
+	got, err := h.Range("5BAA6")
+	if err != nil {
+		t.Fatalf("HIBP.Range() error = %v", err)
+	}
+	want := map[string]int{"003D68EB55068C33ACE09247EE4C639306B": 3, passwordSuffix: 3645804}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("HIBP.Range() = %v, want %v", got, want)
+	}
+
+	if _, err := h.Range("XXXXX"); err == nil {
+		t.Errorf("HIBP.Range() with a failing API returned no error")
+	}
+}
+
+func TestRangeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rangefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dump := "00000AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA:1\r\n" +
+		"00000BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB:2\r\n" +
+		"5BAA6" + passwordSuffix + ":3645804\r\n" +
+		"FFFFFCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC:4"
+	dumpPath, indexPath := filepath.Join(dir, "dump.txt"), filepath.Join(dir, "dump.idx")
+	ioutil.WriteFile(dumpPath, []byte(dump), 0600)
+
+	index := &bytes.Buffer{}
+	err = BuildRangeIndex(bytes.NewReader([]byte(dump)), index)
+	if err != nil {
+		t.Fatalf("BuildRangeIndex() error = %v", err)
+	}
+	ioutil.WriteFile(indexPath, index.Bytes(), 0600)
+
+	f, err := OpenRangeFile(dumpPath, indexPath)
+	if err != nil {
+		t.Fatalf("OpenRangeFile() error = %v", err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		prefix  string
+		want    map[string]int
+		wantErr bool
+	}{
+		{"00000", map[string]int{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA": 1, "BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB": 2}, false},
+		{"00001", map[string]int{}, false},
+		{"5baa6", map[string]int{passwordSuffix: 3645804}, false},
+		{"FFFFF", map[string]int{"CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC": 4}, false},
+		{"ZZZZZ", nil, true},
+		{"5BAA", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := f.Range(tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RangeFile.Range() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RangeFile.Range() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRangeIndex_Unordered(t *testing.T) {
+	dump := "5BAA6" + passwordSuffix + ":1\r\n00000AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA:1\r\n"
+	if err := BuildRangeIndex(bytes.NewReader([]byte(dump)), ioutil.Discard); err == nil {
+		t.Errorf("BuildRangeIndex() accepted a dump that isn't ordered by hash")
+	}
+}
+
+func TestBreachCache(t *testing.T) {
+	checker := &fakeChecker{ranges: map[string]map[string]int{"5BAA6": {passwordSuffix: 1}}}
+	cache := NewBreachCache(checker, 2, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Range("5BAA6")
+	cache.Range("5BAA6")
+	if checker.calls != 1 {
+		t.Errorf("BreachCache.Range() asked the checker %v times for a cached prefix, want 1", checker.calls)
+	}
+
+	// Filling the cache evicts the least recently used prefix
+	cache.Range("00000")
+	cache.Range("5BAA6")
+	cache.Range("11111")
+	if cache.Len() != 2 {
+		t.Errorf("BreachCache.Len() = %v, want 2", cache.Len())
+	}
+	cache.Range("5BAA6")
+	if checker.calls != 3 {
+		t.Errorf("BreachCache.Range() asked the checker %v times, want 3", checker.calls)
+	}
+
+	// Expired ranges are fetched again
+	now = now.Add(2 * time.Minute)
+	cache.Range("5BAA6")
+	if checker.calls != 4 {
+		t.Errorf("BreachCache.Range() asked the checker %v times after expiry, want 4", checker.calls)
+	}
+
+	// Failures aren't cached
+	checker.err = errors.New("down")
+	if _, err := cache.Range("22222"); err == nil {
+		t.Errorf("BreachCache.Range() swallowed the checker's error")
+	}
+	checker.err = nil
+	if _, err := cache.Range("22222"); err != nil {
+		t.Errorf("BreachCache.Range() cached a failure: %v", err)
+	}
+}
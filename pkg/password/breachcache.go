@@ -0,0 +1,92 @@
+package password
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreachCacheSize is how many prefixes are cached by default, each range is around 500 hashes
+	DefaultBreachCacheSize = 1000
+	// DefaultBreachCacheTTL is how long a range is cached for by default, the corpus only changes every few months
+	DefaultBreachCacheTTL = 24 * time.Hour
+)
+
+// BreachCache is a BreachChecker that remembers the ranges another checker returns, keyed by prefix
+type BreachCache struct {
+	checker BreachChecker
+	size    int
+	ttl     time.Duration
+
+	mu       sync.Mutex
+	order    *list.List
+	prefixes map[string]*list.Element
+
+	// now is swapped out in tests
+	now func() time.Time
+}
+
+type breachCacheEntry struct {
+	prefix    string
+	suffixes  map[string]int
+	expiresAt time.Time
+}
+
+// NewBreachCache wraps a checker, keeping up to size ranges for ttl each
+func NewBreachCache(checker BreachChecker, size int, ttl time.Duration) *BreachCache {
+	return &BreachCache{
+		checker:  checker,
+		size:     size,
+		ttl:      ttl,
+		order:    list.New(),
+		prefixes: map[string]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+// Range returns the cached range for the prefix, asking the wrapped checker on a miss. Failures aren't cached.
+func (c *BreachCache) Range(prefix string) (map[string]int, error) {
+	c.mu.Lock()
+	if element, ok := c.prefixes[prefix]; ok {
+		entry := element.Value.(*breachCacheEntry)
+		if c.now().Before(entry.expiresAt) {
+			c.order.MoveToFront(element)
+			c.mu.Unlock()
+			return entry.suffixes, nil
+		}
+
+		c.order.Remove(element)
+		delete(c.prefixes, prefix)
+	}
+	c.mu.Unlock()
+
+	suffixes, err := c.checker.Range(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.prefixes[prefix]; ok {
+		c.order.Remove(element)
+	}
+	c.prefixes[prefix] = c.order.PushFront(&breachCacheEntry{prefix: prefix, suffixes: suffixes, expiresAt: c.now().Add(c.ttl)})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.prefixes, oldest.Value.(*breachCacheEntry).prefix)
+	}
+
+	return suffixes, nil
+}
+
+// Len returns how many ranges are cached
+func (c *BreachCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,39 @@
+package password
+
+import (
+	"sync"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+)
+
+// dummy caches the hash DummyHash returns, it's remade whenever Default changes so its cost always matches
+var dummy struct {
+	sync.Mutex
+	hasher Hasher
+	hash   string
+}
+
+// DummyHash returns a hash made by the Default hasher that no password matches. Comparing against it when
+// there's no real hash to compare with takes as long as rejecting a wrong password, so callers can't be timed
+// to find out whether an account exists.
+func DummyHash() (string, error) {
+	dummy.Lock()
+	defer dummy.Unlock()
+
+	if dummy.hasher == Default && dummy.hash != "" {
+		return dummy.hash, nil
+	}
+
+	secret, err := randtoken.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := Default.Hash(secret)
+	if err != nil {
+		return "", err
+	}
+
+	dummy.hasher, dummy.hash = Default, hash
+	return hash, nil
+}
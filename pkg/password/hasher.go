@@ -0,0 +1,54 @@
+package password
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnknownHash is returned when verifying against a hash none of our hashers produced
+var ErrUnknownHash = errors.New("password: unrecognized hash format")
+
+// Hasher hashes passwords into self-describing strings, the algorithm and its parameters are encoded in
+// the hash itself so old hashes keep verifying after we change how new ones are made
+type Hasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(plaintextPassword string) (string, error)
+
+	// Verify reports whether the password matches an encoded hash made by this kind of hasher
+	Verify(plaintextPassword, encodedHash string) (bool, error)
+
+	// NeedsRehash reports whether an encoded hash was made with a different algorithm or weaker
+	// parameters than this hasher uses, meaning it should be replaced next time we see the password
+	NeedsRehash(encodedHash string) bool
+}
+
+// Default is the hasher new password hashes are made with
+var Default Hasher = &Bcrypt{Cost: DefaultBcryptCost}
+
+// hasherFor picks the hasher that understands an encoded hash from its prefix
+func hasherFor(encodedHash string) (Hasher, error) {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		return &Argon2id{}, nil
+	case strings.HasPrefix(encodedHash, "$2a$"), strings.HasPrefix(encodedHash, "$2b$"), strings.HasPrefix(encodedHash, "$2y$"):
+		return &Bcrypt{}, nil
+	default:
+		return nil, ErrUnknownHash
+	}
+}
+
+// Verify reports whether the password matches the encoded hash, whichever algorithm made it
+func Verify(plaintextPassword, encodedHash string) bool {
+	hasher, err := hasherFor(encodedHash)
+	if err != nil {
+		return false
+	}
+
+	ok, err := hasher.Verify(plaintextPassword, encodedHash)
+	return err == nil && ok
+}
+
+// NeedsRehash reports whether the encoded hash should be replaced with one made by the Default hasher
+func NeedsRehash(encodedHash string) bool {
+	return Default.NeedsRehash(encodedHash)
+}
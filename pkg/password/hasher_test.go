@@ -0,0 +1,134 @@
+package password
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashers(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher Hasher
+	}{
+		{
+			name:   "bcrypt",
+			hasher: &Bcrypt{Cost: bcrypt.MinCost},
+		},
+		{
+			name:   "argon2id",
+			hasher: &Argon2id{Time: 1, Memory: 1024, Threads: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash("testpassword")
+			if err != nil {
+				t.Fatalf("Hash() error = %v", err)
+			}
+
+			if ok, err := tt.hasher.Verify("testpassword", hash); !ok || err != nil {
+				t.Errorf("Verify() with the right password = %v, %v, want true, nil", ok, err)
+			}
+
+			if ok, err := tt.hasher.Verify("badpass", hash); ok || err != nil {
+				t.Errorf("Verify() with the wrong password = %v, %v, want false, nil", ok, err)
+			}
+
+			if !Verify("testpassword", hash) {
+				t.Errorf("Verify() didn't recognize a %v hash", tt.name)
+			}
+
+			if tt.hasher.NeedsRehash(hash) {
+				t.Errorf("NeedsRehash() = true for a hash the hasher just made")
+			}
+		})
+	}
+}
+
+func TestArgon2id_Hash(t *testing.T) {
+	a := &Argon2id{Time: 2, Memory: 2048, Threads: 1}
+
+	hash, err := a.Hash("testpassword")
+	if err != nil {
+		t.Fatalf("Argon2id.Hash() error = %v", err)
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatalf("decodeArgon2id() error = %v", err)
+	}
+
+	if *params != *a || len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Errorf("Argon2id.Hash() = %v, want the hasher's parameters with a %v byte salt and %v byte key", hash, argon2SaltLength, argon2KeyLength)
+	}
+
+	other, _ := a.Hash("testpassword")
+	if other == hash {
+		t.Errorf("Argon2id.Hash() gave the same hash twice, salts aren't random")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weakBcrypt, _ := (&Bcrypt{Cost: bcrypt.MinCost}).Hash("testpassword")
+	strongBcrypt, _ := (&Bcrypt{Cost: bcrypt.MinCost + 1}).Hash("testpassword")
+	weakArgon2, _ := (&Argon2id{Time: 1, Memory: 1024, Threads: 1}).Hash("testpassword")
+	strongArgon2, _ := (&Argon2id{Time: 2, Memory: 1024, Threads: 1}).Hash("testpassword")
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   string
+		want   bool
+	}{
+		{"bcrypt with a lower cost", &Bcrypt{Cost: bcrypt.MinCost + 1}, weakBcrypt, true},
+		{"bcrypt with the same cost", &Bcrypt{Cost: bcrypt.MinCost + 1}, strongBcrypt, false},
+		{"bcrypt with a higher cost", &Bcrypt{Cost: bcrypt.MinCost}, strongBcrypt, false},
+		{"argon2id over bcrypt", &Argon2id{Time: 1, Memory: 1024, Threads: 1}, strongBcrypt, true},
+		{"bcrypt over argon2id", &Bcrypt{Cost: bcrypt.MinCost}, weakArgon2, true},
+		{"argon2id with fewer passes", &Argon2id{Time: 2, Memory: 1024, Threads: 1}, weakArgon2, true},
+		{"argon2id with the same parameters", &Argon2id{Time: 2, Memory: 1024, Threads: 1}, strongArgon2, false},
+		{"argon2id with less memory", &Argon2id{Time: 2, Memory: 2048, Threads: 1}, strongArgon2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify_UnknownHashes(t *testing.T) {
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$m=1024,t=1,p=1$bad", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		if Verify("testpassword", hash) {
+			t.Errorf("Verify() accepted %q", hash)
+		}
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	defaultHasher := Default
+	Default = &Bcrypt{Cost: bcrypt.MinCost}
+	defer func() { Default = defaultHasher }()
+
+	hash, err := DummyHash()
+	if err != nil {
+		t.Fatalf("DummyHash() error = %v", err)
+	}
+
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != bcrypt.MinCost {
+		t.Errorf("DummyHash() cost = %v, want %v", cost, bcrypt.MinCost)
+	}
+
+	if again, _ := DummyHash(); again != hash {
+		t.Errorf("DummyHash() wasn't cached")
+	}
+
+	// Changing the hasher changes the dummy so it keeps costing the same as a real hash
+	Default = &Bcrypt{Cost: bcrypt.MinCost + 1}
+	hash, _ = DummyHash()
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != bcrypt.MinCost+1 {
+		t.Errorf("DummyHash() after changing Default cost = %v, want %v", cost, bcrypt.MinCost+1)
+	}
+}
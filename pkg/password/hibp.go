@@ -0,0 +1,53 @@
+package password
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHIBPURL is the base URL of the HaveIBeenPwned Pwned Passwords API
+const DefaultHIBPURL = "https://api.pwnedpasswords.com"
+
+// HIBP checks passwords against the HaveIBeenPwned range API (https://haveibeenpwned.com/API/v2#SearchingPwnedPasswordsByRange)
+type HIBP struct {
+	// BaseURL is where the API lives, it can point at a mirror
+	BaseURL string
+
+	Client *http.Client
+}
+
+// NewHIBP returns a checker for the range API at baseURL
+func NewHIBP(baseURL string) *HIBP {
+	return &HIBP{BaseURL: strings.TrimSuffix(baseURL, "/"), Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Range fetches the breached hashes with the prefix from the API
+func (h *HIBP) Range(prefix string) (map[string]int, error) {
+	req, err := http.NewRequest("GET", h.BaseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Padding makes every response about the same size, so the prefix can't be guessed from it on the wire
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("password: breach range API returned %v", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRange(strings.Split(string(body), "\n"), 0), nil
+}
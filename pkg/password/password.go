@@ -1,23 +1,11 @@
 package password
 
-import (
-	"golang.org/x/crypto/bcrypt"
-)
-
+// HashAndSalt hashes the password with the Default hasher
 func HashAndSalt(plaintextPassword string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), bcrypt.MinCost)
-	if err != nil {
-		return "", err
-	}
-
-	return string(hash), nil
+	return Default.Hash(plaintextPassword)
 }
 
+// ComparePlaintextWithEncypted reports whether the password matches the stored hash, see Verify
 func ComparePlaintextWithEncypted(plaintextPassword string, encryptedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(encryptedPassword), []byte(plaintextPassword))
-	if err != nil {
-		return false
-	}
-
-	return true
+	return Verify(plaintextPassword, encryptedPassword)
 }
@@ -0,0 +1,229 @@
+package password
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation is one way a password falls short of a policy
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Candidate is a password being set, along with what we know about the user setting it
+type Candidate struct {
+	Password string
+	Email    string
+	Name     string
+
+	// History holds the hashes of the user's previous passwords, newest first
+	History []string
+}
+
+// Rule is a single requirement of a policy
+type Rule interface {
+	// Check returns a violation if the candidate breaks the rule, errors are for when the rule couldn't be checked
+	Check(candidate Candidate) (*Violation, error)
+}
+
+// Policy is the set of rules new passwords have to follow
+type Policy struct {
+	Rules []Rule
+
+	// HistorySize is how many previous password hashes callers should load into candidates
+	HistorySize int
+}
+
+// PolicyConfig is the on-disk form of a policy
+type PolicyConfig struct {
+	// MinLength is the fewest characters a password can have
+	MinLength int `json:"min_length"`
+
+	// MinStrength is the lowest Strength score (0-4) a password can have
+	MinStrength int `json:"min_strength"`
+
+	// DisallowPersonalInfo rejects passwords containing the user's name or email
+	DisallowPersonalInfo bool `json:"disallow_personal_info"`
+
+	// HistorySize rejects passwords matching any of the user's last HistorySize passwords
+	HistorySize int `json:"history_size"`
+
+	// CheckBreaches rejects passwords found by Breaches
+	CheckBreaches bool `json:"check_breaches"`
+}
+
+// DefaultPolicyConfig is used when no policy file is configured
+var DefaultPolicyConfig = PolicyConfig{
+	MinLength:            8,
+	MinStrength:          2,
+	DisallowPersonalInfo: true,
+	HistorySize:          5,
+	CheckBreaches:        true,
+}
+
+// DefaultPolicy is the policy new passwords are checked against
+var DefaultPolicy = NewPolicy(DefaultPolicyConfig)
+
+// NewPolicy builds the rules a config asks for
+func NewPolicy(config PolicyConfig) *Policy {
+	policy := &Policy{HistorySize: config.HistorySize}
+
+	if config.MinLength > 0 {
+		policy.Rules = append(policy.Rules, MinLength(config.MinLength))
+	}
+	if config.MinStrength > 0 {
+		policy.Rules = append(policy.Rules, MinStrength(config.MinStrength))
+	}
+	if config.DisallowPersonalInfo {
+		policy.Rules = append(policy.Rules, NoPersonalInfo{})
+	}
+	if config.HistorySize > 0 {
+		policy.Rules = append(policy.Rules, NoReuse{})
+	}
+	if config.CheckBreaches {
+		policy.Rules = append(policy.Rules, NotBreached{})
+	}
+
+	return policy
+}
+
+// LoadPolicy reads a JSON PolicyConfig from a file, fields it leaves out keep their DefaultPolicyConfig value so a
+// rule is only ever turned off on purpose
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultPolicyConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("password: invalid policy in %v: %v", path, err)
+	}
+
+	return NewPolicy(config), nil
+}
+
+// MaxLength is the most bytes a password can have. Longer ones are turned away before any rule is run, since
+// scoring them gets expensive and nobody types one anyway.
+const MaxLength = 128
+
+// Check runs every rule, returning all the violations at once so users can fix them in one go
+func (p *Policy) Check(candidate Candidate) ([]Violation, error) {
+	if len(candidate.Password) > MaxLength {
+		return []Violation{{Rule: "max_length", Message: fmt.Sprintf("Password must be at most %v characters", MaxLength)}}, nil
+	}
+
+	violations := []Violation{}
+	for _, rule := range p.Rules {
+		violation, err := rule.Check(candidate)
+		if err != nil {
+			return nil, err
+		}
+
+		if violation != nil {
+			violations = append(violations, *violation)
+		}
+	}
+
+	return violations, nil
+}
+
+// MinLength requires passwords to have at least this many characters
+type MinLength int
+
+// Check counts characters rather than bytes
+func (m MinLength) Check(candidate Candidate) (*Violation, error) {
+	if utf8.RuneCountInString(candidate.Password) >= int(m) {
+		return nil, nil
+	}
+
+	return &Violation{Rule: "min_length", Message: fmt.Sprintf("Password must be at least %v characters", int(m))}, nil
+}
+
+// MinStrength requires passwords to have at least this Strength score
+type MinStrength int
+
+// Check scores the password, counting the user's own details as easy to guess
+func (m MinStrength) Check(candidate Candidate) (*Violation, error) {
+	if Strength(candidate.Password, personalInfo(candidate)...) >= int(m) {
+		return nil, nil
+	}
+
+	return &Violation{Rule: "min_strength", Message: "Password is too easy to guess, try a longer password or a few uncommon words"}, nil
+}
+
+// NoPersonalInfo rejects passwords containing the user's name, or their email address or its local part
+type NoPersonalInfo struct{}
+
+// Check looks for the user's details anywhere in the password, ignoring case
+func (NoPersonalInfo) Check(candidate Candidate) (*Violation, error) {
+	lower := strings.ToLower(candidate.Password)
+	for _, info := range personalInfo(candidate) {
+		if strings.Contains(lower, info) {
+			return &Violation{Rule: "personal_info", Message: "Password must not contain your name or email address"}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// NoReuse rejects passwords matching any hash in the candidate's history
+type NoReuse struct{}
+
+// Check verifies the password against each previous hash
+func (NoReuse) Check(candidate Candidate) (*Violation, error) {
+	for _, hash := range candidate.History {
+		if Verify(candidate.Password, hash) {
+			return &Violation{Rule: "history", Message: "Password must not be one you've used recently"}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// NotBreached rejects passwords found by Breaches, see Compromised
+type NotBreached struct{}
+
+// Check looks the password up in Breaches
+func (NotBreached) Check(candidate Candidate) (*Violation, error) {
+	pwned, err := Compromised(candidate.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	if pwned {
+		return &Violation{Rule: "breached", Message: "Password is in the HaveIBeenPwned database"}, nil
+	}
+
+	return nil, nil
+}
+
+// personalInfo returns the lowercased parts of the user's details worth looking for in a password, skipping
+// pieces too short to be meaningful
+func personalInfo(candidate Candidate) []string {
+	info := []string{}
+	add := func(s string) {
+		if utf8.RuneCountInString(s) >= 3 {
+			info = append(info, strings.ToLower(s))
+		}
+	}
+
+	if candidate.Email != "" {
+		add(candidate.Email)
+		if i := strings.LastIndex(candidate.Email, "@"); i > 0 {
+			add(candidate.Email[:i])
+		}
+	}
+
+	add(candidate.Name)
+	for _, part := range strings.Fields(candidate.Name) {
+		add(part)
+	}
+
+	return info
+}
@@ -0,0 +1,124 @@
+package password
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	breaches := Breaches
+	Breaches = &fakeChecker{ranges: map[string]map[string]int{"5BAA6": {passwordSuffix: 1}}}
+	defer func() { Breaches = breaches }()
+
+	previous, _ := (&Bcrypt{Cost: bcrypt.MinCost}).Hash("9X&5eQ#TI9IzBM")
+	policy := NewPolicy(PolicyConfig{MinLength: 10, MinStrength: 3, DisallowPersonalInfo: true, HistorySize: 1, CheckBreaches: true})
+
+	tests := []struct {
+		name      string
+		candidate Candidate
+		want      []string
+	}{
+		{
+			name:      "good password",
+			candidate: Candidate{Password: "7rL!xq2#Vb9@", Email: "kyle@test.com", Name: "Kyle Lucas"},
+			want:      []string{},
+		},
+		{
+			name:      "everything wrong at once",
+			candidate: Candidate{Password: "password", Email: "password@test.com"},
+			want:      []string{"min_length", "min_strength", "personal_info", "breached"},
+		},
+		{
+			name:      "contains name",
+			candidate: Candidate{Password: "7rL!xq2#Lucas", Name: "Kyle Lucas"},
+			want:      []string{"personal_info"},
+		},
+		{
+			name:      "contains email local part",
+			candidate: Candidate{Password: "7rL!KYLE#Vb9@", Email: "kyle@test.com"},
+			want:      []string{"personal_info"},
+		},
+		{
+			name:      "reused",
+			candidate: Candidate{Password: "9X&5eQ#TI9IzBM", History: []string{previous}},
+			want:      []string{"history"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := policy.Check(tt.candidate)
+			if err != nil {
+				t.Fatalf("Policy.Check() error = %v", err)
+			}
+
+			got := []string{}
+			for _, violation := range violations {
+				got = append(got, violation.Rule)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Policy.Check() violated %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Check_TooLong(t *testing.T) {
+	// Long passwords are turned away before anything expensive, like scoring them, is done
+	started := time.Now()
+	violations, err := DefaultPolicy.Check(Candidate{Password: strings.Repeat("qwerty1!", 4096), Email: "kyle@test.com"})
+	if err != nil || len(violations) != 1 || violations[0].Rule != "max_length" {
+		t.Errorf("Policy.Check() = %v, %v, want only max_length", violations, err)
+	}
+	if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+		t.Errorf("Policy.Check() took %v for a long password", elapsed)
+	}
+
+	// Scoring one just under the limit is still quick
+	started = time.Now()
+	Strength(strings.Repeat("qwerty1!", MaxLength/8), "kyle@test.com")
+	if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+		t.Errorf("Strength() took %v for a %v byte password", elapsed, MaxLength)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(path, []byte(`{"min_length": 12, "history_size": 3}`), 0600)
+
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	// Rules the file doesn't mention keep their defaults
+	want := &Policy{Rules: []Rule{MinLength(12), MinStrength(2), NoPersonalInfo{}, NoReuse{}, NotBreached{}}, HistorySize: 3}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("LoadPolicy() = %+v, want %+v", policy, want)
+	}
+
+	// Turning one off has to be done on purpose
+	ioutil.WriteFile(path, []byte(`{"min_strength": 0, "check_breaches": false}`), 0600)
+	policy, err = LoadPolicy(path)
+	want = &Policy{Rules: []Rule{MinLength(8), NoPersonalInfo{}, NoReuse{}}, HistorySize: 5}
+	if err != nil || !reflect.DeepEqual(policy, want) {
+		t.Errorf("LoadPolicy() = %+v, %v, want %+v", policy, err, want)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"min_length": "twelve"}`), 0600)
+	if _, err := LoadPolicy(path); err == nil {
+		t.Errorf("LoadPolicy() accepted an invalid policy")
+	}
+}
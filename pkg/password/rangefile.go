@@ -0,0 +1,131 @@
+package password
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rangeCount is how many 5 hex character prefixes there are
+const rangeCount = 1 << 20
+
+// ErrInvalidPrefix is returned when asked for a range that isn't 5 hex characters
+var ErrInvalidPrefix = errors.New("password: prefix must be 5 hex characters")
+
+// RangeFile checks passwords against a local copy of the Pwned Passwords dump ("ordered by hash" SHA-1 edition),
+// so breach checks don't depend on a third party being up. The dump is tens of gigabytes, so rather than scanning
+// it we look ranges up in an index of where each prefix starts, built once with BuildRangeIndex.
+type RangeFile struct {
+	dump  *os.File
+	index *os.File
+}
+
+// OpenRangeFile opens a dump along with the index BuildRangeIndex made for it
+func OpenRangeFile(dumpPath, indexPath string) (*RangeFile, error) {
+	dump, err := os.Open(dumpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := os.Open(indexPath)
+	if err != nil {
+		dump.Close()
+		return nil, err
+	}
+
+	info, err := index.Stat()
+	if err != nil || info.Size() != (rangeCount+1)*8 {
+		dump.Close()
+		index.Close()
+		return nil, fmt.Errorf("password: %v isn't a range index", indexPath)
+	}
+
+	return &RangeFile{dump: dump, index: index}, nil
+}
+
+// Range reads the lines for the prefix out of the dump
+func (f *RangeFile) Range(prefix string) (map[string]int, error) {
+	if len(prefix) != 5 {
+		return nil, ErrInvalidPrefix
+	}
+	n, err := strconv.ParseUint(prefix, 16, 32)
+	if err != nil {
+		return nil, ErrInvalidPrefix
+	}
+
+	// The range runs from where this prefix starts to where the next one does
+	offsets := make([]byte, 16)
+	_, err = f.index.ReadAt(offsets, int64(n*8))
+	if err != nil {
+		return nil, err
+	}
+	start, end := binary.BigEndian.Uint64(offsets[:8]), binary.BigEndian.Uint64(offsets[8:])
+
+	section := make([]byte, end-start)
+	_, err = f.dump.ReadAt(section, int64(start))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRange(strings.Split(string(section), "\n"), 5), nil
+}
+
+// Close closes the dump and its index
+func (f *RangeFile) Close() error {
+	err := f.dump.Close()
+	if indexErr := f.index.Close(); err == nil {
+		err = indexErr
+	}
+
+	return err
+}
+
+// BuildRangeIndex reads a dump ordered by hash and writes out the byte offset each prefix starts at, followed by
+// the size of the dump, as big endian uint64s. Prefixes no hash starts with get the offset of the next one that
+// does, giving them an empty range.
+func BuildRangeIndex(dump io.Reader, index io.Writer) error {
+	reader := bufio.NewReaderSize(dump, 1<<20)
+	writer := bufio.NewWriter(index)
+	offset := make([]byte, 8)
+
+	// next is the first prefix we haven't written an offset for yet
+	var position, next uint64
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) >= 5 {
+			prefix, parseErr := strconv.ParseUint(line[:5], 16, 32)
+			if parseErr != nil {
+				return fmt.Errorf("password: invalid dump line at byte %v", position)
+			}
+			if prefix+1 < next {
+				return fmt.Errorf("password: dump isn't ordered by hash at byte %v", position)
+			}
+
+			for ; next <= prefix; next++ {
+				binary.BigEndian.PutUint64(offset, position)
+				writer.Write(offset)
+			}
+		}
+		position += uint64(len(line))
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Everything left ends at the end of the dump, and the final offset closes the last range
+	for ; next <= rangeCount; next++ {
+		binary.BigEndian.PutUint64(offset, position)
+		writer.Write(offset)
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,247 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Strength scores how hard a password is to guess from 0 (trivial) to 4 (very hard), the same scale as zxcvbn.
+// Like zxcvbn it estimates how many guesses an attacker who knows common passwords and patterns would need,
+// by finding the cheapest way to build the password out of dictionary words, repeats, sequences and keyboard
+// runs, paying brute force prices for anything left over. userInputs (the user's name, email, ...) count as
+// the most common words there are.
+func Strength(plaintextPassword string, userInputs ...string) int {
+	guesses := estimateGuesses([]rune(plaintextPassword), userInputs)
+
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+const (
+	// bruteForceCardinality is the guesses per character nothing else explains, deliberately low as zxcvbn's is
+	bruteForceCardinality = 10
+	// minMatchGuesses keeps a matched pattern from ever being cheaper than a couple of brute forced characters
+	minMatchGuesses = 50
+)
+
+// match is a span of the password explained by a pattern, and what guessing it would cost
+type match struct {
+	start, end int
+	guesses    float64
+}
+
+func estimateGuesses(password []rune, userInputs []string) float64 {
+	matches := findMatches(password, userInputs)
+
+	// best[i] is the fewest guesses needed for the first i characters
+	best := make([]float64, len(password)+1)
+	best[0] = 1
+	for i := 1; i <= len(password); i++ {
+		best[i] = best[i-1] * bruteForceCardinality
+		for _, m := range matches {
+			if m.end == i {
+				best[i] = math.Min(best[i], best[m.start]*math.Max(m.guesses, minMatchGuesses))
+			}
+		}
+	}
+
+	return best[len(password)]
+}
+
+func findMatches(password []rune, userInputs []string) []match {
+	matches := []match{}
+	matches = append(matches, dictionaryMatches(password, userInputs)...)
+	matches = append(matches, repeatMatches(password)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, keyboardMatches(password)...)
+	return matches
+}
+
+// dictionaryMatches finds common passwords and words, including ones with l33t substitutions or capitals
+func dictionaryMatches(password []rune, userInputs []string) []match {
+	ranks := map[string]int{}
+	longest := 0
+	for _, input := range userInputs {
+		for _, word := range append(strings.FieldsFunc(strings.ToLower(input), isSeparator), strings.ToLower(input)) {
+			ranks[word] = 1
+		}
+	}
+	for i, word := range commonPasswords {
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = i + 1
+		}
+	}
+
+	for word := range ranks {
+		if length := utf8.RuneCountInString(word); length > longest {
+			longest = length
+		}
+	}
+
+	lower := []rune(strings.ToLower(string(password)))
+	unleeted := make([]rune, len(lower))
+	for i, r := range lower {
+		unleeted[i] = r
+		if sub, ok := leetSubstitutions[r]; ok {
+			unleeted[i] = sub
+		}
+	}
+
+	matches := []match{}
+	for start := 0; start < len(password); start++ {
+		// Nothing longer than the longest word can match
+		for end := start + 3; end <= len(password) && end-start <= longest; end++ {
+			rank, ok := ranks[string(unleeted[start:end])]
+			if !ok {
+				continue
+			}
+
+			guesses := float64(rank)
+			if string(lower[start:end]) != string(unleeted[start:end]) {
+				guesses *= 2
+			}
+			if string(lower[start:end]) != string(password[start:end]) {
+				guesses *= 2
+			}
+			matches = append(matches, match{start: start, end: end, guesses: guesses})
+		}
+	}
+
+	return matches
+}
+
+// repeatMatches finds runs of the same character, like "aaaa"
+func repeatMatches(password []rune) []match {
+	matches := []match{}
+	for start := 0; start < len(password); {
+		end := start + 1
+		for end < len(password) && password[end] == password[start] {
+			end++
+		}
+
+		if end-start >= 3 {
+			matches = append(matches, match{start: start, end: end, guesses: cardinality(password[start]) * float64(end-start)})
+		}
+		start = end
+	}
+
+	return matches
+}
+
+// sequenceMatches finds runs of consecutive characters in either direction, like "abcd" or "9876"
+func sequenceMatches(password []rune) []match {
+	matches := []match{}
+	for start := 0; start < len(password)-1; {
+		delta := password[start+1] - password[start]
+		end := start + 1
+		if delta == 1 || delta == -1 {
+			for end < len(password) && password[end]-password[end-1] == delta {
+				end++
+			}
+		}
+
+		if end-start >= 3 {
+			// Sequences starting somewhere obvious are tried first
+			guesses := cardinality(password[start])
+			if strings.ContainsRune("aAzZ019", password[start]) {
+				guesses = 4
+			}
+			matches = append(matches, match{start: start, end: end, guesses: guesses * float64(end-start)})
+			start = end - 1
+			continue
+		}
+		start++
+	}
+
+	return matches
+}
+
+// keyboardMatches finds runs along a row of a QWERTY keyboard, like "qwerty" or "lkjh"
+func keyboardMatches(password []rune) []match {
+	lower := strings.ToLower(string(password))
+	runes := []rune(lower)
+
+	longest := 0
+	for _, row := range keyboardRows {
+		if length := utf8.RuneCountInString(row); length > longest {
+			longest = length
+		}
+	}
+
+	matches := []match{}
+	for start := 0; start < len(runes); start++ {
+		for end := start + 4; end <= len(runes) && end-start <= longest; end++ {
+			run := string(runes[start:end])
+			for _, row := range keyboardRows {
+				if strings.Contains(row, run) || strings.Contains(reverse(row), run) {
+					matches = append(matches, match{start: start, end: end, guesses: float64(len(keyboardRows)*len(row)) * float64(end-start)})
+					break
+				}
+			}
+		}
+	}
+
+	return matches
+}
+
+// cardinality is how many characters an attacker would try in place of r
+func cardinality(r rune) float64 {
+	switch {
+	case unicode.IsDigit(r):
+		return 10
+	case unicode.IsLower(r), unicode.IsUpper(r):
+		return 26
+	default:
+		return 33
+	}
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// commonPasswords are the most used passwords and password words, most common first
+var commonPasswords = []string{
+	"password", "123456", "12345678", "qwerty", "123456789", "12345", "1234", "111111", "1234567", "dragon",
+	"123123", "baseball", "abc123", "football", "monkey", "letmein", "shadow", "master", "696969", "mustang",
+	"michael", "superman", "121212", "killer", "trustno1", "jordan", "jennifer", "hunter",
+	"buster", "soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou", "2000",
+	"charlie", "robert", "thomas", "hockey", "ranger", "daniel", "starwars", "klaster", "112233", "george",
+	"computer", "michelle", "jessica", "pepper", "1111", "zxcvbn", "555555", "11111111", "131313",
+	"freedom", "777777", "pass", "maggie", "159753", "aaaaaa", "ginger", "princess", "joshua", "cheese",
+	"amanda", "summer", "love", "ashley", "6969", "nicole", "chelsea", "biteme", "matthew", "access",
+	"yankees", "987654321", "dallas", "austin", "thunder", "taylor", "matrix", "william", "corvette", "hello",
+	"martin", "heather", "secret", "merlin", "diamond", "1234qwer", "gfhjkm", "hammer", "silver", "222222",
+	"welcome", "admin", "login", "qwertyuiop", "passw0rd", "solo", "flower", "loveme",
+	"whatever", "qazwsx", "666666", "lovely", "7777777", "888888", "654321", "monday", "winter", "spring",
+	"autumn", "fall", "orange", "banana", "apple", "purple", "yellow", "blue", "green", "black",
+	"guitar", "music", "money", "family", "friends", "forever", "angel", "jesus", "god", "baby",
+	"test", "testing", "default", "changeme", "fender", "user", "guest", "root", "letmein1", "password1",
+}
@@ -0,0 +1,29 @@
+package password
+
+import "testing"
+
+func TestStrength(t *testing.T) {
+	tests := []struct {
+		password   string
+		userInputs []string
+		want       int
+	}{
+		{"password", nil, 0},
+		{"P@ssw0rd", nil, 0},
+		{"qwertyuiop", nil, 0},
+		{"aaaaaaaaaaaa", nil, 0},
+		{"abcdefgh12345", nil, 1},
+		{"summer2019", nil, 1},
+		{"kylelucas", []string{"Kyle Lucas"}, 1},
+		{"kylelucas", nil, 3},
+		{"9X&5eQ#TI9IzBM", nil, 4},
+		{"correcthorsebatterystaple", nil, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			if got := Strength(tt.password, tt.userInputs...); got != tt.want {
+				t.Errorf("Strength() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
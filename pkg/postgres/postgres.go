@@ -1,16 +1,20 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/kylegrantlucas/platform-exercise/models"
 	"github.com/kylegrantlucas/platform-exercise/pkg/password"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type DatabaseConnection struct {
@@ -18,19 +22,89 @@ type DatabaseConnection struct {
 }
 
 type Databaser interface {
-	CreateUser(email, name, plaintextPassword string) (models.User, error)
-	UpdateUserByUUID(uuid, email, name, plaintextPassword string) (models.User, error)
-	GetUserByEmail(email string) (models.User, error)
+	CreateUser(organizationUUID, email, name, plaintextPassword string) (models.User, error)
+	UpdateUserByUUID(uuid, email, name, plaintextPassword string, ifUpdatedAt *time.Time) (models.User, error)
+	GetUserByEmail(organizationUUID, email string) (models.User, error)
 	GetUserByUUID(uuid string) (models.User, error)
 	SoftDeleteUserByUUID(uuid string) (models.User, error)
-	CreateSession(userUUID string, expiresAt time.Time) (models.Session, error)
+	RehashUserPasswordByUUID(uuid, oldHash, newHash string) (int, error)
+	GetPasswordHistoryByUserUUID(userUUID string, limit int) ([]string, error)
+	RecordFailedLoginByUUID(uuid string) (int, error)
+	LockUserByUUID(uuid string, until time.Time) (int, error)
+	ResetFailedLoginsByUUID(uuid string) (int, error)
+	TakeRateLimitToken(key string, burst, refillPerSecond float64) (float64, error)
+	PurgeRateLimits(prefix string, burst, refillPerSecond float64, limit int) (int, error)
+	CreateSession(userUUID, organizationUUID, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error)
 	GetSessionByUUID(uuid string) (models.Session, error)
+	ListActiveSessionsByUserUUID(userUUID string) ([]models.Session, error)
+	TouchSessionByUUID(uuid string) (int, error)
 	SoftDeleteSessionByUUID(uuid string) (int, error)
+	SoftDeleteUserSessionByUUID(userUUID, uuid string) (int, error)
+	SoftDeleteSessionsByUserUUID(userUUID string) (int, error)
+	CreateRefreshToken(sessionUUID, tokenHash string, expiresAt time.Time) (models.RefreshToken, error)
+	GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error)
+	UseRefreshTokenByUUID(uuid string) (int, error)
+	RevokeRefreshTokensBySessionUUID(sessionUUID string) (int, error)
+	CreateVerificationToken(userUUID, email, tokenHash string, expiresAt time.Time) (models.VerificationToken, error)
+	GetVerificationTokenByHash(tokenHash string) (models.VerificationToken, error)
+	UseVerificationTokenByUUID(uuid string) (int, error)
+	VerifyUserByUUID(uuid, email string) (int, error)
+	CreatePasswordReset(userUUID, tokenHash string, expiresAt time.Time) (models.PasswordReset, error)
+	GetPasswordResetByHash(tokenHash string) (models.PasswordReset, error)
+	UsePasswordResetByUUID(uuid string) (int, error)
+	UpsertTOTPCredential(userUUID, secret string) (int, error)
+	GetTOTPCredentialByUserUUID(userUUID string) (models.TOTPCredential, error)
+	ConfirmTOTPCredential(userUUID string, step int64, recoveryCodeHashes []string) (int, error)
+	UseTOTPStep(userUUID string, step int64) (int, error)
+	UseRecoveryCode(userUUID, codeHash string) (int, error)
+	CreateMFAChallenge(userUUID, organizationUUID, tokenHash string, expiresAt time.Time) (models.MFAChallenge, error)
+	GetMFAChallengeByHash(tokenHash string) (models.MFAChallenge, error)
+	AttemptMFAChallengeByUUID(uuid string, maxAttempts int) (int, error)
+	UseMFAChallengeByUUID(uuid string) (int, error)
+	CreateWebAuthnChallenge(userUUID, ceremony, challengeHash string, expiresAt time.Time) (models.WebAuthnChallenge, error)
+	GetWebAuthnChallengeByHash(challengeHash string) (models.WebAuthnChallenge, error)
+	UseWebAuthnChallengeByUUID(uuid string) (int, error)
+	CreateWebAuthnCredential(userUUID, credentialID string, publicKey []byte, signCount int64, name string) (models.WebAuthnCredential, error)
+	GetWebAuthnCredentialByCredentialID(credentialID string) (models.WebAuthnCredential, error)
+	ListWebAuthnCredentialsByUserUUID(userUUID string) ([]models.WebAuthnCredential, error)
+	UpdateWebAuthnSignCountByUUID(uuid string, oldCount, newCount int64) (int, error)
+	CreateClientSession(userUUID, clientID, scope, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error)
+	CreateOAuthClient(clientID, secretHash, name string, redirectURIs, grantTypes, scopes []string) (models.OAuthClient, error)
+	GetOAuthClientByClientID(clientID string) (models.OAuthClient, error)
+	CreateOAuthAuthorizationCode(clientID, userUUID, codeHash, redirectURI, scope, codeChallenge, codeChallengeMethod, nonce string, expiresAt time.Time) (models.OAuthAuthorizationCode, error)
+	GetOAuthAuthorizationCodeByHash(codeHash string) (models.OAuthAuthorizationCode, error)
+	UseOAuthAuthorizationCodeByUUID(uuid string) (int, error)
+	CreateFederatedLoginState(provider, stateHash, codeVerifier string, expiresAt time.Time) (models.FederatedLoginState, error)
+	GetFederatedLoginStateByHash(stateHash string) (models.FederatedLoginState, error)
+	UseFederatedLoginStateByUUID(uuid string) (int, error)
+	GetFederatedIdentity(provider, subject string) (models.FederatedIdentity, error)
+	CreateFederatedIdentity(userUUID, provider, subject, email string) (models.FederatedIdentity, error)
+	CreateFederatedUser(organizationUUID, email, name string, verified bool, provider, subject string) (models.User, error)
+	ListUsers(organizationUUID string, filter UserFilter, cursor string, limit int) ([]models.User, string, error)
+	ListRolesByMembership(organizationUUID, userUUID string) ([]string, error)
+	ListPermissionsByMembership(organizationUUID, userUUID string) ([]string, error)
+	ListPermissionsByRoles(roles []string) ([]string, error)
+	ListGlobalRoles(roles []string) ([]string, error)
+	SetMembershipRoles(organizationUUID, userUUID string, roles []string) (int, error)
+	CreateOrganization(slug, name string) (models.Organization, error)
+	GetOrganizationBySlug(slug string) (models.Organization, error)
+	GetOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error)
+	GetDeletedOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error)
+	RestoreUserByUUID(organizationUUID, uuid string, deletedAfter time.Time) (models.User, error)
+	PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error)
+	CreateMembership(organizationUUID, userUUID string) (int, error)
+	GetMembership(organizationUUID, userUUID string) (models.Membership, error)
+	PurgeEndedSessions(endedBefore time.Time, limit int) (int, error)
+	TryAdvisoryLock(key int64) (func() error, bool, error)
+	RecordJobRun(run models.JobRun) (int, error)
+	GetJobRun(name string) (models.JobRun, error)
+	ListJobRuns() ([]models.JobRun, error)
 }
 
 var DB Databaser
 
-func CreateDatabase(host, port, user, password, dbName string) (*DatabaseConnection, error) {
+// ConnectionString builds the URL used to connect to Postgres, it's shared with anything that needs its own connection (ex: LISTEN)
+func ConnectionString(host, port, user, password, dbName string) string {
 	sqlURL := url.URL{
 		Scheme: "postgres",
 		User:   url.UserPassword(user, password),
@@ -38,8 +112,12 @@ func CreateDatabase(host, port, user, password, dbName string) (*DatabaseConnect
 		Path:   dbName,
 	}
 
+	return sqlURL.String() + "?sslmode=disable"
+}
+
+func CreateDatabase(host, port, user, password, dbName string) (*DatabaseConnection, error) {
 	// Create the database connection
-	db, err := sql.Open("postgres", sqlURL.String()+"?sslmode=disable")
+	db, err := sql.Open("postgres", ConnectionString(host, port, user, password, dbName))
 	if err != nil {
 		log.Printf("Error opening db connection: %v", err)
 		return nil, err
@@ -55,7 +133,8 @@ func CreateDatabase(host, port, user, password, dbName string) (*DatabaseConnect
 	return &DatabaseConnection{Connection: db}, nil
 }
 
-func (d *DatabaseConnection) CreateUser(email, name, plaintextPassword string) (models.User, error) {
+// CreateUser signs up a user in the organization, they're made a member of it at the same time
+func (d *DatabaseConnection) CreateUser(organizationUUID, email, name, plaintextPassword string) (models.User, error) {
 	user := models.User{}
 
 	// Hash + Salt our password prior to creating the user record
@@ -65,7 +144,7 @@ func (d *DatabaseConnection) CreateUser(email, name, plaintextPassword string) (
 	}
 
 	// Insert the record
-	rows, err := d.Connection.Query(queries["create_user"], email, name, encryptedPassword, time.Now())
+	rows, err := d.Connection.Query(queries["create_user"], organizationUUID, email, name, encryptedPassword, time.Now())
 	if err != nil {
 		return user, err
 	}
@@ -84,10 +163,17 @@ func (d *DatabaseConnection) CreateUser(email, name, plaintextPassword string) (
 		return user, err
 	}
 
+	err = d.recordPasswordHistory(user.UUID, encryptedPassword)
+	if err != nil {
+		return user, err
+	}
+
 	return user, nil
 }
 
-func (d *DatabaseConnection) UpdateUserByUUID(uuid, email, name, plaintextPassword string) (models.User, error) {
+// UpdateUserByUUID updates the given fields and bumps updated_at. When ifUpdatedAt is set the update only
+// goes through if the user hasn't changed since then, otherwise an empty user is returned.
+func (d *DatabaseConnection) UpdateUserByUUID(uuid, email, name, plaintextPassword string, ifUpdatedAt *time.Time) (models.User, error) {
 	user := models.User{}
 	var rows *sql.Rows
 
@@ -97,7 +183,8 @@ func (d *DatabaseConnection) UpdateUserByUUID(uuid, email, name, plaintextPasswo
 
 	if email != "" {
 		argCount++
-		queryBody = append(queryBody, fmt.Sprintf("email=$%v", argCount))
+		// A new address has to be verified all over again, on the right hand side email is still the old address
+		queryBody = append(queryBody, fmt.Sprintf("email=$%v,verified_at=CASE WHEN email=$%v THEN verified_at END", argCount, argCount))
 		args = append(args, email)
 	}
 
@@ -107,10 +194,12 @@ func (d *DatabaseConnection) UpdateUserByUUID(uuid, email, name, plaintextPasswo
 		args = append(args, name)
 	}
 
+	encryptedPassword := ""
 	if plaintextPassword != "" {
 		argCount++
 
-		encryptedPassword, err := password.HashAndSalt(plaintextPassword)
+		var err error
+		encryptedPassword, err = password.HashAndSalt(plaintextPassword)
 		if err != nil {
 			return user, err
 		}
@@ -119,12 +208,22 @@ func (d *DatabaseConnection) UpdateUserByUUID(uuid, email, name, plaintextPasswo
 		args = append(args, encryptedPassword)
 	}
 
+	argCount++
+	queryBody = append(queryBody, fmt.Sprintf("updated_at=$%v", argCount))
+	args = append(args, time.Now())
+
 	queryBodyString := strings.Join(queryBody, ",")
 
 	argCount++
 	queryBodyString += fmt.Sprintf(" where uuid=$%v AND deleted_at IS NULL", argCount)
 	args = append(args, uuid)
 
+	if ifUpdatedAt != nil {
+		argCount++
+		queryBodyString += fmt.Sprintf(" AND updated_at=$%v", argCount)
+		args = append(args, *ifUpdatedAt)
+	}
+
 	// Update the record
 	rows, err := d.Connection.Query(fmt.Sprintf(queries["update_user_by_uuid"], queryBodyString), args...)
 	if err != nil {
@@ -133,7 +232,7 @@ func (d *DatabaseConnection) UpdateUserByUUID(uuid, email, name, plaintextPasswo
 
 	// Scan off the result to return to the client
 	for rows.Next() {
-		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.VerifiedAt)
 		if err != nil {
 			return user, err
 		}
@@ -145,21 +244,69 @@ func (d *DatabaseConnection) UpdateUserByUUID(uuid, email, name, plaintextPasswo
 		return user, err
 	}
 
+	if encryptedPassword != "" {
+		err = d.recordPasswordHistory(user.UUID, encryptedPassword)
+		if err != nil {
+			return user, err
+		}
+	}
+
 	return user, nil
 }
 
-func (d *DatabaseConnection) GetUserByEmail(email string) (models.User, error) {
+// recordPasswordHistory keeps the hash of a password the user has set, so they can be stopped from reusing it
+func (d *DatabaseConnection) recordPasswordHistory(userUUID, encryptedPassword string) error {
+	// Nothing was written if the user wasn't found
+	if userUUID == "" {
+		return nil
+	}
+
+	_, err := d.Connection.Exec(queries["create_password_history"], userUUID, encryptedPassword, time.Now())
+	return err
+}
+
+// GetPasswordHistoryByUserUUID returns the hashes of the last limit passwords the user has set, newest first
+func (d *DatabaseConnection) GetPasswordHistoryByUserUUID(userUUID string, limit int) ([]string, error) {
+	hashes := []string{}
+
+	// Query the records
+	rows, err := d.Connection.Query(queries["get_password_history_by_user_uuid"], userUUID, limit)
+	if err != nil {
+		return hashes, err
+	}
+
+	// Scan off the results to return to the client
+	for rows.Next() {
+		hash := ""
+		err := rows.Scan(&hash)
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return hashes, err
+	}
+
+	return hashes, nil
+}
+
+// GetUserByEmail finds the organization's own user with the email address, users of other organizations can have it too
+func (d *DatabaseConnection) GetUserByEmail(organizationUUID, email string) (models.User, error) {
 	user := models.User{}
 
 	// Query the record
-	rows, err := d.Connection.Query(queries["get_user_by_email"], email)
+	rows, err := d.Connection.Query(queries["get_user_by_email"], organizationUUID, email)
 	if err != nil {
 		return user, err
 	}
 
 	// Scan off the result to return to the client
 	for rows.Next() {
-		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.VerifiedAt, &user.FailedLoginCount, &user.LockedUntil, &user.Password)
 		if err != nil {
 			return user, err
 		}
@@ -185,7 +332,7 @@ func (d *DatabaseConnection) GetUserByUUID(uuid string) (models.User, error) {
 
 	// Scan off the result to return to the client
 	for rows.Next() {
-		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.VerifiedAt, &user.FailedLoginCount, &user.LockedUntil, &user.Password)
 		if err != nil {
 			return user, err
 		}
@@ -226,18 +373,168 @@ func (d *DatabaseConnection) SoftDeleteUserByUUID(uuid string) (models.User, err
 	return user, nil
 }
 
-func (d *DatabaseConnection) CreateSession(userUUID string, expiresAt time.Time) (models.Session, error) {
+// RehashUserPasswordByUUID swaps the user's password hash for a new hash of the same password. It only affects
+// the row if the hash is still oldHash, so it can't undo a password change that raced with it, and it leaves
+// updated_at alone since nothing about the user visibly changed.
+func (d *DatabaseConnection) RehashUserPasswordByUUID(uuid, oldHash, newHash string) (int, error) {
+	// Replace the hash
+	result, err := d.Connection.Exec(queries["rehash_user_password_by_uuid"], newHash, uuid, oldHash)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// RecordFailedLoginByUUID counts a failed login against the user, returning how many there have been in a row
+func (d *DatabaseConnection) RecordFailedLoginByUUID(uuid string) (int, error) {
+	failures := 0
+
+	// Update the record
+	rows, err := d.Connection.Query(queries["record_failed_login_by_uuid"], uuid)
+	if err != nil {
+		return failures, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&failures)
+		if err != nil {
+			return failures, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return failures, err
+	}
+
+	return failures, nil
+}
+
+// LockUserByUUID stops the user from logging in until the given time
+func (d *DatabaseConnection) LockUserByUUID(uuid string, until time.Time) (int, error) {
+	// Lock the record
+	result, err := d.Connection.Exec(queries["lock_user_by_uuid"], until, uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// ResetFailedLoginsByUUID clears the user's failed logins and any lock after they log in successfully
+func (d *DatabaseConnection) ResetFailedLoginsByUUID(uuid string) (int, error) {
+	// Reset the record
+	result, err := d.Connection.Exec(queries["reset_failed_logins_by_uuid"], uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// TakeRateLimitToken refills the key's token bucket for the time since it was last used and takes a token
+// from it if there's a whole one, all in one statement so replicas can share buckets. It returns how many
+// tokens the bucket had before taking one, less than 1 means the caller is being limited.
+func (d *DatabaseConnection) TakeRateLimitToken(key string, burst, refillPerSecond float64) (float64, error) {
+	tokens := 0.0
+
+	// Upsert the record
+	rows, err := d.Connection.Query(queries["take_rate_limit_token"], key, burst, refillPerSecond, time.Now())
+	if err != nil {
+		return tokens, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&tokens)
+		if err != nil {
+			return tokens, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return tokens, err
+	}
+
+	return tokens, nil
+}
+
+// PurgeRateLimits deletes up to limit of the buckets whose keys start with prefix that have refilled all the way,
+// returning how many were purged. A bucket that's gone is treated as full, so purging them doesn't change any limits.
+func (d *DatabaseConnection) PurgeRateLimits(prefix string, burst, refillPerSecond float64, limit int) (int, error) {
+	result, err := d.Connection.Exec(queries["purge_rate_limits"], prefix, burst, refillPerSecond, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// CreateSession starts a session for the user acting in the organization, which they have to be a member of
+func (d *DatabaseConnection) CreateSession(userUUID, organizationUUID, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error) {
+	session := models.Session{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_session"], userUUID, organizationUUID, time.Now(), expiresAt, userAgent, ipAddress)
+	if err != nil {
+		return session, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&session.UUID, &session.UserUUID, &session.OrganizationUUID, &session.CreatedAt, &session.ExpiresAt, &session.UserAgent, &session.IPAddress, &session.LastSeenAt)
+		if err != nil {
+			return session, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return session, err
+	}
+
+	return session, nil
+}
+
+// CreateClientSession creates a session for a user that's authorized an OAuth client, its tokens are limited to scope
+func (d *DatabaseConnection) CreateClientSession(userUUID, clientID, scope, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error) {
 	session := models.Session{}
 
 	// Insert the record
-	rows, err := d.Connection.Query(queries["create_session"], userUUID, time.Now(), expiresAt)
+	rows, err := d.Connection.Query(queries["create_client_session"], userUUID, time.Now(), expiresAt, userAgent, ipAddress, clientID, scope)
 	if err != nil {
 		return session, err
 	}
 
 	// Scan off the result to return to the client
 	for rows.Next() {
-		err := rows.Scan(&session.UUID, &session.UserUUID, &session.CreatedAt, &session.ExpiresAt)
+		err := rows.Scan(&session.UUID, &session.UserUUID, &session.OrganizationUUID, &session.CreatedAt, &session.ExpiresAt, &session.UserAgent, &session.IPAddress, &session.LastSeenAt, &session.ClientID, &session.Scope)
 		if err != nil {
 			return session, err
 		}
@@ -263,7 +560,7 @@ func (d *DatabaseConnection) GetSessionByUUID(uuid string) (models.Session, erro
 
 	// Scan off the result to return to the client
 	for rows.Next() {
-		err := rows.Scan(&session.UUID, &session.UserUUID, &session.CreatedAt, &session.ExpiresAt, &session.DeletedAt)
+		err := rows.Scan(&session.UUID, &session.UserUUID, &session.OrganizationUUID, &session.CreatedAt, &session.ExpiresAt, &session.DeletedAt, &session.UserAgent, &session.IPAddress, &session.LastSeenAt, &session.ClientID, &session.Scope)
 		if err != nil {
 			return session, err
 		}
@@ -278,6 +575,49 @@ func (d *DatabaseConnection) GetSessionByUUID(uuid string) (models.Session, erro
 	return session, nil
 }
 
+func (d *DatabaseConnection) ListActiveSessionsByUserUUID(userUUID string) ([]models.Session, error) {
+	sessions := []models.Session{}
+
+	// Query the records
+	rows, err := d.Connection.Query(queries["list_active_sessions_by_user_uuid"], userUUID, time.Now())
+	if err != nil {
+		return sessions, err
+	}
+
+	// Scan off the results to return to the client
+	for rows.Next() {
+		session := models.Session{}
+		err := rows.Scan(&session.UUID, &session.UserUUID, &session.OrganizationUUID, &session.CreatedAt, &session.ExpiresAt, &session.DeletedAt, &session.UserAgent, &session.IPAddress, &session.LastSeenAt, &session.ClientID, &session.Scope)
+		if err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return sessions, err
+	}
+
+	return sessions, nil
+}
+
+func (d *DatabaseConnection) TouchSessionByUUID(uuid string) (int, error) {
+	// Bump the last seen time
+	result, err := d.Connection.Exec(queries["touch_session_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
 func (d *DatabaseConnection) SoftDeleteSessionByUUID(uuid string) (int, error) {
 	// Soft delete the record
 	result, err := d.Connection.Exec(queries["soft_delete_session_by_uuid"], time.Now(), uuid)
@@ -293,49 +633,1884 @@ func (d *DatabaseConnection) SoftDeleteSessionByUUID(uuid string) (int, error) {
 	return int(numRows), nil
 }
 
-var queries = map[string]string{
-	"create_user":                 "insert into users (email, name, password, created_at, updated_at) values ($1, $2, $3, $4, $4) returning uuid, email, name, created_at, updated_at;",
-	"create_session":              "insert into sessions (user_uuid, created_at, expires_at) values ($1, $2, $3) returning uuid, user_uuid, created_at, expires_at;",
-	"update_user_by_uuid":         "update users set %v returning uuid, email, name, created_at, updated_at;",
-	"soft_delete_user_by_uuid":    "update users set deleted_at=$1, updated_at=$1 where uuid=$2 returning uuid, email, name, created_at, updated_at, deleted_at;",
-	"soft_delete_session_by_uuid": "update sessions set deleted_at=$1 where uuid=$2 AND deleted_at IS NULL;",
-	"get_session_by_uuid":         "select uuid, user_uuid, created_at, expires_at, deleted_at FROM sessions WHERE uuid=$1 LIMIT 1;",
-	"get_user_by_uuid":            "select uuid, email, name, created_at, updated_at, password FROM users WHERE uuid=$1 AND deleted_at IS NULL LIMIT 1;",
-	"get_user_by_email":           "select uuid, email, name, created_at, updated_at, password FROM users WHERE email=$1 AND deleted_at IS NULL LIMIT 1;",
-}
+// SoftDeleteUserSessionByUUID soft deletes a session only if it belongs to the given user
+func (d *DatabaseConnection) SoftDeleteUserSessionByUUID(userUUID, uuid string) (int, error) {
+	// Soft delete the record
+	result, err := d.Connection.Exec(queries["soft_delete_user_session_by_uuid"], time.Now(), uuid, userUUID)
+	if err != nil {
+		return 0, err
+	}
 
-type DBMock struct{}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
 
-func (d *DBMock) CreateUser(email, name, plaintextPassword string) (models.User, error) {
-	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc"}, nil
+	return int(numRows), nil
 }
 
-func (d *DBMock) UpdateUserByUUID(uuid, email, name, plaintextPassword string) (models.User, error) {
-	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc"}, nil
-}
+func (d *DatabaseConnection) SoftDeleteSessionsByUserUUID(userUUID string) (int, error) {
+	// Soft delete every live record for the user
+	result, err := d.Connection.Exec(queries["soft_delete_sessions_by_user_uuid"], time.Now(), userUUID)
+	if err != nil {
+		return 0, err
+	}
 
-func (d *DBMock) SoftDeleteUserByUUID(uuid string) (models.User, error) {
-	ct := time.Now()
-	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc", DeletedAt: &ct}, nil
-}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
 
-func (d *DBMock) GetUserByUUID(uuid string) (models.User, error) {
-	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc"}, nil
+	return int(numRows), nil
 }
 
-func (d *DBMock) GetUserByEmail(email string) (models.User, error) {
-	test, _ := password.HashAndSalt("test")
-	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc", Password: test}, nil
+func (d *DatabaseConnection) CreateRefreshToken(sessionUUID, tokenHash string, expiresAt time.Time) (models.RefreshToken, error) {
+	refreshToken := models.RefreshToken{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_refresh_token"], sessionUUID, tokenHash, time.Now(), expiresAt)
+	if err != nil {
+		return refreshToken, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&refreshToken.UUID, &refreshToken.SessionUUID, &refreshToken.CreatedAt, &refreshToken.ExpiresAt)
+		if err != nil {
+			return refreshToken, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return refreshToken, err
+	}
+
+	return refreshToken, nil
 }
 
-func (d *DBMock) CreateSession(userUUID string, expiresAt time.Time) (models.Session, error) {
-	return models.Session{UUID: "abc"}, nil
+func (d *DatabaseConnection) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	refreshToken := models.RefreshToken{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_refresh_token_by_hash"], tokenHash)
+	if err != nil {
+		return refreshToken, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&refreshToken.UUID, &refreshToken.SessionUUID, &refreshToken.CreatedAt, &refreshToken.ExpiresAt, &refreshToken.UsedAt, &refreshToken.RevokedAt)
+		if err != nil {
+			return refreshToken, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return refreshToken, err
+	}
+
+	return refreshToken, nil
 }
 
-func (d *DBMock) GetSessionByUUID(uuid string) (models.Session, error) {
-	return models.Session{UUID: "abc"}, nil
+// UseRefreshTokenByUUID marks a refresh token as used, it only affects a row if the token hasn't already been used or revoked
+// so a caller that gets 0 back has lost a race with another exchange of the same token
+func (d *DatabaseConnection) UseRefreshTokenByUUID(uuid string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_refresh_token_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
 }
 
-func (d *DBMock) SoftDeleteSessionByUUID(uuid string) (int, error) {
-	return 1, nil
+func (d *DatabaseConnection) RevokeRefreshTokensBySessionUUID(sessionUUID string) (int, error) {
+	// Revoke every outstanding token in the session
+	result, err := d.Connection.Exec(queries["revoke_refresh_tokens_by_session_uuid"], time.Now(), sessionUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+func (d *DatabaseConnection) CreateVerificationToken(userUUID, email, tokenHash string, expiresAt time.Time) (models.VerificationToken, error) {
+	verificationToken := models.VerificationToken{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_verification_token"], userUUID, email, tokenHash, time.Now(), expiresAt)
+	if err != nil {
+		return verificationToken, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&verificationToken.UUID, &verificationToken.UserUUID, &verificationToken.Email, &verificationToken.CreatedAt, &verificationToken.ExpiresAt)
+		if err != nil {
+			return verificationToken, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return verificationToken, err
+	}
+
+	return verificationToken, nil
+}
+
+func (d *DatabaseConnection) GetVerificationTokenByHash(tokenHash string) (models.VerificationToken, error) {
+	verificationToken := models.VerificationToken{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_verification_token_by_hash"], tokenHash)
+	if err != nil {
+		return verificationToken, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&verificationToken.UUID, &verificationToken.UserUUID, &verificationToken.Email, &verificationToken.CreatedAt, &verificationToken.ExpiresAt, &verificationToken.UsedAt)
+		if err != nil {
+			return verificationToken, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return verificationToken, err
+	}
+
+	return verificationToken, nil
+}
+
+// UseVerificationTokenByUUID marks a verification token as used, it only affects a row if the token hasn't already been used
+func (d *DatabaseConnection) UseVerificationTokenByUUID(uuid string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_verification_token_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// VerifyUserByUUID marks the user's email as verified, as long as it's still the address the token was sent to
+func (d *DatabaseConnection) VerifyUserByUUID(uuid, email string) (int, error) {
+	// Mark the record as verified
+	result, err := d.Connection.Exec(queries["verify_user_by_uuid"], time.Now(), uuid, email)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+func (d *DatabaseConnection) CreatePasswordReset(userUUID, tokenHash string, expiresAt time.Time) (models.PasswordReset, error) {
+	passwordReset := models.PasswordReset{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_password_reset"], userUUID, tokenHash, time.Now(), expiresAt)
+	if err != nil {
+		return passwordReset, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&passwordReset.UUID, &passwordReset.UserUUID, &passwordReset.CreatedAt, &passwordReset.ExpiresAt)
+		if err != nil {
+			return passwordReset, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return passwordReset, err
+	}
+
+	return passwordReset, nil
+}
+
+func (d *DatabaseConnection) GetPasswordResetByHash(tokenHash string) (models.PasswordReset, error) {
+	passwordReset := models.PasswordReset{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_password_reset_by_hash"], tokenHash)
+	if err != nil {
+		return passwordReset, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&passwordReset.UUID, &passwordReset.UserUUID, &passwordReset.CreatedAt, &passwordReset.ExpiresAt, &passwordReset.UsedAt)
+		if err != nil {
+			return passwordReset, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return passwordReset, err
+	}
+
+	return passwordReset, nil
+}
+
+// UsePasswordResetByUUID marks a password reset as used, it only affects a row if the reset hasn't already been used
+func (d *DatabaseConnection) UsePasswordResetByUUID(uuid string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_password_reset_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// UpsertTOTPCredential starts (or restarts) a TOTP enrollment with a sealed secret, it won't replace one that's been confirmed
+func (d *DatabaseConnection) UpsertTOTPCredential(userUUID, secret string) (int, error) {
+	// Upsert the record
+	result, err := d.Connection.Exec(queries["upsert_totp_credential"], userUUID, secret, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+func (d *DatabaseConnection) GetTOTPCredentialByUserUUID(userUUID string) (models.TOTPCredential, error) {
+	credential := models.TOTPCredential{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_totp_credential_by_user_uuid"], userUUID)
+	if err != nil {
+		return credential, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&credential.UserUUID, &credential.Secret, &credential.CreatedAt, &credential.ConfirmedAt, &credential.LastUsedStep)
+		if err != nil {
+			return credential, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return credential, err
+	}
+
+	return credential, nil
+}
+
+// ConfirmTOTPCredential turns on a pending TOTP enrollment, recording the step of the code that confirmed it, and
+// replaces the user's recovery codes in the same transaction so MFA is never on without them. It only affects a row
+// if the enrollment hadn't already been confirmed.
+func (d *DatabaseConnection) ConfirmTOTPCredential(userUUID string, step int64, recoveryCodeHashes []string) (int, error) {
+	tx, err := d.Connection.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Confirm the record
+	result, err := tx.Exec(queries["confirm_totp_credential"], time.Now(), step, userUUID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil || numRows == 0 {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// Swap out the recovery codes
+	_, err = tx.Exec(queries["delete_recovery_codes_by_user_uuid"], userUUID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(queries["create_recovery_code"], userUUID, codeHash, time.Now())
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// UseTOTPStep records that the code for a step has been used, it only affects a row if no code for that step
+// or a later one has been, so each code works once
+func (d *DatabaseConnection) UseTOTPStep(userUUID string, step int64) (int, error) {
+	// Update the record
+	result, err := d.Connection.Exec(queries["use_totp_step"], step, userUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used, it only affects a row if the code is theirs and unused
+func (d *DatabaseConnection) UseRecoveryCode(userUUID, codeHash string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_recovery_code"], time.Now(), userUUID, codeHash)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+func (d *DatabaseConnection) CreateMFAChallenge(userUUID, organizationUUID, tokenHash string, expiresAt time.Time) (models.MFAChallenge, error) {
+	challenge := models.MFAChallenge{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_mfa_challenge"], userUUID, organizationUUID, tokenHash, time.Now(), expiresAt)
+	if err != nil {
+		return challenge, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&challenge.UUID, &challenge.UserUUID, &challenge.OrganizationUUID, &challenge.CreatedAt, &challenge.ExpiresAt)
+		if err != nil {
+			return challenge, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return challenge, err
+	}
+
+	return challenge, nil
+}
+
+func (d *DatabaseConnection) GetMFAChallengeByHash(tokenHash string) (models.MFAChallenge, error) {
+	challenge := models.MFAChallenge{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_mfa_challenge_by_hash"], tokenHash)
+	if err != nil {
+		return challenge, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&challenge.UUID, &challenge.UserUUID, &challenge.OrganizationUUID, &challenge.CreatedAt, &challenge.ExpiresAt, &challenge.UsedAt, &challenge.Attempts)
+		if err != nil {
+			return challenge, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return challenge, err
+	}
+
+	return challenge, nil
+}
+
+// AttemptMFAChallengeByUUID counts an attempt at a challenge, it only affects a row if the challenge is unused and
+// has had fewer than maxAttempts, so codes can't be guessed without limit
+func (d *DatabaseConnection) AttemptMFAChallengeByUUID(uuid string, maxAttempts int) (int, error) {
+	// Update the record
+	result, err := d.Connection.Exec(queries["attempt_mfa_challenge_by_uuid"], uuid, maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// UseMFAChallengeByUUID marks a challenge as used, it only affects a row if the challenge hasn't already been used
+func (d *DatabaseConnection) UseMFAChallengeByUUID(uuid string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_mfa_challenge_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// CreateWebAuthnChallenge stores the hash of a ceremony's challenge, userUUID is empty for logins since we don't know who it is yet
+func (d *DatabaseConnection) CreateWebAuthnChallenge(userUUID, ceremony, challengeHash string, expiresAt time.Time) (models.WebAuthnChallenge, error) {
+	challenge := models.WebAuthnChallenge{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_webauthn_challenge"], userUUID, ceremony, challengeHash, time.Now(), expiresAt)
+	if err != nil {
+		return challenge, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&challenge.UUID, &challenge.UserUUID, &challenge.Ceremony, &challenge.CreatedAt, &challenge.ExpiresAt)
+		if err != nil {
+			return challenge, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return challenge, err
+	}
+
+	return challenge, nil
+}
+
+func (d *DatabaseConnection) GetWebAuthnChallengeByHash(challengeHash string) (models.WebAuthnChallenge, error) {
+	challenge := models.WebAuthnChallenge{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_webauthn_challenge_by_hash"], challengeHash)
+	if err != nil {
+		return challenge, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&challenge.UUID, &challenge.UserUUID, &challenge.Ceremony, &challenge.CreatedAt, &challenge.ExpiresAt, &challenge.UsedAt)
+		if err != nil {
+			return challenge, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return challenge, err
+	}
+
+	return challenge, nil
+}
+
+// UseWebAuthnChallengeByUUID marks a challenge as used, it only affects a row if the challenge hasn't already been used
+func (d *DatabaseConnection) UseWebAuthnChallengeByUUID(uuid string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_webauthn_challenge_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// CreateWebAuthnCredential stores a newly registered credential, an empty credential is returned if its ID is already registered
+func (d *DatabaseConnection) CreateWebAuthnCredential(userUUID, credentialID string, publicKey []byte, signCount int64, name string) (models.WebAuthnCredential, error) {
+	credential := models.WebAuthnCredential{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_webauthn_credential"], userUUID, credentialID, publicKey, signCount, name, time.Now())
+	if err != nil {
+		return credential, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&credential.UUID, &credential.UserUUID, &credential.CredentialID, &credential.PublicKey, &credential.SignCount, &credential.Name, &credential.CreatedAt)
+		if err != nil {
+			return credential, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return credential, err
+	}
+
+	return credential, nil
+}
+
+func (d *DatabaseConnection) GetWebAuthnCredentialByCredentialID(credentialID string) (models.WebAuthnCredential, error) {
+	credential := models.WebAuthnCredential{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_webauthn_credential_by_credential_id"], credentialID)
+	if err != nil {
+		return credential, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&credential.UUID, &credential.UserUUID, &credential.CredentialID, &credential.PublicKey, &credential.SignCount, &credential.Name, &credential.CreatedAt, &credential.LastUsedAt)
+		if err != nil {
+			return credential, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return credential, err
+	}
+
+	return credential, nil
+}
+
+func (d *DatabaseConnection) ListWebAuthnCredentialsByUserUUID(userUUID string) ([]models.WebAuthnCredential, error) {
+	credentials := []models.WebAuthnCredential{}
+
+	// Query the records
+	rows, err := d.Connection.Query(queries["list_webauthn_credentials_by_user_uuid"], userUUID)
+	if err != nil {
+		return credentials, err
+	}
+
+	// Scan off the results to return to the client
+	for rows.Next() {
+		credential := models.WebAuthnCredential{}
+		err := rows.Scan(&credential.UUID, &credential.UserUUID, &credential.CredentialID, &credential.PublicKey, &credential.SignCount, &credential.Name, &credential.CreatedAt, &credential.LastUsedAt)
+		if err != nil {
+			return credentials, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return credentials, err
+	}
+
+	return credentials, nil
+}
+
+// UpdateWebAuthnSignCountByUUID stores a credential's new signature counter after a login, it only affects a row if
+// the counter is still what the login was checked against, so two logins can't both move it on from the same value
+func (d *DatabaseConnection) UpdateWebAuthnSignCountByUUID(uuid string, oldCount, newCount int64) (int, error) {
+	// Update the record
+	result, err := d.Connection.Exec(queries["update_webauthn_sign_count_by_uuid"], newCount, time.Now(), uuid, oldCount)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// CreateOAuthClient registers an OAuth client, secretHash is empty for public clients that can't keep a secret
+func (d *DatabaseConnection) CreateOAuthClient(clientID, secretHash, name string, redirectURIs, grantTypes, scopes []string) (models.OAuthClient, error) {
+	client := models.OAuthClient{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_oauth_client"], clientID, secretHash, name, pq.Array(redirectURIs), pq.Array(grantTypes), pq.Array(scopes), time.Now())
+	if err != nil {
+		return client, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&client.UUID, &client.ClientID, &client.SecretHash, &client.Name, pq.Array(&client.RedirectURIs), pq.Array(&client.GrantTypes), pq.Array(&client.Scopes), &client.CreatedAt)
+		if err != nil {
+			return client, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return client, err
+	}
+
+	return client, nil
+}
+
+func (d *DatabaseConnection) GetOAuthClientByClientID(clientID string) (models.OAuthClient, error) {
+	client := models.OAuthClient{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_oauth_client_by_client_id"], clientID)
+	if err != nil {
+		return client, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&client.UUID, &client.ClientID, &client.SecretHash, &client.Name, pq.Array(&client.RedirectURIs), pq.Array(&client.GrantTypes), pq.Array(&client.Scopes), &client.CreatedAt)
+		if err != nil {
+			return client, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return client, err
+	}
+
+	return client, nil
+}
+
+// CreateOAuthAuthorizationCode stores the hash of an authorization code along with everything it was issued for,
+// which the token endpoint checks the exchange against
+func (d *DatabaseConnection) CreateOAuthAuthorizationCode(clientID, userUUID, codeHash, redirectURI, scope, codeChallenge, codeChallengeMethod, nonce string, expiresAt time.Time) (models.OAuthAuthorizationCode, error) {
+	code := models.OAuthAuthorizationCode{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_oauth_authorization_code"], codeHash, clientID, userUUID, redirectURI, scope, codeChallenge, codeChallengeMethod, nonce, time.Now(), expiresAt)
+	if err != nil {
+		return code, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&code.UUID, &code.ClientID, &code.UserUUID, &code.RedirectURI, &code.Scope, &code.CodeChallenge, &code.CodeChallengeMethod, &code.Nonce, &code.CreatedAt, &code.ExpiresAt)
+		if err != nil {
+			return code, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return code, err
+	}
+
+	return code, nil
+}
+
+func (d *DatabaseConnection) GetOAuthAuthorizationCodeByHash(codeHash string) (models.OAuthAuthorizationCode, error) {
+	code := models.OAuthAuthorizationCode{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_oauth_authorization_code_by_hash"], codeHash)
+	if err != nil {
+		return code, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&code.UUID, &code.ClientID, &code.UserUUID, &code.RedirectURI, &code.Scope, &code.CodeChallenge, &code.CodeChallengeMethod, &code.Nonce, &code.CreatedAt, &code.ExpiresAt, &code.UsedAt)
+		if err != nil {
+			return code, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return code, err
+	}
+
+	return code, nil
+}
+
+// UseOAuthAuthorizationCodeByUUID marks an authorization code as used, it only affects a row if the code hasn't already been used
+func (d *DatabaseConnection) UseOAuthAuthorizationCodeByUUID(uuid string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_oauth_authorization_code_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+func (d *DatabaseConnection) CreateFederatedLoginState(provider, stateHash, codeVerifier string, expiresAt time.Time) (models.FederatedLoginState, error) {
+	state := models.FederatedLoginState{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_federated_login_state"], provider, stateHash, codeVerifier, time.Now(), expiresAt)
+	if err != nil {
+		return state, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&state.UUID, &state.Provider, &state.CodeVerifier, &state.CreatedAt, &state.ExpiresAt)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+func (d *DatabaseConnection) GetFederatedLoginStateByHash(stateHash string) (models.FederatedLoginState, error) {
+	state := models.FederatedLoginState{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_federated_login_state_by_hash"], stateHash)
+	if err != nil {
+		return state, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&state.UUID, &state.Provider, &state.CodeVerifier, &state.CreatedAt, &state.ExpiresAt, &state.UsedAt)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+// UseFederatedLoginStateByUUID marks a login state as used, it only affects a row if the state hasn't already been used
+func (d *DatabaseConnection) UseFederatedLoginStateByUUID(uuid string) (int, error) {
+	// Mark the record as used
+	result, err := d.Connection.Exec(queries["use_federated_login_state_by_uuid"], time.Now(), uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// GetFederatedIdentity finds the link between a provider's subject and one of our users, links to deleted users aren't returned
+func (d *DatabaseConnection) GetFederatedIdentity(provider, subject string) (models.FederatedIdentity, error) {
+	identity := models.FederatedIdentity{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_federated_identity"], provider, subject)
+	if err != nil {
+		return identity, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&identity.UUID, &identity.UserUUID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return identity, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return identity, err
+	}
+
+	return identity, nil
+}
+
+// CreateFederatedIdentity links a provider's subject to the user. A subject already linked to a deleted user moves
+// over, if it's linked to anyone else an empty identity is returned.
+func (d *DatabaseConnection) CreateFederatedIdentity(userUUID, provider, subject, email string) (models.FederatedIdentity, error) {
+	return createFederatedIdentity(d.Connection, userUUID, provider, subject, email)
+}
+
+// CreateFederatedUser signs up a user in the organization who logged in with a provider, along with the link to
+// their subject. They have no password, and their email is verified if the provider says it is. An empty user is
+// returned if the email address or subject is already taken.
+func (d *DatabaseConnection) CreateFederatedUser(organizationUUID, email, name string, verified bool, provider, subject string) (models.User, error) {
+	user := models.User{}
+	currentTime := time.Now()
+
+	var verifiedAt *time.Time
+	if verified {
+		verifiedAt = &currentTime
+	}
+
+	tx, err := d.Connection.Begin()
+	if err != nil {
+		return user, err
+	}
+
+	// Insert the record
+	rows, err := tx.Query(queries["create_federated_user"], organizationUUID, email, name, currentTime, verifiedAt)
+	if err != nil {
+		tx.Rollback()
+		return user, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.VerifiedAt)
+		if err != nil {
+			tx.Rollback()
+			return user, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil || user.UUID == "" {
+		tx.Rollback()
+		return user, err
+	}
+
+	identity, err := createFederatedIdentity(tx, user.UUID, provider, subject, email)
+	if err != nil || identity.UUID == "" {
+		tx.Rollback()
+		return models.User{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// createFederatedIdentity is shared by CreateFederatedIdentity and CreateFederatedUser, which needs it in its transaction
+func createFederatedIdentity(q querier, userUUID, provider, subject, email string) (models.FederatedIdentity, error) {
+	identity := models.FederatedIdentity{}
+
+	// Insert the record
+	rows, err := q.Query(queries["create_federated_identity"], userUUID, provider, subject, email, time.Now())
+	if err != nil {
+		return identity, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&identity.UUID, &identity.UserUUID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return identity, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return identity, err
+	}
+
+	return identity, nil
+}
+
+// querier is what a *sql.DB and *sql.Tx have in common that we need
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// UserFilter narrows down the users ListUsers returns, the zero value matches every live user
+type UserFilter struct {
+	// Search matches users whose email address or name contains it
+	Search string
+	// EmailPrefix matches users whose email address starts with it
+	EmailPrefix string
+	// Name matches users whose name contains it
+	Name string
+	// CreatedAfter and CreatedBefore match users created from CreatedAfter up to, but not including, CreatedBefore
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// IncludeDeleted matches soft deleted users as well as live ones
+	IncludeDeleted bool
+}
+
+// ErrInvalidCursor is returned by ListUsers for a cursor it didn't hand out
+var ErrInvalidCursor = errors.New("postgres: cursor is invalid")
+
+// rxUUID matches a uuid the way Postgres prints them, which is how they end up in cursors
+var rxUUID = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+
+// ListUsers returns a page of up to limit of the organization's own users matching the filter, oldest first. Along
+// with them it returns the cursor to pass back in for the next page, which is empty on the last one; an empty cursor
+// starts from the first page. Pages are keyed on (created_at, uuid) rather than an offset, so users signing up or
+// being deleted while someone pages through never shifts a user onto a page they've already seen.
+func (d *DatabaseConnection) ListUsers(organizationUUID string, filter UserFilter, cursor string, limit int) ([]models.User, string, error) {
+	users := []models.User{}
+
+	queryBody := []string{"organization_uuid=$1"}
+	args := []interface{}{organizationUUID}
+	argCount := 1
+
+	if !filter.IncludeDeleted {
+		queryBody = append(queryBody, "deleted_at IS NULL")
+	}
+
+	if filter.Search != "" {
+		argCount++
+		queryBody = append(queryBody, fmt.Sprintf("(email ILIKE $%v OR name ILIKE $%v)", argCount, argCount))
+		args = append(args, "%"+escapeLike(filter.Search)+"%")
+	}
+
+	if filter.EmailPrefix != "" {
+		argCount++
+		queryBody = append(queryBody, fmt.Sprintf("email ILIKE $%v", argCount))
+		args = append(args, escapeLike(filter.EmailPrefix)+"%")
+	}
+
+	if filter.Name != "" {
+		argCount++
+		queryBody = append(queryBody, fmt.Sprintf("name ILIKE $%v", argCount))
+		args = append(args, "%"+escapeLike(filter.Name)+"%")
+	}
+
+	if filter.CreatedAfter != nil {
+		argCount++
+		queryBody = append(queryBody, fmt.Sprintf("created_at >= $%v", argCount))
+		args = append(args, *filter.CreatedAfter)
+	}
+
+	if filter.CreatedBefore != nil {
+		argCount++
+		queryBody = append(queryBody, fmt.Sprintf("created_at < $%v", argCount))
+		args = append(args, *filter.CreatedBefore)
+	}
+
+	if cursor != "" {
+		createdAt, uuid, err := decodeCursor(cursor)
+		if err != nil {
+			return users, "", err
+		}
+
+		argCount += 2
+		queryBody = append(queryBody, fmt.Sprintf("(created_at, uuid) > ($%v, $%v)", argCount-1, argCount))
+		args = append(args, createdAt, uuid)
+	}
+
+	// Ask for one more than the page holds, if it comes back there's another page
+	argCount++
+	args = append(args, limit+1)
+
+	// Query the records
+	rows, err := d.Connection.Query(fmt.Sprintf(queries["list_users"], strings.Join(queryBody, " AND "), argCount), args...)
+	if err != nil {
+		return users, "", err
+	}
+
+	// Scan off the results to return to the client
+	for rows.Next() {
+		user := models.User{}
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.VerifiedAt, &user.FailedLoginCount, &user.LockedUntil)
+		if err != nil {
+			return users, "", err
+		}
+		users = append(users, user)
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return users, "", err
+	}
+
+	if len(users) <= limit || limit < 1 {
+		return users, "", nil
+	}
+
+	users = users[:limit]
+	last := users[limit-1]
+	return users, encodeCursor(last.CreatedAt, last.UUID), nil
+}
+
+// encodeCursor makes an opaque cursor pointing just past the user with the given created_at and uuid
+func encodeCursor(createdAt time.Time, uuid string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + uuid))
+}
+
+// decodeCursor reads back the created_at and uuid encodeCursor put in a cursor
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || !rxUUID.MatchString(parts[1]) {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return createdAt, parts[1], nil
+}
+
+// ListRolesByMembership returns the names of the roles the user has been given in the organization
+func (d *DatabaseConnection) ListRolesByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return d.listNames(queries["list_roles_by_membership"], organizationUUID, userUUID)
+}
+
+// ListPermissionsByMembership returns every permission the user has in the organization through any of their roles
+func (d *DatabaseConnection) ListPermissionsByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return d.listNames(queries["list_permissions_by_membership"], organizationUUID, userUUID)
+}
+
+// ListPermissionsByRoles returns every permission any of the roles grant
+func (d *DatabaseConnection) ListPermissionsByRoles(roles []string) ([]string, error) {
+	return d.listNames(queries["list_permissions_by_roles"], pq.Array(roles))
+}
+
+// ListGlobalRoles returns which of the roles are global, meaning they're for the whole deployment rather than the
+// organization they're held in
+func (d *DatabaseConnection) ListGlobalRoles(roles []string) ([]string, error) {
+	return d.listNames(queries["list_global_roles"], pq.Array(roles))
+}
+
+// SetMembershipRoles replaces the user's roles in the organization with the given ones, which shouldn't repeat. The
+// user has to be a member. Nothing changes if any of the roles don't exist, then 0 is returned rather than the
+// number of roles set.
+func (d *DatabaseConnection) SetMembershipRoles(organizationUUID, userUUID string, roles []string) (int, error) {
+	tx, err := d.Connection.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Clear out the old roles
+	_, err = tx.Exec(queries["delete_user_roles_by_membership"], organizationUUID, userUUID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// Roles that don't exist are skipped by the insert, so they show up as missing rows
+	result, err := tx.Exec(queries["create_user_roles"], organizationUUID, userUUID, pq.Array(roles), time.Now())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil || int(numRows) != len(roles) {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// CreateOrganization creates an organization, an empty one is returned if the slug is taken
+func (d *DatabaseConnection) CreateOrganization(slug, name string) (models.Organization, error) {
+	organization := models.Organization{}
+
+	// Insert the record
+	rows, err := d.Connection.Query(queries["create_organization"], slug, name, time.Now())
+	if err != nil {
+		return organization, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&organization.UUID, &organization.Slug, &organization.Name, &organization.CreatedAt)
+		if err != nil {
+			return organization, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return organization, err
+	}
+
+	return organization, nil
+}
+
+func (d *DatabaseConnection) GetOrganizationBySlug(slug string) (models.Organization, error) {
+	organization := models.Organization{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_organization_by_slug"], slug)
+	if err != nil {
+		return organization, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&organization.UUID, &organization.Slug, &organization.Name, &organization.CreatedAt)
+		if err != nil {
+			return organization, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return organization, err
+	}
+
+	return organization, nil
+}
+
+// GetOrganizationUserByUUID finds one of the organization's own users, users of other organizations aren't
+// returned even if they're members of it
+func (d *DatabaseConnection) GetOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error) {
+	user := models.User{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_organization_user_by_uuid"], organizationUUID, uuid)
+	if err != nil {
+		return user, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.VerifiedAt, &user.FailedLoginCount, &user.LockedUntil, &user.Password)
+		if err != nil {
+			return user, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// GetDeletedOrganizationUserByUUID finds one of the organization's own users that has been soft deleted, live users
+// aren't returned
+func (d *DatabaseConnection) GetDeletedOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error) {
+	user := models.User{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_deleted_organization_user_by_uuid"], organizationUUID, uuid)
+	if err != nil {
+		return user, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.VerifiedAt)
+		if err != nil {
+			return user, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// RestoreUserByUUID undoes the soft delete of one of the organization's own users, as long as they were deleted
+// after deletedAfter and nobody has signed up with their email address since. Otherwise an empty user is returned.
+func (d *DatabaseConnection) RestoreUserByUUID(organizationUUID, uuid string, deletedAfter time.Time) (models.User, error) {
+	user := models.User{}
+
+	// Update the record
+	rows, err := d.Connection.Query(queries["restore_user_by_uuid"], time.Now(), organizationUUID, uuid, deletedAfter)
+	if err != nil {
+		return user, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&user.UUID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.VerifiedAt)
+		if err != nil {
+			return user, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// PurgeDeletedUsers hard deletes up to limit users who were soft deleted before deletedBefore, along with everything
+// of theirs, returning how many were purged
+func (d *DatabaseConnection) PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error) {
+	result, err := d.Connection.Exec(queries["purge_deleted_users"], deletedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// CreateMembership lets a user act in another organization, it only affects a row if they weren't already a member
+func (d *DatabaseConnection) CreateMembership(organizationUUID, userUUID string) (int, error) {
+	// Insert the record
+	result, err := d.Connection.Exec(queries["create_membership"], organizationUUID, userUUID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+func (d *DatabaseConnection) GetMembership(organizationUUID, userUUID string) (models.Membership, error) {
+	membership := models.Membership{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_membership"], organizationUUID, userUUID)
+	if err != nil {
+		return membership, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&membership.OrganizationUUID, &membership.UserUUID, &membership.CreatedAt)
+		if err != nil {
+			return membership, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return membership, err
+	}
+
+	return membership, nil
+}
+
+// PurgeEndedSessions hard deletes up to limit sessions that expired or were logged out before endedBefore, along
+// with their refresh tokens, returning how many were purged
+func (d *DatabaseConnection) PurgeEndedSessions(endedBefore time.Time, limit int) (int, error) {
+	result, err := d.Connection.Exec(queries["purge_ended_sessions"], endedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+// TryAdvisoryLock takes the session level advisory lock key without waiting for it, reporting whether it was taken.
+// The lock is held on a connection of its own until unlock is called, and is let go by Postgres if we lose that
+// connection, so a replica that dies holding it doesn't hold it forever.
+func (d *DatabaseConnection) TryAdvisoryLock(key int64) (func() error, bool, error) {
+	ctx := context.Background()
+	conn, err := d.Connection.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	locked := false
+	err = conn.QueryRowContext(ctx, queries["try_advisory_lock"], key).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, false, err
+	}
+
+	unlock := func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(ctx, queries["advisory_unlock"], key)
+		return err
+	}
+
+	return unlock, true, nil
+}
+
+// RecordJobRun keeps the latest run of a job, replacing the one before it
+func (d *DatabaseConnection) RecordJobRun(run models.JobRun) (int, error) {
+	result, err := d.Connection.Exec(queries["record_job_run"], run.Name, run.Host, run.StartedAt, run.FinishedAt, run.Processed, run.Error)
+	if err != nil {
+		return 0, err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(numRows), nil
+}
+
+func (d *DatabaseConnection) GetJobRun(name string) (models.JobRun, error) {
+	run := models.JobRun{}
+
+	// Query the record
+	rows, err := d.Connection.Query(queries["get_job_run"], name)
+	if err != nil {
+		return run, err
+	}
+
+	// Scan off the result to return to the client
+	for rows.Next() {
+		err := rows.Scan(&run.Name, &run.Host, &run.StartedAt, &run.FinishedAt, &run.Processed, &run.Error)
+		if err != nil {
+			return run, err
+		}
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return run, err
+	}
+
+	return run, nil
+}
+
+func (d *DatabaseConnection) ListJobRuns() ([]models.JobRun, error) {
+	runs := []models.JobRun{}
+
+	// Query the records
+	rows, err := d.Connection.Query(queries["list_job_runs"])
+	if err != nil {
+		return runs, err
+	}
+
+	// Scan off the results to return to the client
+	for rows.Next() {
+		run := models.JobRun{}
+		err := rows.Scan(&run.Name, &run.Host, &run.StartedAt, &run.FinishedAt, &run.Processed, &run.Error)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return runs, err
+	}
+
+	return runs, nil
+}
+
+// listNames runs a query for a single text column and returns every row
+func (d *DatabaseConnection) listNames(query string, args ...interface{}) ([]string, error) {
+	names := []string{}
+
+	// Query the records
+	rows, err := d.Connection.Query(query, args...)
+	if err != nil {
+		return names, err
+	}
+
+	// Scan off the results to return to the client
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+
+	// Check to make sure there were no errors during scan
+	err = rows.Err()
+	if err != nil {
+		return names, err
+	}
+
+	return names, nil
+}
+
+// escapeLike escapes the characters that mean something to LIKE, so a search only matches what was typed
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+var queries = map[string]string{
+	"create_user":                              "with created as (insert into users (organization_uuid, email, name, password, created_at, updated_at) values ($1, $2, $3, $4, $5, $5) returning uuid, organization_uuid, email, name, created_at, updated_at), membership as (insert into memberships (organization_uuid, user_uuid, created_at) select organization_uuid, uuid, created_at FROM created) select uuid, email, name, created_at, updated_at FROM created;",
+	"create_session":                           "insert into sessions (user_uuid, organization_uuid, created_at, expires_at, user_agent, ip_address, last_seen_at) values ($1, $2, $3, $4, $5, $6, $3) returning uuid, user_uuid, organization_uuid, created_at, expires_at, user_agent, ip_address, last_seen_at;",
+	"update_user_by_uuid":                      "update users set %v returning uuid, email, name, created_at, updated_at, verified_at;",
+	"soft_delete_user_by_uuid":                 "update users set deleted_at=$1, updated_at=$1 where uuid=$2 returning uuid, email, name, created_at, updated_at, deleted_at;",
+	"rehash_user_password_by_uuid":             "update users set password=$1 where uuid=$2 AND password=$3 AND deleted_at IS NULL;",
+	"create_password_history":                  "insert into password_history (user_uuid, password, created_at) values ($1, $2, $3);",
+	"get_password_history_by_user_uuid":        "select password FROM password_history WHERE user_uuid=$1 ORDER BY created_at DESC LIMIT $2;",
+	"record_failed_login_by_uuid":              "update users set failed_login_count=failed_login_count+1 where uuid=$1 returning failed_login_count;",
+	"lock_user_by_uuid":                        "update users set locked_until=$1 where uuid=$2;",
+	"reset_failed_logins_by_uuid":              "update users set failed_login_count=0, locked_until=NULL where uuid=$1 AND (failed_login_count > 0 OR locked_until IS NOT NULL);",
+	"take_rate_limit_token":                    "with previous as (select tokens, updated_at FROM rate_limits WHERE key=$1 FOR UPDATE), refilled as (select least($2::double precision, coalesce((select tokens + extract(epoch from $4::timestamptz - updated_at) * $3 FROM previous), $2)) as tokens), taken as (insert into rate_limits (key, tokens, updated_at) select $1, case when tokens >= 1 then tokens - 1 else tokens end, $4 FROM refilled on conflict (key) do update set tokens=excluded.tokens, updated_at=excluded.updated_at returning key) select refilled.tokens FROM refilled, taken;",
+	"purge_rate_limits":                        "delete from rate_limits where key IN (select key FROM rate_limits WHERE left(key, length($1)) = $1 AND tokens + extract(epoch from $4::timestamptz - updated_at) * $3 >= $2 LIMIT $5);",
+	"create_client_session":                    "insert into sessions (user_uuid, organization_uuid, created_at, expires_at, user_agent, ip_address, last_seen_at, client_id, scope) select uuid, organization_uuid, $2, $3, $4, $5, $2, $6, $7 FROM users WHERE uuid=$1 returning uuid, user_uuid, organization_uuid, created_at, expires_at, user_agent, ip_address, last_seen_at, client_id, scope;",
+	"soft_delete_session_by_uuid":              "update sessions set deleted_at=$1 where uuid=$2 AND deleted_at IS NULL;",
+	"get_session_by_uuid":                      "select uuid, user_uuid, organization_uuid, created_at, expires_at, deleted_at, user_agent, ip_address, last_seen_at, client_id, scope FROM sessions WHERE uuid=$1 LIMIT 1;",
+	"list_active_sessions_by_user_uuid":        "select uuid, user_uuid, organization_uuid, created_at, expires_at, deleted_at, user_agent, ip_address, last_seen_at, client_id, scope FROM sessions WHERE user_uuid=$1 AND deleted_at IS NULL AND expires_at > $2 ORDER BY last_seen_at DESC;",
+	"touch_session_by_uuid":                    "update sessions set last_seen_at=$1 where uuid=$2 AND deleted_at IS NULL;",
+	"soft_delete_user_session_by_uuid":         "update sessions set deleted_at=$1 where uuid=$2 AND user_uuid=$3 AND deleted_at IS NULL;",
+	"soft_delete_sessions_by_user_uuid":        "update sessions set deleted_at=$1 where user_uuid=$2 AND deleted_at IS NULL;",
+	"get_user_by_uuid":                         "select uuid, email, name, created_at, updated_at, verified_at, failed_login_count, locked_until, password FROM users WHERE uuid=$1 AND deleted_at IS NULL LIMIT 1;",
+	"get_user_by_email":                        "select uuid, email, name, created_at, updated_at, verified_at, failed_login_count, locked_until, password FROM users WHERE organization_uuid=$1 AND email=$2 AND deleted_at IS NULL LIMIT 1;",
+	"create_refresh_token":                     "insert into refresh_tokens (session_uuid, token_hash, created_at, expires_at) values ($1, $2, $3, $4) returning uuid, session_uuid, created_at, expires_at;",
+	"get_refresh_token_by_hash":                "select uuid, session_uuid, created_at, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash=$1 LIMIT 1;",
+	"create_verification_token":                "insert into verification_tokens (user_uuid, email, token_hash, created_at, expires_at) values ($1, $2, $3, $4, $5) returning uuid, user_uuid, email, created_at, expires_at;",
+	"get_verification_token_by_hash":           "select uuid, user_uuid, email, created_at, expires_at, used_at FROM verification_tokens WHERE token_hash=$1 LIMIT 1;",
+	"use_verification_token_by_uuid":           "update verification_tokens set used_at=$1 where uuid=$2 AND used_at IS NULL;",
+	"verify_user_by_uuid":                      "update users set verified_at=$1 where uuid=$2 AND email=$3 AND deleted_at IS NULL;",
+	"create_password_reset":                    "insert into password_resets (user_uuid, token_hash, created_at, expires_at) values ($1, $2, $3, $4) returning uuid, user_uuid, created_at, expires_at;",
+	"get_password_reset_by_hash":               "select uuid, user_uuid, created_at, expires_at, used_at FROM password_resets WHERE token_hash=$1 LIMIT 1;",
+	"use_password_reset_by_uuid":               "update password_resets set used_at=$1 where uuid=$2 AND used_at IS NULL;",
+	"upsert_totp_credential":                   "insert into totp_credentials (user_uuid, secret, created_at) values ($1, $2, $3) on conflict (user_uuid) do update set secret=excluded.secret, created_at=excluded.created_at, last_used_step=0 where totp_credentials.confirmed_at IS NULL;",
+	"get_totp_credential_by_user_uuid":         "select user_uuid, secret, created_at, confirmed_at, last_used_step FROM totp_credentials WHERE user_uuid=$1 LIMIT 1;",
+	"confirm_totp_credential":                  "update totp_credentials set confirmed_at=$1, last_used_step=$2 where user_uuid=$3 AND confirmed_at IS NULL AND last_used_step < $2;",
+	"use_totp_step":                            "update totp_credentials set last_used_step=$1 where user_uuid=$2 AND confirmed_at IS NOT NULL AND last_used_step < $1;",
+	"delete_recovery_codes_by_user_uuid":       "delete from recovery_codes where user_uuid=$1;",
+	"create_recovery_code":                     "insert into recovery_codes (user_uuid, code_hash, created_at) values ($1, $2, $3);",
+	"use_recovery_code":                        "update recovery_codes set used_at=$1 where user_uuid=$2 AND code_hash=$3 AND used_at IS NULL;",
+	"create_mfa_challenge":                     "insert into mfa_challenges (user_uuid, organization_uuid, token_hash, created_at, expires_at) values ($1, $2, $3, $4, $5) returning uuid, user_uuid, organization_uuid, created_at, expires_at;",
+	"get_mfa_challenge_by_hash":                "select uuid, user_uuid, organization_uuid, created_at, expires_at, used_at, attempts FROM mfa_challenges WHERE token_hash=$1 LIMIT 1;",
+	"attempt_mfa_challenge_by_uuid":            "update mfa_challenges set attempts=attempts+1 where uuid=$1 AND used_at IS NULL AND attempts < $2;",
+	"use_mfa_challenge_by_uuid":                "update mfa_challenges set used_at=$1 where uuid=$2 AND used_at IS NULL;",
+	"create_oauth_client":                      "insert into oauth_clients (client_id, secret_hash, name, redirect_uris, grant_types, scopes, created_at) values ($1, $2, $3, $4, $5, $6, $7) returning uuid, client_id, secret_hash, name, redirect_uris, grant_types, scopes, created_at;",
+	"get_oauth_client_by_client_id":            "select uuid, client_id, secret_hash, name, redirect_uris, grant_types, scopes, created_at FROM oauth_clients WHERE client_id=$1 AND deleted_at IS NULL LIMIT 1;",
+	"create_oauth_authorization_code":          "insert into oauth_authorization_codes (code_hash, client_id, user_uuid, redirect_uri, scope, code_challenge, code_challenge_method, nonce, created_at, expires_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning uuid, client_id, user_uuid, redirect_uri, scope, code_challenge, code_challenge_method, nonce, created_at, expires_at;",
+	"get_oauth_authorization_code_by_hash":     "select uuid, client_id, user_uuid, redirect_uri, scope, code_challenge, code_challenge_method, nonce, created_at, expires_at, used_at FROM oauth_authorization_codes WHERE code_hash=$1 LIMIT 1;",
+	"use_oauth_authorization_code_by_uuid":     "update oauth_authorization_codes set used_at=$1 where uuid=$2 AND used_at IS NULL;",
+	"create_webauthn_challenge":                "insert into webauthn_challenges (user_uuid, ceremony, challenge_hash, created_at, expires_at) values (NULLIF($1, '')::uuid, $2, $3, $4, $5) returning uuid, COALESCE(user_uuid::text, ''), ceremony, created_at, expires_at;",
+	"get_webauthn_challenge_by_hash":           "select uuid, COALESCE(user_uuid::text, ''), ceremony, created_at, expires_at, used_at FROM webauthn_challenges WHERE challenge_hash=$1 LIMIT 1;",
+	"use_webauthn_challenge_by_uuid":           "update webauthn_challenges set used_at=$1 where uuid=$2 AND used_at IS NULL;",
+	"create_webauthn_credential":               "insert into webauthn_credentials (user_uuid, credential_id, public_key, sign_count, name, created_at) values ($1, $2, $3, $4, $5, $6) on conflict (credential_id) do nothing returning uuid, user_uuid, credential_id, public_key, sign_count, name, created_at;",
+	"get_webauthn_credential_by_credential_id": "select uuid, user_uuid, credential_id, public_key, sign_count, name, created_at, last_used_at FROM webauthn_credentials WHERE credential_id=$1 LIMIT 1;",
+	"list_webauthn_credentials_by_user_uuid":   "select uuid, user_uuid, credential_id, public_key, sign_count, name, created_at, last_used_at FROM webauthn_credentials WHERE user_uuid=$1 ORDER BY created_at;",
+	"update_webauthn_sign_count_by_uuid":       "update webauthn_credentials set sign_count=$1, last_used_at=$2 where uuid=$3 AND sign_count=$4;",
+	"create_federated_login_state":             "insert into federated_login_states (provider, state_hash, code_verifier, created_at, expires_at) values ($1, $2, $3, $4, $5) returning uuid, provider, code_verifier, created_at, expires_at;",
+	"get_federated_login_state_by_hash":        "select uuid, provider, code_verifier, created_at, expires_at, used_at FROM federated_login_states WHERE state_hash=$1 LIMIT 1;",
+	"use_federated_login_state_by_uuid":        "update federated_login_states set used_at=$1 where uuid=$2 AND used_at IS NULL;",
+	"get_federated_identity":                   "select federated_identities.uuid, user_uuid, provider, subject, federated_identities.email, federated_identities.created_at FROM federated_identities JOIN users ON users.uuid=user_uuid WHERE provider=$1 AND subject=$2 AND users.deleted_at IS NULL LIMIT 1;",
+	"create_federated_identity":                "insert into federated_identities (user_uuid, provider, subject, email, created_at) values ($1, $2, $3, $4, $5) on conflict (provider, subject) do update set user_uuid=excluded.user_uuid, email=excluded.email, created_at=excluded.created_at where federated_identities.user_uuid IN (select uuid FROM users WHERE deleted_at IS NOT NULL) returning uuid, user_uuid, provider, subject, email, created_at;",
+	"create_federated_user":                    "with created as (insert into users (organization_uuid, email, name, password, created_at, updated_at, verified_at) values ($1, $2, $3, '', $4, $4, $5) on conflict (organization_uuid, email) WHERE deleted_at IS NULL do nothing returning uuid, organization_uuid, email, name, created_at, updated_at, verified_at), membership as (insert into memberships (organization_uuid, user_uuid, created_at) select organization_uuid, uuid, created_at FROM created) select uuid, email, name, created_at, updated_at, verified_at FROM created;",
+	"use_refresh_token_by_uuid":                "update refresh_tokens set used_at=$1 where uuid=$2 AND used_at IS NULL AND revoked_at IS NULL;",
+	"revoke_refresh_tokens_by_session_uuid":    "update refresh_tokens set revoked_at=$1 where session_uuid=$2 AND revoked_at IS NULL;",
+	"list_users":                               "select uuid, email, name, created_at, updated_at, deleted_at, verified_at, failed_login_count, locked_until FROM users WHERE %v ORDER BY created_at, uuid LIMIT $%v;",
+	"list_roles_by_membership":                 "select role_name FROM user_roles WHERE organization_uuid=$1 AND user_uuid=$2 ORDER BY role_name;",
+	"list_permissions_by_roles":                "select distinct permission_name FROM role_permissions WHERE role_name = ANY($1) ORDER BY permission_name;",
+	"list_global_roles":                        "select name FROM roles WHERE global AND name = ANY($1) ORDER BY name;",
+	"list_permissions_by_membership":           "select distinct permission_name FROM role_permissions JOIN user_roles USING (role_name) WHERE organization_uuid=$1 AND user_uuid=$2 ORDER BY permission_name;",
+	"delete_user_roles_by_membership":          "delete from user_roles where organization_uuid=$1 AND user_uuid=$2;",
+	"create_organization":                      "insert into organizations (slug, name, created_at) values ($1, $2, $3) on conflict (slug) do nothing returning uuid, slug, name, created_at;",
+	"get_organization_by_slug":                 "select uuid, slug, name, created_at FROM organizations WHERE slug=$1 LIMIT 1;",
+	"get_organization_user_by_uuid":            "select uuid, email, name, created_at, updated_at, verified_at, failed_login_count, locked_until, password FROM users WHERE organization_uuid=$1 AND uuid=$2 AND deleted_at IS NULL LIMIT 1;",
+	"get_deleted_organization_user_by_uuid":    "select uuid, email, name, created_at, updated_at, deleted_at, verified_at FROM users WHERE organization_uuid=$1 AND uuid=$2 AND deleted_at IS NOT NULL LIMIT 1;",
+	"restore_user_by_uuid":                     "update users set deleted_at=NULL, updated_at=$1 where organization_uuid=$2 AND uuid=$3 AND deleted_at > $4 AND NOT EXISTS (select 1 FROM users live WHERE live.organization_uuid=users.organization_uuid AND live.email=users.email AND live.deleted_at IS NULL) returning uuid, email, name, created_at, updated_at, verified_at;",
+	"purge_deleted_users":                      "delete from users where uuid IN (select uuid FROM users WHERE deleted_at < $1 LIMIT $2);",
+	"create_membership":                        "insert into memberships (organization_uuid, user_uuid, created_at) values ($1, $2, $3) on conflict do nothing;",
+	"get_membership":                           "select organization_uuid, user_uuid, created_at FROM memberships WHERE organization_uuid=$1 AND user_uuid=$2 LIMIT 1;",
+	"create_user_roles":                        "insert into user_roles (organization_uuid, user_uuid, role_name, created_at) select $1, $2, name, $4 FROM roles WHERE name = ANY($3);",
+	"purge_ended_sessions":                     "delete from sessions where uuid IN (select uuid FROM sessions WHERE expires_at < $1 OR deleted_at < $1 LIMIT $2);",
+	"try_advisory_lock":                        "select pg_try_advisory_lock($1);",
+	"advisory_unlock":                          "select pg_advisory_unlock($1);",
+	"record_job_run":                           "insert into job_runs (name, host, started_at, finished_at, processed, error) values ($1, $2, $3, $4, $5, $6) on conflict (name) do update set host = EXCLUDED.host, started_at = EXCLUDED.started_at, finished_at = EXCLUDED.finished_at, processed = EXCLUDED.processed, error = EXCLUDED.error;",
+	"get_job_run":                              "select name, host, started_at, finished_at, processed, error FROM job_runs WHERE name=$1 LIMIT 1;",
+	"list_job_runs":                            "select name, host, started_at, finished_at, processed, error FROM job_runs ORDER BY name;",
+}
+
+type DBMock struct{}
+
+func (d *DBMock) CreateUser(organizationUUID, email, name, plaintextPassword string) (models.User, error) {
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc"}, nil
+}
+
+func (d *DBMock) UpdateUserByUUID(uuid, email, name, plaintextPassword string, ifUpdatedAt *time.Time) (models.User, error) {
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc", UpdatedAt: time.Now()}, nil
+}
+
+func (d *DBMock) SoftDeleteUserByUUID(uuid string) (models.User, error) {
+	ct := time.Now()
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc", DeletedAt: &ct}, nil
+}
+
+func (d *DBMock) RehashUserPasswordByUUID(uuid, oldHash, newHash string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) GetPasswordHistoryByUserUUID(userUUID string, limit int) ([]string, error) {
+	return []string{}, nil
+}
+
+func (d *DBMock) RecordFailedLoginByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) LockUserByUUID(uuid string, until time.Time) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) ResetFailedLoginsByUUID(uuid string) (int, error) {
+	return 0, nil
+}
+
+func (d *DBMock) TakeRateLimitToken(key string, burst, refillPerSecond float64) (float64, error) {
+	return burst, nil
+}
+
+func (d *DBMock) PurgeRateLimits(prefix string, burst, refillPerSecond float64, limit int) (int, error) {
+	return 0, nil
+}
+
+func (d *DBMock) GetUserByUUID(uuid string) (models.User, error) {
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc"}, nil
+}
+
+func (d *DBMock) GetUserByEmail(organizationUUID, email string) (models.User, error) {
+	test, _ := password.HashAndSalt("test")
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc", Password: test}, nil
+}
+
+func (d *DBMock) CreateSession(userUUID, organizationUUID, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error) {
+	return models.Session{UUID: "abc", UserUUID: userUUID, OrganizationUUID: organizationUUID, UserAgent: userAgent, IPAddress: ipAddress, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetSessionByUUID(uuid string) (models.Session, error) {
+	return models.Session{UUID: "abc", UserUUID: "abc", OrganizationUUID: "abc", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (d *DBMock) ListActiveSessionsByUserUUID(userUUID string) ([]models.Session, error) {
+	return []models.Session{{UUID: "abc", UserUUID: userUUID}, {UUID: "def", UserUUID: userUUID}}, nil
+}
+
+func (d *DBMock) TouchSessionByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) SoftDeleteSessionByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) SoftDeleteUserSessionByUUID(userUUID, uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) SoftDeleteSessionsByUserUUID(userUUID string) (int, error) {
+	return 2, nil
+}
+
+func (d *DBMock) CreateRefreshToken(sessionUUID, tokenHash string, expiresAt time.Time) (models.RefreshToken, error) {
+	return models.RefreshToken{UUID: "abc", SessionUUID: sessionUUID, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	return models.RefreshToken{UUID: "abc", SessionUUID: "abc", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (d *DBMock) UseRefreshTokenByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) RevokeRefreshTokensBySessionUUID(sessionUUID string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) CreateVerificationToken(userUUID, email, tokenHash string, expiresAt time.Time) (models.VerificationToken, error) {
+	return models.VerificationToken{UUID: "abc", UserUUID: userUUID, Email: email, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetVerificationTokenByHash(tokenHash string) (models.VerificationToken, error) {
+	return models.VerificationToken{UUID: "abc", UserUUID: "abc", Email: "test@test.com", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (d *DBMock) UseVerificationTokenByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) VerifyUserByUUID(uuid, email string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) CreatePasswordReset(userUUID, tokenHash string, expiresAt time.Time) (models.PasswordReset, error) {
+	return models.PasswordReset{UUID: "abc", UserUUID: userUUID, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetPasswordResetByHash(tokenHash string) (models.PasswordReset, error) {
+	return models.PasswordReset{UUID: "abc", UserUUID: "abc", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (d *DBMock) UsePasswordResetByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) UpsertTOTPCredential(userUUID, secret string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) GetTOTPCredentialByUserUUID(userUUID string) (models.TOTPCredential, error) {
+	return models.TOTPCredential{}, nil
+}
+
+func (d *DBMock) ConfirmTOTPCredential(userUUID string, step int64, recoveryCodeHashes []string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) UseTOTPStep(userUUID string, step int64) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) UseRecoveryCode(userUUID, codeHash string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) CreateMFAChallenge(userUUID, organizationUUID, tokenHash string, expiresAt time.Time) (models.MFAChallenge, error) {
+	return models.MFAChallenge{UUID: "abc", UserUUID: userUUID, OrganizationUUID: organizationUUID, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetMFAChallengeByHash(tokenHash string) (models.MFAChallenge, error) {
+	return models.MFAChallenge{UUID: "abc", UserUUID: "abc", OrganizationUUID: "abc", ExpiresAt: time.Now().Add(time.Minute)}, nil
+}
+
+func (d *DBMock) AttemptMFAChallengeByUUID(uuid string, maxAttempts int) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) UseMFAChallengeByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) CreateWebAuthnChallenge(userUUID, ceremony, challengeHash string, expiresAt time.Time) (models.WebAuthnChallenge, error) {
+	return models.WebAuthnChallenge{UUID: "abc", UserUUID: userUUID, Ceremony: ceremony, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetWebAuthnChallengeByHash(challengeHash string) (models.WebAuthnChallenge, error) {
+	return models.WebAuthnChallenge{}, nil
+}
+
+func (d *DBMock) UseWebAuthnChallengeByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) CreateWebAuthnCredential(userUUID, credentialID string, publicKey []byte, signCount int64, name string) (models.WebAuthnCredential, error) {
+	return models.WebAuthnCredential{UUID: "abc", UserUUID: userUUID, CredentialID: credentialID, PublicKey: publicKey, SignCount: signCount, Name: name}, nil
+}
+
+func (d *DBMock) GetWebAuthnCredentialByCredentialID(credentialID string) (models.WebAuthnCredential, error) {
+	return models.WebAuthnCredential{}, nil
+}
+
+func (d *DBMock) ListWebAuthnCredentialsByUserUUID(userUUID string) ([]models.WebAuthnCredential, error) {
+	return []models.WebAuthnCredential{}, nil
+}
+
+func (d *DBMock) UpdateWebAuthnSignCountByUUID(uuid string, oldCount, newCount int64) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) CreateClientSession(userUUID, clientID, scope, userAgent, ipAddress string, expiresAt time.Time) (models.Session, error) {
+	return models.Session{UUID: "abc", UserUUID: userUUID, ClientID: clientID, Scope: scope, UserAgent: userAgent, IPAddress: ipAddress, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) CreateOAuthClient(clientID, secretHash, name string, redirectURIs, grantTypes, scopes []string) (models.OAuthClient, error) {
+	return models.OAuthClient{UUID: "abc", ClientID: clientID, SecretHash: secretHash, Name: name, RedirectURIs: redirectURIs, GrantTypes: grantTypes, Scopes: scopes}, nil
+}
+
+func (d *DBMock) GetOAuthClientByClientID(clientID string) (models.OAuthClient, error) {
+	return models.OAuthClient{}, nil
+}
+
+func (d *DBMock) CreateOAuthAuthorizationCode(clientID, userUUID, codeHash, redirectURI, scope, codeChallenge, codeChallengeMethod, nonce string, expiresAt time.Time) (models.OAuthAuthorizationCode, error) {
+	return models.OAuthAuthorizationCode{UUID: "abc", ClientID: clientID, UserUUID: userUUID, RedirectURI: redirectURI, Scope: scope, CodeChallenge: codeChallenge, CodeChallengeMethod: codeChallengeMethod, Nonce: nonce, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetOAuthAuthorizationCodeByHash(codeHash string) (models.OAuthAuthorizationCode, error) {
+	return models.OAuthAuthorizationCode{}, nil
+}
+
+func (d *DBMock) UseOAuthAuthorizationCodeByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) CreateFederatedLoginState(provider, stateHash, codeVerifier string, expiresAt time.Time) (models.FederatedLoginState, error) {
+	return models.FederatedLoginState{UUID: "abc", Provider: provider, CodeVerifier: codeVerifier, ExpiresAt: expiresAt}, nil
+}
+
+func (d *DBMock) GetFederatedLoginStateByHash(stateHash string) (models.FederatedLoginState, error) {
+	return models.FederatedLoginState{}, nil
+}
+
+func (d *DBMock) UseFederatedLoginStateByUUID(uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) GetFederatedIdentity(provider, subject string) (models.FederatedIdentity, error) {
+	return models.FederatedIdentity{}, nil
+}
+
+func (d *DBMock) CreateFederatedIdentity(userUUID, provider, subject, email string) (models.FederatedIdentity, error) {
+	return models.FederatedIdentity{UUID: "abc", UserUUID: userUUID, Provider: provider, Subject: subject, Email: email}, nil
+}
+
+func (d *DBMock) CreateFederatedUser(organizationUUID, email, name string, verified bool, provider, subject string) (models.User, error) {
+	return models.User{Email: email, Name: name, UUID: "abc"}, nil
+}
+
+func (d *DBMock) ListUsers(organizationUUID string, filter UserFilter, cursor string, limit int) ([]models.User, string, error) {
+	return []models.User{{Email: "test@test.com", Name: "Testy McTesterson", UUID: "abc"}}, "", nil
+}
+
+func (d *DBMock) ListRolesByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (d *DBMock) ListPermissionsByMembership(organizationUUID, userUUID string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (d *DBMock) ListPermissionsByRoles(roles []string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (d *DBMock) ListGlobalRoles(roles []string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (d *DBMock) SetMembershipRoles(organizationUUID, userUUID string, roles []string) (int, error) {
+	return len(roles), nil
+}
+
+func (d *DBMock) CreateOrganization(slug, name string) (models.Organization, error) {
+	return models.Organization{UUID: "abc", Slug: slug, Name: name}, nil
+}
+
+func (d *DBMock) GetOrganizationBySlug(slug string) (models.Organization, error) {
+	return models.Organization{UUID: "abc", Slug: slug, Name: "Testy Organization"}, nil
+}
+
+func (d *DBMock) GetOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error) {
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: uuid}, nil
+}
+
+func (d *DBMock) GetDeletedOrganizationUserByUUID(organizationUUID, uuid string) (models.User, error) {
+	deletedAt := time.Now()
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: uuid, DeletedAt: &deletedAt}, nil
+}
+
+func (d *DBMock) RestoreUserByUUID(organizationUUID, uuid string, deletedAfter time.Time) (models.User, error) {
+	return models.User{Email: "test@test.com", Name: "Testy McTesterson", UUID: uuid}, nil
+}
+
+func (d *DBMock) PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error) {
+	return 0, nil
+}
+
+func (d *DBMock) CreateMembership(organizationUUID, userUUID string) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) GetMembership(organizationUUID, userUUID string) (models.Membership, error) {
+	return models.Membership{OrganizationUUID: organizationUUID, UserUUID: userUUID}, nil
+}
+
+func (d *DBMock) PurgeEndedSessions(endedBefore time.Time, limit int) (int, error) {
+	return 0, nil
+}
+
+func (d *DBMock) TryAdvisoryLock(key int64) (func() error, bool, error) {
+	return func() error { return nil }, true, nil
+}
+
+func (d *DBMock) RecordJobRun(run models.JobRun) (int, error) {
+	return 1, nil
+}
+
+func (d *DBMock) GetJobRun(name string) (models.JobRun, error) {
+	return models.JobRun{}, nil
+}
+
+func (d *DBMock) ListJobRuns() ([]models.JobRun, error) {
+	return []models.JobRun{}, nil
 }
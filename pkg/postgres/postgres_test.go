@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
 	"reflect"
 	"regexp"
 	"testing"
@@ -91,13 +93,14 @@ func TestDatabaseConnection_CreateUser(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		mock.ExpectQuery(regexp.QuoteMeta(queries["create_user"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at"}).AddRow("abc", "test@test.com", "testy testerson", time.Now(), time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(queries["create_user"])).WithArgs("org", "test@test.com", "testy testerson", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at"}).AddRow("abc", "test@test.com", "testy testerson", time.Now(), time.Now()))
+		mock.ExpectExec(regexp.QuoteMeta(queries["create_password_history"])).WithArgs("abc", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
 
 		t.Run(tt.name, func(t *testing.T) {
 			d := &DatabaseConnection{
 				Connection: tt.fields.Connection,
 			}
-			got, err := d.CreateUser(tt.args.email, tt.args.name, tt.args.plaintextPassword)
+			got, err := d.CreateUser("org", tt.args.email, tt.args.name, tt.args.plaintextPassword)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DatabaseConnection.CreateUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -130,11 +133,14 @@ func TestDatabaseConnection_UpdateUserByUUID(t *testing.T) {
 		email             string
 		name              string
 		plaintextPassword string
+		ifUpdatedAt       *time.Time
 	}
 	tests := []struct {
 		name    string
 		fields  fields
 		args    args
+		query   string
+		rows    *sqlmock.Rows
 		want    models.User
 		wantErr bool
 	}{
@@ -149,6 +155,8 @@ func TestDatabaseConnection_UpdateUserByUUID(t *testing.T) {
 				name:              "testy testerson",
 				plaintextPassword: "completelytestpassword",
 			},
+			query: "update users set email=$1,verified_at=CASE WHEN email=$1 THEN verified_at END,name=$2,password=$3,updated_at=$4 where uuid=$5 AND deleted_at IS NULL returning uuid, email, name, created_at, updated_at, verified_at;",
+			rows:  sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "verified_at"}).AddRow("abc", "test@test.com", "testy testerson", currentTime, currentTime, nil),
 			want: models.User{
 				UUID:      "abc",
 				Email:     "test@test.com",
@@ -157,15 +165,32 @@ func TestDatabaseConnection_UpdateUserByUUID(t *testing.T) {
 				UpdatedAt: currentTime,
 			},
 		},
+		{
+			name: "stale conditional update",
+			fields: fields{
+				Connection: db,
+			},
+			args: args{
+				uuid:        "abc",
+				name:        "testy testerson",
+				ifUpdatedAt: &currentTime,
+			},
+			query: "update users set name=$1,updated_at=$2 where uuid=$3 AND deleted_at IS NULL AND updated_at=$4 returning uuid, email, name, created_at, updated_at, verified_at;",
+			rows:  sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "verified_at"}),
+			want:  models.User{},
+		},
 	}
 	for _, tt := range tests {
-		mock.ExpectQuery(regexp.QuoteMeta("update users set email=$1,name=$2,password=$3 where uuid=$4 AND deleted_at IS NULL returning uuid, email, name, created_at, updated_at;")).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at"}).AddRow("abc", "test@test.com", "testy testerson", currentTime, currentTime))
+		mock.ExpectQuery(regexp.QuoteMeta(tt.query)).WillReturnRows(tt.rows)
+		if tt.args.plaintextPassword != "" {
+			mock.ExpectExec(regexp.QuoteMeta(queries["create_password_history"])).WithArgs("abc", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
 
 		t.Run(tt.name, func(t *testing.T) {
 			d := &DatabaseConnection{
 				Connection: tt.fields.Connection,
 			}
-			got, err := d.UpdateUserByUUID(tt.args.uuid, tt.args.email, tt.args.name, tt.args.plaintextPassword)
+			got, err := d.UpdateUserByUUID(tt.args.uuid, tt.args.email, tt.args.name, tt.args.plaintextPassword, tt.args.ifUpdatedAt)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DatabaseConnection.UpdateUserByUUID() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -218,13 +243,13 @@ func TestDatabaseConnection_GetUserByEmail(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		mock.ExpectQuery(regexp.QuoteMeta(queries["get_user_by_email"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "password"}).AddRow("abc", "test@test.com", "testy testerson", currentTime, currentTime, "abc"))
+		mock.ExpectQuery(regexp.QuoteMeta(queries["get_user_by_email"])).WithArgs("org", "test@test.com").WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "verified_at", "failed_login_count", "locked_until", "password"}).AddRow("abc", "test@test.com", "testy testerson", currentTime, currentTime, nil, 0, nil, "abc"))
 
 		t.Run(tt.name, func(t *testing.T) {
 			d := &DatabaseConnection{
 				Connection: tt.fields.Connection,
 			}
-			got, err := d.GetUserByEmail(tt.args.email)
+			got, err := d.GetUserByEmail("org", tt.args.email)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DatabaseConnection.GetUserByEmail() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -276,7 +301,7 @@ func TestDatabaseConnection_GetUserByUUID(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		mock.ExpectQuery(regexp.QuoteMeta(queries["get_user_by_uuid"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "password"}).AddRow("abc", "test@test.com", "testy testerson", currentTime, currentTime, "abc"))
+		mock.ExpectQuery(regexp.QuoteMeta(queries["get_user_by_uuid"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "verified_at", "failed_login_count", "locked_until", "password"}).AddRow("abc", "test@test.com", "testy testerson", currentTime, currentTime, nil, 0, nil, "abc"))
 
 		t.Run(tt.name, func(t *testing.T) {
 			d := &DatabaseConnection{
@@ -364,6 +389,8 @@ func TestDatabaseConnection_CreateSession(t *testing.T) {
 	}
 	type args struct {
 		userUUID  string
+		userAgent string
+		ipAddress string
 		expiresAt time.Time
 	}
 	tests := []struct {
@@ -380,23 +407,29 @@ func TestDatabaseConnection_CreateSession(t *testing.T) {
 			},
 			args: args{
 				userUUID:  "abc",
+				userAgent: "curl/7.54.0",
+				ipAddress: "127.0.0.1",
 				expiresAt: currentTime,
 			},
 			want: models.Session{
-				UUID:      "abc",
-				UserUUID:  "abc",
-				CreatedAt: currentTime,
-				ExpiresAt: currentTime,
+				UUID:             "abc",
+				UserUUID:         "abc",
+				OrganizationUUID: "org",
+				CreatedAt:        currentTime,
+				ExpiresAt:        currentTime,
+				UserAgent:        "curl/7.54.0",
+				IPAddress:        "127.0.0.1",
+				LastSeenAt:       currentTime,
 			},
 		},
 	}
 	for _, tt := range tests {
-		mock.ExpectQuery(regexp.QuoteMeta(queries["create_session"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "created_at", "expires_at"}).AddRow("abc", "abc", currentTime, currentTime))
+		mock.ExpectQuery(regexp.QuoteMeta(queries["create_session"])).WithArgs("abc", "org", sqlmock.AnyArg(), currentTime, "curl/7.54.0", "127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "organization_uuid", "created_at", "expires_at", "user_agent", "ip_address", "last_seen_at"}).AddRow("abc", "abc", "org", currentTime, currentTime, "curl/7.54.0", "127.0.0.1", currentTime))
 		t.Run(tt.name, func(t *testing.T) {
 			d := &DatabaseConnection{
 				Connection: tt.fields.Connection,
 			}
-			got, err := d.CreateSession(tt.args.userUUID, tt.args.expiresAt)
+			got, err := d.CreateSession(tt.args.userUUID, "org", tt.args.userAgent, tt.args.ipAddress, tt.args.expiresAt)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DatabaseConnection.CreateSession() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -438,16 +471,20 @@ func TestDatabaseConnection_GetSessionByUUID(t *testing.T) {
 				uuid: "abc",
 			},
 			want: models.Session{
-				UUID:      "abc",
-				UserUUID:  "abc",
-				CreatedAt: currentTime,
-				ExpiresAt: currentTime,
-				DeletedAt: &currentTime,
+				UUID:             "abc",
+				UserUUID:         "abc",
+				OrganizationUUID: "org",
+				CreatedAt:        currentTime,
+				ExpiresAt:        currentTime,
+				DeletedAt:        &currentTime,
+				UserAgent:        "curl/7.54.0",
+				IPAddress:        "127.0.0.1",
+				LastSeenAt:       currentTime,
 			},
 		},
 	}
 	for _, tt := range tests {
-		mock.ExpectQuery(regexp.QuoteMeta(queries["get_session_by_uuid"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "created_at", "expires_at", "deleted_at"}).AddRow("abc", "abc", currentTime, currentTime, currentTime))
+		mock.ExpectQuery(regexp.QuoteMeta(queries["get_session_by_uuid"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "organization_uuid", "created_at", "expires_at", "deleted_at", "user_agent", "ip_address", "last_seen_at", "client_id", "scope"}).AddRow("abc", "abc", "org", currentTime, currentTime, currentTime, "curl/7.54.0", "127.0.0.1", currentTime, "", ""))
 		t.Run(tt.name, func(t *testing.T) {
 			d := &DatabaseConnection{
 				Connection: tt.fields.Connection,
@@ -511,3 +548,1262 @@ func TestDatabaseConnection_SoftDeleteSessionByUUID(t *testing.T) {
 		})
 	}
 }
+
+func TestDatabaseConnection_ListActiveSessionsByUserUUID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	currentTime := time.Now()
+
+	type fields struct {
+		Connection *sql.DB
+	}
+	type args struct {
+		userUUID string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []models.Session
+		wantErr bool
+	}{
+		{
+			name: "valid session list",
+			fields: fields{
+				Connection: db,
+			},
+			args: args{
+				userUUID: "abc",
+			},
+			want: []models.Session{
+				{UUID: "abc", UserUUID: "abc", OrganizationUUID: "org", CreatedAt: currentTime, ExpiresAt: currentTime, UserAgent: "curl/7.54.0", IPAddress: "127.0.0.1", LastSeenAt: currentTime},
+				{UUID: "def", UserUUID: "abc", OrganizationUUID: "org", CreatedAt: currentTime, ExpiresAt: currentTime, UserAgent: "Mozilla/5.0", IPAddress: "10.0.0.1", LastSeenAt: currentTime, ClientID: "mobile", Scope: "profile"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery(regexp.QuoteMeta(queries["list_active_sessions_by_user_uuid"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "organization_uuid", "created_at", "expires_at", "deleted_at", "user_agent", "ip_address", "last_seen_at", "client_id", "scope"}).
+			AddRow("abc", "abc", "org", currentTime, currentTime, nil, "curl/7.54.0", "127.0.0.1", currentTime, "", "").
+			AddRow("def", "abc", "org", currentTime, currentTime, nil, "Mozilla/5.0", "10.0.0.1", currentTime, "mobile", "profile"))
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DatabaseConnection{
+				Connection: tt.fields.Connection,
+			}
+			got, err := d.ListActiveSessionsByUserUUID(tt.args.userUUID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DatabaseConnection.ListActiveSessionsByUserUUID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DatabaseConnection.ListActiveSessionsByUserUUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseConnection_Updates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+
+	tests := []struct {
+		name  string
+		query string
+		rows  int64
+		call  func() (int, error)
+	}{
+		{
+			name:  "rehash a user's password",
+			query: queries["rehash_user_password_by_uuid"],
+			rows:  1,
+			call:  func() (int, error) { return d.RehashUserPasswordByUUID("abc", "old", "new") },
+		},
+		{
+			name:  "lock a user",
+			query: queries["lock_user_by_uuid"],
+			rows:  1,
+			call:  func() (int, error) { return d.LockUserByUUID("abc", time.Now().Add(time.Minute)) },
+		},
+		{
+			name:  "reset failed logins that are already clear",
+			query: queries["reset_failed_logins_by_uuid"],
+			rows:  0,
+			call:  func() (int, error) { return d.ResetFailedLoginsByUUID("abc") },
+		},
+		{
+			name:  "touch session",
+			query: queries["touch_session_by_uuid"],
+			rows:  1,
+			call:  func() (int, error) { return d.TouchSessionByUUID("abc") },
+		},
+		{
+			name:  "soft delete another user's session",
+			query: queries["soft_delete_user_session_by_uuid"],
+			rows:  0,
+			call:  func() (int, error) { return d.SoftDeleteUserSessionByUUID("def", "abc") },
+		},
+		{
+			name:  "soft delete every session for a user",
+			query: queries["soft_delete_sessions_by_user_uuid"],
+			rows:  3,
+			call:  func() (int, error) { return d.SoftDeleteSessionsByUserUUID("abc") },
+		},
+	}
+	for _, tt := range tests {
+		mock.ExpectExec(regexp.QuoteMeta(tt.query)).WillReturnResult(sqlmock.NewResult(0, tt.rows))
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.call()
+			if err != nil {
+				t.Errorf("%v error = %v", tt.name, err)
+				return
+			}
+			if got != int(tt.rows) {
+				t.Errorf("%v = %v, want %v", tt.name, got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestDatabaseConnection_CreateRefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	currentTime := time.Now()
+
+	type fields struct {
+		Connection *sql.DB
+	}
+	type args struct {
+		sessionUUID string
+		tokenHash   string
+		expiresAt   time.Time
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    models.RefreshToken
+		wantErr bool
+	}{
+		{
+			name: "valid refresh token create",
+			fields: fields{
+				Connection: db,
+			},
+			args: args{
+				sessionUUID: "abc",
+				tokenHash:   "abc",
+				expiresAt:   currentTime,
+			},
+			want: models.RefreshToken{
+				UUID:        "abc",
+				SessionUUID: "abc",
+				CreatedAt:   currentTime,
+				ExpiresAt:   currentTime,
+			},
+		},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery(regexp.QuoteMeta(queries["create_refresh_token"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "session_uuid", "created_at", "expires_at"}).AddRow("abc", "abc", currentTime, currentTime))
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DatabaseConnection{
+				Connection: tt.fields.Connection,
+			}
+			got, err := d.CreateRefreshToken(tt.args.sessionUUID, tt.args.tokenHash, tt.args.expiresAt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DatabaseConnection.CreateRefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DatabaseConnection.CreateRefreshToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseConnection_GetRefreshTokenByHash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	currentTime := time.Now()
+
+	type fields struct {
+		Connection *sql.DB
+	}
+	type args struct {
+		tokenHash string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    models.RefreshToken
+		wantErr bool
+	}{
+		{
+			name: "valid used refresh token",
+			fields: fields{
+				Connection: db,
+			},
+			args: args{
+				tokenHash: "abc",
+			},
+			want: models.RefreshToken{
+				UUID:        "abc",
+				SessionUUID: "abc",
+				CreatedAt:   currentTime,
+				ExpiresAt:   currentTime,
+				UsedAt:      &currentTime,
+			},
+		},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery(regexp.QuoteMeta(queries["get_refresh_token_by_hash"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "session_uuid", "created_at", "expires_at", "used_at", "revoked_at"}).AddRow("abc", "abc", currentTime, currentTime, currentTime, nil))
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DatabaseConnection{
+				Connection: tt.fields.Connection,
+			}
+			got, err := d.GetRefreshTokenByHash(tt.args.tokenHash)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DatabaseConnection.GetRefreshTokenByHash() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DatabaseConnection.GetRefreshTokenByHash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseConnection_UseRefreshTokenByUUID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	type fields struct {
+		Connection *sql.DB
+	}
+	type args struct {
+		uuid string
+	}
+	tests := []struct {
+		name         string
+		fields       fields
+		args         args
+		rowsAffected int64
+		want         int
+		wantErr      bool
+	}{
+		{
+			name: "unused refresh token",
+			fields: fields{
+				Connection: db,
+			},
+			args: args{
+				uuid: "abc",
+			},
+			rowsAffected: 1,
+			want:         1,
+		},
+		{
+			name: "already used refresh token",
+			fields: fields{
+				Connection: db,
+			},
+			args: args{
+				uuid: "abc",
+			},
+			rowsAffected: 0,
+			want:         0,
+		},
+	}
+	for _, tt := range tests {
+		mock.ExpectExec(regexp.QuoteMeta(queries["use_refresh_token_by_uuid"])).WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DatabaseConnection{
+				Connection: tt.fields.Connection,
+			}
+			got, err := d.UseRefreshTokenByUUID(tt.args.uuid)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DatabaseConnection.UseRefreshTokenByUUID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("DatabaseConnection.UseRefreshTokenByUUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseConnection_RevokeRefreshTokensBySessionUUID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	type fields struct {
+		Connection *sql.DB
+	}
+	type args struct {
+		sessionUUID string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "valid refresh token revoke",
+			fields: fields{
+				Connection: db,
+			},
+			args: args{
+				sessionUUID: "abc",
+			},
+			want: 2,
+		},
+	}
+	for _, tt := range tests {
+		mock.ExpectExec(regexp.QuoteMeta(queries["revoke_refresh_tokens_by_session_uuid"])).WillReturnResult(sqlmock.NewResult(0, 2))
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DatabaseConnection{
+				Connection: tt.fields.Connection,
+			}
+			got, err := d.RevokeRefreshTokensBySessionUUID(tt.args.sessionUUID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DatabaseConnection.RevokeRefreshTokensBySessionUUID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("DatabaseConnection.RevokeRefreshTokensBySessionUUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseConnection_VerificationTokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_verification_token"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "email", "created_at", "expires_at"}).AddRow("abc", "def", "test@test.com", currentTime, currentTime))
+	created, err := d.CreateVerificationToken("def", "test@test.com", "hash", currentTime)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateVerificationToken() error = %v", err)
+	}
+	want := models.VerificationToken{UUID: "abc", UserUUID: "def", Email: "test@test.com", CreatedAt: currentTime, ExpiresAt: currentTime}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateVerificationToken() = %v, want %v", created, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_verification_token_by_hash"])).WithArgs("hash").WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "email", "created_at", "expires_at", "used_at"}).AddRow("abc", "def", "test@test.com", currentTime, currentTime, currentTime))
+	found, err := d.GetVerificationTokenByHash("hash")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetVerificationTokenByHash() error = %v", err)
+	}
+	want.UsedAt = &currentTime
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetVerificationTokenByHash() = %v, want %v", found, want)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["use_verification_token_by_uuid"])).WillReturnResult(sqlmock.NewResult(0, 0))
+	used, err := d.UseVerificationTokenByUUID("abc")
+	if err != nil || used != 0 {
+		t.Errorf("DatabaseConnection.UseVerificationTokenByUUID() = %v, %v, want 0, nil", used, err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["verify_user_by_uuid"])).WithArgs(sqlmock.AnyArg(), "def", "test@test.com").WillReturnResult(sqlmock.NewResult(0, 1))
+	verified, err := d.VerifyUserByUUID("def", "test@test.com")
+	if err != nil || verified != 1 {
+		t.Errorf("DatabaseConnection.VerifyUserByUUID() = %v, %v, want 1, nil", verified, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_PasswordResets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_password_reset"])).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "created_at", "expires_at"}).AddRow("abc", "def", currentTime, currentTime))
+	created, err := d.CreatePasswordReset("def", "hash", currentTime)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreatePasswordReset() error = %v", err)
+	}
+	want := models.PasswordReset{UUID: "abc", UserUUID: "def", CreatedAt: currentTime, ExpiresAt: currentTime}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreatePasswordReset() = %v, want %v", created, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_password_reset_by_hash"])).WithArgs("hash").WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "created_at", "expires_at", "used_at"}).AddRow("abc", "def", currentTime, currentTime, nil))
+	found, err := d.GetPasswordResetByHash("hash")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetPasswordResetByHash() error = %v", err)
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetPasswordResetByHash() = %v, want %v", found, want)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["use_password_reset_by_uuid"])).WithArgs(sqlmock.AnyArg(), "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	used, err := d.UsePasswordResetByUUID("abc")
+	if err != nil || used != 1 {
+		t.Errorf("DatabaseConnection.UsePasswordResetByUUID() = %v, %v, want 1, nil", used, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_GetPasswordHistoryByUserUUID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_password_history_by_user_uuid"])).WithArgs("abc", 3).WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow("newest").AddRow("older"))
+	got, err := d.GetPasswordHistoryByUserUUID("abc", 3)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetPasswordHistoryByUserUUID() error = %v", err)
+	}
+
+	if want := []string{"newest", "older"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DatabaseConnection.GetPasswordHistoryByUserUUID() = %v, want %v", got, want)
+	}
+}
+
+func TestDatabaseConnection_RecordFailedLoginByUUID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["record_failed_login_by_uuid"])).WithArgs("abc").WillReturnRows(sqlmock.NewRows([]string{"failed_login_count"}).AddRow(4))
+	got, err := d.RecordFailedLoginByUUID("abc")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.RecordFailedLoginByUUID() error = %v", err)
+	}
+
+	if got != 4 {
+		t.Errorf("DatabaseConnection.RecordFailedLoginByUUID() = %v, want %v", got, 4)
+	}
+}
+
+func TestDatabaseConnection_TakeRateLimitToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["take_rate_limit_token"])).WithArgs("login:ip:127.0.0.1", 10.0, 0.5, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"tokens"}).AddRow(0.25))
+	got, err := d.TakeRateLimitToken("login:ip:127.0.0.1", 10, 0.5)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.TakeRateLimitToken() error = %v", err)
+	}
+
+	if got != 0.25 {
+		t.Errorf("DatabaseConnection.TakeRateLimitToken() = %v, want %v", got, 0.25)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["purge_rate_limits"])).WithArgs("login:ip:", 10.0, 0.5, sqlmock.AnyArg(), 100).WillReturnResult(sqlmock.NewResult(0, 3))
+	purged, err := d.PurgeRateLimits("login:ip:", 10, 0.5, 100)
+	if err != nil || purged != 3 {
+		t.Errorf("DatabaseConnection.PurgeRateLimits() = %v, %v, want 3, nil", purged, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_TOTPCredentials(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["upsert_totp_credential"])).WithArgs("abc", "sealed", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	upserted, err := d.UpsertTOTPCredential("abc", "sealed")
+	if err != nil || upserted != 1 {
+		t.Errorf("DatabaseConnection.UpsertTOTPCredential() = %v, %v, want 1, nil", upserted, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_totp_credential_by_user_uuid"])).WithArgs("abc").WillReturnRows(sqlmock.NewRows([]string{"user_uuid", "secret", "created_at", "confirmed_at", "last_used_step"}).AddRow("abc", "sealed", currentTime, nil, 0))
+	found, err := d.GetTOTPCredentialByUserUUID("abc")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetTOTPCredentialByUserUUID() error = %v", err)
+	}
+	want := models.TOTPCredential{UserUUID: "abc", Secret: "sealed", CreatedAt: currentTime}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetTOTPCredentialByUserUUID() = %v, want %v", found, want)
+	}
+
+	// Confirming swaps the recovery codes in the same transaction
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries["confirm_totp_credential"])).WithArgs(sqlmock.AnyArg(), int64(42), "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queries["delete_recovery_codes_by_user_uuid"])).WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(regexp.QuoteMeta(queries["create_recovery_code"])).WithArgs("abc", "one", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queries["create_recovery_code"])).WithArgs("abc", "two", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	confirmed, err := d.ConfirmTOTPCredential("abc", 42, []string{"one", "two"})
+	if err != nil || confirmed != 1 {
+		t.Errorf("DatabaseConnection.ConfirmTOTPCredential() = %v, %v, want 1, nil", confirmed, err)
+	}
+
+	// An enrollment that's already confirmed leaves the recovery codes alone
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries["confirm_totp_credential"])).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	confirmed, err = d.ConfirmTOTPCredential("abc", 43, []string{"three"})
+	if err != nil || confirmed != 0 {
+		t.Errorf("DatabaseConnection.ConfirmTOTPCredential() when confirmed = %v, %v, want 0, nil", confirmed, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_MFAChallenges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_mfa_challenge"])).WithArgs("def", "org", "hash", sqlmock.AnyArg(), currentTime).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "organization_uuid", "created_at", "expires_at"}).AddRow("abc", "def", "org", currentTime, currentTime))
+	created, err := d.CreateMFAChallenge("def", "org", "hash", currentTime)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateMFAChallenge() error = %v", err)
+	}
+	want := models.MFAChallenge{UUID: "abc", UserUUID: "def", OrganizationUUID: "org", CreatedAt: currentTime, ExpiresAt: currentTime}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateMFAChallenge() = %v, want %v", created, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_mfa_challenge_by_hash"])).WithArgs("hash").WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "organization_uuid", "created_at", "expires_at", "used_at", "attempts"}).AddRow("abc", "def", "org", currentTime, currentTime, nil, 2))
+	found, err := d.GetMFAChallengeByHash("hash")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetMFAChallengeByHash() error = %v", err)
+	}
+	want.Attempts = 2
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetMFAChallengeByHash() = %v, want %v", found, want)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		rows  int64
+		call  func() (int, error)
+	}{
+		{
+			name:  "attempt a challenge that's out of attempts",
+			query: queries["attempt_mfa_challenge_by_uuid"],
+			rows:  0,
+			call:  func() (int, error) { return d.AttemptMFAChallengeByUUID("abc", 5) },
+		},
+		{
+			name:  "use a challenge",
+			query: queries["use_mfa_challenge_by_uuid"],
+			rows:  1,
+			call:  func() (int, error) { return d.UseMFAChallengeByUUID("abc") },
+		},
+		{
+			name:  "replay a TOTP step",
+			query: queries["use_totp_step"],
+			rows:  0,
+			call:  func() (int, error) { return d.UseTOTPStep("def", 42) },
+		},
+		{
+			name:  "use a recovery code",
+			query: queries["use_recovery_code"],
+			rows:  1,
+			call:  func() (int, error) { return d.UseRecoveryCode("def", "hash") },
+		},
+	}
+	for _, tt := range tests {
+		mock.ExpectExec(regexp.QuoteMeta(tt.query)).WillReturnResult(sqlmock.NewResult(0, tt.rows))
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.call()
+			if err != nil {
+				t.Errorf("%v error = %v", tt.name, err)
+				return
+			}
+			if got != int(tt.rows) {
+				t.Errorf("%v = %v, want %v", tt.name, got, tt.rows)
+			}
+		})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_WebAuthnChallenges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+
+	// Login challenges aren't bound to a user until the assertion comes back
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_webauthn_challenge"])).WithArgs("", "login", "hash", sqlmock.AnyArg(), currentTime).WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "ceremony", "created_at", "expires_at"}).AddRow("abc", "", "login", currentTime, currentTime))
+	created, err := d.CreateWebAuthnChallenge("", "login", "hash", currentTime)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateWebAuthnChallenge() error = %v", err)
+	}
+	want := models.WebAuthnChallenge{UUID: "abc", Ceremony: "login", CreatedAt: currentTime, ExpiresAt: currentTime}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateWebAuthnChallenge() = %v, want %v", created, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_webauthn_challenge_by_hash"])).WithArgs("hash").WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "ceremony", "created_at", "expires_at", "used_at"}).AddRow("abc", "def", "registration", currentTime, currentTime, currentTime))
+	found, err := d.GetWebAuthnChallengeByHash("hash")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetWebAuthnChallengeByHash() error = %v", err)
+	}
+	want = models.WebAuthnChallenge{UUID: "abc", UserUUID: "def", Ceremony: "registration", CreatedAt: currentTime, ExpiresAt: currentTime, UsedAt: &currentTime}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetWebAuthnChallengeByHash() = %v, want %v", found, want)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["use_webauthn_challenge_by_uuid"])).WithArgs(sqlmock.AnyArg(), "abc").WillReturnResult(sqlmock.NewResult(0, 0))
+	used, err := d.UseWebAuthnChallengeByUUID("abc")
+	if err != nil || used != 0 {
+		t.Errorf("DatabaseConnection.UseWebAuthnChallengeByUUID() on a used challenge = %v, %v, want 0, nil", used, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_WebAuthnCredentials(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+	columns := []string{"uuid", "user_uuid", "credential_id", "public_key", "sign_count", "name", "created_at", "last_used_at"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_webauthn_credential"])).WithArgs("def", "cred", []byte("key"), int64(0), "laptop", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(columns[:7]).AddRow("abc", "def", "cred", []byte("key"), 0, "laptop", currentTime))
+	created, err := d.CreateWebAuthnCredential("def", "cred", []byte("key"), 0, "laptop")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateWebAuthnCredential() error = %v", err)
+	}
+	want := models.WebAuthnCredential{UUID: "abc", UserUUID: "def", CredentialID: "cred", PublicKey: []byte("key"), Name: "laptop", CreatedAt: currentTime}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateWebAuthnCredential() = %v, want %v", created, want)
+	}
+
+	// A credential ID that's already registered comes back empty
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_webauthn_credential"])).WillReturnRows(sqlmock.NewRows(columns[:7]))
+	created, err = d.CreateWebAuthnCredential("ghi", "cred", []byte("key"), 0, "")
+	if err != nil || created.UUID != "" {
+		t.Errorf("DatabaseConnection.CreateWebAuthnCredential() on a duplicate = %v, %v, want empty, nil", created, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_webauthn_credential_by_credential_id"])).WithArgs("cred").WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", "def", "cred", []byte("key"), 7, "laptop", currentTime, currentTime))
+	found, err := d.GetWebAuthnCredentialByCredentialID("cred")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetWebAuthnCredentialByCredentialID() error = %v", err)
+	}
+	want.SignCount = 7
+	want.LastUsedAt = &currentTime
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetWebAuthnCredentialByCredentialID() = %v, want %v", found, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["list_webauthn_credentials_by_user_uuid"])).WithArgs("def").WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", "def", "cred", []byte("key"), 7, "laptop", currentTime, currentTime).AddRow("ghi", "def", "cred2", []byte("key2"), 0, "", currentTime, nil))
+	listed, err := d.ListWebAuthnCredentialsByUserUUID("def")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.ListWebAuthnCredentialsByUserUUID() error = %v", err)
+	}
+	if len(listed) != 2 || !reflect.DeepEqual(listed[0], want) || listed[1].CredentialID != "cred2" {
+		t.Errorf("DatabaseConnection.ListWebAuthnCredentialsByUserUUID() = %v", listed)
+	}
+
+	// The counter only moves on from the value the login was checked against
+	mock.ExpectExec(regexp.QuoteMeta(queries["update_webauthn_sign_count_by_uuid"])).WithArgs(int64(8), sqlmock.AnyArg(), "abc", int64(7)).WillReturnResult(sqlmock.NewResult(0, 0))
+	updated, err := d.UpdateWebAuthnSignCountByUUID("abc", 7, 8)
+	if err != nil || updated != 0 {
+		t.Errorf("DatabaseConnection.UpdateWebAuthnSignCountByUUID() after a race = %v, %v, want 0, nil", updated, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_CreateClientSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_client_session"])).WithArgs("abc", sqlmock.AnyArg(), currentTime, "okhttp/3.12", "127.0.0.1", "mobile", "profile").WillReturnRows(sqlmock.NewRows([]string{"uuid", "user_uuid", "organization_uuid", "created_at", "expires_at", "user_agent", "ip_address", "last_seen_at", "client_id", "scope"}).AddRow("def", "abc", "org", currentTime, currentTime, "okhttp/3.12", "127.0.0.1", currentTime, "mobile", "profile"))
+	got, err := d.CreateClientSession("abc", "mobile", "profile", "okhttp/3.12", "127.0.0.1", currentTime)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateClientSession() error = %v", err)
+	}
+	want := models.Session{UUID: "def", UserUUID: "abc", OrganizationUUID: "org", CreatedAt: currentTime, ExpiresAt: currentTime, UserAgent: "okhttp/3.12", IPAddress: "127.0.0.1", LastSeenAt: currentTime, ClientID: "mobile", Scope: "profile"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DatabaseConnection.CreateClientSession() = %v, want %v", got, want)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_OAuthClients(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+	columns := []string{"uuid", "client_id", "secret_hash", "name", "redirect_uris", "grant_types", "scopes", "created_at"}
+	want := models.OAuthClient{
+		UUID:         "abc",
+		ClientID:     "mobile",
+		Name:         "Mobile",
+		RedirectURIs: []string{"com.fender.app:/callback", "https://fender.com/callback"},
+		GrantTypes:   []string{"authorization_code", "refresh_token"},
+		Scopes:       []string{"profile"},
+		CreatedAt:    currentTime,
+	}
+
+	// Lists go in and come out as postgres arrays
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_oauth_client"])).WithArgs("mobile", "", "Mobile", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", "mobile", "", "Mobile", "{com.fender.app:/callback,https://fender.com/callback}", "{authorization_code,refresh_token}", "{profile}", currentTime))
+	created, err := d.CreateOAuthClient("mobile", "", "Mobile", want.RedirectURIs, want.GrantTypes, want.Scopes)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateOAuthClient() error = %v", err)
+	}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateOAuthClient() = %v, want %v", created, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_oauth_client_by_client_id"])).WithArgs("mobile").WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", "mobile", "", "Mobile", "{com.fender.app:/callback,https://fender.com/callback}", "{authorization_code,refresh_token}", "{profile}", currentTime))
+	found, err := d.GetOAuthClientByClientID("mobile")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetOAuthClientByClientID() error = %v", err)
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetOAuthClientByClientID() = %v, want %v", found, want)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_OAuthAuthorizationCodes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+	columns := []string{"uuid", "client_id", "user_uuid", "redirect_uri", "scope", "code_challenge", "code_challenge_method", "nonce", "created_at", "expires_at", "used_at"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_oauth_authorization_code"])).WithArgs("hash", "mobile", "def", "https://fender.com/callback", "profile", "challenge", "S256", "n-0S6_WzA2Mj", sqlmock.AnyArg(), currentTime).WillReturnRows(sqlmock.NewRows(columns[:10]).AddRow("abc", "mobile", "def", "https://fender.com/callback", "profile", "challenge", "S256", "n-0S6_WzA2Mj", currentTime, currentTime))
+	created, err := d.CreateOAuthAuthorizationCode("mobile", "def", "hash", "https://fender.com/callback", "profile", "challenge", "S256", "n-0S6_WzA2Mj", currentTime)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateOAuthAuthorizationCode() error = %v", err)
+	}
+	want := models.OAuthAuthorizationCode{UUID: "abc", ClientID: "mobile", UserUUID: "def", RedirectURI: "https://fender.com/callback", Scope: "profile", CodeChallenge: "challenge", CodeChallengeMethod: "S256", Nonce: "n-0S6_WzA2Mj", CreatedAt: currentTime, ExpiresAt: currentTime}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateOAuthAuthorizationCode() = %v, want %v", created, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_oauth_authorization_code_by_hash"])).WithArgs("hash").WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", "mobile", "def", "https://fender.com/callback", "profile", "challenge", "S256", "n-0S6_WzA2Mj", currentTime, currentTime, currentTime))
+	found, err := d.GetOAuthAuthorizationCodeByHash("hash")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetOAuthAuthorizationCodeByHash() error = %v", err)
+	}
+	want.UsedAt = &currentTime
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetOAuthAuthorizationCodeByHash() = %v, want %v", found, want)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["use_oauth_authorization_code_by_uuid"])).WithArgs(sqlmock.AnyArg(), "abc").WillReturnResult(sqlmock.NewResult(0, 0))
+	used, err := d.UseOAuthAuthorizationCodeByUUID("abc")
+	if err != nil || used != 0 {
+		t.Errorf("DatabaseConnection.UseOAuthAuthorizationCodeByUUID() on a used code = %v, %v, want 0, nil", used, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_FederatedLoginStates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_federated_login_state"])).WithArgs("google", "hash", "verifier", sqlmock.AnyArg(), currentTime).WillReturnRows(sqlmock.NewRows([]string{"uuid", "provider", "code_verifier", "created_at", "expires_at"}).AddRow("abc", "google", "verifier", currentTime, currentTime))
+	created, err := d.CreateFederatedLoginState("google", "hash", "verifier", currentTime)
+	if err != nil {
+		t.Fatalf("DatabaseConnection.CreateFederatedLoginState() error = %v", err)
+	}
+	want := models.FederatedLoginState{UUID: "abc", Provider: "google", CodeVerifier: "verifier", CreatedAt: currentTime, ExpiresAt: currentTime}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateFederatedLoginState() = %v, want %v", created, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_federated_login_state_by_hash"])).WithArgs("hash").WillReturnRows(sqlmock.NewRows([]string{"uuid", "provider", "code_verifier", "created_at", "expires_at", "used_at"}).AddRow("abc", "google", "verifier", currentTime, currentTime, currentTime))
+	found, err := d.GetFederatedLoginStateByHash("hash")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetFederatedLoginStateByHash() error = %v", err)
+	}
+	want.UsedAt = &currentTime
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetFederatedLoginStateByHash() = %v, want %v", found, want)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["use_federated_login_state_by_uuid"])).WithArgs(sqlmock.AnyArg(), "abc").WillReturnResult(sqlmock.NewResult(0, 0))
+	used, err := d.UseFederatedLoginStateByUUID("abc")
+	if err != nil || used != 0 {
+		t.Errorf("DatabaseConnection.UseFederatedLoginStateByUUID() on a used state = %v, %v, want 0, nil", used, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_FederatedIdentities(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+	columns := []string{"uuid", "user_uuid", "provider", "subject", "email", "created_at"}
+	userColumns := []string{"uuid", "email", "name", "created_at", "updated_at", "verified_at"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_federated_identity"])).WithArgs("google", "1234").WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", "def", "google", "1234", "test@test.com", currentTime))
+	found, err := d.GetFederatedIdentity("google", "1234")
+	if err != nil {
+		t.Fatalf("DatabaseConnection.GetFederatedIdentity() error = %v", err)
+	}
+	want := models.FederatedIdentity{UUID: "abc", UserUUID: "def", Provider: "google", Subject: "1234", Email: "test@test.com", CreatedAt: currentTime}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetFederatedIdentity() = %v, want %v", found, want)
+	}
+
+	// A subject linked to someone else comes back empty
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_federated_identity"])).WithArgs("ghi", "google", "1234", "test@test.com", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(columns))
+	created, err := d.CreateFederatedIdentity("ghi", "google", "1234", "test@test.com")
+	if err != nil || created.UUID != "" {
+		t.Errorf("DatabaseConnection.CreateFederatedIdentity() on a linked subject = %v, %v, want empty, nil", created, err)
+	}
+
+	// Signing up creates the user and its link together
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_federated_user"])).WithArgs("org", "test@test.com", "Testy McTesterson", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(userColumns).AddRow("def", "test@test.com", "Testy McTesterson", currentTime, currentTime, currentTime))
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_federated_identity"])).WithArgs("def", "google", "1234", "test@test.com", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(columns).AddRow("abc", "def", "google", "1234", "test@test.com", currentTime))
+	mock.ExpectCommit()
+	user, err := d.CreateFederatedUser("org", "test@test.com", "Testy McTesterson", true, "google", "1234")
+	if err != nil || user.UUID != "def" || user.VerifiedAt == nil {
+		t.Errorf("DatabaseConnection.CreateFederatedUser() = %v, %v, want a verified user", user, err)
+	}
+
+	// A taken email address doesn't get as far as the link
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_federated_user"])).WithArgs("org", "test@test.com", "", sqlmock.AnyArg(), nil).WillReturnRows(sqlmock.NewRows(userColumns))
+	mock.ExpectRollback()
+	user, err = d.CreateFederatedUser("org", "test@test.com", "", false, "google", "5678")
+	if err != nil || user.UUID != "" {
+		t.Errorf("DatabaseConnection.CreateFederatedUser() with a taken email = %v, %v, want empty, nil", user, err)
+	}
+
+	// Nor is the user kept if the subject turns out to be linked already
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_federated_user"])).WillReturnRows(sqlmock.NewRows(userColumns).AddRow("jkl", "new@test.com", "", currentTime, currentTime, nil))
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_federated_identity"])).WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+	user, err = d.CreateFederatedUser("org", "new@test.com", "", false, "google", "1234")
+	if err != nil || user.UUID != "" {
+		t.Errorf("DatabaseConnection.CreateFederatedUser() with a linked subject = %v, %v, want empty, nil", user, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_ListUsers(t *testing.T) {
+	currentTime := time.Now()
+	after := currentTime.Add(-time.Hour)
+	before := currentTime.Add(time.Hour)
+	cursorTime := time.Date(2019, 5, 30, 9, 12, 4, 123456000, time.UTC)
+	cursor := encodeCursor(cursorTime, "0b9fd0c4-3f0e-4c43-9a4f-5e0e1e7b8d21")
+	columns := []string{"uuid", "email", "name", "created_at", "updated_at", "deleted_at", "verified_at", "failed_login_count", "locked_until"}
+	selected := "select uuid, email, name, created_at, updated_at, deleted_at, verified_at, failed_login_count, locked_until FROM users WHERE "
+
+	tests := []struct {
+		name      string
+		filter    UserFilter
+		cursor    string
+		wantQuery string
+		wantArgs  []driver.Value
+	}{
+		{
+			name:      "everyone",
+			wantQuery: selected + "organization_uuid=$1 AND deleted_at IS NULL ORDER BY created_at, uuid LIMIT $2;",
+			wantArgs:  []driver.Value{"org", 3},
+		},
+		{
+			// What's typed is matched literally, even characters LIKE treats as wildcards
+			name:      "search",
+			filter:    UserFilter{Search: "test_1%"},
+			wantQuery: selected + "organization_uuid=$1 AND deleted_at IS NULL AND (email ILIKE $2 OR name ILIKE $2) ORDER BY created_at, uuid LIMIT $3;",
+			wantArgs:  []driver.Value{"org", `%test\_1\%%`, 3},
+		},
+		{
+			name:      "every filter",
+			filter:    UserFilter{EmailPrefix: "test", Name: "Testy", CreatedAfter: &after, CreatedBefore: &before, IncludeDeleted: true},
+			wantQuery: selected + "organization_uuid=$1 AND email ILIKE $2 AND name ILIKE $3 AND created_at >= $4 AND created_at < $5 ORDER BY created_at, uuid LIMIT $6;",
+			wantArgs:  []driver.Value{"org", "test%", "%Testy%", after, before, 3},
+		},
+		{
+			name:      "next page",
+			filter:    UserFilter{EmailPrefix: "test"},
+			cursor:    cursor,
+			wantQuery: selected + "organization_uuid=$1 AND deleted_at IS NULL AND email ILIKE $2 AND (created_at, uuid) > ($3, $4) ORDER BY created_at, uuid LIMIT $5;",
+			wantArgs:  []driver.Value{"org", "test%", cursorTime, "0b9fd0c4-3f0e-4c43-9a4f-5e0e1e7b8d21", 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error building sqlmock: %v", err)
+			}
+
+			d := &DatabaseConnection{Connection: db}
+			mock.ExpectQuery(regexp.QuoteMeta(tt.wantQuery)).WithArgs(tt.wantArgs...).WillReturnRows(sqlmock.NewRows(columns).AddRow("def", "test@test.com", "Testy McTesterson", currentTime, currentTime, nil, nil, 2, nil))
+			users, next, err := d.ListUsers("org", tt.filter, tt.cursor, 2)
+			if err != nil {
+				t.Fatalf("DatabaseConnection.ListUsers() error = %v", err)
+			}
+
+			want := []models.User{{UUID: "def", Email: "test@test.com", Name: "Testy McTesterson", CreatedAt: currentTime, UpdatedAt: currentTime, FailedLoginCount: 2}}
+			if !reflect.DeepEqual(users, want) || next != "" {
+				t.Errorf("DatabaseConnection.ListUsers() = %v, %q, want %v and no next page", users, next, want)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestDatabaseConnection_ListUsers_Pages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	first := time.Date(2019, 5, 30, 9, 12, 4, 123456000, time.UTC)
+	second := first.Add(time.Second)
+	firstUUID, secondUUID := "0b9fd0c4-3f0e-4c43-9a4f-5e0e1e7b8d21", "6c1e1d2a-8f44-4d0b-b3a7-2f5f3b9c0e57"
+	columns := []string{"uuid", "email", "name", "created_at", "updated_at", "deleted_at", "verified_at", "failed_login_count", "locked_until"}
+	query := "select uuid, email, name, created_at, updated_at, deleted_at, verified_at, failed_login_count, locked_until FROM users WHERE organization_uuid=$1 AND deleted_at IS NULL"
+
+	// A full page comes back with one more user than it holds, the cursor points after the last one kept
+	mock.ExpectQuery(regexp.QuoteMeta(query+" ORDER BY created_at, uuid LIMIT $2;")).WithArgs("org", 2).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(firstUUID, "a@test.com", "A", first, first, nil, nil, 0, nil).
+		AddRow(secondUUID, "b@test.com", "B", second, second, nil, nil, 0, nil))
+	users, next, err := d.ListUsers("org", UserFilter{}, "", 1)
+	if err != nil || len(users) != 1 || users[0].UUID != firstUUID || next == "" {
+		t.Fatalf("DatabaseConnection.ListUsers() = %v, %q, %v, want the first user and a cursor", users, next, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(query+" AND (created_at, uuid) > ($2, $3) ORDER BY created_at, uuid LIMIT $4;")).WithArgs("org", first, firstUUID, 2).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(secondUUID, "b@test.com", "B", second, second, nil, nil, 0, nil))
+	users, next, err = d.ListUsers("org", UserFilter{}, next, 1)
+	if err != nil || len(users) != 1 || users[0].UUID != secondUUID || next != "" {
+		t.Errorf("DatabaseConnection.ListUsers() for the next page = %v, %q, %v, want the second user and no cursor", users, next, err)
+	}
+
+	// Cursors that weren't handed out never reach the database, including ones tampered with to hold something
+	// Postgres couldn't cast to a uuid
+	invalid := []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("abc")),
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday|" + firstUUID)),
+		encodeCursor(first, ""),
+		encodeCursor(first, "abc"),
+	}
+	for _, cursor := range invalid {
+		if _, _, err := d.ListUsers("org", UserFilter{}, cursor, 1); err != ErrInvalidCursor {
+			t.Errorf("DatabaseConnection.ListUsers() with cursor %q error = %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_Roles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["list_roles_by_membership"])).WithArgs("org", "abc").WillReturnRows(sqlmock.NewRows([]string{"role_name"}).AddRow("admin").AddRow("support"))
+	roles, err := d.ListRolesByMembership("org", "abc")
+	if err != nil || !reflect.DeepEqual(roles, []string{"admin", "support"}) {
+		t.Errorf("DatabaseConnection.ListRolesByMembership() = %v, %v, want [admin support], nil", roles, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["list_permissions_by_membership"])).WithArgs("org", "abc").WillReturnRows(sqlmock.NewRows([]string{"permission_name"}).AddRow("users:read"))
+	permissions, err := d.ListPermissionsByMembership("org", "abc")
+	if err != nil || !reflect.DeepEqual(permissions, []string{"users:read"}) {
+		t.Errorf("DatabaseConnection.ListPermissionsByMembership() = %v, %v, want [users:read], nil", permissions, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["list_permissions_by_roles"])).WithArgs("{\"support\"}").WillReturnRows(sqlmock.NewRows([]string{"permission_name"}).AddRow("sessions:revoke").AddRow("users:read"))
+	permissions, err = d.ListPermissionsByRoles([]string{"support"})
+	if err != nil || !reflect.DeepEqual(permissions, []string{"sessions:revoke", "users:read"}) {
+		t.Errorf("DatabaseConnection.ListPermissionsByRoles() = %v, %v, want [sessions:revoke users:read], nil", permissions, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["list_global_roles"])).WithArgs("{\"admin\",\"operator\"}").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("operator"))
+	global, err := d.ListGlobalRoles([]string{"admin", "operator"})
+	if err != nil || !reflect.DeepEqual(global, []string{"operator"}) {
+		t.Errorf("DatabaseConnection.ListGlobalRoles() = %v, %v, want [operator], nil", global, err)
+	}
+
+	// Setting roles swaps them all out in one transaction
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries["delete_user_roles_by_membership"])).WithArgs("org", "abc").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(queries["create_user_roles"])).WithArgs("org", "abc", "{\"admin\"}", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	set, err := d.SetMembershipRoles("org", "abc", []string{"admin"})
+	if err != nil || set != 1 {
+		t.Errorf("DatabaseConnection.SetMembershipRoles() = %v, %v, want 1, nil", set, err)
+	}
+
+	// A role that doesn't exist leaves the old ones in place
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries["delete_user_roles_by_membership"])).WithArgs("org", "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queries["create_user_roles"])).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+	set, err = d.SetMembershipRoles("org", "abc", []string{"admin", "superuser"})
+	if err != nil || set != 0 {
+		t.Errorf("DatabaseConnection.SetMembershipRoles() with an unknown role = %v, %v, want 0, nil", set, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_Organizations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+	columns := []string{"uuid", "slug", "name", "created_at"}
+	want := models.Organization{UUID: "org", Slug: "fender", Name: "Fender", CreatedAt: currentTime}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["create_organization"])).WithArgs("fender", "Fender", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(columns).AddRow("org", "fender", "Fender", currentTime))
+	created, err := d.CreateOrganization("fender", "Fender")
+	if err != nil || !reflect.DeepEqual(created, want) {
+		t.Errorf("DatabaseConnection.CreateOrganization() = %v, %v, want %v, nil", created, err, want)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_organization_by_slug"])).WithArgs("fender").WillReturnRows(sqlmock.NewRows(columns).AddRow("org", "fender", "Fender", currentTime))
+	found, err := d.GetOrganizationBySlug("fender")
+	if err != nil || !reflect.DeepEqual(found, want) {
+		t.Errorf("DatabaseConnection.GetOrganizationBySlug() = %v, %v, want %v, nil", found, err, want)
+	}
+
+	// Another organization's user isn't found, even by someone who knows their UUID
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_organization_user_by_uuid"])).WithArgs("org", "abc").WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "verified_at", "failed_login_count", "locked_until", "password"}))
+	user, err := d.GetOrganizationUserByUUID("org", "abc")
+	if err != nil || user.UUID != "" {
+		t.Errorf("DatabaseConnection.GetOrganizationUserByUUID() for another organization's user = %v, %v, want empty, nil", user, err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["create_membership"])).WithArgs("org", "abc", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	added, err := d.CreateMembership("org", "abc")
+	if err != nil || added != 1 {
+		t.Errorf("DatabaseConnection.CreateMembership() = %v, %v, want 1, nil", added, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_membership"])).WithArgs("org", "abc").WillReturnRows(sqlmock.NewRows([]string{"organization_uuid", "user_uuid", "created_at"}).AddRow("org", "abc", currentTime))
+	membership, err := d.GetMembership("org", "abc")
+	wantMembership := models.Membership{OrganizationUUID: "org", UserUUID: "abc", CreatedAt: currentTime}
+	if err != nil || !reflect.DeepEqual(membership, wantMembership) {
+		t.Errorf("DatabaseConnection.GetMembership() = %v, %v, want %v, nil", membership, err, wantMembership)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_Retention(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+	cutoff := currentTime.Add(-time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_deleted_organization_user_by_uuid"])).WithArgs("org", "abc").WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "deleted_at", "verified_at"}).AddRow("abc", "test@test.com", "Testy McTesterson", currentTime, currentTime, currentTime, nil))
+	deleted, err := d.GetDeletedOrganizationUserByUUID("org", "abc")
+	wantDeleted := models.User{UUID: "abc", Email: "test@test.com", Name: "Testy McTesterson", CreatedAt: currentTime, UpdatedAt: currentTime, DeletedAt: &currentTime}
+	if err != nil || !reflect.DeepEqual(deleted, wantDeleted) {
+		t.Errorf("DatabaseConnection.GetDeletedOrganizationUserByUUID() = %v, %v, want %v, nil", deleted, err, wantDeleted)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["restore_user_by_uuid"])).WithArgs(sqlmock.AnyArg(), "org", "abc", cutoff).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "verified_at"}).AddRow("abc", "test@test.com", "Testy McTesterson", currentTime, currentTime, nil))
+	restored, err := d.RestoreUserByUUID("org", "abc", cutoff)
+	wantRestored := models.User{UUID: "abc", Email: "test@test.com", Name: "Testy McTesterson", CreatedAt: currentTime, UpdatedAt: currentTime}
+	if err != nil || !reflect.DeepEqual(restored, wantRestored) {
+		t.Errorf("DatabaseConnection.RestoreUserByUUID() = %v, %v, want %v, nil", restored, err, wantRestored)
+	}
+
+	// Deleted too long ago, or someone else has the address now
+	mock.ExpectQuery(regexp.QuoteMeta(queries["restore_user_by_uuid"])).WithArgs(sqlmock.AnyArg(), "org", "abc", cutoff).WillReturnRows(sqlmock.NewRows([]string{"uuid", "email", "name", "created_at", "updated_at", "verified_at"}))
+	restored, err = d.RestoreUserByUUID("org", "abc", cutoff)
+	if err != nil || restored.UUID != "" {
+		t.Errorf("DatabaseConnection.RestoreUserByUUID() for a user that can't be restored = %v, %v, want empty, nil", restored, err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["purge_deleted_users"])).WithArgs(cutoff, 100).WillReturnResult(sqlmock.NewResult(0, 3))
+	purged, err := d.PurgeDeletedUsers(cutoff, 100)
+	if err != nil || purged != 3 {
+		t.Errorf("DatabaseConnection.PurgeDeletedUsers() = %v, %v, want 3, nil", purged, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDatabaseConnection_Jobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error building sqlmock: %v", err)
+	}
+
+	d := &DatabaseConnection{Connection: db}
+	currentTime := time.Now()
+	cutoff := currentTime.Add(-time.Hour)
+
+	mock.ExpectExec(regexp.QuoteMeta(queries["purge_ended_sessions"])).WithArgs(cutoff, 100).WillReturnResult(sqlmock.NewResult(0, 7))
+	purged, err := d.PurgeEndedSessions(cutoff, 100)
+	if err != nil || purged != 7 {
+		t.Errorf("DatabaseConnection.PurgeEndedSessions() = %v, %v, want 7, nil", purged, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["try_advisory_lock"])).WithArgs(int64(42)).WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectExec(regexp.QuoteMeta(queries["advisory_unlock"])).WithArgs(int64(42)).WillReturnResult(sqlmock.NewResult(0, 1))
+	unlock, locked, err := d.TryAdvisoryLock(42)
+	if err != nil || !locked {
+		t.Fatalf("DatabaseConnection.TryAdvisoryLock() = %v, %v, want true, nil", locked, err)
+	}
+	err = unlock()
+	if err != nil {
+		t.Errorf("unlock() = %v, want nil", err)
+	}
+
+	// Another replica has it
+	mock.ExpectQuery(regexp.QuoteMeta(queries["try_advisory_lock"])).WithArgs(int64(42)).WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
+	unlock, locked, err = d.TryAdvisoryLock(42)
+	if err != nil || locked || unlock != nil {
+		t.Errorf("DatabaseConnection.TryAdvisoryLock() while held elsewhere = %v, %v, want false, nil", locked, err)
+	}
+
+	run := models.JobRun{Name: "sweep-sessions", Host: "web-1", StartedAt: cutoff, FinishedAt: currentTime, Processed: 7}
+	mock.ExpectExec(regexp.QuoteMeta(queries["record_job_run"])).WithArgs(run.Name, run.Host, run.StartedAt, run.FinishedAt, run.Processed, run.Error).WillReturnResult(sqlmock.NewResult(0, 1))
+	recorded, err := d.RecordJobRun(run)
+	if err != nil || recorded != 1 {
+		t.Errorf("DatabaseConnection.RecordJobRun() = %v, %v, want 1, nil", recorded, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["get_job_run"])).WithArgs(run.Name).WillReturnRows(sqlmock.NewRows([]string{"name", "host", "started_at", "finished_at", "processed", "error"}).AddRow(run.Name, run.Host, run.StartedAt, run.FinishedAt, run.Processed, run.Error))
+	last, err := d.GetJobRun(run.Name)
+	if err != nil || !reflect.DeepEqual(last, run) {
+		t.Errorf("DatabaseConnection.GetJobRun() = %v, %v, want %v, nil", last, err, run)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries["list_job_runs"])).WillReturnRows(sqlmock.NewRows([]string{"name", "host", "started_at", "finished_at", "processed", "error"}).AddRow(run.Name, run.Host, run.StartedAt, run.FinishedAt, run.Processed, run.Error))
+	runs, err := d.ListJobRuns()
+	if err != nil || !reflect.DeepEqual(runs, []models.JobRun{run}) {
+		t.Errorf("DatabaseConnection.ListJobRuns() = %v, %v, want %v, nil", runs, err, []models.JobRun{run})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys is how many buckets a Memory limiter tracks before it starts pruning full ones
+const DefaultMaxKeys = 100000
+
+// Memory is a Limiter that keeps its buckets in process, each replica has its own so it only suits a single instance
+type Memory struct {
+	rate    Rate
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruneAt int
+
+	// now is swapped out in tests
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewMemory returns an in-memory limiter where every key gets a bucket of the given rate
+func NewMemory(rate Rate) *Memory {
+	return &Memory{
+		rate:    rate,
+		maxKeys: DefaultMaxKeys,
+		buckets: map[string]*bucket{},
+		pruneAt: DefaultMaxKeys,
+		now:     time.Now,
+	}
+}
+
+// Take refills the key's bucket for the time since it was last used and takes a token if there's a whole one
+func (m *Memory) Take(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	b, ok := m.buckets[key]
+	if !ok {
+		if len(m.buckets) >= m.pruneAt {
+			m.prune(now)
+		}
+
+		b = &bucket{tokens: float64(m.rate.Burst), updatedAt: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = m.refill(b, now)
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return m.rate.wait(b.tokens), nil
+	}
+
+	b.tokens--
+	return 0, nil
+}
+
+// Len is how many buckets are being tracked
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.buckets)
+}
+
+// refill is how many tokens the bucket holds at now
+func (m *Memory) refill(b *bucket, now time.Time) float64 {
+	return math.Min(float64(m.rate.Burst), b.tokens+now.Sub(b.updatedAt).Seconds()*m.rate.perSecond())
+}
+
+// prune forgets buckets that have refilled, they're no different to a new one. If most buckets are still
+// draining the next prune is pushed back so we don't walk the whole map on every new key.
+func (m *Memory) prune(now time.Time) {
+	for key, b := range m.buckets {
+		if m.refill(b, now) >= float64(m.rate.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+
+	m.pruneAt = m.maxKeys
+	if len(m.buckets)*2 > m.pruneAt {
+		m.pruneAt = len(m.buckets) * 2
+	}
+}
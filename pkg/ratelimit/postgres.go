@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+// Postgres is a Limiter that keeps its buckets in the rate_limits table so every replica shares them
+type Postgres struct {
+	db     postgres.Databaser
+	prefix string
+	rate   Rate
+}
+
+// NewPostgres returns a limiter backed by the database, prefix namespaces its keys from other limiters sharing the table
+func NewPostgres(db postgres.Databaser, prefix string, rate Rate) *Postgres {
+	return &Postgres{db: db, prefix: prefix, rate: rate}
+}
+
+// Take refills the key's bucket for the time since it was last used and takes a token if there's a whole one
+func (p *Postgres) Take(key string) (time.Duration, error) {
+	tokens, err := p.db.TakeRateLimitToken(p.prefix+key, float64(p.rate.Burst), p.rate.perSecond())
+	if err != nil {
+		return 0, err
+	}
+
+	return p.rate.wait(tokens), nil
+}
+
+// Purge deletes up to limit of the limiter's buckets that have refilled, returning how many it deleted. Keys are
+// picked by whoever's calling, so without it the table grows by a row for every email or address ever tried.
+func (p *Postgres) Purge(limit int) (int, error) {
+	return p.db.PurgeRateLimits(p.prefix, float64(p.rate.Burst), p.rate.perSecond(), limit)
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limiter hands out tokens from a bucket per key, once a key's bucket is empty its caller has to wait for it to refill
+type Limiter interface {
+	// Take removes a token from the key's bucket, returning zero if there was one or how long until there will be
+	Take(key string) (time.Duration, error)
+}
+
+// Rate describes a token bucket, it holds up to Burst tokens and gains one every Every
+type Rate struct {
+	Burst int
+	Every time.Duration
+}
+
+// perSecond is how many tokens the bucket gains a second
+func (r Rate) perSecond() float64 {
+	return float64(time.Second) / float64(r.Every)
+}
+
+// wait is how long a bucket holding tokens takes to get back to a whole token
+func (r Rate) wait(tokens float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil((1 - tokens) * float64(r.Every)))
+}
+
+// Unlimited is a Limiter that never runs out of tokens
+type Unlimited struct{}
+
+// Take always succeeds
+func (Unlimited) Take(key string) (time.Duration, error) {
+	return 0, nil
+}
+
+// Backoff locks an account out for exponentially longer after each failure past Threshold, up to Max
+type Backoff struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// Delay is how long to lock out for after the given number of failures in a row, zero until Threshold is reached
+func (b Backoff) Delay(failures int) time.Duration {
+	if b.Threshold <= 0 || failures < b.Threshold {
+		return 0
+	}
+
+	delay := b.Base
+	for i := b.Threshold; i < failures && delay < b.Max; i++ {
+		delay *= 2
+	}
+
+	if delay > b.Max {
+		return b.Max
+	}
+
+	return delay
+}
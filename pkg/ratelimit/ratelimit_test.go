@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+func TestMemory_Take(t *testing.T) {
+	now := time.Now()
+	limiter := NewMemory(Rate{Burst: 2, Every: 10 * time.Second})
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		wait, err := limiter.Take("127.0.0.1")
+		if err != nil || wait != 0 {
+			t.Fatalf("Memory.Take() #%v = %v, %v, want 0", i, wait, err)
+		}
+	}
+
+	wait, _ := limiter.Take("127.0.0.1")
+	if wait != 10*time.Second {
+		t.Errorf("Memory.Take() on an empty bucket = %v, want %v", wait, 10*time.Second)
+	}
+
+	// Other keys have their own bucket
+	wait, _ = limiter.Take("10.0.0.1")
+	if wait != 0 {
+		t.Errorf("Memory.Take() for another key = %v, want 0", wait)
+	}
+
+	// Part way through refilling the wait is only what's left
+	now = now.Add(4 * time.Second)
+	wait, _ = limiter.Take("127.0.0.1")
+	if wait != 6*time.Second {
+		t.Errorf("Memory.Take() part way refilled = %v, want %v", wait, 6*time.Second)
+	}
+
+	now = now.Add(6 * time.Second)
+	wait, _ = limiter.Take("127.0.0.1")
+	if wait != 0 {
+		t.Errorf("Memory.Take() once refilled = %v, want 0", wait)
+	}
+}
+
+func TestMemory_prune(t *testing.T) {
+	now := time.Now()
+	limiter := NewMemory(Rate{Burst: 1, Every: time.Minute})
+	limiter.now = func() time.Time { return now }
+	limiter.maxKeys, limiter.pruneAt = 2, 2
+
+	limiter.Take("a")
+	now = now.Add(time.Minute)
+	limiter.Take("b")
+
+	// a has refilled so it's dropped to make room, b is still draining and has to be kept
+	limiter.Take("c")
+	if limiter.Len() != 2 {
+		t.Errorf("Memory.Len() = %v, want 2", limiter.Len())
+	}
+
+	wait, _ := limiter.Take("b")
+	if wait == 0 {
+		t.Errorf("Memory.Take() for a pruned but draining key = 0, want a wait")
+	}
+}
+
+type tokensDB struct {
+	postgres.DBMock
+	key    string
+	tokens float64
+	prefix string
+	burst  float64
+	refill float64
+}
+
+func (d *tokensDB) TakeRateLimitToken(key string, burst, refillPerSecond float64) (float64, error) {
+	d.key = key
+	return d.tokens, nil
+}
+
+func (d *tokensDB) PurgeRateLimits(prefix string, burst, refillPerSecond float64, limit int) (int, error) {
+	d.prefix, d.burst, d.refill = prefix, burst, refillPerSecond
+	return limit, nil
+}
+
+func TestPostgres_Take(t *testing.T) {
+	db := &tokensDB{tokens: 0.5}
+	limiter := NewPostgres(db, "login:ip:", Rate{Burst: 5, Every: 30 * time.Second})
+
+	wait, err := limiter.Take("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Postgres.Take() error = %v", err)
+	}
+
+	if db.key != "login:ip:127.0.0.1" {
+		t.Errorf("Postgres.Take() key = %v, want %v", db.key, "login:ip:127.0.0.1")
+	}
+
+	if wait != 15*time.Second {
+		t.Errorf("Postgres.Take() = %v, want %v", wait, 15*time.Second)
+	}
+
+	db.tokens = 3
+	wait, _ = limiter.Take("127.0.0.1")
+	if wait != 0 {
+		t.Errorf("Postgres.Take() with tokens left = %v, want 0", wait)
+	}
+}
+
+func TestPostgres_Purge(t *testing.T) {
+	db := &tokensDB{}
+	limiter := NewPostgres(db, "login:ip:", Rate{Burst: 5, Every: 2 * time.Second})
+
+	purged, err := limiter.Purge(100)
+	if err != nil || purged != 100 {
+		t.Fatalf("Postgres.Purge() = %v, %v, want 100, nil", purged, err)
+	}
+
+	// Only its own buckets go, once they've refilled at its rate
+	if db.prefix != "login:ip:" || db.burst != 5 || db.refill != 0.5 {
+		t.Errorf("Postgres.Purge() purged %q buckets full at %v refilling %v/s, want %q at 5 refilling 0.5/s", db.prefix, db.burst, db.refill, "login:ip:")
+	}
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	backoff := Backoff{Threshold: 5, Base: time.Minute, Max: time.Hour}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{11, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff.Delay(tt.failures); got != tt.want {
+			t.Errorf("Backoff.Delay(%v) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package retention decides how long records are kept around after they stop being used, and has the jobs that
+// remove them once they've been kept long enough
+package retention
+
+import (
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+var (
+	// UserPeriod is how long a deleted user can be restored for, after that they're purged along with everything
+	// of theirs
+	UserPeriod = 30 * 24 * time.Hour
+	// SessionPeriod is how long sessions are kept after they expire or are logged out, so they can still be looked
+	// into for a while
+	SessionPeriod = 7 * 24 * time.Hour
+	// BatchSize is how many rows are removed at a time, so a backlog doesn't hold locks for long
+	BatchSize = 500
+)
+
+// RestorableAfter is the oldest deletion that can still be undone at currentTime
+func RestorableAfter(currentTime time.Time) time.Time {
+	return currentTime.Add(-UserPeriod)
+}
+
+// PurgeUsers hard deletes users who were deleted longer than UserPeriod ago, a batch at a time until there are none
+// left or stop is closed. It's meant to be run as a jobs.Job.
+func PurgeUsers(stop <-chan struct{}) (int, error) {
+	deletedBefore := RestorableAfter(time.Now())
+	return inBatches(stop, func() (int, error) {
+		return postgres.DB.PurgeDeletedUsers(deletedBefore, BatchSize)
+	})
+}
+
+// PurgeSessions hard deletes sessions that expired or were logged out longer than SessionPeriod ago, along with
+// their refresh tokens, a batch at a time until there are none left or stop is closed. It's meant to be run as a
+// jobs.Job.
+func PurgeSessions(stop <-chan struct{}) (int, error) {
+	endedBefore := time.Now().Add(-SessionPeriod)
+	return inBatches(stop, func() (int, error) {
+		return postgres.DB.PurgeEndedSessions(endedBefore, BatchSize)
+	})
+}
+
+// Purger removes a batch of records that aren't needed anymore, ex: a ratelimit.Postgres's refilled buckets
+type Purger interface {
+	Purge(limit int) (int, error)
+}
+
+// PurgeRateLimits returns a jobs.Job's Run that deletes the limiters' buckets once they've refilled, a batch at a
+// time until there are none left or stop is closed
+func PurgeRateLimits(limiters ...Purger) func(stop <-chan struct{}) (int, error) {
+	return func(stop <-chan struct{}) (int, error) {
+		removed := 0
+		for _, limiter := range limiters {
+			batch, err := inBatches(stop, func() (int, error) {
+				return limiter.Purge(BatchSize)
+			})
+			removed += batch
+			if err != nil {
+				return removed, err
+			}
+		}
+
+		return removed, nil
+	}
+}
+
+// inBatches calls removeBatch until it removes less than a full batch, or stop is closed, returning how many rows
+// were removed altogether
+func inBatches(stop <-chan struct{}, removeBatch func() (int, error)) (int, error) {
+	removed := 0
+	for {
+		select {
+		case <-stop:
+			return removed, nil
+		default:
+		}
+
+		batch, err := removeBatch()
+		removed += batch
+		if err != nil || batch < BatchSize {
+			return removed, err
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+// backlogDB has a number of users or sessions waiting to be purged, and remembers the cutoff it was asked to purge before
+type backlogDB struct {
+	postgres.DBMock
+	waiting       int
+	batches       int
+	deletedBefore time.Time
+}
+
+func (d *backlogDB) PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error) {
+	d.batches++
+	d.deletedBefore = deletedBefore
+	purged := limit
+	if d.waiting < limit {
+		purged = d.waiting
+	}
+	d.waiting -= purged
+	return purged, nil
+}
+
+func (d *backlogDB) PurgeEndedSessions(endedBefore time.Time, limit int) (int, error) {
+	return d.PurgeDeletedUsers(endedBefore, limit)
+}
+
+func TestPurgeUsers(t *testing.T) {
+	BatchSize = 2
+	defer func() { BatchSize = 500 }()
+
+	db := &backlogDB{waiting: 5}
+	postgres.DB = db
+
+	purged, err := PurgeUsers(make(chan struct{}))
+	if err != nil || purged != 5 || db.batches != 3 {
+		t.Errorf("PurgeUsers() = %v, %v in %v batches, want 5 in 3 batches", purged, err, db.batches)
+	}
+
+	// Only users deleted longer than UserPeriod ago go
+	if cutoff := time.Now().Add(-UserPeriod); db.deletedBefore.After(cutoff) || db.deletedBefore.Before(cutoff.Add(-time.Minute)) {
+		t.Errorf("PurgeUsers() purged users deleted before %v, want %v", db.deletedBefore, cutoff)
+	}
+
+	// Once stopped the rest is left for next time
+	db = &backlogDB{waiting: 5}
+	postgres.DB = db
+	stop := make(chan struct{})
+	close(stop)
+
+	purged, err = PurgeUsers(stop)
+	if err != nil || purged != 0 || db.waiting != 5 {
+		t.Errorf("PurgeUsers() after stop = %v, %v, want nothing purged", purged, err)
+	}
+}
+
+func TestPurgeSessions(t *testing.T) {
+	BatchSize = 2
+	defer func() { BatchSize = 500 }()
+
+	db := &backlogDB{waiting: 4}
+	postgres.DB = db
+
+	// A full last batch takes one more to find out there's nothing left
+	purged, err := PurgeSessions(make(chan struct{}))
+	if err != nil || purged != 4 || db.batches != 3 {
+		t.Errorf("PurgeSessions() = %v, %v in %v batches, want 4 in 3 batches", purged, err, db.batches)
+	}
+
+	// Only sessions that ended longer than SessionPeriod ago go
+	if cutoff := time.Now().Add(-SessionPeriod); db.deletedBefore.After(cutoff) || db.deletedBefore.Before(cutoff.Add(-time.Minute)) {
+		t.Errorf("PurgeSessions() purged sessions that ended before %v, want %v", db.deletedBefore, cutoff)
+	}
+}
+
+// bucketPurger has a number of refilled buckets waiting to be purged
+type bucketPurger struct {
+	waiting int
+}
+
+func (p *bucketPurger) Purge(limit int) (int, error) {
+	purged := limit
+	if p.waiting < limit {
+		purged = p.waiting
+	}
+	p.waiting -= purged
+	return purged, nil
+}
+
+func TestPurgeRateLimits(t *testing.T) {
+	BatchSize = 2
+	defer func() { BatchSize = 500 }()
+
+	ip, email := &bucketPurger{waiting: 3}, &bucketPurger{waiting: 4}
+	purged, err := PurgeRateLimits(ip, email)(make(chan struct{}))
+	if err != nil || purged != 7 || ip.waiting != 0 || email.waiting != 0 {
+		t.Errorf("PurgeRateLimits() = %v, %v, want 7 purged from both limiters", purged, err)
+	}
+}
@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// KeySize is how long a key has to be, it selects AES-256
+const KeySize = 32
+
+var (
+	// ErrKeySize is returned when a key isn't KeySize bytes
+	ErrKeySize = errors.New("secrets: key must be 32 bytes")
+	// ErrOpen is returned when a sealed value is malformed, was sealed with another key or was tampered with
+	ErrOpen = errors.New("secrets: message authentication failed")
+)
+
+// Box encrypts small values for storage with AES-GCM, so a database leak alone doesn't leak them
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox returns a box sealing with the given key
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// NewBoxFromBase64 returns a box sealing with a base64 encoded key, as it'd be given in the environment
+func NewBoxFromBase64(encodedKey string) (*Box, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBox(key)
+}
+
+// Seal encrypts plaintext with a random nonce, returning them base64 encoded together. additionalData isn't
+// stored but has to be given again to open the value, binding it to something like the row it's stored in.
+func (b *Box) Seal(plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, plaintext, additionalData)), nil
+}
+
+// Open decrypts a value made by Seal with the same additionalData
+func (b *Box) Open(sealed string, additionalData []byte) ([]byte, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return nil, ErrOpen
+	}
+
+	plaintext, err := b.aead.Open(nil, raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return plaintext, nil
+}
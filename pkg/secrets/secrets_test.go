@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestBox(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	box, err := NewBoxFromBase64(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatalf("NewBoxFromBase64() error = %v", err)
+	}
+
+	sealed, err := box.Seal([]byte("secret"), []byte("abc"))
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	if again, _ := box.Seal([]byte("secret"), []byte("abc")); again == sealed {
+		t.Errorf("Seal() twice gave the same value, nonces aren't random")
+	}
+
+	opened, err := box.Open(sealed, []byte("abc"))
+	if err != nil || string(opened) != "secret" {
+		t.Errorf("Open() = %q, %v, want %q", opened, err, "secret")
+	}
+
+	if _, err := box.Open(sealed, []byte("def")); err != ErrOpen {
+		t.Errorf("Open() with other additional data error = %v, want %v", err, ErrOpen)
+	}
+
+	other, _ := NewBox(bytes.Repeat([]byte{8}, KeySize))
+	if _, err := other.Open(sealed, []byte("abc")); err != ErrOpen {
+		t.Errorf("Open() with another key error = %v, want %v", err, ErrOpen)
+	}
+
+	if _, err := box.Open("AAAA", []byte("abc")); err != ErrOpen {
+		t.Errorf("Open() of garbage error = %v, want %v", err, ErrOpen)
+	}
+
+	if _, err := NewBox([]byte("short")); err != ErrKeySize {
+		t.Errorf("NewBox() with a short key error = %v, want %v", err, ErrKeySize)
+	}
+}
@@ -0,0 +1,108 @@
+package sessioncache
+
+import (
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres NOTIFY channel a session's UUID is sent on when it's soft deleted
+const Channel = "session_revoked"
+
+// UserChannel is the Postgres NOTIFY channel a user's UUID is sent on whenever they change, including being deleted
+const UserChannel = "user_changed"
+
+// Notification names a revoked session or a changed user. An empty one means notifications may have been missed
+// (ex: the connection dropped) and everything cached should be thrown away.
+type Notification struct {
+	SessionUUID string
+	UserUUID    string
+}
+
+// Notifier delivers notifications of revoked sessions and changed users
+type Notifier interface {
+	Notifications() <-chan Notification
+	Close() error
+}
+
+// PQNotifier is a Notifier fed by LISTEN on a dedicated Postgres connection
+type PQNotifier struct {
+	listener      *pq.Listener
+	notifications chan Notification
+}
+
+// NewPQNotifier connects to Postgres and starts listening on Channel and UserChannel
+func NewPQNotifier(connectionString string) (*PQNotifier, error) {
+	listener := pq.NewListener(connectionString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("session revocation listener: %v", err)
+		}
+	})
+
+	for _, channel := range []string{Channel, UserChannel} {
+		err := listener.Listen(channel)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	n := &PQNotifier{listener: listener, notifications: make(chan Notification, 64)}
+	go n.forward()
+	return n, nil
+}
+
+// Notifications returns the channel notifications are delivered on
+func (n *PQNotifier) Notifications() <-chan Notification {
+	return n.notifications
+}
+
+// Close stops listening and closes the notifications channel
+func (n *PQNotifier) Close() error {
+	return n.listener.Close()
+}
+
+func (n *PQNotifier) forward() {
+	defer close(n.notifications)
+
+	for notification := range n.listener.Notify {
+		// pq sends a nil notification after reconnecting, anything sent while we were gone is lost
+		if notification == nil {
+			n.notifications <- Notification{}
+			continue
+		}
+
+		if notification.Channel == UserChannel {
+			n.notifications <- Notification{UserUUID: notification.Extra}
+			continue
+		}
+		n.notifications <- Notification{SessionUUID: notification.Extra}
+	}
+}
+
+// FakeNotifier is a Notifier for tests, notifications are sent by calling Notify
+type FakeNotifier struct {
+	notifications chan Notification
+}
+
+// NewFakeNotifier returns a FakeNotifier
+func NewFakeNotifier() *FakeNotifier {
+	return &FakeNotifier{notifications: make(chan Notification)}
+}
+
+// Notify delivers a notification, it blocks until the listener has picked it up
+func (n *FakeNotifier) Notify(notification Notification) {
+	n.notifications <- notification
+}
+
+// Notifications returns the channel notifications are delivered on
+func (n *FakeNotifier) Notifications() <-chan Notification {
+	return n.notifications
+}
+
+// Close closes the notifications channel
+func (n *FakeNotifier) Close() error {
+	close(n.notifications)
+	return nil
+}
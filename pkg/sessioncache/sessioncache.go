@@ -0,0 +1,192 @@
+package sessioncache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+)
+
+// Loader fetches a session and its user from the source of truth on a cache miss, postgres.Databaser satisfies it
+type Loader interface {
+	GetSessionByUUID(uuid string) (models.Session, error)
+	GetUserByUUID(uuid string) (models.User, error)
+}
+
+// Load reads a session and the user it belongs to from the loader. The user is only looked up when there's a
+// session, and is empty if they've been deleted.
+func Load(loader Loader, uuid string) (models.Session, models.User, error) {
+	session, err := loader.GetSessionByUUID(uuid)
+	if err != nil || session.UUID == "" {
+		return session, models.User{}, err
+	}
+
+	user, err := loader.GetUserByUUID(session.UserUUID)
+	return session, user, err
+}
+
+// Cache keeps recently validated sessions, along with their users, in memory so protected requests don't need a
+// round trip to Postgres. It's bounded by size (least recently used entries are evicted first) and entries expire
+// after a TTL, which caps how stale an entry can get if a notification is ever missed.
+type Cache struct {
+	loader  Loader
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+
+	// loading has the lookups going to the loader, so invalidations that land while one is in flight can stop it
+	// putting back what was just revoked. generation does the same for Flush and InvalidateUser, which could
+	// affect any lookup.
+	loading    map[string]*load
+	generation uint64
+}
+
+type entry struct {
+	session  models.Session
+	user     models.User
+	cachedAt time.Time
+}
+
+// load is the lookups of one session in flight, and how many times it's been invalidated since they started
+type load struct {
+	waiting       int
+	invalidations uint64
+}
+
+// New returns a cache holding up to size sessions for ttl each, loading misses from loader
+func New(loader Loader, size int, ttl time.Duration) *Cache {
+	return &Cache{
+		loader:  loader,
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+		loading: map[string]*load{},
+	}
+}
+
+// GetSessionAndUser returns the session and its user from the cache, or from the loader if they aren't cached or
+// have gone stale
+func (c *Cache) GetSessionAndUser(uuid string) (models.Session, models.User, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[uuid]; ok {
+		e := element.Value.(*entry)
+		if c.now().Sub(e.cachedAt) < c.ttl {
+			c.order.MoveToFront(element)
+			c.mu.Unlock()
+			return e.session, e.user, nil
+		}
+		c.remove(element)
+	}
+
+	// Note how many invalidations there had been before going to the loader, if that changes by the time it answers
+	// the answer may predate a revocation
+	l, ok := c.loading[uuid]
+	if !ok {
+		l = &load{}
+		c.loading[uuid] = l
+	}
+	l.waiting++
+	invalidations, generation := l.invalidations, c.generation
+	c.mu.Unlock()
+
+	session, user, err := Load(c.loader, uuid)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l.waiting--
+	if l.waiting == 0 {
+		delete(c.loading, uuid)
+	}
+
+	if err != nil {
+		return session, user, err
+	}
+
+	// Don't fill the cache with lookups for sessions or users that don't exist, or that raced an invalidation
+	if session.UUID == "" || user.UUID == "" || l.invalidations != invalidations || c.generation != generation {
+		return session, user, nil
+	}
+
+	if element, ok := c.entries[uuid]; ok {
+		c.remove(element)
+	}
+	c.entries[uuid] = c.order.PushFront(&entry{session: session, user: user, cachedAt: c.now()})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+
+	return session, user, nil
+}
+
+// Invalidate drops a session from the cache so the next lookup goes to the loader
+func (c *Cache) Invalidate(uuid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[uuid]; ok {
+		c.remove(element)
+	}
+	if l, ok := c.loading[uuid]; ok {
+		l.invalidations++
+	}
+}
+
+// InvalidateUser drops every session belonging to the user, so the next lookup of any of them reloads the user too
+func (c *Cache) InvalidateUser(userUUID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Users change rarely enough that looking through every entry is cheaper than keeping an index up to date
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*entry).session.UserUUID == userUUID {
+			c.remove(element)
+		}
+		element = next
+	}
+	c.generation++
+}
+
+// Flush drops every session from the cache
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+}
+
+// Len returns the number of cached sessions
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// Listen invalidates sessions and users as notifications arrive from the notifier, until its channel is closed
+func (c *Cache) Listen(n Notifier) {
+	for notification := range n.Notifications() {
+		switch {
+		case notification.SessionUUID != "":
+			c.Invalidate(notification.SessionUUID)
+		case notification.UserUUID != "":
+			c.InvalidateUser(notification.UserUUID)
+		default:
+			c.Flush()
+		}
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*entry).session.UUID)
+	c.order.Remove(element)
+}
@@ -0,0 +1,197 @@
+package sessioncache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+)
+
+// countingLoader returns a session for any UUID other than "missing", belonging to "abc" unless it's "orphan" whose
+// user was deleted, and counts the lookups
+type countingLoader struct {
+	loads int
+}
+
+func (l *countingLoader) GetSessionByUUID(uuid string) (models.Session, error) {
+	l.loads++
+	switch uuid {
+	case "missing":
+		return models.Session{}, nil
+	case "orphan":
+		return models.Session{UUID: uuid, UserUUID: "deleted"}, nil
+	}
+	return models.Session{UUID: uuid, UserUUID: "abc"}, nil
+}
+
+func (l *countingLoader) GetUserByUUID(uuid string) (models.User, error) {
+	if uuid == "deleted" {
+		return models.User{}, nil
+	}
+	return models.User{UUID: uuid}, nil
+}
+
+func TestCache_GetSessionAndUser(t *testing.T) {
+	currentTime := time.Now()
+
+	tests := []struct {
+		name      string
+		size      int
+		lookups   []string
+		advance   time.Duration
+		wantLoads int
+		wantLen   int
+	}{
+		{
+			name:      "repeat lookups hit the cache",
+			size:      10,
+			lookups:   []string{"a", "a", "a"},
+			wantLoads: 1,
+			wantLen:   1,
+		},
+		{
+			name:      "stale entries are reloaded",
+			size:      10,
+			lookups:   []string{"a", "a"},
+			advance:   2 * time.Minute,
+			wantLoads: 2,
+			wantLen:   1,
+		},
+		{
+			name:      "least recently used entries are evicted",
+			size:      2,
+			lookups:   []string{"a", "b", "a", "c", "a", "b"},
+			wantLoads: 4,
+			wantLen:   2,
+		},
+		{
+			name:      "missing sessions aren't cached",
+			size:      10,
+			lookups:   []string{"missing", "missing"},
+			wantLoads: 2,
+			wantLen:   0,
+		},
+		{
+			name:      "sessions of deleted users aren't cached",
+			size:      10,
+			lookups:   []string{"orphan", "orphan"},
+			wantLoads: 2,
+			wantLen:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := &countingLoader{}
+			c := New(loader, tt.size, time.Minute)
+			now := currentTime
+			c.now = func() time.Time { return now }
+
+			for _, uuid := range tt.lookups {
+				got, user, err := c.GetSessionAndUser(uuid)
+				if err != nil {
+					t.Fatalf("Cache.GetSessionAndUser() error = %v", err)
+				}
+				if uuid != "missing" && (got.UUID != uuid || user.UUID != got.UserUUID && got.UserUUID != "deleted") {
+					t.Errorf("Cache.GetSessionAndUser() = %v, %v, want %v and its user", got.UUID, user.UUID, uuid)
+				}
+				now = now.Add(tt.advance)
+			}
+
+			if loader.loads != tt.wantLoads {
+				t.Errorf("Cache.GetSessionAndUser() loads = %v, want %v", loader.loads, tt.wantLoads)
+			}
+			if c.Len() != tt.wantLen {
+				t.Errorf("Cache.Len() = %v, want %v", c.Len(), tt.wantLen)
+			}
+		})
+	}
+}
+
+// slowLoader holds every lookup until it's released, telling the test when one has started
+type slowLoader struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (l *slowLoader) GetSessionByUUID(uuid string) (models.Session, error) {
+	l.started <- struct{}{}
+	<-l.release
+	return models.Session{UUID: uuid, UserUUID: "abc"}, nil
+}
+
+func (l *slowLoader) GetUserByUUID(uuid string) (models.User, error) {
+	return models.User{UUID: uuid}, nil
+}
+
+func TestCache_InvalidateDuringLoad(t *testing.T) {
+	for _, invalidate := range []func(c *Cache){
+		func(c *Cache) { c.Invalidate("a") },
+		func(c *Cache) { c.InvalidateUser("abc") },
+		func(c *Cache) { c.Flush() },
+	} {
+		loader := &slowLoader{started: make(chan struct{}), release: make(chan struct{})}
+		c := New(loader, 10, time.Minute)
+
+		done := make(chan models.Session)
+		go func() {
+			session, _, _ := c.GetSessionAndUser("a")
+			done <- session
+		}()
+
+		// The session is revoked after it was read from the loader, but before the read got back
+		<-loader.started
+		invalidate(c)
+		close(loader.release)
+
+		if session := <-done; session.UUID != "a" {
+			t.Errorf("Cache.GetSessionAndUser() = %v, want a", session.UUID)
+		}
+		if c.Len() != 0 {
+			t.Errorf("Cache.Len() after an invalidation during a load = %v, want 0", c.Len())
+		}
+	}
+}
+
+func TestCache_Listen(t *testing.T) {
+	c := New(&countingLoader{}, 10, time.Minute)
+	n := NewFakeNotifier()
+	done := make(chan struct{})
+	go func() {
+		c.Listen(n)
+		close(done)
+	}()
+
+	c.GetSessionAndUser("a")
+	c.GetSessionAndUser("b")
+	c.GetSessionAndUser("c")
+
+	// A revocation only drops that session
+	n.Notify(Notification{SessionUUID: "a"})
+	waitForLen(t, c, 2)
+
+	// A missed notification drops everything
+	n.Notify(Notification{})
+	waitForLen(t, c, 0)
+
+	// A change to a user drops their sessions and nobody else's
+	c.GetSessionAndUser("a")
+	c.GetSessionAndUser("b")
+	n.Notify(Notification{UserUUID: "someone-else"})
+	waitForLen(t, c, 2)
+	n.Notify(Notification{UserUUID: "abc"})
+	waitForLen(t, c, 0)
+
+	n.Close()
+	<-done
+}
+
+// waitForLen waits for the listener to catch up, since it invalidates on its own goroutine
+func waitForLen(t *testing.T, c *Cache, want int) {
+	deadline := time.Now().Add(time.Second)
+	for c.Len() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Cache.Len() = %v, want %v", c.Len(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,55 @@
+package signing
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+)
+
+// Handler protects an http.Handler the same way jwt.Handler does, but verifies tokens against a Register
+// so any key in it (including EdDSA ones the jwt package can't check) is accepted.
+type Handler struct {
+	// Target is the secured service.
+	Target http.Handler
+
+	// Keys are the credentials tokens are checked against.
+	Keys *Register
+
+	// HeaderBinding maps JWT claim names to HTTP header names, all requests passed to Target have these headers set.
+	HeaderBinding map[string]string
+}
+
+// ServeHTTP honors the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.Keys.CheckHeader(r)
+	if err != nil {
+		if err == jwt.ErrNoHeader {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description=`+strconv.QuoteToASCII(err.Error()))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !claims.Valid(time.Now()) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="jwt: time constraints exceeded"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	for claimName, headerName := range h.HeaderBinding {
+		s, ok := claims.String(claimName)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description=`+strconv.QuoteToASCII("jwt: want string for claim "+claimName))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.Header.Set(headerName, s)
+	}
+
+	h.Target.ServeHTTP(w, r)
+}
@@ -0,0 +1,88 @@
+package signing
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := NewKey(edKey)
+	keys := NewRegister()
+	keys.SetSigner(key)
+
+	valid, _ := keys.Sign(testClaims())
+
+	expiredClaims := testClaims()
+	expiredClaims.Expires = jwt.NewNumericTime(time.Now().Add(-time.Minute))
+	expired, _ := keys.Sign(expiredClaims)
+
+	missingClaims := testClaims()
+	missingClaims.Set = nil
+	missing, _ := keys.Sign(missingClaims)
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantSession   string
+	}{
+		{
+			name:          "valid token",
+			authorization: "Bearer " + string(valid),
+			wantStatus:    http.StatusOK,
+			wantSession:   "abc",
+		},
+		{
+			name:       "no token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "expired token",
+			authorization: "Bearer " + string(expired),
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "token missing a bound claim",
+			authorization: "Bearer " + string(missing),
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "garbage token",
+			authorization: "Bearer abc.def.ghi",
+			wantStatus:    http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotSession string
+			h := &Handler{
+				Target: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotSession = r.Header.Get("X-Verified-Session-Uuid")
+				}),
+				Keys:          keys,
+				HeaderBinding: map[string]string{"sid": "X-Verified-Session-Uuid"},
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Handler.ServeHTTP() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if gotSession != tt.wantSession {
+				t.Errorf("Handler.ServeHTTP() session header = %v, want %v", gotSession, tt.wantSession)
+			}
+		})
+	}
+}
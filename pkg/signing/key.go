@@ -0,0 +1,211 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pascaldekloe/jwt"
+	"golang.org/x/crypto/ed25519"
+)
+
+// EdDSA is the JWS algorithm identifier for Ed25519 signatures (RFC 8037), the jwt package doesn't know about it
+const EdDSA = "EdDSA"
+
+// oidEd25519 identifies Ed25519 keys in PKCS #8 and PKIX structures (RFC 8410)
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// Key is a public key we accept token signatures from, and when the private half is present, one we can sign with
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+	private   crypto.PrivateKey
+}
+
+// NewKey wraps a private or public RSA, ECDSA or Ed25519 key, picking the JWS algorithm from the key type.
+// The key ID defaults to the JWK thumbprint (RFC 7638) of the public key.
+func NewKey(key interface{}) (*Key, error) {
+	k, err := newKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	k.ID = k.Thumbprint()
+	return k, nil
+}
+
+func newKey(key interface{}) (*Key, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Algorithm: jwt.RS256, Public: &k.PublicKey, private: k}, nil
+	case *rsa.PublicKey:
+		return &Key{Algorithm: jwt.RS256, Public: k}, nil
+	case *ecdsa.PrivateKey:
+		alg, err := ecdsaAlgorithm(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{Algorithm: alg, Public: &k.PublicKey, private: k}, nil
+	case *ecdsa.PublicKey:
+		alg, err := ecdsaAlgorithm(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{Algorithm: alg, Public: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{Algorithm: EdDSA, Public: k.Public(), private: k}, nil
+	case ed25519.PublicKey:
+		return &Key{Algorithm: EdDSA, Public: k}, nil
+	default:
+		return nil, fmt.Errorf("signing: unsupported key type %T", key)
+	}
+}
+
+// CanSign reports whether we hold the private half of the key
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// Sign returns a new JWT for the claims signed with the key, stamping the key ID in the header
+func (k *Key) Sign(claims *jwt.Claims) ([]byte, error) {
+	claims.KeyID = k.ID
+
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		return claims.RSASign(k.Algorithm, private)
+	case *ecdsa.PrivateKey:
+		return claims.ECDSASign(k.Algorithm, private)
+	case ed25519.PrivateKey:
+		return signEdDSA(claims, private)
+	default:
+		return nil, errors.New("signing: key has no private half to sign with")
+	}
+}
+
+// LoadPEMFile reads every key out of a PEM file, see ParsePEM
+func LoadPEMFile(path string) ([]*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePEM(data)
+}
+
+// ParsePEM reads every key out of PEM encoded data, supporting PKCS #1 and SEC 1 private keys along with
+// PKCS #8 private keys and PKIX public keys for RSA, ECDSA and Ed25519
+func ParsePEM(data []byte) ([]*Key, error) {
+	keys := []*Key{}
+	for {
+		block, remainder := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = remainder
+
+		var parsed interface{}
+		var err error
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			parsed, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			parsed, err = parsePKCS8PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			parsed, err = parsePKIXPublicKey(block.Bytes)
+		default:
+			err = fmt.Errorf("signing: unsupported PEM type %q", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := NewKey(parsed)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("signing: no PEM encoded keys found")
+	}
+
+	return keys, nil
+}
+
+func ecdsaAlgorithm(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.ES256, nil
+	case elliptic.P384():
+		return jwt.ES384, nil
+	case elliptic.P521():
+		return jwt.ES512, nil
+	default:
+		return "", errors.New("signing: unsupported elliptic curve")
+	}
+}
+
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+	Attributes asn1.RawValue `asn1:"optional,tag:0"`
+	PublicKey  asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkixPublicKey struct {
+	Algo      pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// parsePKCS8PrivateKey handles Ed25519 itself since older versions of crypto/x509 don't understand it
+func parsePKCS8PrivateKey(der []byte) (interface{}, error) {
+	var privKey pkcs8
+	if _, err := asn1.Unmarshal(der, &privKey); err != nil {
+		return nil, err
+	}
+
+	if !privKey.Algo.Algorithm.Equal(oidEd25519) {
+		return x509.ParsePKCS8PrivateKey(der)
+	}
+
+	var seed []byte
+	if _, err := asn1.Unmarshal(privKey.PrivateKey, &seed); err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("signing: invalid Ed25519 private key")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// parsePKIXPublicKey handles Ed25519 itself since older versions of crypto/x509 don't understand it
+func parsePKIXPublicKey(der []byte) (interface{}, error) {
+	var pubKey pkixPublicKey
+	if _, err := asn1.Unmarshal(der, &pubKey); err != nil {
+		return nil, err
+	}
+
+	if !pubKey.Algo.Algorithm.Equal(oidEd25519) {
+		return x509.ParsePKIXPublicKey(der)
+	}
+
+	if len(pubKey.PublicKey.Bytes) != ed25519.PublicKeySize {
+		return nil, errors.New("signing: invalid Ed25519 public key")
+	}
+
+	return ed25519.PublicKey(pubKey.PublicKey.Bytes), nil
+}
@@ -0,0 +1,106 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// pemEncode encodes a private key the way openssl genpkey would, as PKCS #8
+func pemEncode(t *testing.T, key interface{}) []byte {
+	var der []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		var seed []byte
+		seed, err = asn1.Marshal(k.Seed())
+		if err == nil {
+			der, err = asn1.Marshal(pkcs8{Algo: pkix.AlgorithmIdentifier{Algorithm: oidEd25519}, PrivateKey: seed})
+		}
+	default:
+		der, err = x509.MarshalPKCS8PrivateKey(k)
+	}
+	if err != nil {
+		t.Fatalf("error marshaling key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParsePEM(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecPublic, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	edPublic, _ := asn1.Marshal(pkixPublicKey{Algo: pkix.AlgorithmIdentifier{Algorithm: oidEd25519}, PublicKey: asn1.BitString{Bytes: edKey.Public().(ed25519.PublicKey), BitLength: 8 * ed25519.PublicKeySize}})
+
+	tests := []struct {
+		name          string
+		data          []byte
+		wantAlgorithm string
+		wantCanSign   bool
+		wantErr       bool
+	}{
+		{
+			name:          "PKCS #1 RSA private key",
+			data:          pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+			wantAlgorithm: "RS256",
+			wantCanSign:   true,
+		},
+		{
+			name:          "PKCS #8 ECDSA private key",
+			data:          pemEncode(t, ecKey),
+			wantAlgorithm: "ES256",
+			wantCanSign:   true,
+		},
+		{
+			name:          "PKCS #8 Ed25519 private key",
+			data:          pemEncode(t, edKey),
+			wantAlgorithm: "EdDSA",
+			wantCanSign:   true,
+		},
+		{
+			name:          "PKIX ECDSA public key",
+			data:          pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecPublic}),
+			wantAlgorithm: "ES256",
+		},
+		{
+			name:          "PKIX Ed25519 public key",
+			data:          pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edPublic}),
+			wantAlgorithm: "EdDSA",
+		},
+		{
+			name:    "unsupported block",
+			data:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: []byte("abc")}),
+			wantErr: true,
+		},
+		{
+			name:    "no PEM data",
+			data:    []byte("not a key"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePEM(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePEM() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != 1 || got[0].Algorithm != tt.wantAlgorithm || got[0].CanSign() != tt.wantCanSign {
+				t.Errorf("ParsePEM() = %+v, want algorithm %v and CanSign %v", got, tt.wantAlgorithm, tt.wantCanSign)
+			}
+		})
+	}
+}
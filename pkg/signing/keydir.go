@@ -0,0 +1,132 @@
+package signing
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// currentFile is the name of the file in a key directory holding the ID of the key to sign with
+const currentFile = "current"
+
+// KeyDir keeps a register in sync with a directory of PEM files, one key per "<key id>.pem" file.
+//
+// Rotating is done entirely on disk: drop in the new key's file, point the "current" file at its ID once
+// verifiers have had a chance to fetch it from the JWKS endpoint, and delete the old key's file once every
+// token it signed has expired. Without a "current" file the private key with the greatest ID signs, so
+// date prefixed IDs (ex: 2019-04-20.pem) rotate on their own.
+type KeyDir struct {
+	Path string
+
+	// loaded tracks the keys that came from the directory, so we only ever retire keys we added
+	loaded map[string]bool
+}
+
+// NewKeyDir returns a KeyDir for the directory at path
+func NewKeyDir(path string) *KeyDir {
+	return &KeyDir{Path: path, loaded: map[string]bool{}}
+}
+
+// Sync loads new keys from the directory into the register, switches the signer if needed,
+// and retires keys whose files have been removed.
+//
+// If the "current" file names a key that isn't in the directory, or can't sign, the register keeps signing
+// with the key it already has; it's only an error when there's no signer yet to fall back on.
+func (d *KeyDir) Sync(r *Register) error {
+	paths, err := filepath.Glob(filepath.Join(d.Path, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*Key{}
+	ids := []string{}
+	for _, path := range paths {
+		loaded, err := LoadPEMFile(path)
+		if err != nil {
+			return err
+		}
+
+		key := loaded[0]
+		key.ID = strings.TrimSuffix(filepath.Base(path), ".pem")
+		keys[key.ID] = key
+		ids = append(ids, key.ID)
+	}
+	sort.Strings(ids)
+
+	signer, err := d.currentSigner(keys, ids)
+	if err == ErrUnknownKey && r.Signer() != "" {
+		log.Printf("%v doesn't name a signing key in %v, still signing with key %v", currentFile, d.Path, r.Signer())
+	} else if err != nil {
+		return err
+	}
+
+	// Add everything first so the new signer is verifiable before anything is signed with it
+	for _, id := range ids {
+		r.Add(keys[id])
+		d.loaded[id] = true
+	}
+
+	if signer != nil && r.Signer() != signer.ID {
+		err = r.SetSigner(signer)
+		if err != nil {
+			return err
+		}
+		log.Printf("signing new tokens with key %v", signer.ID)
+	}
+
+	for id := range d.loaded {
+		// The signer's file can only be removed once another key has taken over, until then it's kept
+		if keys[id] != nil || id == r.Signer() {
+			continue
+		}
+
+		err = r.Retire(id)
+		if err != nil && err != ErrUnknownKey {
+			return err
+		}
+		delete(d.loaded, id)
+		log.Printf("retired signing key %v", id)
+	}
+
+	return nil
+}
+
+// Watch syncs the register with the directory every interval until stop is closed
+func (d *KeyDir) Watch(r *Register, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := d.Sync(r)
+			if err != nil {
+				log.Printf("couldn't sync signing keys from %v: %v", d.Path, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (d *KeyDir) currentSigner(keys map[string]*Key, ids []string) (*Key, error) {
+	current, err := ioutil.ReadFile(filepath.Join(d.Path, currentFile))
+	if err == nil {
+		id := strings.TrimSpace(string(current))
+		if keys[id] == nil || !keys[id].CanSign() {
+			return nil, ErrUnknownKey
+		}
+		return keys[id], nil
+	}
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		if keys[ids[i]].CanSign() {
+			return keys[ids[i]], nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,147 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestKeyDir_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keydir")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeKey := func(name string, key interface{}) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), pemEncode(t, key), 0600)
+		if err != nil {
+			t.Fatalf("error writing key: %v", err)
+		}
+	}
+
+	r := NewRegister()
+	d := NewKeyDir(dir)
+
+	// Start out with a single key
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeKey("2019-01-01.pem", oldKey)
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() error = %v", err)
+	}
+	if r.Signer() != "2019-01-01" {
+		t.Fatalf("KeyDir.Sync() signer = %v, want %v", r.Signer(), "2019-01-01")
+	}
+	oldToken, _ := r.Sign(testClaims())
+
+	// Rotate in a new key, the old one should keep verifying
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writeKey("2019-02-01.pem", newKey)
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() error = %v", err)
+	}
+	if r.Signer() != "2019-02-01" {
+		t.Fatalf("KeyDir.Sync() signer = %v, want %v", r.Signer(), "2019-02-01")
+	}
+	newToken, _ := r.Sign(testClaims())
+
+	if _, err := r.Check(oldToken); err != nil {
+		t.Errorf("Register.Check() old token error = %v before retiring its key", err)
+	}
+	if claims, err := r.Check(newToken); err != nil || claims.KeyID != "2019-02-01" {
+		t.Errorf("Register.Check() new token = %v, %v, want kid %v", claims, err, "2019-02-01")
+	}
+
+	// Pin the signer back to the old key with the current file
+	ioutil.WriteFile(filepath.Join(dir, "current"), []byte("2019-01-01\n"), 0600)
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() error = %v", err)
+	}
+	if r.Signer() != "2019-01-01" {
+		t.Errorf("KeyDir.Sync() signer = %v, want pinned %v", r.Signer(), "2019-01-01")
+	}
+	os.Remove(filepath.Join(dir, "current"))
+	d.Sync(r)
+
+	// Retire the old key by removing its file
+	os.Remove(filepath.Join(dir, "2019-01-01.pem"))
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() error = %v", err)
+	}
+	if _, err := r.Check(oldToken); err != ErrUnknownKey {
+		t.Errorf("Register.Check() old token error = %v after retiring its key, want %v", err, ErrUnknownKey)
+	}
+	if _, err := r.Check(newToken); err != nil {
+		t.Errorf("Register.Check() new token error = %v", err)
+	}
+	if ids := r.KeyIDs(); len(ids) != 1 || ids[0] != "2019-02-01" {
+		t.Errorf("Register.KeyIDs() = %v, want only the new key", ids)
+	}
+}
+
+func TestKeyDir_SyncUnknownCurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keydir")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeKey := func(name string, key interface{}) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), pemEncode(t, key), 0600)
+		if err != nil {
+			t.Fatalf("error writing key: %v", err)
+		}
+	}
+
+	// With nothing signing yet there's nothing to fall back on
+	ioutil.WriteFile(filepath.Join(dir, "current"), []byte("missing"), 0600)
+	if err := NewKeyDir(dir).Sync(NewRegister()); err != ErrUnknownKey {
+		t.Errorf("KeyDir.Sync() error = %v, want %v", err, ErrUnknownKey)
+	}
+	os.Remove(filepath.Join(dir, "current"))
+
+	r := NewRegister()
+	d := NewKeyDir(dir)
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeKey("2019-01-01.pem", oldKey)
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() error = %v", err)
+	}
+
+	// A typo in the current file keeps the signer we had, while new keys are still picked up
+	ioutil.WriteFile(filepath.Join(dir, "current"), []byte("2019-02-0l"), 0600)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writeKey("2019-02-01.pem", newKey)
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() with an unknown current key error = %v", err)
+	}
+	if r.Signer() != "2019-01-01" {
+		t.Errorf("KeyDir.Sync() signer = %v, want %v kept", r.Signer(), "2019-01-01")
+	}
+	if ids := r.KeyIDs(); len(ids) != 2 {
+		t.Errorf("Register.KeyIDs() = %v, want both keys", ids)
+	}
+
+	// Removing the signer's file doesn't retire it until another key takes over
+	os.Remove(filepath.Join(dir, "2019-01-01.pem"))
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() error = %v", err)
+	}
+	if r.Signer() != "2019-01-01" {
+		t.Errorf("KeyDir.Sync() signer = %v, want %v kept", r.Signer(), "2019-01-01")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "current"), []byte("2019-02-01"), 0600)
+	if err := d.Sync(r); err != nil {
+		t.Fatalf("KeyDir.Sync() error = %v", err)
+	}
+	if ids := r.KeyIDs(); r.Signer() != "2019-02-01" || len(ids) != 1 {
+		t.Errorf("KeyDir.Sync() signer = %v with keys %v, want only %v", r.Signer(), ids, "2019-02-01")
+	}
+}
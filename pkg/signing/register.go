@@ -0,0 +1,234 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pascaldekloe/jwt"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrNoSigner is returned when a register is asked to sign without any key or secret to sign with
+var ErrNoSigner = errors.New("signing: no signing key configured")
+
+// ErrUnknownKey is returned when a token names a key ID that isn't in the register, most likely because it was retired
+var ErrUnknownKey = errors.New("signing: unknown key ID")
+
+// Register is our key ring, it holds every key we accept token signatures from along with the one key we sign
+// new tokens with. Keys can be added and retired while it's in use, which is how we rotate without downtime:
+// add the new key, start signing with it, and only retire the old one once the tokens it signed have expired.
+type Register struct {
+	mu      sync.RWMutex
+	signer  *Key
+	keys    []*Key
+	secrets [][]byte
+}
+
+// Keys is the register used to sign and verify tokens across the service
+var Keys = NewRegister()
+
+// NewRegister returns an empty register
+func NewRegister() *Register {
+	return &Register{}
+}
+
+// Add accepts signatures from the key, replacing any key already registered under the same ID
+func (r *Register) Add(key *Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.add(key)
+}
+
+func (r *Register) add(key *Key) {
+	for i, existing := range r.keys {
+		if existing.ID == key.ID {
+			r.keys[i] = key
+			return
+		}
+	}
+
+	r.keys = append(r.keys, key)
+}
+
+// SetSigner makes the key the one new tokens are signed with, it's also accepted for verification
+func (r *Register) SetSigner(key *Key) error {
+	if !key.CanSign() {
+		return errors.New("signing: signer must be a private key")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.signer = key
+	r.add(key)
+	return nil
+}
+
+// Signer returns the ID of the key new tokens are signed with, or an empty string when signing with a shared secret
+func (r *Register) Signer() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.signer == nil {
+		return ""
+	}
+
+	return r.signer.ID
+}
+
+// SigningAlgorithm returns the JWS algorithm new tokens are signed with, or an empty string when there's nothing to sign with
+func (r *Register) SigningAlgorithm() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.signer != nil {
+		return r.signer.Algorithm
+	}
+
+	if len(r.secrets) > 0 {
+		return jwt.HS512
+	}
+
+	return ""
+}
+
+// Retire stops accepting signatures from the key, tokens it signed fail to verify from then on.
+// The current signer can't be retired, another key has to take over signing first.
+func (r *Register) Retire(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.signer != nil && r.signer.ID == id {
+		return fmt.Errorf("signing: key %q is the current signer", id)
+	}
+
+	for i, key := range r.keys {
+		if key.ID == id {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrUnknownKey
+}
+
+// KeyIDs returns the IDs of every key in the register
+func (r *Register) KeyIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := []string{}
+	for _, key := range r.keys {
+		ids = append(ids, key.ID)
+	}
+
+	return ids
+}
+
+// AddSecret accepts HS512 signatures from a shared secret, the first secret added is used
+// for signing when no asymmetric signer is set so deployments can migrate off JWT_KEY gradually
+func (r *Register) AddSecret(secret []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.secrets = append(r.secrets, secret)
+}
+
+// Sign returns a new JWT for the claims, with the "kid" header set to the signing key
+func (r *Register) Sign(claims *jwt.Claims) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.signer != nil {
+		return r.signer.Sign(claims)
+	}
+
+	if len(r.secrets) > 0 {
+		return claims.HMACSign(jwt.HS512, r.secrets[0])
+	}
+
+	return nil, ErrNoSigner
+}
+
+// Check parses a JWT and returns the claims if, and only if, the signature matches a key in the register.
+// Tokens with a "kid" header are only checked against that key, tokens without one (minted before we stamped
+// key IDs) are checked against all of them. Like jwt.KeyRegister.Check this doesn't validate the time
+// constraints, see jwt.Claims.Valid.
+func (r *Register) Check(token []byte) (*jwt.Claims, error) {
+	h, err := parseHeader(token)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	candidates := []*Key{}
+	for _, key := range r.keys {
+		if h.Kid == "" || key.ID == h.Kid {
+			candidates = append(candidates, key)
+		}
+	}
+	secrets := r.secrets
+	r.mu.RUnlock()
+
+	if h.Kid != "" && len(candidates) == 0 {
+		return nil, ErrUnknownKey
+	}
+
+	if h.Alg == EdDSA {
+		keys := []ed25519.PublicKey{}
+		for _, key := range candidates {
+			if public, ok := key.Public.(ed25519.PublicKey); ok {
+				keys = append(keys, public)
+			}
+		}
+		return checkEdDSA(token, keys, h.Kid)
+	}
+
+	keys := &jwt.KeyRegister{}
+	if h.Kid == "" {
+		keys.Secrets = secrets
+	}
+	for _, key := range candidates {
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			keys.RSAs = append(keys.RSAs, public)
+		case *ecdsa.PublicKey:
+			keys.ECDSAs = append(keys.ECDSAs, public)
+		}
+	}
+
+	return keys.Check(token)
+}
+
+// CheckHeader applies Check to the bearer token in the Authorization header of a request
+func (r *Register) CheckHeader(req *http.Request) (*jwt.Claims, error) {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return nil, jwt.ErrNoHeader
+	}
+
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, errors.New("signing: want Bearer schema")
+	}
+
+	return r.Check([]byte(auth[len("Bearer "):]))
+}
+
+// JWKS returns the JSON Web Key Set of every asymmetric key in the register, shared secrets are never published
+func (r *Register) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range r.keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+
+	return set
+}
@@ -0,0 +1,226 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+	"golang.org/x/crypto/ed25519"
+)
+
+func testClaims() *jwt.Claims {
+	var claims jwt.Claims
+	claims.Issuer = "fender"
+	claims.Subject = "abc"
+	claims.Audiences = []string{"platform"}
+	claims.Expires = jwt.NewNumericTime(time.Now().Add(time.Minute))
+	claims.Set = map[string]interface{}{"sid": "abc"}
+	return &claims
+}
+
+func TestRegister_SignAndCheck(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherEdKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name     string
+		signer   interface{}
+		secret   []byte
+		verifier interface{}
+		wantErr  bool
+	}{
+		{
+			name:   "RS256",
+			signer: rsaKey,
+		},
+		{
+			name:   "ES256",
+			signer: ecKey,
+		},
+		{
+			name:   "EdDSA",
+			signer: edKey,
+		},
+		{
+			name:   "legacy HS512 secret",
+			secret: []byte("fenderdigital"),
+		},
+		{
+			name:     "EdDSA signed by an unknown key",
+			signer:   otherEdKey,
+			verifier: edKey.Public(),
+			wantErr:  true,
+		},
+		{
+			name:     "ES256 signed by an unknown key",
+			signer:   ecKey,
+			verifier: rsaKey.Public(),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := NewRegister()
+			if tt.secret != nil {
+				signer.AddSecret(tt.secret)
+			} else {
+				key, err := NewKey(tt.signer)
+				if err != nil {
+					t.Fatalf("NewKey() error = %v", err)
+				}
+				signer.SetSigner(key)
+			}
+
+			// Verify on a register that only holds public keys, the way a downstream service would
+			verifier := NewRegister()
+			if tt.secret != nil {
+				verifier.AddSecret(tt.secret)
+			} else if tt.verifier != nil {
+				key, _ := NewKey(tt.verifier)
+				verifier.Add(key)
+			} else {
+				for _, key := range signer.keys {
+					public, _ := NewKey(key.Public)
+					verifier.Add(public)
+				}
+			}
+
+			token, err := signer.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("Register.Sign() error = %v", err)
+			}
+
+			got, err := verifier.Check(token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Register.Check() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			sid, _ := got.String("sid")
+			if got.Subject != "abc" || sid != "abc" || len(got.Audiences) != 1 || !got.Valid(time.Now()) {
+				t.Errorf("Register.Check() = %+v, want the signed claims back", got)
+			}
+		})
+	}
+}
+
+func TestRegister_SignWithoutKeys(t *testing.T) {
+	_, err := NewRegister().Sign(testClaims())
+	if err != ErrNoSigner {
+		t.Errorf("Register.Sign() error = %v, want %v", err, ErrNoSigner)
+	}
+}
+
+func TestRegister_SigningAlgorithm(t *testing.T) {
+	r := NewRegister()
+	if alg := r.SigningAlgorithm(); alg != "" {
+		t.Errorf("Register.SigningAlgorithm() without keys = %q, want none", alg)
+	}
+
+	r.AddSecret([]byte("fenderdigital"))
+	if alg := r.SigningAlgorithm(); alg != jwt.HS512 {
+		t.Errorf("Register.SigningAlgorithm() with a secret = %q, want %q", alg, jwt.HS512)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, _ := NewKey(ecKey)
+	r.SetSigner(key)
+	if alg := r.SigningAlgorithm(); alg != jwt.ES256 {
+		t.Errorf("Register.SigningAlgorithm() with a key = %q, want %q", alg, jwt.ES256)
+	}
+}
+
+func TestRegister_JWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	r := NewRegister()
+	r.AddSecret([]byte("fenderdigital"))
+	for _, k := range []interface{}{rsaKey, &ecKey.PublicKey, edPublic} {
+		key, _ := NewKey(k)
+		r.Add(key)
+	}
+
+	got := r.JWKS()
+	if len(got.Keys) != 3 {
+		t.Fatalf("Register.JWKS() = %v keys, want 3", len(got.Keys))
+	}
+
+	want := []JWK{
+		{KeyType: "RSA", Algorithm: "RS256", E: "AQAB"},
+		{KeyType: "EC", Algorithm: "ES256", Curve: "P-256"},
+		{KeyType: "OKP", Algorithm: "EdDSA", Curve: "Ed25519"},
+	}
+	for i, jwk := range got.Keys {
+		if jwk.KeyType != want[i].KeyType || jwk.Algorithm != want[i].Algorithm || jwk.Curve != want[i].Curve || jwk.Use != "sig" {
+			t.Errorf("Register.JWKS()[%v] = %+v, want %+v", i, jwk, want[i])
+		}
+		if want[i].E != "" && jwk.E != want[i].E {
+			t.Errorf("Register.JWKS()[%v].E = %v, want %v", i, jwk.E, want[i].E)
+		}
+	}
+
+	if len(got.Keys[1].X) != 43 || len(got.Keys[1].Y) != 43 {
+		t.Errorf("Register.JWKS() EC coordinates aren't padded to the curve size: %+v", got.Keys[1])
+	}
+}
+
+func TestRegister_Rotation(t *testing.T) {
+	_, oldPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	newPrivate, _ := rsa.GenerateKey(rand.Reader, 2048)
+	oldKey, _ := NewKey(oldPrivate)
+	newKey, _ := NewKey(newPrivate)
+
+	r := NewRegister()
+	r.AddSecret([]byte("fenderdigital"))
+	r.SetSigner(oldKey)
+	oldToken, _ := r.Sign(testClaims())
+
+	// Tokens minted before key IDs were stamped still verify against the secret
+	legacyToken, _ := testClaims().HMACSign(jwt.HS512, []byte("fenderdigital"))
+
+	r.SetSigner(newKey)
+	newToken, _ := r.Sign(testClaims())
+
+	if err := r.Retire(newKey.ID); err == nil {
+		t.Errorf("Register.Retire() of the signer should fail")
+	}
+
+	for name, token := range map[string][]byte{"old": oldToken, "new": newToken, "legacy": legacyToken} {
+		if _, err := r.Check(token); err != nil {
+			t.Errorf("Register.Check() %v token error = %v before rotation finished", name, err)
+		}
+	}
+
+	if err := r.Retire(oldKey.ID); err != nil {
+		t.Fatalf("Register.Retire() error = %v", err)
+	}
+
+	if _, err := r.Check(oldToken); err != ErrUnknownKey {
+		t.Errorf("Register.Check() old token error = %v, want %v", err, ErrUnknownKey)
+	}
+	if claims, err := r.Check(newToken); err != nil || claims.KeyID != newKey.ID {
+		t.Errorf("Register.Check() new token = %v, %v, want kid %v", claims, err, newKey.ID)
+	}
+}
+
+func TestKey_Thumbprint(t *testing.T) {
+	// Example from RFC 7638, section 3.1
+	n, _ := encoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	key, _ := NewKey(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; key.ID != want {
+		t.Errorf("Key.Thumbprint() = %v, want %v", key.ID, want)
+	}
+}
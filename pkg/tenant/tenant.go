@@ -0,0 +1,35 @@
+// Package tenant resolves the organization a request is for. Every user belongs to the organization they signed up
+// in, and can be made a member of others, their email address only has to be unique within the one they signed up in.
+package tenant
+
+import (
+	"errors"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+)
+
+// DefaultSlug is the organization requests that don't name one are for, the migration that creates organizations
+// seeds it and moves every existing user into it
+var DefaultSlug = "default"
+
+// ErrUnknownOrganization is returned by Find when there's no organization with the slug
+var ErrUnknownOrganization = errors.New("tenant: unknown organization")
+
+// Find looks up the organization by its slug, an empty slug is DefaultSlug
+func Find(slug string) (models.Organization, error) {
+	if slug == "" {
+		slug = DefaultSlug
+	}
+
+	organization, err := postgres.DB.GetOrganizationBySlug(slug)
+	if err != nil {
+		return organization, err
+	}
+
+	if organization.UUID == "" {
+		return organization, ErrUnknownOrganization
+	}
+
+	return organization, nil
+}
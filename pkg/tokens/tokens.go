@@ -0,0 +1,276 @@
+// Package tokens issues the access and refresh tokens every kind of login ends in, so a password, a passkey and an
+// OAuth client all get the same claims signed the same way
+package tokens
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+	"github.com/pascaldekloe/jwt"
+)
+
+// Issuer is the "iss" of every token we sign, OpenID Connect needs it to be the https URL we're served from
+var Issuer = "fender"
+
+const (
+	// AccessTokenLifetime is how long a JWT is good for before it has to be refreshed
+	AccessTokenLifetime = 15 * time.Minute
+	// SessionLifetime is how long a session (and the refresh tokens in it) can be kept alive before a full login is required
+	SessionLifetime = 30 * 24 * time.Hour
+)
+
+// ErrInvalidRefreshToken is returned by Rotate for any refresh token that can't be exchanged, whatever the reason
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+
+// Access describes who an access token is for and what it can do
+type Access struct {
+	// Subject is the user's UUID, or the client's ID when a client is acting for itself
+	Subject string
+	// SessionUUID is the session the token belongs to, empty for tokens with no user behind them
+	SessionUUID string
+	// ClientID is the OAuth client the token was issued to, empty for our own logins
+	ClientID string
+	// Scope is the space separated scopes an OAuth client was granted, our own logins aren't limited
+	Scope string
+	// Organization is the UUID of the organization the session is acting in, empty for tokens with no user behind them
+	Organization string
+	// Roles are the user's roles when the token was issued, they're only a hint for frontends since permissions
+	// are checked against the roles the user has now
+	Roles []string
+}
+
+// ForSession describes an access token for a session, carrying over the client and scope it was started with
+func ForSession(session models.Session) Access {
+	return Access{Subject: session.UserUUID, SessionUUID: session.UUID, ClientID: session.ClientID, Scope: session.Scope, Organization: session.OrganizationUUID}
+}
+
+// Claims builds the JWT claims for the token, good from currentTime for AccessTokenLifetime
+func (a Access) Claims(currentTime time.Time) *jwt.Claims {
+	var claims jwt.Claims
+	claims.Issuer = Issuer
+	claims.Subject = a.Subject
+	claims.NotBefore = jwt.NewNumericTime(currentTime)
+	claims.Issued = jwt.NewNumericTime(currentTime)
+	claims.Expires = jwt.NewNumericTime(currentTime.Add(AccessTokenLifetime))
+	claims.Set = map[string]interface{}{}
+
+	if a.SessionUUID != "" {
+		claims.Set["sid"] = a.SessionUUID
+	}
+	if a.ClientID != "" {
+		claims.Set["client_id"] = a.ClientID
+		claims.Set["scope"] = a.Scope
+	}
+	if a.Organization != "" {
+		claims.Set["org"] = a.Organization
+	}
+	if len(a.Roles) > 0 {
+		claims.Set["roles"] = a.Roles
+	}
+
+	return &claims
+}
+
+// Sign signs the token's claims with the current signing key
+func (a Access) Sign(currentTime time.Time) (string, error) {
+	token, err := signing.Keys.Sign(a.Claims(currentTime))
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
+
+// HasScope reports whether scope is one of the space separated scopes in granted
+func HasScope(granted, scope string) bool {
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRefreshToken generates a refresh token for the session, only its hash is stored
+func NewRefreshToken(session models.Session) (string, error) {
+	refreshToken, err := randtoken.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = postgres.DB.CreateRefreshToken(session.UUID, randtoken.Hash(refreshToken), session.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// Rotate uses up a refresh token issued to clientID (empty for our own logins), returning the session it keeps alive
+// so new tokens can be issued for it. A refresh token is single use, seeing one again means it was stolen (or the
+// real client was), so the whole session is revoked.
+func Rotate(refreshToken, clientID string, currentTime time.Time) (models.Session, error) {
+	stored, err := postgres.DB.GetRefreshTokenByHash(randtoken.Hash(refreshToken))
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	if stored.UUID == "" {
+		return models.Session{}, ErrInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil || stored.RevokedAt != nil {
+		return models.Session{}, revoke(stored.SessionUUID)
+	}
+
+	session, err := postgres.DB.GetSessionByUUID(stored.SessionUUID)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	// Tokens only work for whoever they were issued to, a login's can't be used by a client or the other way round
+	if session.ClientID != clientID || currentTime.After(stored.ExpiresAt) {
+		return models.Session{}, ErrInvalidRefreshToken
+	}
+
+	// Nor once the session's over or its user has been deleted
+	live, err := isLive(session, session.UserUUID, currentTime)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	if !live {
+		return models.Session{}, ErrInvalidRefreshToken
+	}
+
+	// Mark the token used, if another request beat us to it then this is a replay as well
+	used, err := postgres.DB.UseRefreshTokenByUUID(stored.UUID)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	if used == 0 {
+		return models.Session{}, revoke(session.UUID)
+	}
+
+	return session, nil
+}
+
+// revoke revokes a session, returning ErrInvalidRefreshToken once it's done
+func revoke(sessionUUID string) error {
+	err := Revoke(sessionUUID)
+	if err != nil {
+		return err
+	}
+
+	return ErrInvalidRefreshToken
+}
+
+// Inspection is what Inspect found out about a token, an inactive token has nothing else filled in
+type Inspection struct {
+	Active bool
+	// Refresh is set for refresh tokens, otherwise it was an access token
+	Refresh bool
+	Access
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Inspect reports whether a token we issued can still be used. An access token needs a good signature and to not
+// have expired, and any token with a session behind it needs the session to still be live, which is what a
+// signature check alone can't tell. Anything we don't recognise is inactive.
+func Inspect(token string, currentTime time.Time) (Inspection, error) {
+	claims, err := signing.Keys.Check([]byte(token))
+	if err != nil {
+		return inspectRefreshToken(token, currentTime)
+	}
+
+	if !claims.Valid(currentTime) || claims.Issued == nil || claims.Expires == nil {
+		return Inspection{}, nil
+	}
+
+	sessionUUID, _ := claims.String("sid")
+	clientID, _ := claims.String("client_id")
+	scope, _ := claims.String("scope")
+	inspection := Inspection{
+		Access:    Access{Subject: claims.Subject, SessionUUID: sessionUUID, ClientID: clientID, Scope: scope},
+		IssuedAt:  claims.Issued.Time(),
+		ExpiresAt: claims.Expires.Time(),
+	}
+
+	// A client acting for itself has no session, anything else without one isn't an access token, ex: an id_token
+	if sessionUUID == "" {
+		inspection.Active = clientID != "" && claims.Subject == clientID
+		if !inspection.Active {
+			return Inspection{}, nil
+		}
+		return inspection, nil
+	}
+
+	session, err := postgres.DB.GetSessionByUUID(sessionUUID)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	inspection.Active, err = isLive(session, claims.Subject, currentTime)
+	if err != nil || !inspection.Active {
+		return Inspection{}, err
+	}
+
+	return inspection, nil
+}
+
+// inspectRefreshToken is Inspect for tokens that aren't JWTs
+func inspectRefreshToken(token string, currentTime time.Time) (Inspection, error) {
+	stored, err := postgres.DB.GetRefreshTokenByHash(randtoken.Hash(token))
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	if stored.UUID == "" || stored.UsedAt != nil || stored.RevokedAt != nil || currentTime.After(stored.ExpiresAt) {
+		return Inspection{}, nil
+	}
+
+	session, err := postgres.DB.GetSessionByUUID(stored.SessionUUID)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	live, err := isLive(session, session.UserUUID, currentTime)
+	if err != nil || !live {
+		return Inspection{}, err
+	}
+
+	return Inspection{Active: true, Refresh: true, Access: ForSession(session), IssuedAt: stored.CreatedAt, ExpiresAt: stored.ExpiresAt}, nil
+}
+
+// isLive reports whether the session belongs to the user, hasn't been logged out of or expired, and the user is
+// still around
+func isLive(session models.Session, userUUID string, currentTime time.Time) (bool, error) {
+	if session.UUID == "" || session.DeletedAt != nil || !currentTime.Before(session.ExpiresAt) || session.UserUUID != userUUID {
+		return false, nil
+	}
+
+	user, err := postgres.DB.GetUserByUUID(userUUID)
+	if err != nil {
+		return false, err
+	}
+
+	return user.UUID != "", nil
+}
+
+// Revoke logs out of a session, soft deleting it along with every refresh token issued for it
+func Revoke(sessionUUID string) error {
+	_, err := postgres.DB.SoftDeleteSessionByUUID(sessionUUID)
+	if err != nil {
+		return err
+	}
+
+	_, err = postgres.DB.RevokeRefreshTokensBySessionUUID(sessionUUID)
+	return err
+}
@@ -0,0 +1,202 @@
+package tokens
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kylegrantlucas/platform-exercise/models"
+	"github.com/kylegrantlucas/platform-exercise/pkg/postgres"
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/signing"
+)
+
+func TestAccess_Sign(t *testing.T) {
+	signing.Keys = &signing.Register{}
+	signing.Keys.AddSecret([]byte("fenderdigital"))
+
+	currentTime := time.Now()
+	tests := []struct {
+		name      string
+		access    Access
+		wantSID   bool
+		wantScope bool
+		wantOrg   bool
+	}{
+		{
+			name:    "login",
+			access:  Access{Subject: "abc", SessionUUID: "def"},
+			wantSID: true,
+		},
+		{
+			name:    "login with roles",
+			access:  Access{Subject: "abc", SessionUUID: "def", Roles: []string{"admin", "support"}},
+			wantSID: true,
+		},
+		{
+			name:    "login in an organization",
+			access:  Access{Subject: "abc", SessionUUID: "def", Organization: "ghi"},
+			wantSID: true,
+			wantOrg: true,
+		},
+		{
+			name:      "authorized client",
+			access:    Access{Subject: "abc", SessionUUID: "def", ClientID: "mobile", Scope: "profile"},
+			wantSID:   true,
+			wantScope: true,
+		},
+		{
+			name:      "client acting for itself",
+			access:    Access{Subject: "mobile", ClientID: "mobile", Scope: "reports"},
+			wantScope: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.access.Sign(currentTime)
+			if err != nil {
+				t.Fatalf("Access.Sign() error = %v", err)
+			}
+
+			claims, err := signing.Keys.Check([]byte(token))
+			if err != nil {
+				t.Fatalf("Access.Sign() made a token that doesn't check: %v", err)
+			}
+
+			if claims.Issuer != Issuer || claims.Subject != tt.access.Subject || !claims.Valid(currentTime) || claims.Valid(currentTime.Add(AccessTokenLifetime+time.Second)) {
+				t.Errorf("Access.Sign() claims = %+v", claims)
+			}
+
+			sid, ok := claims.String("sid")
+			if ok != tt.wantSID || sid != tt.access.SessionUUID {
+				t.Errorf("Access.Sign() sid = %q, %v, want %q, %v", sid, ok, tt.access.SessionUUID, tt.wantSID)
+			}
+
+			scope, ok := claims.String("scope")
+			if ok != tt.wantScope || scope != tt.access.Scope {
+				t.Errorf("Access.Sign() scope = %q, %v, want %q, %v", scope, ok, tt.access.Scope, tt.wantScope)
+			}
+
+			org, ok := claims.String("org")
+			if ok != tt.wantOrg || org != tt.access.Organization {
+				t.Errorf("Access.Sign() org = %q, %v, want %q, %v", org, ok, tt.access.Organization, tt.wantOrg)
+			}
+
+			roles, ok := claims.Set["roles"].([]interface{})
+			if ok != (len(tt.access.Roles) > 0) || len(roles) != len(tt.access.Roles) {
+				t.Errorf("Access.Sign() roles = %v, want %v", claims.Set["roles"], tt.access.Roles)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	if !HasScope("openid profile", "profile") {
+		t.Errorf("HasScope() didn't find a granted scope")
+	}
+
+	if HasScope("openid profiles", "profile") || HasScope("", "profile") {
+		t.Errorf("HasScope() found a scope that wasn't granted")
+	}
+}
+
+// clientSessionDB has a live refresh token for a session that belongs to the mobile client
+type clientSessionDB struct {
+	postgres.DBMock
+	used int
+}
+
+func (d *clientSessionDB) GetSessionByUUID(uuid string) (models.Session, error) {
+	return models.Session{UUID: uuid, UserUUID: "abc", ClientID: "mobile", Scope: "profile", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (d *clientSessionDB) UseRefreshTokenByUUID(uuid string) (int, error) {
+	d.used++
+	return 1, nil
+}
+
+func TestRotate(t *testing.T) {
+	db := &clientSessionDB{}
+	postgres.DB = db
+
+	// Another client, or our own refresh endpoint, can't use the token, and trying doesn't use it up
+	for _, clientID := range []string{"", "partner"} {
+		if _, err := Rotate("abc", clientID, time.Now()); err != ErrInvalidRefreshToken {
+			t.Errorf("Rotate() for %q error = %v, want %v", clientID, err, ErrInvalidRefreshToken)
+		}
+	}
+
+	if db.used != 0 {
+		t.Fatalf("Rotate() used the token for the wrong client")
+	}
+
+	session, err := Rotate("abc", "mobile", time.Now())
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if db.used != 1 || !reflect.DeepEqual(ForSession(session), Access{Subject: "abc", SessionUUID: "abc", ClientID: "mobile", Scope: "profile"}) {
+		t.Errorf("Rotate() = %+v, want the client's session", session)
+	}
+}
+
+// inspectDB has a live session and one that's been logged out of, refresh tokens only exist for the live one
+type inspectDB struct {
+	postgres.DBMock
+}
+
+func (d *inspectDB) GetSessionByUUID(uuid string) (models.Session, error) {
+	session := models.Session{UUID: uuid, UserUUID: "abc", ClientID: "mobile", Scope: "profile", ExpiresAt: time.Now().Add(time.Hour)}
+	if uuid == "logged-out" {
+		deletedAt := time.Now()
+		session.DeletedAt = &deletedAt
+	}
+	return session, nil
+}
+
+func (d *inspectDB) GetRefreshTokenByHash(tokenHash string) (models.RefreshToken, error) {
+	if tokenHash != randtoken.Hash("refresh") {
+		return models.RefreshToken{}, nil
+	}
+	return models.RefreshToken{UUID: "def", SessionUUID: "live", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func TestInspect(t *testing.T) {
+	signing.Keys = &signing.Register{}
+	signing.Keys.AddSecret([]byte("fenderdigital"))
+	postgres.DB = &inspectDB{}
+
+	currentTime := time.Now()
+	sign := func(access Access, issued time.Time) string {
+		token, _ := access.Sign(issued)
+		return token
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  Access
+	}{
+		{name: "live session", token: sign(Access{Subject: "abc", SessionUUID: "live", ClientID: "mobile", Scope: "profile"}, currentTime), want: Access{Subject: "abc", SessionUUID: "live", ClientID: "mobile", Scope: "profile"}},
+		{name: "logged out session", token: sign(Access{Subject: "abc", SessionUUID: "logged-out"}, currentTime)},
+		{name: "someone else's session", token: sign(Access{Subject: "ghi", SessionUUID: "live"}, currentTime)},
+		{name: "expired", token: sign(Access{Subject: "abc", SessionUUID: "live"}, currentTime.Add(-AccessTokenLifetime-time.Second))},
+		{name: "client acting for itself", token: sign(Access{Subject: "partner", ClientID: "partner", Scope: "reports"}, currentTime), want: Access{Subject: "partner", ClientID: "partner", Scope: "reports"}},
+		{name: "not an access token", token: sign(Access{Subject: "abc"}, currentTime)},
+		{name: "refresh token", token: "refresh", want: Access{Subject: "abc", SessionUUID: "live", ClientID: "mobile", Scope: "profile"}},
+		{name: "unknown", token: "made up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Inspect(tt.token, currentTime)
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+
+			wantActive := !reflect.DeepEqual(tt.want, Access{})
+			if got.Active != wantActive || !reflect.DeepEqual(got.Access, tt.want) || got.Refresh != (tt.token == "refresh") {
+				t.Errorf("Inspect() = %+v, want active %v for %+v", got, wantActive, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrCBOR is returned for CBOR we can't decode, we only handle the definite length subset CTAP2 authenticators emit
+var ErrCBOR = errors.New("webauthn: malformed CBOR")
+
+// maxCBORDepth bounds how deeply arrays and maps can nest, so hostile input can't exhaust the stack
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the CBOR data item at the start of data, returning it along with whatever follows it.
+// Integers decode to int64, byte strings to []byte, text to string, arrays to []interface{} and maps to
+// map[interface{}]interface{}; tags are dropped and null decodes to nil.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, ErrCBOR
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22, 23:
+			return nil, data[1:], nil
+		default:
+			return nil, nil, ErrCBOR
+		}
+	}
+
+	argument, rest, err := readCBORArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0, 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, ErrCBOR
+		}
+		if major == 1 {
+			return -1 - int64(argument), rest, nil
+		}
+		return int64(argument), rest, nil
+	case 2, 3:
+		if argument > uint64(len(rest)) {
+			return nil, nil, ErrCBOR
+		}
+		if major == 3 {
+			return string(rest[:argument]), rest[argument:], nil
+		}
+		value := make([]byte, argument)
+		copy(value, rest)
+		return value, rest[argument:], nil
+	case 4:
+		// Every item takes at least a byte, so a longer array than there's data for is malformed
+		if argument > uint64(len(rest)) {
+			return nil, nil, ErrCBOR
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if argument > uint64(len(rest)) {
+			return nil, nil, ErrCBOR
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrCBOR
+			}
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, rest, nil
+	case 6:
+		return decodeCBORItem(rest, depth+1)
+	default:
+		return nil, nil, ErrCBOR
+	}
+}
+
+// readCBORArgument reads the integer that follows an initial byte, its size is given by the initial byte's low bits
+func readCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, ErrCBOR
+	}
+}
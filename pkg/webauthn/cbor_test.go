@@ -0,0 +1,68 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples from RFC 7049 appendix A
+	tests := []struct {
+		name    string
+		hex     string
+		want    interface{}
+		wantErr bool
+	}{
+		{"zero", "00", int64(0), false},
+		{"one byte uint", "1864", int64(100), false},
+		{"four byte uint", "1a000f4240", int64(1000000), false},
+		{"negative", "3903e7", int64(-1000), false},
+		{"bytes", "4401020304", []byte{1, 2, 3, 4}, false},
+		{"text", "6449455446", "IETF", false},
+		{"array", "83010203", []interface{}{int64(1), int64(2), int64(3)}, false},
+		{"map", "a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}, false},
+		{"text keys", "a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}, false},
+		{"tagged", "c11a514b67b0", int64(1363896240), false},
+		{"true", "f5", true, false},
+		{"null", "f6", nil, false},
+		{"float", "f93c00", nil, true},
+		{"indefinite bytes", "5f42010243030405ff", nil, true},
+		{"truncated bytes", "4401", nil, true},
+		{"huge array", "9bffffffffffffffff", nil, true},
+		{"array key", "a1810102", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.hex)
+			got, rest, err := decodeCBOR(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeCBOR() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (!reflect.DeepEqual(got, tt.want) || len(rest) != 0) {
+				t.Errorf("decodeCBOR() = %#v, %x, want %#v", got, rest, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBOR_Depth(t *testing.T) {
+	nested := []byte{}
+	for i := 0; i <= maxCBORDepth+1; i++ {
+		nested = append(nested, 0x81)
+	}
+	nested = append(nested, 0x00)
+
+	if _, _, err := decodeCBOR(nested); err != ErrCBOR {
+		t.Errorf("decodeCBOR() of deeply nested arrays error = %v, want %v", err, ErrCBOR)
+	}
+}
+
+func TestDecodeCBOR_Rest(t *testing.T) {
+	// Authenticator data has extensions after the COSE key, the decoder has to say where the key ended
+	got, rest, err := decodeCBOR([]byte{0x01, 0xa0})
+	if err != nil || got != int64(1) || !reflect.DeepEqual(rest, []byte{0xa0}) {
+		t.Errorf("decodeCBOR() = %v, %x, %v, want 1, a0, nil", got, rest, err)
+	}
+}
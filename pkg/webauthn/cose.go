@@ -0,0 +1,125 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// COSE algorithm identifiers for the signatures we can verify
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms are offered to authenticators at registration, in order of preference
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+var (
+	// ErrUnsupportedKey is returned for COSE keys of a type, curve or algorithm we don't handle
+	ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+	// ErrSignature is returned when a signature doesn't verify
+	ErrSignature = errors.New("webauthn: invalid signature")
+)
+
+// COSE key parameters, see RFC 8152 section 7 and 13
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// parsePublicKey decodes a COSE_Key into an *ecdsa.PublicKey, ed25519.PublicKey or *rsa.PublicKey
+func parsePublicKey(coseKey []byte) (crypto.PublicKey, error) {
+	decoded, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, ErrCBOR
+	}
+
+	kty, _ := key[int64(coseKty)].(int64)
+	alg, _ := key[int64(coseAlg)].(int64)
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := key[int64(coseCrv)].(int64)
+		x, _ := key[int64(coseX)].([]byte)
+		y, _ := key[int64(coseY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, ErrUnsupportedKey
+		}
+
+		return public, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := key[int64(coseCrv)].(int64)
+		x, _ := key[int64(coseX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+
+		return ed25519.PublicKey(x), nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := key[int64(coseN)].([]byte)
+		e, _ := key[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// verifySignature checks a signature over message made by the key, in the format WebAuthn uses for its algorithm
+func verifySignature(public crypto.PublicKey, message, signature []byte) error {
+	digest := sha256.Sum256(message)
+
+	switch public := public.(type) {
+	case *ecdsa.PublicKey:
+		var parsed struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(signature, &parsed)
+		if err != nil || len(rest) != 0 || !ecdsa.Verify(public, digest[:], parsed.R, parsed.S) {
+			return ErrSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(public, message, signature) {
+			return ErrSignature
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) != nil {
+			return ErrSignature
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Timeout is how long the browser gives the user to complete a ceremony, challenges should live as long
+const Timeout = 5 * time.Minute
+
+// Bytes is binary data that's base64url encoded in JSON, the encoding WebAuthn's JSON serialization uses
+type Bytes []byte
+
+// MarshalJSON encodes the bytes as unpadded base64url
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes base64url, with or without padding
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var encoded string
+	err := json.Unmarshal(data, &encoded)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+// CreationOptions are handed to navigator.credentials.create() to start a registration
+type CreationOptions struct {
+	PublicKey PublicKeyCreationOptions `json:"publicKey"`
+}
+
+type PublicKeyCreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// RequestOptions are handed to navigator.credentials.get() to start a login
+type RequestOptions struct {
+	PublicKey PublicKeyRequestOptions `json:"publicKey"`
+}
+
+type PublicKeyRequestOptions struct {
+	Challenge        Bytes  `json:"challenge"`
+	RPID             string `json:"rpId"`
+	Timeout          int64  `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+}
+
+// AttestationResponse is the JSON form of the PublicKeyCredential navigator.credentials.create() resolves with
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AttestationObject Bytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential navigator.credentials.get() resolves with
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+// NewCreationOptions builds the options for registering a credential for a user, challenge is the base64url
+// encoded challenge and excluded are the IDs of credentials the user already has, so they aren't registered twice
+func (rp *RelyingParty) NewCreationOptions(challenge, userUUID, email, name string, excluded [][]byte) (CreationOptions, error) {
+	challengeBytes, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil {
+		return CreationOptions{}, err
+	}
+
+	options := PublicKeyCreationOptions{
+		Challenge:          challengeBytes,
+		RP:                 RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:               UserEntity{ID: Bytes(userUUID), Name: email, DisplayName: name},
+		Timeout:            int64(Timeout / time.Millisecond),
+		ExcludeCredentials: []CredentialDescriptor{},
+		// Passkeys have to be discoverable, login doesn't ask who the user is first
+		AuthenticatorSelection: AuthenticatorSelection{ResidentKey: "required", UserVerification: rp.userVerification()},
+		Attestation:            "none",
+	}
+
+	for _, alg := range SupportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	for _, id := range excluded {
+		options.ExcludeCredentials = append(options.ExcludeCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return CreationOptions{PublicKey: options}, nil
+}
+
+// NewRequestOptions builds the options for logging in with any discoverable credential for us
+func (rp *RelyingParty) NewRequestOptions(challenge string) (RequestOptions, error) {
+	challengeBytes, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil {
+		return RequestOptions{}, err
+	}
+
+	return RequestOptions{PublicKey: PublicKeyRequestOptions{
+		Challenge:        challengeBytes,
+		RPID:             rp.ID,
+		Timeout:          int64(Timeout / time.Millisecond),
+		UserVerification: rp.userVerification(),
+	}}, nil
+}
+
+func (rp *RelyingParty) userVerification() string {
+	if rp.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
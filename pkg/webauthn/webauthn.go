@@ -0,0 +1,231 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// Authenticator data flags, see https://www.w3.org/TR/webauthn/#flags
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+var (
+	// ErrClientData is returned when the client data is malformed, for the wrong ceremony, challenge or origin
+	ErrClientData = errors.New("webauthn: client data doesn't match the ceremony")
+	// ErrAuthenticatorData is returned when authenticator data is malformed, for another relying party or lacks user presence
+	ErrAuthenticatorData = errors.New("webauthn: invalid authenticator data")
+	// ErrUserVerification is returned when the relying party requires user verification and the authenticator didn't do it
+	ErrUserVerification = errors.New("webauthn: user wasn't verified")
+	// ErrUnsupportedAttestation is returned for attestation formats we can't check
+	ErrUnsupportedAttestation = errors.New("webauthn: unsupported attestation format")
+	// ErrSignCount is returned when an assertion's signature counter went backwards, a sign the authenticator was cloned
+	ErrSignCount = errors.New("webauthn: signature counter went backwards")
+)
+
+// Default is the relying party ceremonies are checked against, WebAuthn is off while it's nil
+var Default *RelyingParty
+
+// RelyingParty is who credentials are scoped to. ID is the domain credentials belong to (ex: "fender.com") and
+// Origins are the exact origins the ceremonies can run on (ex: "https://www.fender.com").
+type RelyingParty struct {
+	ID                      string
+	Name                    string
+	Origins                 []string
+	RequireUserVerification bool
+}
+
+// ClientData is what the browser says about a ceremony, see https://www.w3.org/TR/webauthn/#dictionary-client-data
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Credential is a newly registered public key credential
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// authenticatorData is the parsed form of the binary authenticator data, see https://www.w3.org/TR/webauthn/#sctn-authenticator-data
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// ParseClientData decodes client data JSON, callers use the challenge in it to find the ceremony it belongs to
+func ParseClientData(clientDataJSON []byte) (ClientData, error) {
+	clientData := ClientData{}
+	err := json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil || clientData.Challenge == "" {
+		return clientData, ErrClientData
+	}
+
+	return clientData, nil
+}
+
+// VerifyRegistration checks the response to a registration ceremony made with challenge, returning the new credential
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (Credential, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	decoded, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return Credential{}, ErrCBOR
+	}
+
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if authData.flags&flagAttestedCredentialData == 0 {
+		return Credential{}, ErrAuthenticatorData
+	}
+
+	public, err := parsePublicKey(authData.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	// We ask for no attestation, so all we accept is none or a self attestation signed by the credential itself.
+	// Anything with a certificate chain would need trust roots we don't keep.
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return Credential{}, ErrUnsupportedAttestation
+		}
+	case "packed":
+		signature, _ := statement["sig"].([]byte)
+		if _, hasChain := statement["x5c"]; hasChain || signature == nil {
+			return Credential{}, ErrUnsupportedAttestation
+		}
+
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		err = verifySignature(public, append(append([]byte{}, rawAuthData...), clientDataHash[:]...), signature)
+		if err != nil {
+			return Credential{}, err
+		}
+	default:
+		return Credential{}, ErrUnsupportedAttestation
+	}
+
+	return Credential{ID: authData.credentialID, PublicKey: authData.publicKey, SignCount: authData.signCount}, nil
+}
+
+// VerifyAssertion checks the response to a login ceremony made with challenge against the credential's stored
+// public key and signature counter, returning the new counter to store
+func (rp *RelyingParty) VerifyAssertion(challenge string, publicKey []byte, signCount uint32, clientDataJSON, rawAuthData, signature []byte) (uint32, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	public, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	err = verifySignature(public, append(append([]byte{}, rawAuthData...), clientDataHash[:]...), signature)
+	if err != nil {
+		return 0, err
+	}
+
+	// Authenticators that don't count always send zero, otherwise the counter has to keep going up
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return 0, ErrSignCount
+	}
+
+	return authData.signCount, nil
+}
+
+// checkClientData makes sure the client data is for this ceremony, challenge and one of our origins
+func (rp *RelyingParty) checkClientData(clientDataJSON []byte, ceremony, challenge string) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+
+	if clientData.Type != ceremony || subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+		return ErrClientData
+	}
+
+	for _, origin := range rp.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+
+	return ErrClientData
+}
+
+// parseAuthenticatorData decodes authenticator data, checking it's scoped to us and the user was present
+func (rp *RelyingParty) parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	authData := authenticatorData{}
+	if len(raw) < 37 {
+		return authData, ErrAuthenticatorData
+	}
+
+	authData.rpIDHash, authData.flags, authData.signCount = raw[:32], raw[32], binary.BigEndian.Uint32(raw[33:37])
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) || authData.flags&flagUserPresent == 0 {
+		return authData, ErrAuthenticatorData
+	}
+
+	if rp.RequireUserVerification && authData.flags&flagUserVerified == 0 {
+		return authData, ErrUserVerification
+	}
+
+	// Attested credential data is the AAGUID, then a length prefixed credential ID, then the COSE key.
+	// Only the key's own encoding says how long it is, so we decode it to find where it ends.
+	if authData.flags&flagAttestedCredentialData != 0 {
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return authData, ErrAuthenticatorData
+		}
+
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || len(rest) < idLength {
+			return authData, ErrAuthenticatorData
+		}
+		authData.credentialID, rest = rest[:idLength], rest[idLength:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return authData, err
+		}
+		authData.publicKey = rest[:len(rest)-len(after)]
+	}
+
+	return authData, nil
+}
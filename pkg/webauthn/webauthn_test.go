@@ -0,0 +1,153 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/randtoken"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn/webauthntest"
+)
+
+var rp = &webauthn.RelyingParty{ID: "fender.com", Name: "Fender", Origins: []string{"https://www.fender.com"}}
+
+func register(t *testing.T, authenticator *webauthntest.Authenticator) webauthn.Credential {
+	challenge, _ := randtoken.Generate()
+	response, err := authenticator.Register(challenge, []byte("abc"))
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, response.Response.ClientDataJSON, response.Response.AttestationObject)
+	if err != nil {
+		t.Fatalf("VerifyRegistration() error = %v", err)
+	}
+
+	return credential
+}
+
+func TestRelyingParty_VerifyRegistration(t *testing.T) {
+	authenticator, _ := webauthntest.New("fender.com", "https://www.fender.com")
+	credential := register(t, authenticator)
+
+	if string(credential.ID) != string(authenticator.CredentialID) || string(credential.PublicKey) != string(authenticator.PublicKey()) {
+		t.Errorf("VerifyRegistration() = %x %x, want the authenticator's credential", credential.ID, credential.PublicKey)
+	}
+
+	challenge, _ := randtoken.Generate()
+	other, _ := randtoken.Generate()
+
+	tests := []struct {
+		name          string
+		authenticator func() *webauthntest.Authenticator
+		challenge     string
+		wantErr       error
+	}{
+		{
+			name: "wrong challenge",
+			authenticator: func() *webauthntest.Authenticator {
+				a, _ := webauthntest.New("fender.com", "https://www.fender.com")
+				return a
+			},
+			challenge: other,
+			wantErr:   webauthn.ErrClientData,
+		},
+		{
+			name: "wrong origin",
+			authenticator: func() *webauthntest.Authenticator {
+				a, _ := webauthntest.New("fender.com", "https://evil.com")
+				return a
+			},
+			challenge: challenge,
+			wantErr:   webauthn.ErrClientData,
+		},
+		{
+			name: "credential for another relying party",
+			authenticator: func() *webauthntest.Authenticator {
+				a, _ := webauthntest.New("evil.com", "https://www.fender.com")
+				return a
+			},
+			challenge: challenge,
+			wantErr:   webauthn.ErrAuthenticatorData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := tt.authenticator().Register(challenge, []byte("abc"))
+			_, err := rp.VerifyRegistration(tt.challenge, response.Response.ClientDataJSON, response.Response.AttestationObject)
+			if err != tt.wantErr {
+				t.Errorf("VerifyRegistration() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRelyingParty_VerifyRegistration_UserVerification(t *testing.T) {
+	strict := *rp
+	strict.RequireUserVerification = true
+
+	authenticator, _ := webauthntest.New("fender.com", "https://www.fender.com")
+	authenticator.UserVerified = false
+
+	challenge, _ := randtoken.Generate()
+	response, _ := authenticator.Register(challenge, []byte("abc"))
+	if _, err := strict.VerifyRegistration(challenge, response.Response.ClientDataJSON, response.Response.AttestationObject); err != webauthn.ErrUserVerification {
+		t.Errorf("VerifyRegistration() without user verification error = %v, want %v", err, webauthn.ErrUserVerification)
+	}
+}
+
+func TestRelyingParty_VerifyAssertion(t *testing.T) {
+	authenticator, _ := webauthntest.New("fender.com", "https://www.fender.com")
+	credential := register(t, authenticator)
+
+	challenge, _ := randtoken.Generate()
+	response, _ := authenticator.Login(challenge)
+
+	signCount, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, response.Response.ClientDataJSON, response.Response.AuthenticatorData, response.Response.Signature)
+	if err != nil || signCount != 1 {
+		t.Fatalf("VerifyAssertion() = %v, %v, want 1, nil", signCount, err)
+	}
+
+	// A counter that hasn't moved on from what we stored means two authenticators share the key
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, signCount, response.Response.ClientDataJSON, response.Response.AuthenticatorData, response.Response.Signature); err != webauthn.ErrSignCount {
+		t.Errorf("VerifyAssertion() replayed error = %v, want %v", err, webauthn.ErrSignCount)
+	}
+
+	// Registration responses can't be passed off as logins
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 0, []byte(`{"type": "webauthn.create", "challenge": "`+challenge+`", "origin": "https://www.fender.com"}`), response.Response.AuthenticatorData, response.Response.Signature); err != webauthn.ErrClientData {
+		t.Errorf("VerifyAssertion() of a create ceremony error = %v, want %v", err, webauthn.ErrClientData)
+	}
+
+	// Tampering with the signed data breaks the signature
+	tampered := append([]byte{}, response.Response.AuthenticatorData...)
+	tampered[len(tampered)-1]++
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 0, response.Response.ClientDataJSON, tampered, response.Response.Signature); err != webauthn.ErrSignature {
+		t.Errorf("VerifyAssertion() of tampered data error = %v, want %v", err, webauthn.ErrSignature)
+	}
+
+	// Another credential's key doesn't verify it
+	stranger, _ := webauthntest.New("fender.com", "https://www.fender.com")
+	if _, err := rp.VerifyAssertion(challenge, stranger.PublicKey(), 0, response.Response.ClientDataJSON, response.Response.AuthenticatorData, response.Response.Signature); err != webauthn.ErrSignature {
+		t.Errorf("VerifyAssertion() with another key error = %v, want %v", err, webauthn.ErrSignature)
+	}
+}
+
+func TestRelyingParty_NewCreationOptions(t *testing.T) {
+	challenge, _ := randtoken.Generate()
+	options, err := rp.NewCreationOptions(challenge, "abc", "test@test.com", "Testy", [][]byte{{1, 2, 3}})
+	if err != nil {
+		t.Fatalf("NewCreationOptions() error = %v", err)
+	}
+
+	encoded, _ := json.Marshal(options)
+	decoded := map[string]map[string]interface{}{}
+	json.Unmarshal(encoded, &decoded)
+
+	if decoded["publicKey"]["challenge"] != challenge {
+		t.Errorf("NewCreationOptions() challenge = %v, want %v", decoded["publicKey"]["challenge"], challenge)
+	}
+
+	if excluded := decoded["publicKey"]["excludeCredentials"].([]interface{}); len(excluded) != 1 {
+		t.Errorf("NewCreationOptions() excludeCredentials = %v, want the existing credential", excluded)
+	}
+}
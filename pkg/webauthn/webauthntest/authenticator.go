@@ -0,0 +1,141 @@
+// Package webauthntest provides a software authenticator for testing WebAuthn ceremonies without a browser
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+
+	"github.com/kylegrantlucas/platform-exercise/pkg/webauthn"
+)
+
+// Authenticator holds a single ES256 credential and answers ceremonies the way a browser and security key would
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	// UserVerified sets the UV flag, as though the user entered a PIN or used a biometric
+	UserVerified bool
+
+	key *ecdsa.PrivateKey
+}
+
+// New returns an authenticator with a fresh credential for the relying party ID, running on origin
+func New(rpID, origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID := make([]byte, 16)
+	_, err = rand.Read(credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{RPID: rpID, Origin: origin, CredentialID: credentialID, UserVerified: true, key: key}, nil
+}
+
+// Register answers a registration ceremony for challenge (base64url, as it's stored) with a "none" attestation
+func (a *Authenticator) Register(challenge string, userHandle []byte) (webauthn.AttestationResponse, error) {
+	a.UserHandle = userHandle
+	response := webauthn.AttestationResponse{ID: base64.RawURLEncoding.EncodeToString(a.CredentialID), RawID: a.CredentialID, Type: "public-key"}
+
+	clientDataJSON, err := a.clientData("webauthn.create", challenge)
+	if err != nil {
+		return response, err
+	}
+
+	// Attested credential data: an all zero AAGUID, the length prefixed credential ID and the COSE key
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(a.CredentialID)))
+	attested = append(attested, a.CredentialID...)
+	attested = append(attested, a.PublicKey()...)
+
+	authData := append(a.authenticatorData(0x40), attested...)
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AttestationObject = encodeMap([]entry{
+		{"fmt", "none"},
+		{"attStmt", []entry{}},
+		{"authData", authData},
+	})
+
+	return response, nil
+}
+
+// Login answers a login ceremony for challenge, bumping the signature counter
+func (a *Authenticator) Login(challenge string) (webauthn.AssertionResponse, error) {
+	response := webauthn.AssertionResponse{ID: base64.RawURLEncoding.EncodeToString(a.CredentialID), RawID: a.CredentialID, Type: "public-key"}
+
+	clientDataJSON, err := a.clientData("webauthn.get", challenge)
+	if err != nil {
+		return response, err
+	}
+
+	a.SignCount++
+	authData := a.authenticatorData(0)
+
+	signature, err := a.Sign(authData, clientDataJSON)
+	if err != nil {
+		return response, err
+	}
+
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+	response.Response.UserHandle = a.UserHandle
+
+	return response, nil
+}
+
+// Sign signs authenticator data and the hash of the client data, as an assertion or self attestation does
+func (a *Authenticator) Sign(authData, clientDataJSON []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+}
+
+// PublicKey returns the credential's public key as a COSE_Key
+func (a *Authenticator) PublicKey() []byte {
+	return encodeMap([]entry{
+		{1, 2},
+		{3, webauthn.AlgES256},
+		{-1, 1},
+		{-2, pad32(a.key.X.Bytes())},
+		{-3, pad32(a.key.Y.Bytes())},
+	})
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(webauthn.ClientData{Type: ceremony, Challenge: challenge, Origin: a.Origin})
+}
+
+func (a *Authenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	flags |= 0x01
+	if a.UserVerified {
+		flags |= 0x04
+	}
+
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.SignCount)
+	return data
+}
+
+func pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
@@ -0,0 +1,54 @@
+package webauthntest
+
+import "encoding/binary"
+
+// entry is a CBOR map entry, maps are kept as slices so their encoding is stable
+type entry struct {
+	key   interface{}
+	value interface{}
+}
+
+// encodeMap encodes a CBOR map, values can be ints, strings, byte strings or nested maps
+func encodeMap(entries []entry) []byte {
+	out := header(5, uint64(len(entries)))
+	for _, e := range entries {
+		out = append(out, encode(e.key)...)
+		out = append(out, encode(e.value)...)
+	}
+	return out
+}
+
+func encode(value interface{}) []byte {
+	switch value := value.(type) {
+	case int:
+		if value < 0 {
+			return header(1, uint64(-1-value))
+		}
+		return header(0, uint64(value))
+	case string:
+		return append(header(3, uint64(len(value))), value...)
+	case []byte:
+		return append(header(2, uint64(len(value))), value...)
+	case []entry:
+		return encodeMap(value)
+	default:
+		panic("webauthntest: can't encode value")
+	}
+}
+
+func header(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		out := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(out[1:], uint16(argument))
+		return out
+	default:
+		out := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(out[1:], uint32(argument))
+		return out
+	}
+}